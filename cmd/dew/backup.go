@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("dew backup", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: dew backup [flags] <file>")
+	}
+	path := fs.Arg(0)
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// written alongside the destination first so an interrupted
+	// backup never leaves behind a file which looks complete
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	header, err := kvs.Backup(db, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	encrypted := "unencrypted"
+	if header.Encrypted {
+		encrypted = "encrypted"
+	}
+	fmt.Printf("wrote %s backup of schema version %d to %s\n", encrypted, header.SchemaVersion, path)
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("dew restore", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: dew restore [flags] <file>")
+	}
+	if len(store.dir) == 0 {
+		return errors.New("--dir is required")
+	}
+
+	if entries, err := os.ReadDir(store.dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty, backups can only be restored into a new store", store.dir)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := kvs.ReadBackupHeader(f)
+	if err != nil {
+		return err
+	}
+	if latest := mail.Migrations().Latest(); header.SchemaVersion > latest {
+		return fmt.Errorf("%w: backup is at version %d, latest known version is %d", kvs.ErrNewerSchema, header.SchemaVersion, latest)
+	}
+	if header.Encrypted && len(store.key.keyFile) == 0 && !store.key.passphrase {
+		return errors.New("backup is encrypted, either --key-file or --passphrase is required")
+	}
+
+	if err := restoreInto(store, header, f); err != nil {
+		os.RemoveAll(store.dir)
+		return err
+	}
+
+	fmt.Printf("restored backup of schema version %d into %s\n", header.SchemaVersion, store.dir)
+	return nil
+}
+
+func restoreInto(store storeFlags, header kvs.BackupHeader, f *os.File) error {
+	if err := kvs.RestoreSalt(store.dir, header); err != nil {
+		return err
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	_, err = kvs.Restore(db, f)
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+const dbUsage = `usage: dew db <command> [flags]
+
+commands:
+  tables   list every table and the number of rows it has
+  dump     print the decoded rows of the store
+  get      print the decoded value of a single key
+  stats    print the number of rows, keys and bytes of each table
+  verify   check every key and value of the store can be read
+  rekey    re-encrypt the store with a new key`
+
+func runDBCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(dbUsage)
+	}
+
+	switch args[0] {
+	case "tables":
+		return runDBTables(args[1:])
+	case "dump":
+		return runDBDump(args[1:])
+	case "get":
+		return runDBGet(args[1:])
+	case "stats":
+		return runDBStats(args[1:])
+	case "verify":
+		return runDBVerify(args[1:])
+	case "rekey":
+		return runDBRekey(args[1:])
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", args[0], dbUsage)
+}
+
+func runDBRekey(args []string) error {
+	fs := flag.NewFlagSet("dew db rekey", flag.ContinueOnError)
+	dir := fs.String("dir", "", "directory of the store to re-encrypt")
+	var current, next keyOptions
+	current.register(fs, "", "current")
+	next.register(fs, "new-", "new")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*dir) == 0 {
+		return errors.New("--dir is required")
+	}
+
+	oldKey, err := current.resolve(*dir, "Current passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	newKey, err := next.resolve(*dir, "New passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	if len(oldKey) == 0 && len(newKey) == 0 {
+		return errors.New("neither a current or new key was given, nothing to do")
+	}
+
+	return kvs.Rekey(*dir, oldKey, newKey)
+}
+
+// openDB opens the store kept within dir, or an in memory store if no dir is given.
+func openDB(dir string, key keyOptions) (kvs.DB, error) {
+	if len(dir) == 0 {
+		return kvs.NewMemDB()
+	}
+
+	k, err := key.resolve(dir, "Passphrase: ")
+	if err != nil {
+		return kvs.DB{}, err
+	}
+
+	return kvs.NewDiskDB(dir, k)
+}
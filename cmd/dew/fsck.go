@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// exit codes follow those of fsck(8)
+const (
+	fsckOK          = 0
+	fsckRepaired    = 1
+	fsckUncorrected = 4
+	fsckFailed      = 8
+)
+
+const fsckUsage = `usage: dew fsck [flags]
+
+exit codes:
+  0  no problems were found
+  1  problems were found and repaired
+  4  problems were found and left uncorrected
+  8  the check itself failed`
+
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("dew fsck", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), fsckUsage)
+		fs.PrintDefaults()
+	}
+	var store storeFlags
+	store.register(fs)
+	repair := fs.Bool("repair", false, "delete orphaned rows and backfill missing columns with their zero value")
+	if err := fs.Parse(args); err != nil {
+		return fsckFailed
+	}
+
+	db, err := store.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dew fsck: %v\n", err)
+		return fsckFailed
+	}
+	defer db.Close()
+
+	report, err := mail.Fsck(db, mail.FsckOptions{Repair: *repair})
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dew fsck: %v\n", err)
+		return fsckFailed
+	}
+
+	switch {
+	case len(report.Problems) == 0:
+		fmt.Println("no problems found")
+		return fsckOK
+	case report.Repaired == len(report.Problems):
+		fmt.Printf("repaired %d problems\n", report.Repaired)
+		return fsckRepaired
+	}
+
+	fmt.Printf("found %d problems, run with --repair to fix them\n", len(report.Problems))
+	return fsckUncorrected
+}
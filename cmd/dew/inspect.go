@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// storeFlags are the flags shared by every command which reads an existing store.
+type storeFlags struct {
+	dir string
+	key keyOptions
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dir, "dir", "", "directory of the store")
+	f.key.register(fs, "", "store")
+}
+
+func (f storeFlags) open() (kvs.DB, error) {
+	if len(f.dir) == 0 {
+		return kvs.DB{}, errors.New("--dir is required")
+	}
+	return openDB(f.dir, f.key)
+}
+
+type outputFormat string
+
+func (f *outputFormat) String() string { return string(*f) }
+
+func (f *outputFormat) Set(v string) error {
+	switch v {
+	case "json", "table":
+		*f = outputFormat(v)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected json or table", v)
+}
+
+func runDBTables(args []string) error {
+	fs := flag.NewFlagSet("dew db tables", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := kvs.Stats(db)
+	if err != nil {
+		return err
+	}
+
+	rows := map[string]int{}
+	for _, s := range stats {
+		if s.Rows > 0 {
+			rows[s.Table] = s.Rows
+		}
+	}
+
+	tables := mail.Tables()
+	names := tables.Names()
+	for name := range rows {
+		if _, ok := tables.Lookup(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tTYPE\tROWS")
+	for _, name := range names {
+		typeName := "unregistered"
+		if t, ok := tables.Lookup(name); ok {
+			typeName = t.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", name, typeName, rows[name])
+	}
+	return w.Flush()
+}
+
+func runDBDump(args []string) error {
+	fs := flag.NewFlagSet("dew db dump", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	var opts kvs.DumpOptions
+	fs.StringVar(&opts.Table, "table", "", "only dump the rows of this table")
+	fs.StringVar(&opts.Owner, "owner", "", "only dump the rows belonging to this owner")
+	format := outputFormat("table")
+	fs.Var(&format, "format", "output format, either json or table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := kvs.Dump(db, mail.Tables(), opts)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return writeJSON(rows)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tOWNER\tROW\tCOLUMN\tVALUE")
+	for _, row := range rows {
+		columns := make([]string, 0, len(row.Values))
+		for column := range row.Values {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%v\n", row.Table, row.Owner, row.RowID, column, row.Values[column])
+		}
+	}
+	return w.Flush()
+}
+
+func runDBGet(args []string) error {
+	fs := flag.NewFlagSet("dew db get", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: dew db get [flags] <key>")
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	value, err := kvs.Inspect(db, mail.Tables(), fs.Arg(0))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return fmt.Errorf("key %s not found", fs.Arg(0))
+		}
+		return err
+	}
+
+	_, err = fmt.Fprintf(os.Stdout, "%v\n", value)
+	return err
+}
+
+func runDBStats(args []string) error {
+	fs := flag.NewFlagSet("dew db stats", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	format := outputFormat("table")
+	fs.Var(&format, "format", "output format, either json or table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := kvs.Stats(db)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return writeJSON(stats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS\tKEYS\tBYTES")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", s.Table, s.Rows, s.Keys, s.Bytes)
+	}
+	return w.Flush()
+}
+
+func runDBVerify(args []string) error {
+	fs := flag.NewFlagSet("dew db verify", flag.ContinueOnError)
+	var store storeFlags
+	store.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := kvs.Verify(db, mail.Tables())
+	if err != nil {
+		return err
+	}
+
+	version, err := kvs.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if latest := mail.Migrations().Latest(); version != latest {
+		problems = append(problems, kvs.Problem{
+			Key: "_schema.version",
+			Err: fmt.Errorf("store is at schema version %d, latest is %d", version, latest),
+		})
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(os.Stdout, p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+	_, err = fmt.Fprintln(os.Stdout, "no problems found")
+	return err
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
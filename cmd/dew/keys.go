@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tauraamui/maildew/internal/configs"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"golang.org/x/term"
+)
+
+type keyOptions struct {
+	keyFile    string
+	passphrase bool
+}
+
+func (o *keyOptions) register(fs *flag.FlagSet, prefix, description string) {
+	fs.StringVar(&o.keyFile, prefix+"key-file", "", fmt.Sprintf("root key file to derive the %s encryption key from", description))
+	fs.BoolVar(&o.passphrase, prefix+"passphrase", false, fmt.Sprintf("prompt for a passphrase to derive the %s encryption key from", description))
+}
+
+// resolve returns a nil key if neither a key file or passphrase were requested,
+// which means the store is not encrypted.
+func (o keyOptions) resolve(dir, prompt string) ([]byte, error) {
+	switch {
+	case len(o.keyFile) > 0 && o.passphrase:
+		return nil, errors.New("a key file and a passphrase cannot both be used")
+	case len(o.keyFile) > 0:
+		rootKey, err := config.LoadRootKey(o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return kvs.DeriveKeyFromRootKey(rootKey)
+	case o.passphrase:
+		passphrase, err := readPassphrase(prompt)
+		if err != nil {
+			return nil, err
+		}
+		return kvs.DeriveKeyFromPassphrase(dir, passphrase)
+	}
+	return nil, nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		return term.ReadPassword(fd)
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
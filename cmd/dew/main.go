@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 
 	"github.com/emersion/go-imap/backend"
 	"github.com/emersion/go-imap/server"
+	"github.com/tauraamui/maildew/internal/config"
+	"github.com/tauraamui/maildew/internal/configdef"
 	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/internal/mail/mock"
 	"github.com/tauraamui/maildew/internal/storage/models"
@@ -16,6 +21,36 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		if err := runDBCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "dew db: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(runFsck(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		run := runBackup
+		if os.Args[1] == "restore" {
+			run = runRestore
+		}
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "dew %s: %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	dbDir := flag.String("db", "", "directory to keep the store in, an in memory store is used if not set")
+	var dbKey keyOptions
+	dbKey.register(flag.CommandLine, "", "store")
+	dryRunMigrations := flag.Bool("dry-run-migrations", false, "report pending schema migrations without applying them and exit")
+	flag.Parse()
+
 	f, err := os.OpenFile("maildew.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
 	if err != nil {
 		panic(err)
@@ -25,6 +60,11 @@ func main() {
 	log := logging.New(logging.Options{Level: logging.DEBUG, Writer: f})
 	log.Info().Msg("MAILDEW v0.0.0a")
 
+	cfg, configured, err := loadConfig()
+	if err != nil {
+		log.Fatal().Msgf("unable to load config: %v", err)
+	}
+
 	l, err := setupListener()
 	if err != nil {
 		log.Fatal().Msgf("unable to start localhost TCP listener: %v", err)
@@ -51,32 +91,86 @@ func main() {
 		log.Fatal().Msgf("unable to start local IMAP server: %v", err)
 	}
 
-	db, err := kvs.NewMemDB()
+	db, err := openDB(*dbDir, dbKey)
+	if err != nil {
+		log.Fatal().Msgf("unable to open KVS: %v", err)
+	}
+
+	result, err := kvs.Migrate(db, mail.Migrations(), kvs.MigrateOptions{DryRun: *dryRunMigrations})
 	if err != nil {
-		log.Fatal().Msgf("unable to open in memory KVS: %v", err)
+		log.Fatal().Msgf("unable to migrate KVS schema: %v", err)
+	}
+	if *dryRunMigrations {
+		fmt.Printf("schema version %d -> %d\n", result.From, result.To)
+		for _, mg := range result.Applied {
+			fmt.Printf("  %d: %s\n", mg.Version, mg.Name)
+		}
+		db.Close()
+		l.Close()
+		shutdown()
+		return
+	}
+	for _, mg := range result.Applied {
+		log.Info().Msgf("applied schema migration %d: %s", mg.Version, mg.Name)
 	}
 
 	accRepo := mail.NewAccountRepo(db)
 	mbRepo := mail.NewMailboxRepo(db)
 	msgRepo := mail.NewMessageRepo(db)
 
+	policy := mail.DefaultBodyCachePolicy()
+	if configured {
+		policy = mail.BodyCachePolicyFromConfig(cfg.BodyCache)
+	}
+	bodyCache := mail.NewBodyCache(log, db, policy, nil)
+	evictCtx, stopEvictor := context.WithCancel(context.Background())
+	evictorDone := make(chan struct{})
+	go func() {
+		bodyCache.RunEvictor(evictCtx, 0)
+		close(evictorDone)
+	}()
+
+	changes, err := db.Subscribe("")
+	if err != nil {
+		log.Fatal().Msgf("unable to subscribe to KVS changes: %v", err)
+	}
+
 	if err := tui.Run(
 		log,
 		l.Addr().String(),
 		tui.Repositories{
-			AccountRepo: accRepo,
-			MailboxRepo: mbRepo,
-			MessageRepo: msgRepo,
-		}); err != nil {
+			AccountRepo:     accRepo,
+			MailboxRepo:     mbRepo,
+			MessageRepo:     msgRepo,
+			SavedSearchRepo: mail.NewSavedSearchRepo(db),
+			Searcher:        mail.NewSearcher(db, nil),
+			BodyCache:       bodyCache,
+		}, changes, tui.ReaderOptionsFromConfig(cfg.Reader), tui.ComposeOptionsFromConfig(cfg.Compose)); err != nil {
 		log.Fatal().Msgf("failed to load TUI: %v", err)
 	}
 
-	db.DumpTo(f)
+	stopEvictor()
+	<-evictorDone
+	changes.Close()
+	db.Close()
 
 	l.Close()
 	shutdown()
 }
 
+// loadConfig resolves the config file, reporting false
+// if there is none and the defaults are to be used.
+func loadConfig() (configdef.Values, bool, error) {
+	cfg, err := config.DefaultResolver().Resolve()
+	if errors.Is(err, os.ErrNotExist) {
+		return configdef.Values{}, false, nil
+	}
+	if err != nil {
+		return configdef.Values{}, false, err
+	}
+	return cfg, true, nil
+}
+
 func setupListener() (net.Listener, error) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
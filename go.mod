@@ -23,6 +23,9 @@ require (
 	github.com/tacusci/logging/v2 v2.1.1
 	github.com/tauraamui/gonp v0.0.0-20230129073740-ad7ea625393b
 	github.com/tauraamui/xerror v0.0.0-20230122173728-a6ff5ab2f4d7
+	golang.org/x/crypto v0.4.0
+	golang.org/x/net v0.3.0
+	golang.org/x/term v0.3.0
 	gopkg.in/dealancer/validate.v2 v2.1.0
 )
 
@@ -57,10 +60,7 @@ require (
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/sahilm/fuzzy v0.1.0 // indirect
 	go.opencensus.io v0.22.5 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
-	golang.org/x/net v0.3.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
-	golang.org/x/term v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -5,8 +5,41 @@ import (
 )
 
 type Values struct {
-	Debug   bool   `json:"debug"`
-	RootKey []byte `json:"root_key"`
+	Debug     bool            `json:"debug"`
+	RootKey   []byte          `json:"root_key"`
+	BodyCache BodyCacheValues `json:"body_cache"`
+	Reader    ReaderValues    `json:"reader"`
+	Compose   ComposeValues   `json:"compose"`
+}
+
+// BodyCacheValues configures how many message bodies are kept cached,
+// zero values for the size and ages mean unlimited.
+type BodyCacheValues struct {
+	MaxSizeMB         int64          `json:"max_size_mb" validate:"gte=0"`
+	MaxAgeDays        int            `json:"max_age_days" validate:"gte=0"`
+	MailboxMaxAgeDays map[string]int `json:"mailbox_max_age_days" validate:"> gte=0"`
+	KeepFlagged       bool           `json:"keep_flagged"`
+	KeepRecent        bool           `json:"keep_recent"`
+}
+
+// ReaderValues configures how the reader shows messages.
+type ReaderValues struct {
+	// HTMLCommand renders HTML-only mail in place of the built in renderer when
+	// set, such as ["w3m", "-dump", "-T", "text/html"], reading the HTML on stdin.
+	HTMLCommand               []string `json:"html_command"`
+	HTMLCommandTimeoutSeconds int      `json:"html_command_timeout_seconds" validate:"gte=0"`
+	// DownloadDir is where attachments are saved, ~/Downloads if not set.
+	DownloadDir string `json:"download_dir"`
+	// OpenCommands maps a media type, or a type such as image/*, to the command
+	// attachments of that type are opened with, xdg-open is used for the rest.
+	OpenCommands map[string][]string `json:"open_commands"`
+}
+
+// ComposeValues configures writing messages.
+type ComposeValues struct {
+	// AttachmentSizeWarningMB is the total size of attachments beyond which a
+	// warning is shown before sending, 25 if not set.
+	AttachmentSizeWarningMB int64 `json:"attachment_size_warning_mb" validate:"gte=0"`
 }
 
 func (v Values) RunValidate() error {
@@ -36,3 +36,24 @@ func TestValidatePopulatedConfigPassesValidation(t *testing.T) {
 	is.NoErr(json.Unmarshal([]byte(body), &config))
 	is.NoErr(config.RunValidate())
 }
+
+func TestValidateNegativeBodyCacheValuesFails(t *testing.T) {
+	is := is.New(t)
+	body := `{
+			"body_cache": {
+				"max_size_mb": -1
+			}
+		}`
+	config := configdef.Values{}
+	is.NoErr(json.Unmarshal([]byte(body), &config))
+	is.True(config.RunValidate() != nil)
+
+	body = `{
+			"body_cache": {
+				"mailbox_max_age_days": { "INBOX": -5 }
+			}
+		}`
+	config = configdef.Values{}
+	is.NoErr(json.Unmarshal([]byte(body), &config))
+	is.True(config.RunValidate() != nil)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/gtank/cryptopasta"
@@ -10,3 +11,19 @@ func ResolveRootKey() {
 	key := cryptopasta.NewEncryptionKey()
 	os.WriteFile(".mailkey", key[:], os.ModePerm)
 }
+
+func LoadRootKey(path string) ([32]byte, error) {
+	var key [32]byte
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return key, err
+	}
+
+	if len(b) != len(key) {
+		return key, fmt.Errorf("root key %s must be exactly %d bytes", path, len(key))
+	}
+
+	copy(key[:], b)
+	return key, nil
+}
@@ -0,0 +1,318 @@
+package kvs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	backupMagic          = "maildew backup\n"
+	backupFormatVersion  = 1
+	maxBackupHeaderSize  = 1 << 20
+	loadMaxPendingWrites = 256
+)
+
+var ErrBackupCorrupt = errors.New("backup is corrupt")
+
+type BackupHeader struct {
+	FormatVersion uint32    `json:"format_version"`
+	SchemaVersion uint32    `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Encrypted backups can only be restored into a store
+	// opened with the same key as the one backed up.
+	Encrypted bool   `json:"encrypted"`
+	Nonce     []byte `json:"nonce,omitempty"`
+	// Salt is the passphrase salt of the backed up store, which is
+	// needed to derive the same key again for the restored store.
+	Salt     []byte   `json:"salt,omitempty"`
+	Manifest Manifest `json:"manifest"`
+}
+
+// Manifest holds a checksum of every key and value of each table.
+type Manifest map[string]ManifestEntry
+
+type ManifestEntry struct {
+	Keys   int    `json:"keys"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a header followed by a full snapshot of the store to w,
+// the snapshot is encrypted when the store itself is encrypted.
+func Backup(db DB, w io.Writer) (BackupHeader, error) {
+	h := BackupHeader{FormatVersion: backupFormatVersion, CreatedAt: time.Now().UTC()}
+
+	var err error
+	if h.SchemaVersion, err = SchemaVersion(db); err != nil {
+		return h, err
+	}
+	if h.Manifest, err = computeManifest(db); err != nil {
+		return h, err
+	}
+
+	opts := db.conn.Opts()
+	if len(opts.EncryptionKey) > 0 {
+		h.Encrypted = true
+		h.Nonce = make([]byte, backupNonceSize)
+		if _, err := rand.Read(h.Nonce); err != nil {
+			return h, err
+		}
+		if salt, err := os.ReadFile(filepath.Join(opts.Dir, saltFileName)); err == nil {
+			h.Salt = salt
+		}
+	}
+
+	if err := writeBackupHeader(w, h); err != nil {
+		return h, err
+	}
+
+	var body io.WriteCloser = nopWriteCloser{w}
+	if h.Encrypted {
+		key, err := deriveBackupKey(opts.EncryptionKey)
+		if err != nil {
+			return h, err
+		}
+		if body, err = newChunkWriter(w, key, h.Nonce); err != nil {
+			return h, err
+		}
+	}
+
+	if _, err := db.conn.Backup(body, 0); err != nil {
+		return h, err
+	}
+	return h, body.Close()
+}
+
+// Restore loads a backup written by Backup into the empty store db, then
+// checks the restored keys and values against the backup's manifest.
+func Restore(db DB, r io.Reader) (BackupHeader, error) {
+	h, err := ReadBackupHeader(r)
+	if err != nil {
+		return h, err
+	}
+
+	empty, err := isEmpty(db)
+	if err != nil {
+		return h, err
+	}
+	if !empty {
+		return h, errors.New("backups can only be restored into an empty store")
+	}
+
+	body := r
+	if h.Encrypted {
+		dbKey := db.conn.Opts().EncryptionKey
+		if len(dbKey) == 0 {
+			return h, errors.New("backup is encrypted, the store restored into must be opened with its key")
+		}
+		key, err := deriveBackupKey(dbKey)
+		if err != nil {
+			return h, err
+		}
+		if body, err = newChunkReader(r, key, h.Nonce); err != nil {
+			return h, err
+		}
+	}
+
+	if err := db.conn.Load(body, loadMaxPendingWrites); err != nil {
+		return h, fmt.Errorf("unable to load backup: %w", err)
+	}
+
+	restored, err := computeManifest(db)
+	if err != nil {
+		return h, err
+	}
+	if err := h.Manifest.compare(restored); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+func ReadBackupHeader(r io.Reader) (BackupHeader, error) {
+	h := BackupHeader{}
+
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != backupMagic {
+		return h, errors.New("not a maildew backup")
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return h, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	if size > maxBackupHeaderSize {
+		return h, fmt.Errorf("%w: header is too large", ErrBackupCorrupt)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return h, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+
+	if h.FormatVersion != backupFormatVersion {
+		return h, fmt.Errorf("unsupported backup format version %d", h.FormatVersion)
+	}
+	return h, nil
+}
+
+// RestoreSalt writes the backed up passphrase salt into dir, so a passphrase
+// derives the same key for the restored store as it did for the original.
+func RestoreSalt(dir string, h BackupHeader) error {
+	if len(h.Salt) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, saltFileName), h.Salt, 0o600)
+}
+
+func writeBackupHeader(w io.Writer, h BackupHeader) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func deriveBackupKey(dbKey []byte) ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dbKey, nil, []byte("maildew backup encryption")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func computeManifest(db DB) (Manifest, error) {
+	type tableHash struct {
+		keys int
+		sum  hashWriter
+	}
+
+	tables := map[string]*tableHash{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			table := keyTable(item.Key())
+			th, ok := tables[table]
+			if !ok {
+				th = &tableHash{sum: hashWriter{sha256.New()}}
+				tables[table] = th
+			}
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			th.keys++
+			th.sum.writeField(item.Key())
+			th.sum.writeField(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := Manifest{}
+	for table, th := range tables {
+		m[table] = ManifestEntry{Keys: th.keys, SHA256: hex.EncodeToString(th.sum.Sum(nil))}
+	}
+	return m, nil
+}
+
+func (m Manifest) compare(restored Manifest) error {
+	tables := []string{}
+	for table := range m {
+		tables = append(tables, table)
+	}
+	for table := range restored {
+		if _, ok := m[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if m[table] != restored[table] {
+			return fmt.Errorf("%w: restored table %s does not match its checksum", ErrBackupCorrupt, table)
+		}
+	}
+	return nil
+}
+
+func isEmpty(db DB) (bool, error) {
+	empty := true
+	err := db.conn.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty, err
+}
+
+// keyTable resolves which table a key belongs to, index and row ID entries
+// belong to their table and every other key is grouped by its first segment.
+func keyTable(key []byte) string {
+	if k, ok := parseIndexKey(key); ok {
+		return k.table
+	}
+	if k, ok := parseRowIDKey(key); ok {
+		return k.table
+	}
+	if i := bytes.IndexByte(key, '.'); i >= 0 {
+		return string(key[:i])
+	}
+	return string(key)
+}
+
+type hashWriter struct {
+	hash.Hash
+}
+
+// writeField length prefixes b so that adjacent fields can never be confused.
+func (h hashWriter) writeField(b []byte) {
+	binary.Write(h, binary.BigEndian, uint32(len(b)))
+	h.Write(b)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
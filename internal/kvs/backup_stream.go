@@ -0,0 +1,155 @@
+package kvs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	backupNonceSize = 12
+	backupChunkSize = 64 << 10
+
+	chunkMore  byte = 0
+	chunkFinal byte = 1
+)
+
+// chunkWriter encrypts a stream as a series of AES-GCM sealed chunks, each
+// with its own nonce derived from its position. The final chunk is flagged
+// so that a truncated stream can never be mistaken for a complete one.
+type chunkWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	count uint64
+	buf   []byte
+}
+
+func newChunkWriter(w io.Writer, key, nonce []byte) (*chunkWriter, error) {
+	aead, err := newBackupAEAD(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{w: w, aead: aead, nonce: nonce, buf: make([]byte, 0, backupChunkSize)}, nil
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(cw.buf[len(cw.buf):cap(cw.buf)], p)
+		cw.buf = cw.buf[:len(cw.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(cw.buf) == cap(cw.buf) {
+			if err := cw.seal(chunkMore); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close writes the final chunk, it does not close the underlying writer.
+func (cw *chunkWriter) Close() error {
+	return cw.seal(chunkFinal)
+}
+
+func (cw *chunkWriter) seal(flag byte) error {
+	ciphertext := cw.aead.Seal(nil, chunkNonce(cw.nonce, cw.count), cw.buf, []byte{flag})
+	cw.count++
+	cw.buf = cw.buf[:0]
+
+	header := make([]byte, 5)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(ciphertext)))
+	if _, err := cw.w.Write(header); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(ciphertext)
+	return err
+}
+
+type chunkReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	count uint64
+	buf   []byte
+	final bool
+}
+
+func newChunkReader(r io.Reader, key, nonce []byte) (*chunkReader, error) {
+	aead, err := newBackupAEAD(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{r: r, aead: aead, nonce: nonce}, nil
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.final {
+			return 0, io.EOF
+		}
+		if err := cr.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+func (cr *chunkReader) open() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(cr.r, header); err != nil {
+		return fmt.Errorf("%w: backup ends before its final chunk", ErrBackupCorrupt)
+	}
+
+	flag, size := header[0], binary.BigEndian.Uint32(header[1:])
+	if size > backupChunkSize+uint32(cr.aead.Overhead()) {
+		return fmt.Errorf("%w: chunk is too large", ErrBackupCorrupt)
+	}
+
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, ciphertext); err != nil {
+		return fmt.Errorf("%w: backup ends before its final chunk", ErrBackupCorrupt)
+	}
+
+	plaintext, err := cr.aead.Open(nil, chunkNonce(cr.nonce, cr.count), ciphertext, []byte{flag})
+	if err != nil {
+		return errors.New("unable to decrypt backup, it was either made with a different key or is corrupt")
+	}
+	cr.count++
+	cr.buf = plaintext
+	cr.final = flag == chunkFinal
+	return nil
+}
+
+func newBackupAEAD(key, nonce []byte) (cipher.AEAD, error) {
+	if len(nonce) != backupNonceSize {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrBackupCorrupt)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce xors the chunk's position into the last 8 bytes of the base nonce.
+func chunkNonce(base []byte, count uint64) []byte {
+	nonce := append([]byte{}, base...)
+	position := make([]byte, 8)
+	binary.BigEndian.PutUint64(position, count)
+	for i, b := range position {
+		nonce[len(nonce)-8+i] ^= b
+	}
+	return nonce
+}
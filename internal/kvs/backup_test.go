@@ -0,0 +1,169 @@
+package kvs_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+func storeBackupTestRows(t *testing.T, db kvs.DB) uuid.UUID {
+	t.Helper()
+	is := is.New(t)
+
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{Version: 1, Name: "initial schema"})
+	_, err := kvs.Migrate(db, migrations, kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	owner := uuid.New()
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	for i := 0; i < 3; i++ {
+		_, err := r.Save(owner, queryTestRow{Subject: string(plaintextMarker)})
+		is.NoErr(err)
+	}
+	return owner
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+	owner := storeBackupTestRows(t, db)
+
+	backup := bytes.Buffer{}
+	header, err := kvs.Backup(db, &backup)
+	is.NoErr(err)
+	is.Equal(header.SchemaVersion, uint32(1))
+	is.True(!header.Encrypted)
+
+	restored, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer restored.Close()
+
+	header, err = kvs.Restore(restored, &backup)
+	is.NoErr(err)
+	is.Equal(header.SchemaVersion, uint32(1))
+
+	version, err := kvs.SchemaVersion(restored)
+	is.NoErr(err)
+	is.Equal(version, uint32(1))
+
+	rows, err := kvs.NewRepo[queryTestRow](restored, "messages").FetchByOwner(owner)
+	is.NoErr(err)
+	is.Equal(len(rows), 3)
+	is.Equal(rows[2].Subject, string(plaintextMarker))
+
+	id, err := restored.NextRowID("messages")
+	is.NoErr(err)
+	is.Equal(id, uint32(3)) // the row sequence should be restored along with the rows
+
+	_, err = kvs.Restore(restored, bytes.NewReader(backup.Bytes()))
+	is.True(err != nil) // restoring into a store which already has keys should be refused
+}
+
+func TestRestoreDetectsTamperedValues(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+	storeBackupTestRows(t, db)
+
+	backup := bytes.Buffer{}
+	_, err = kvs.Backup(db, &backup)
+	is.NoErr(err)
+
+	b := backup.Bytes()
+	i := bytes.Index(b, plaintextMarker)
+	is.True(i > 0)
+	b[i] = 'T'
+
+	restored, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer restored.Close()
+
+	_, err = kvs.Restore(restored, bytes.NewReader(b))
+	is.True(errors.Is(err, kvs.ErrBackupCorrupt))
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	is := is.New(t)
+
+	dir := filepath.Join(t.TempDir(), "store")
+	key, err := kvs.DeriveKeyFromPassphrase(dir, []byte("correct horse battery staple"))
+	is.NoErr(err)
+
+	db, err := kvs.NewDiskDB(dir, key)
+	is.NoErr(err)
+	owner := storeBackupTestRows(t, db)
+
+	backup := bytes.Buffer{}
+	header, err := kvs.Backup(db, &backup)
+	is.NoErr(err)
+	is.NoErr(db.Close())
+	is.True(header.Encrypted)
+	is.True(len(header.Salt) > 0)
+	is.True(!bytes.Contains(backup.Bytes(), plaintextMarker))
+
+	restoreDir := filepath.Join(t.TempDir(), "restored")
+	header, err = kvs.ReadBackupHeader(bytes.NewReader(backup.Bytes()))
+	is.NoErr(err)
+	is.NoErr(kvs.RestoreSalt(restoreDir, header))
+
+	restoredKey, err := kvs.DeriveKeyFromPassphrase(restoreDir, []byte("correct horse battery staple"))
+	is.NoErr(err)
+	is.Equal(restoredKey, key) // the same passphrase should derive the same key from the restored salt
+
+	restored, err := kvs.NewDiskDB(restoreDir, restoredKey)
+	is.NoErr(err)
+	defer restored.Close()
+
+	_, err = kvs.Restore(restored, bytes.NewReader(backup.Bytes()))
+	is.NoErr(err)
+
+	rows, err := kvs.NewRepo[queryTestRow](restored, "messages").FetchByOwner(owner)
+	is.NoErr(err)
+	is.Equal(len(rows), 3)
+}
+
+func TestEncryptedBackupRefusesWrongKeyAndTruncation(t *testing.T) {
+	is := is.New(t)
+
+	key, err := kvs.DeriveKeyFromRootKey([32]byte{1})
+	is.NoErr(err)
+	db, err := kvs.NewDiskDB(t.TempDir(), key)
+	is.NoErr(err)
+	defer db.Close()
+	storeBackupTestRows(t, db)
+
+	backup := bytes.Buffer{}
+	_, err = kvs.Backup(db, &backup)
+	is.NoErr(err)
+
+	unencrypted, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer unencrypted.Close()
+	_, err = kvs.Restore(unencrypted, bytes.NewReader(backup.Bytes()))
+	is.True(err != nil)
+
+	wrongKey, err := kvs.DeriveKeyFromRootKey([32]byte{2})
+	is.NoErr(err)
+	wrong, err := kvs.NewDiskDB(t.TempDir(), wrongKey)
+	is.NoErr(err)
+	defer wrong.Close()
+	_, err = kvs.Restore(wrong, bytes.NewReader(backup.Bytes()))
+	is.True(err != nil)
+
+	truncated, err := kvs.NewDiskDB(t.TempDir(), key)
+	is.NoErr(err)
+	defer truncated.Close()
+	_, err = kvs.Restore(truncated, bytes.NewReader(backup.Bytes()[:backup.Len()-10]))
+	is.True(errors.Is(err, kvs.ErrBackupCorrupt))
+}
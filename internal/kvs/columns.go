@@ -0,0 +1,162 @@
+package kvs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encoder is implemented by types which provide their own stored representation.
+type Encoder interface {
+	EncodeKVS() ([]byte, error)
+}
+
+// Decoder is implemented by types which can restore themselves from the
+// representation produced by their Encoder.
+type Decoder interface {
+	DecodeKVS(data []byte) error
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	encoderType = reflect.TypeOf((*Encoder)(nil)).Elem()
+)
+
+type column struct {
+	name  string
+	field string
+	index []int
+	opts  mdbFieldOptions
+}
+
+type typeColumns struct {
+	columns []column
+	byName  map[string]column
+	err     error
+}
+
+// columnCache holds the resolved columns for each struct type so
+// the reflection walk only happens the first time a type is seen.
+var columnCache sync.Map
+
+// resolveColumns lists every stored column of struct type t, fields which
+// are themselves structs are flattened into dotted column names.
+func resolveColumns(t reflect.Type) ([]column, error) {
+	tc := resolveTypeColumns(t)
+	return tc.columns, tc.err
+}
+
+func resolveColumn(t reflect.Type, nameToMatch string) (column, bool, error) {
+	tc := resolveTypeColumns(t)
+	if tc.err != nil {
+		return column{}, false, tc.err
+	}
+	c, ok := tc.byName[nameToMatch]
+	return c, ok, nil
+}
+
+func resolveTypeColumns(t reflect.Type) typeColumns {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if tc, ok := columnCache.Load(t); ok {
+		return tc.(typeColumns)
+	}
+
+	columns, err := appendColumns(nil, t, "", "", nil)
+	if err != nil {
+		tc := typeColumns{err: fmt.Errorf("%s: %w", t, err)}
+		columnCache.Store(t, tc)
+		return tc
+	}
+
+	tc := typeColumns{columns: columns, byName: make(map[string]column, len(columns))}
+	for _, c := range tc.columns {
+		if existing, ok := tc.byName[c.name]; ok {
+			tc.err = fmt.Errorf("%s: fields %s and %s both use column name %q", t, existing.field, c.field, c.name)
+			break
+		}
+		tc.byName[c.name] = c
+	}
+
+	columnCache.Store(t, tc)
+	return tc
+}
+
+func appendColumns(columns []column, t reflect.Type, prefix, fieldPrefix string, parentIndex []int) ([]column, error) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// the exported fields of unexported embedded structs are still reachable
+		if !f.IsExported() && !(f.Anonymous && isFlattenedStruct(f.Type)) {
+			continue
+		}
+
+		fOpts := resolveFieldOptions(f)
+		if fOpts.Ignore {
+			continue
+		}
+
+		index := append(append([]int{}, parentIndex...), i)
+		name := prefix + fOpts.Column
+		field := fieldPrefix + f.Name
+
+		// the dots of a key separate its table, column, owner and row, only
+		// the columns of flattened structs are joined by them
+		if len(fOpts.Column) == 0 || strings.Contains(fOpts.Column, ".") {
+			return nil, fmt.Errorf("field %s has invalid column name %q", field, fOpts.Column)
+		}
+
+		if isFlattenedStruct(f.Type) {
+			var err error
+			if columns, err = appendColumns(columns, f.Type, name+".", field+".", index); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		columns = append(columns, column{name: name, field: field, index: index, opts: fOpts})
+	}
+
+	return columns, nil
+}
+
+func isFlattenedStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	return !t.Implements(encoderType) && !reflect.PointerTo(t).Implements(encoderType)
+}
+
+type mdbFieldOptions struct {
+	Ignore bool
+	Index  bool
+	// Redact hides the stored value whenever the store is inspected.
+	Redact bool
+	// Column is the stored name of the field, it defaults to the lower
+	// cased field name and is kept exactly as given when set by a tag,
+	// which must neither be empty nor contain a dot.
+	Column string
+}
+
+// resolveFieldOptions parses the comma separated options of a field's
+// mdb tag, for example `mdb:"column=messageID,index"`.
+func resolveFieldOptions(f reflect.StructField) mdbFieldOptions {
+	opts := mdbFieldOptions{Column: strings.ToLower(f.Name)}
+	for _, opt := range strings.Split(f.Tag.Get("mdb"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch key {
+		case "ignore":
+			opts.Ignore = true
+		case "index":
+			opts.Index = true
+		case "redact":
+			opts.Redact = true
+		case "column":
+			opts.Column = value
+		}
+	}
+	return opts
+}
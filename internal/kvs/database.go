@@ -10,23 +10,53 @@ import (
 
 type DB struct {
 	conn *badger.DB
+	seqs *sequences
 }
 
 func NewDB(db *badger.DB) (DB, error) {
-	return newDB(false)
+	return DB{conn: db, seqs: newSequences()}, nil
 }
 
 func NewMemDB() (DB, error) {
-	return newDB(true)
+	return newDB(badger.DefaultOptions("").WithInMemory(true))
 }
 
-func newDB(inMemory bool) (DB, error) {
-	db, err := badger.Open(badger.DefaultOptions("").WithLogger(nil).WithInMemory(inMemory))
+// NewMemDBWithMemTableSize behaves the same as NewMemDB, only with a memtable
+// of the given size. Badger caps a transaction at 15% of the memtable, so a
+// small one lets tests exceed a single transaction without storing much.
+func NewMemDBWithMemTableSize(size int64) (DB, error) {
+	// values stored inline have to fit within a transaction
+	return newDB(badger.DefaultOptions("").WithInMemory(true).WithMemTableSize(size).WithValueThreshold(size / 100))
+}
+
+// NewDiskDB opens the store kept within dir, when encryptionKey is
+// not empty everything badger writes to disk is encrypted with it.
+func NewDiskDB(dir string, encryptionKey []byte) (DB, error) {
+	return newDB(diskOptions(dir, encryptionKey))
+}
+
+func newDB(opts badger.Options) (DB, error) {
+	db, err := badger.Open(opts.WithLogger(nil))
 	if err != nil {
 		return DB{}, err
 	}
 
-	return DB{conn: db}, nil
+	return NewDB(db)
+}
+
+// NextRowID returns the next unused row ID of the given table, IDs only ever
+// increase, including across separate instances of the same store.
+func (db DB) NextRowID(tableName string) (uint32, error) {
+	seq, err := db.seqs.get(db.conn, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := seq.Next()
+	if err != nil {
+		return 0, err
+	}
+	return uint32(id), nil
 }
 
 func (db DB) GetSeq(key []byte, bandwidth uint64) (*badger.Sequence, error) {
@@ -66,6 +96,11 @@ func (db DB) DumpToStdout() error {
 	return db.DumpTo(os.Stdout)
 }
 
+// Close releases every table's row sequence before closing the store.
 func (db DB) Close() error {
-	return db.conn.Close()
+	seqErr := db.seqs.releaseAll()
+	if err := db.conn.Close(); err != nil {
+		return err
+	}
+	return seqErr
 }
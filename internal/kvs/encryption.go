@@ -0,0 +1,125 @@
+package kvs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v3"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	encryptionKeySize = 32
+	// badger requires an index cache when encryption is enabled,
+	// otherwise every table index would be decrypted on each read
+	encryptedIndexCacheSize = 64 << 20
+	saltFileName            = "KEYSALT"
+)
+
+func diskOptions(dir string, encryptionKey []byte) badger.Options {
+	opts := badger.DefaultOptions(dir)
+	if len(encryptionKey) > 0 {
+		opts = opts.WithEncryptionKey(encryptionKey).WithIndexCacheSize(encryptedIndexCacheSize)
+	}
+	return opts
+}
+
+// DeriveKeyFromRootKey derives the store's encryption key from the config root
+// key, so that the root key itself is never handed to badger directly.
+func DeriveKeyFromRootKey(rootKey [32]byte) ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, rootKey[:], nil, []byte("maildew kvs encryption")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeriveKeyFromPassphrase stretches passphrase with Argon2id using
+// the salt kept alongside the store in dir, creating it if needed.
+func DeriveKeyFromPassphrase(dir string, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+
+	salt, err := resolveSalt(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return argon2.IDKey(passphrase, salt, 3, 64*1024, 4, encryptionKeySize), nil
+}
+
+func resolveSalt(dir string) ([]byte, error) {
+	path := filepath.Join(dir, saltFileName)
+	salt, err := os.ReadFile(path)
+	if err == nil {
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	salt = make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return salt, os.WriteFile(path, salt, 0o600)
+}
+
+// Rekey re-encrypts every value in the store kept within dir by streaming it
+// into a fresh copy encrypted with newKey, which then replaces the original.
+// An empty key on either side means the store is, or will be, unencrypted.
+func Rekey(dir string, oldKey, newKey []byte) error {
+	// streaming a store requires it to be opened in managed mode
+	src, err := badger.OpenManaged(diskOptions(dir, oldKey).WithLogger(nil))
+	if err != nil {
+		return fmt.Errorf("unable to open store with current key: %w", err)
+	}
+
+	dest := filepath.Clean(dir) + ".rekey"
+	if err := os.RemoveAll(dest); err != nil {
+		src.Close()
+		return err
+	}
+
+	if err := src.StreamDB(diskOptions(dest, newKey).WithLogger(nil)); err != nil {
+		src.Close()
+		os.RemoveAll(dest)
+		return fmt.Errorf("unable to re-encrypt store: %w", err)
+	}
+
+	if err := src.Close(); err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+
+	// the salt is not part of the store itself so has to be carried over
+	if salt, err := os.ReadFile(filepath.Join(dir, saltFileName)); err == nil {
+		if err := os.WriteFile(filepath.Join(dest, saltFileName), salt, 0o600); err != nil {
+			os.RemoveAll(dest)
+			return err
+		}
+	}
+
+	old := filepath.Clean(dir) + ".old"
+	if err := os.Rename(dir, old); err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+	if err := os.Rename(dest, dir); err != nil {
+		os.Rename(old, dir)
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
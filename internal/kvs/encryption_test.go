@@ -0,0 +1,145 @@
+package kvs_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+var plaintextMarker = []byte("this is a deeply confidential message body")
+
+func storePlaintext(t *testing.T, dir string, key []byte) {
+	t.Helper()
+	is := is.New(t)
+
+	db, err := kvs.NewDiskDB(dir, key)
+	is.NoErr(err)
+
+	is.NoErr(kvs.Store(db, kvs.Entry{TableName: "messages", ColumnName: "body", OwnerUUID: kvs.RootOwner{}, Data: plaintextMarker}))
+	// large enough to be written to the value log rather than inlined into the LSM tree
+	is.NoErr(kvs.Store(db, kvs.Entry{
+		TableName: "messages", ColumnName: "body", OwnerUUID: kvs.RootOwner{}, RowID: 1,
+		Data: bytes.Repeat(plaintextMarker, (2<<20)/len(plaintextMarker)),
+	}))
+	is.NoErr(db.Close())
+}
+
+func filesContaining(t *testing.T, dir string, needle []byte) []string {
+	t.Helper()
+
+	found := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(b, needle) {
+			found = append(found, filepath.Base(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestUnencryptedDiskDBContainsPlaintext(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	storePlaintext(t, dir, nil)
+
+	// sanity check that the way we look for plaintext actually finds it
+	is.True(len(filesContaining(t, dir, plaintextMarker)) > 0)
+}
+
+func TestEncryptedDiskDBContainsNoPlaintext(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	key, err := kvs.DeriveKeyFromRootKey([32]byte{1, 2, 3})
+	is.NoErr(err)
+
+	storePlaintext(t, dir, key)
+
+	is.Equal(filesContaining(t, dir, plaintextMarker), []string{})
+
+	db, err := kvs.NewDiskDB(dir, key)
+	is.NoErr(err)
+	defer db.Close()
+
+	e := kvs.Entry{TableName: "messages", ColumnName: "body", OwnerUUID: kvs.RootOwner{}}
+	is.NoErr(kvs.Get(db, &e))
+	is.Equal(e.Data, plaintextMarker)
+}
+
+func TestEncryptedDiskDBRefusesWrongKey(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	key, err := kvs.DeriveKeyFromPassphrase(dir, []byte("correct horse battery staple"))
+	is.NoErr(err)
+	storePlaintext(t, dir, key)
+
+	wrongKey, err := kvs.DeriveKeyFromPassphrase(dir, []byte("incorrect horse"))
+	is.NoErr(err)
+
+	_, err = kvs.NewDiskDB(dir, wrongKey)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "Encryption key mismatch"))
+
+	sameKey, err := kvs.DeriveKeyFromPassphrase(dir, []byte("correct horse battery staple"))
+	is.NoErr(err)
+	is.Equal(sameKey, key) // the same passphrase should derive the same key from the stored salt
+}
+
+func TestRekeyReEncryptsStore(t *testing.T) {
+	is := is.New(t)
+
+	dir := filepath.Join(t.TempDir(), "store")
+	oldKey, err := kvs.DeriveKeyFromPassphrase(dir, []byte("first passphrase"))
+	is.NoErr(err)
+	storePlaintext(t, dir, oldKey)
+
+	newKey, err := kvs.DeriveKeyFromPassphrase(dir, []byte("second passphrase"))
+	is.NoErr(err)
+	is.NoErr(kvs.Rekey(dir, oldKey, newKey))
+
+	_, err = kvs.NewDiskDB(dir, oldKey)
+	is.True(err != nil) // old key should no longer open the store
+
+	is.Equal(filesContaining(t, dir, plaintextMarker), []string{})
+
+	db, err := kvs.NewDiskDB(dir, newKey)
+	is.NoErr(err)
+	defer db.Close()
+
+	e := kvs.Entry{TableName: "messages", ColumnName: "body", OwnerUUID: kvs.RootOwner{}, RowID: 1}
+	is.NoErr(kvs.Get(db, &e))
+	is.True(bytes.HasPrefix(e.Data, plaintextMarker))
+
+	_, err = os.Stat(filepath.Join(dir, "KEYSALT"))
+	is.NoErr(err) // salt should have been carried over to the rekeyed store
+}
+
+func TestRekeyEncryptsPreviouslyUnencryptedStore(t *testing.T) {
+	is := is.New(t)
+
+	dir := filepath.Join(t.TempDir(), "store")
+	storePlaintext(t, dir, nil)
+
+	newKey, err := kvs.DeriveKeyFromRootKey([32]byte{9, 9, 9})
+	is.NoErr(err)
+	is.NoErr(kvs.Rekey(dir, nil, newKey))
+
+	is.Equal(filesContaining(t, dir, plaintextMarker), []string{})
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/google/uuid"
@@ -40,10 +41,16 @@ func (e Entry) resolveOwnerID() string {
 
 func Store(db DB, e Entry) error {
 	return db.conn.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(e.Key()), e.Data)
+		return setValue(txn, []byte(e.Key()), e.Data)
 	})
 }
 
+// setValue marks the stored value with userMetaValue, which lets change
+// subscribers tell an empty value apart from a deleted one.
+func setValue(txn *badger.Txn, key, data []byte) error {
+	return txn.SetEntry(badger.NewEntry(key, data).WithMeta(userMetaValue))
+}
+
 func Get(db DB, e *Entry) error {
 	return db.conn.View(func(txn *badger.Txn) error {
 		lookupKey := e.Key()
@@ -63,12 +70,12 @@ func Get(db DB, e *Entry) error {
 	})
 }
 
-func ConvertToBlankEntries(tableName string, ownerID, rowID uint32, x interface{}) []Entry {
+func ConvertToBlankEntries(tableName string, ownerID, rowID uint32, x interface{}) ([]Entry, error) {
 	v := reflect.ValueOf(x)
 	return convertToEntries(tableName, ownerID, rowID, v, false)
 }
 
-func ConvertToEntries(tableName string, ownerID, rowID uint32, x interface{}) []Entry {
+func ConvertToEntries(tableName string, ownerID, rowID uint32, x interface{}) ([]Entry, error) {
 	v := reflect.ValueOf(x)
 	return convertToEntries(tableName, ownerID, rowID, v, true)
 }
@@ -81,12 +88,12 @@ type RootOwner struct{}
 
 func (o RootOwner) String() string { return "root" }
 
-func ConvertToBlankEntriesWithUUID(tableName string, ownerID UUID, rowID uint32, x interface{}) []Entry {
+func ConvertToBlankEntriesWithUUID(tableName string, ownerID UUID, rowID uint32, x interface{}) ([]Entry, error) {
 	v := reflect.ValueOf(x)
 	return convertToEntriesWithUUID(tableName, 0, rowID, ownerID, v, false)
 }
 
-func ConvertToEntriesWithUUID(tableName string, ownerID UUID, rowID uint32, x interface{}) []Entry {
+func ConvertToEntriesWithUUID(tableName string, ownerID UUID, rowID uint32, x interface{}) ([]Entry, error) {
 	v := reflect.ValueOf(x)
 	return convertToEntriesWithUUID(tableName, 0, rowID, ownerID, v, true)
 }
@@ -109,17 +116,15 @@ func LoadEntry(s interface{}, entry Entry) error {
 }
 
 func resolveFieldRef(v reflect.Value, nameToMatch string) (reflect.Value, error) {
-	t := v.Type()
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-
-		if strings.EqualFold(field.Name, nameToMatch) {
-			return v.Field(i), nil
-		}
+	c, ok, err := resolveColumn(v.Type(), nameToMatch)
+	if err != nil {
+		return reflect.Zero(reflect.TypeOf(v)), err
+	}
+	if !ok {
+		return reflect.Zero(reflect.TypeOf(v)), fmt.Errorf("struct does not have a field with name %q", nameToMatch)
 	}
 
-	return reflect.Zero(reflect.TypeOf(v)), fmt.Errorf("struct does not have a field with name %q", nameToMatch)
+	return v.FieldByIndex(c.index), nil
 }
 
 func LoadEntries(s interface{}, entries []Entry) error {
@@ -132,33 +137,30 @@ func LoadEntries(s interface{}, entries []Entry) error {
 	return nil
 }
 
-func convertToEntriesWithUUID(tableName string, ownerID, rowID uint32, ownerUUID UUID, v reflect.Value, includeData bool) []Entry {
-	entries := []Entry{}
-
+func convertToEntriesWithUUID(tableName string, ownerID, rowID uint32, ownerUUID UUID, v reflect.Value, includeData bool) ([]Entry, error) {
 	if v.Kind() == reflect.Pointer {
 		v = v.Elem()
 	}
-	for i := 0; i < v.NumField(); i++ {
-		vv := reflect.Indirect(v)
-		f := vv.Type().Field(i)
 
-		fOpts := resolveFieldOptions(f)
-		if fOpts.Ignore {
-			continue
-		}
+	columns, err := resolveColumns(v.Type())
+	if err != nil {
+		return nil, err
+	}
 
+	entries := make([]Entry, 0, len(columns))
+	for _, c := range columns {
 		e := Entry{
 			TableName:  tableName,
-			ColumnName: strings.ToLower(f.Name),
+			ColumnName: c.name,
 			OwnerID:    ownerID,
 			OwnerUUID:  ownerUUID,
 			RowID:      rowID,
 		}
 
 		if includeData {
-			bd, err := convertToBytes(v.Field(i).Interface())
+			bd, err := convertToBytes(encodableValue(v.FieldByIndex(c.index)))
 			if err != nil {
-				return entries
+				return nil, fmt.Errorf("unable to convert column %s: %w", c.name, err)
 			}
 			e.Data = bd
 		}
@@ -166,25 +168,55 @@ func convertToEntriesWithUUID(tableName string, ownerID, rowID uint32, ownerUUID
 		entries = append(entries, e)
 	}
 
-	return entries
+	return entries, nil
+}
+
+// encodableValue returns the field's value, or a pointer to
+// it if only the pointer type satisfies the Encoder interface.
+func encodableValue(fv reflect.Value) interface{} {
+	if fv.Type().Implements(encoderType) || !reflect.PointerTo(fv.Type()).Implements(encoderType) {
+		return fv.Interface()
+	}
+
+	if fv.CanAddr() {
+		return fv.Addr().Interface()
+	}
+
+	ptr := reflect.New(fv.Type())
+	ptr.Elem().Set(fv)
+	return ptr.Interface()
 }
 
-// TODO:(tauraamui): come up with novel and well designed method of preserving full upper casing
-func convertToEntries(tableName string, ownerID, rowID uint32, v reflect.Value, includeData bool) []Entry {
+// Column names default to the lower cased field name, an explicit name with
+// its casing preserved can be given with the `mdb:"column=name"` tag option.
+func convertToEntries(tableName string, ownerID, rowID uint32, v reflect.Value, includeData bool) ([]Entry, error) {
 	return convertToEntriesWithUUID(tableName, ownerID, rowID, uuid.UUID{}, v, includeData)
 }
 
 func convertToBytes(i interface{}) ([]byte, error) {
 	// Check the type of the interface.
 	switch v := i.(type) {
+	case Encoder:
+		// Let the type provide its own representation.
+		return v.EncodeKVS()
 	case []byte:
 		// Return the input as a []byte if it is already a []byte.
 		return v, nil
 	case string:
 		// Convert the string to a []byte and return it.
 		return []byte(v), nil
+	case time.Time:
+		// Use RFC 3339 with nanoseconds so the location offset is kept.
+		return v.MarshalText()
+	case []string:
+		// Encode string slices as a JSON array so elements may contain any character.
+		if v == nil {
+			return []byte("[]"), nil
+		}
+		return json.Marshal(v)
 	default:
-		// Use json.Marshal to convert the interface to a []byte.
+		// Use json.Marshal to convert the interface to a []byte,
+		// this covers numbers, bools, maps and any other slices.
 		return json.Marshal(v)
 	}
 }
@@ -197,6 +229,9 @@ func convertFromBytes(data []byte, i interface{}) error {
 
 	// Check the type of the interface.
 	switch v := i.(type) {
+	case Decoder:
+		// Let the type restore itself from its own representation.
+		return v.DecodeKVS(data)
 	case *[]byte:
 		// Set the value of the interface to the []byte if it is a pointer to a []byte.
 		*v = data
@@ -205,6 +240,9 @@ func convertFromBytes(data []byte, i interface{}) error {
 		// Convert the []byte to a string and set the value of the interface to the string.
 		*v = string(data)
 		return nil
+	case *time.Time:
+		// Parse the RFC 3339 timestamp and set the value of the interface to it.
+		return v.UnmarshalText(data)
 	case *UUID:
 		// Convert the []byte to a UUID instance and set the value of the interface to it.
 		uuidv, err := uuid.ParseBytes(data)
@@ -218,14 +256,3 @@ func convertFromBytes(data []byte, i interface{}) error {
 		return json.Unmarshal(data, v)
 	}
 }
-
-type mdbFieldOptions struct {
-	Ignore bool
-}
-
-func resolveFieldOptions(f reflect.StructField) mdbFieldOptions {
-	mdbTagValue := f.Tag.Get("mdb")
-	return mdbFieldOptions{
-		Ignore: strings.Contains(mdbTagValue, "ignore"),
-	}
-}
@@ -1,6 +1,7 @@
 package kvs
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/matryer/is"
@@ -61,3 +62,20 @@ func TestConvertStructFromBytes(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(destination, TestStruct{A: 5, B: "hello"})
 }
+
+func TestResolveColumnsIsCachedPerType(t *testing.T) {
+	is := is.New(t)
+
+	type cached struct {
+		A string
+		B int
+	}
+
+	first, err := resolveColumns(reflect.TypeOf(cached{}))
+	is.NoErr(err)
+	second, err := resolveColumns(reflect.TypeOf(&cached{}))
+	is.NoErr(err)
+
+	is.Equal(len(first), 2)
+	is.Equal(&first[0], &second[0]) // columns should not have been resolved a second time
+}
@@ -1,7 +1,9 @@
 package kvs_test
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/matryer/is"
@@ -92,7 +94,8 @@ func TestConvertToEntries(t *testing.T) {
 		Bar: 4,
 	}
 
-	e := kvs.ConvertToEntries("test", 0, 0, source)
+	e, err := kvs.ConvertToEntries("test", 0, 0, source)
+	is.NoErr(err)
 	is.Equal(len(e), 2)
 
 	is = is.NewRelaxed(t)
@@ -186,3 +189,213 @@ func TestSequences(t *testing.T) {
 	is.NoErr(err) // error occurred when aquiring next iter value
 	is.Equal(id, uint64(2))
 }
+
+type testEnvelope struct {
+	Subject string
+	From    []string
+}
+
+type testEmbedded struct {
+	Folder string
+}
+
+type testFlags uint8
+
+func (f testFlags) EncodeKVS() ([]byte, error) {
+	return []byte(fmt.Sprintf("flags:%d", f)), nil
+}
+
+func (f *testFlags) DecodeKVS(data []byte) error {
+	_, err := fmt.Sscanf(string(data), "flags:%d", f)
+	return err
+}
+
+type testRoundTrip struct {
+	testEmbedded
+	ID       uint32 `mdb:"ignore"`
+	Name     string
+	Count    int
+	Date     time.Time
+	Tags     []string
+	Headers  map[string]string
+	Envelope testEnvelope
+	Flags    testFlags
+}
+
+func TestConvertToEntriesFlattensNestedStructs(t *testing.T) {
+	is := is.New(t)
+
+	e, err := kvs.ConvertToBlankEntries("messages", 0, 0, testRoundTrip{})
+	is.NoErr(err)
+
+	columns := []string{}
+	for _, ent := range e {
+		columns = append(columns, ent.ColumnName)
+	}
+
+	is.Equal(columns, []string{
+		"testembedded.folder", "name", "count", "date", "tags", "headers",
+		"envelope.subject", "envelope.from", "flags",
+	})
+}
+
+func TestConvertToEntriesEncodesFieldTypes(t *testing.T) {
+	is := is.New(t)
+
+	e, err := kvs.ConvertToEntries("messages", 0, 0, testRoundTrip{
+		Date:    time.Date(2023, 1, 31, 9, 30, 0, 0, time.UTC),
+		Tags:    []string{"\\Seen", "work, urgent"},
+		Headers: map[string]string{"X-Mailer": "dew"},
+		Flags:   3,
+	})
+	is.NoErr(err)
+
+	data := map[string]string{}
+	for _, ent := range e {
+		data[ent.ColumnName] = string(ent.Data)
+	}
+
+	is.Equal(data["date"], "2023-01-31T09:30:00Z")
+	is.Equal(data["tags"], `["\\Seen","work, urgent"]`)
+	is.Equal(data["headers"], `{"X-Mailer":"dew"}`)
+	is.Equal(data["envelope.from"], "[]")
+	is.Equal(data["flags"], "flags:3")
+}
+
+func TestConvertToEntriesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input testRoundTrip
+	}{
+		{
+			name:  "scalar fields",
+			input: testRoundTrip{Name: "inbox", Count: -42, Tags: []string{}, Headers: map[string]string{}, Envelope: testEnvelope{From: []string{}}},
+		},
+		{
+			name: "time in UTC",
+			input: testRoundTrip{
+				Date: time.Date(2023, 2, 14, 8, 1, 2, 345, time.UTC),
+				Tags: []string{}, Headers: map[string]string{}, Envelope: testEnvelope{From: []string{}},
+			},
+		},
+		{
+			name: "string slices and maps",
+			input: testRoundTrip{
+				Tags:     []string{"\\Seen", "\\Flagged", "comma, separated"},
+				Headers:  map[string]string{"List-Id": "<dev.example.org>", "X-Priority": "1"},
+				Envelope: testEnvelope{From: []string{"Ann <ann@example.org>", "bob@example.org"}},
+			},
+		},
+		{
+			name: "embedded and nested structs",
+			input: testRoundTrip{
+				testEmbedded: testEmbedded{Folder: "Archive/2023"},
+				Tags:         []string{}, Headers: map[string]string{},
+				Envelope: testEnvelope{Subject: "Re: lunch?", From: []string{"ann@example.org"}},
+			},
+		},
+		{
+			name:  "custom encoder",
+			input: testRoundTrip{Flags: 7, Tags: []string{}, Headers: map[string]string{}, Envelope: testEnvelope{From: []string{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			entries, err := kvs.ConvertToEntries("messages", 0, 0, tt.input)
+			is.NoErr(err)
+
+			output := testRoundTrip{}
+			is.NoErr(kvs.LoadEntries(&output, entries))
+			is.Equal(output, tt.input)
+		})
+	}
+}
+
+func TestConvertToEntriesRoundTripTimeKeepsOffset(t *testing.T) {
+	is := is.New(t)
+
+	input := testRoundTrip{Date: time.Date(2023, 2, 14, 8, 1, 2, 0, time.FixedZone("", 5*60*60))}
+	entries, err := kvs.ConvertToEntries("messages", 0, 0, input)
+	is.NoErr(err)
+
+	output := testRoundTrip{}
+	is.NoErr(kvs.LoadEntries(&output, entries))
+
+	is.True(output.Date.Equal(input.Date))
+	_, offset := output.Date.Zone()
+	is.Equal(offset, 5*60*60)
+}
+
+func TestConvertToEntriesUsesExplicitColumnNames(t *testing.T) {
+	is := is.New(t)
+
+	type message struct {
+		MessageID string    `mdb:"column=MessageID"`
+		MessageId string    `mdb:"column=legacyMessageId"`
+		Date      time.Time `mdb:"index,column=sentAt"`
+	}
+
+	input := message{MessageID: "<1@example.org>", MessageId: "<2@example.org>"}
+	e, err := kvs.ConvertToEntries("messages", 0, 0, input)
+	is.NoErr(err)
+	is.Equal(len(e), 3)
+	is.Equal(e[0].ColumnName, "MessageID")
+	is.Equal(e[1].ColumnName, "legacyMessageId")
+	is.Equal(e[2].ColumnName, "sentAt")
+
+	output := message{}
+	is.NoErr(kvs.LoadEntries(&output, e))
+	is.Equal(output, input)
+}
+
+func TestConvertToEntriesRejectsDuplicateColumnNames(t *testing.T) {
+	is := is.New(t)
+
+	type message struct {
+		MessageID string
+		MessageId string
+	}
+
+	_, err := kvs.ConvertToEntries("messages", 0, 0, message{})
+	is.True(err != nil)
+	is.Equal(err.Error(), `kvs_test.message: fields MessageID and MessageId both use column name "messageid"`)
+
+	is.True(kvs.LoadEntry(&message{}, kvs.Entry{ColumnName: "messageid"}) != nil)
+}
+
+func TestLoadEntryMatchesColumnNameExactly(t *testing.T) {
+	is := is.New(t)
+
+	type message struct {
+		MessageID string `mdb:"column=MessageID"`
+	}
+
+	err := kvs.LoadEntry(&message{}, kvs.Entry{ColumnName: "messageid", Data: []byte("<1@example.org>")})
+	is.True(err != nil)
+	is.Equal(err.Error(), `struct does not have a field with name "messageid"`)
+}
+
+func TestConvertToEntriesRejectsInvalidColumnNames(t *testing.T) {
+	is := is.New(t)
+
+	type empty struct {
+		Subject string `mdb:"column="`
+	}
+	_, err := kvs.ConvertToEntries("messages", 0, 0, empty{})
+	is.True(err != nil)
+	is.Equal(err.Error(), `kvs_test.empty: field Subject has invalid column name ""`)
+
+	type dotted struct {
+		Envelope struct {
+			Subject string `mdb:"column=envelope.subject"`
+		}
+	}
+	_, err = kvs.ConvertToEntries("messages", 0, 0, dotted{})
+	is.True(err != nil)
+	is.Equal(err.Error(), `kvs_test.dotted: field Envelope.Subject has invalid column name "envelope.subject"`)
+
+	is.True(kvs.LoadEntry(&dotted{}, kvs.Entry{ColumnName: "envelope.subject"}) != nil)
+}
@@ -0,0 +1,249 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	indexKeyPrefix = "_idx"
+	rowIDKeyPrefix = "_rows"
+)
+
+// rowIDPrefixKey is the prefix of the keys listing the rows of a table which
+// belong to owner, of the form _rows.table.owner.row. The row ID is zero padded
+// so they sort by it, as the row ID of a column key is not.
+func rowIDPrefixKey(tableName string, owner UUID) []byte {
+	return []byte(fmt.Sprintf("%s.%s.%s.", rowIDKeyPrefix, tableName, Entry{OwnerUUID: owner}.resolveOwnerID()))
+}
+
+func rowIDKey(tableName string, owner UUID, rowID uint32) []byte {
+	return append(rowIDPrefixKey(tableName, owner), fmt.Sprintf("%010d", rowID)...)
+}
+
+// IndexEntry is a secondary index key for a column tagged with `mdb:"index"`.
+// Its key sorts by the column value and then by row ID, so ordered queries
+// can seek straight to the start of a page rather than scanning a table.
+type IndexEntry struct {
+	TableName  string
+	ColumnName string
+	OwnerUUID  UUID
+	RowID      uint32
+	Value      []byte
+}
+
+func (e IndexEntry) PrefixKey() []byte {
+	owner := Entry{OwnerUUID: e.OwnerUUID}.resolveOwnerID()
+	return []byte(fmt.Sprintf("%s.%s.%s.%s.", indexKeyPrefix, e.TableName, e.ColumnName, owner))
+}
+
+func (e IndexEntry) Key() []byte {
+	// hex keeps the byte ordering of the value whilst guaranteeing
+	// it can never contain the '.' separator
+	return append(e.PrefixKey(), fmt.Sprintf("%x.%010d", e.Value, e.RowID)...)
+}
+
+func StoreIndex(db DB, e IndexEntry) error {
+	return db.conn.Update(func(txn *badger.Txn) error {
+		return txn.Set(e.Key(), nil)
+	})
+}
+
+func ConvertToIndexEntriesWithUUID(tableName string, ownerID UUID, rowID uint32, x interface{}) ([]IndexEntry, error) {
+	entries := []IndexEntry{}
+
+	v := reflect.Indirect(reflect.ValueOf(x))
+	columns, err := resolveColumns(v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range columns {
+		if !c.opts.Index {
+			continue
+		}
+
+		iv, err := convertToIndexValue(v.FieldByIndex(c.index))
+		if err != nil {
+			return nil, fmt.Errorf("unable to index column %s: %w", c.name, err)
+		}
+
+		entries = append(entries, IndexEntry{
+			TableName:  tableName,
+			ColumnName: c.name,
+			OwnerUUID:  ownerID,
+			RowID:      rowID,
+			Value:      iv,
+		})
+	}
+
+	return entries, nil
+}
+
+// storedOwner is an owner as it appears within a stored key.
+type storedOwner string
+
+func (o storedOwner) String() string { return string(o) }
+
+// Reindex stores the index entries of every row of the table within txn, for
+// migrations which tag columns with `mdb:"index"` after rows have been stored
+// in them. x is the type of the value stored in the table's rows. Tables too
+// large for a single transaction are reindexed with ReindexStep instead.
+func Reindex(txn *badger.Txn, tableName string, x interface{}) error {
+	prefix, err := firstColumnPrefix(tableName, x)
+	if err != nil || prefix == nil {
+		return err
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		rk, ok := parseRowKey(it.Item().Key())
+		if !ok {
+			continue
+		}
+		if err := reindexRow(txn, tableName, x, rk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReindexStep is the Step form of Reindex.
+func ReindexStep(tableName string, x interface{}) Step {
+	return rowStep(tableName, x, func(txn *badger.Txn, rk rowKey) error {
+		return reindexRow(txn, tableName, x, rk)
+	})
+}
+
+// RowIDsStep stores the row ID entry of every row of the table, which
+// rows stored before queries ordered by row ID seeked through them lack.
+func RowIDsStep(tableName string, x interface{}) Step {
+	return rowStep(tableName, x, func(txn *badger.Txn, rk rowKey) error {
+		return txn.Set(rowIDKey(tableName, storedOwner(rk.owner), rk.rowID), nil)
+	})
+}
+
+// rowStep is a Step calling fn once with every row of the table.
+func rowStep(tableName string, x interface{}, fn func(txn *badger.Txn, rk rowKey) error) Step {
+	prefix, err := firstColumnPrefix(tableName, x)
+	if err != nil || prefix == nil {
+		// the table's keys are only walked to give back the error
+		return Step{
+			Prefix: tableName + ".",
+			Apply:  func(*badger.Txn, []byte) error { return err },
+		}
+	}
+	return Step{
+		Prefix: string(prefix),
+		Apply: func(txn *badger.Txn, key []byte) error {
+			rk, ok := parseRowKey(key)
+			if !ok {
+				return nil
+			}
+			return fn(txn, rk)
+		},
+	}
+}
+
+// firstColumnPrefix returns the prefix of the keys of the first column of the
+// table's rows, which every row is expected to have stored, or nil if x has
+// no columns.
+func firstColumnPrefix(tableName string, x interface{}) ([]byte, error) {
+	t := reflect.TypeOf(x)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	blankEntries, err := ConvertToBlankEntriesWithUUID(tableName, RootOwner{}, 0, reflect.New(t).Interface())
+	if err != nil || len(blankEntries) == 0 {
+		return nil, err
+	}
+	return []byte(tableName + "." + blankEntries[0].ColumnName + "."), nil
+}
+
+// reindexRow stores the index entries of the given row.
+func reindexRow(txn *badger.Txn, tableName string, x interface{}, rk rowKey) error {
+	t := reflect.TypeOf(x)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	owner := storedOwner(rk.owner)
+	v := reflect.New(t).Interface()
+	if _, err := loadRow(txn, tableName, owner, rk.rowID, v); err != nil {
+		return err
+	}
+
+	indexEntries, err := ConvertToIndexEntriesWithUUID(tableName, owner, rk.rowID, v)
+	if err != nil {
+		return err
+	}
+	for _, e := range indexEntries {
+		if err := txn.Set(e.Key(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isIndexedColumn(x interface{}, columnName string) (bool, error) {
+	c, ok, err := resolveColumn(reflect.TypeOf(x), columnName)
+	return ok && c.opts.Index, err
+}
+
+func parseIndexKeyRowID(key []byte) (uint32, error) {
+	k := string(key)
+	rowID, err := strconv.ParseUint(k[strings.LastIndexByte(k, '.')+1:], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed index key %s: %w", key, err)
+	}
+	return uint32(rowID), nil
+}
+
+// convertToIndexValue encodes v such that comparing the resulting
+// bytes gives the same ordering as comparing the original values.
+func convertToIndexValue(v reflect.Value) ([]byte, error) {
+	if t, ok := v.Interface().(time.Time); ok {
+		return encodeInt64(t.UnixNano()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v.Uint())
+		return b, nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported index type %s", v.Type())
+}
+
+func encodeInt64(i int64) []byte {
+	b := make([]byte, 8)
+	// flipping the sign bit makes negative values sort before positive ones
+	binary.BigEndian.PutUint64(b, uint64(i)^(1<<63))
+	return b
+}
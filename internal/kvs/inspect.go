@@ -0,0 +1,286 @@
+package kvs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const RedactedValue = "<redacted>"
+
+type DumpOptions struct {
+	// Table and Owner limit the dump to a single table or
+	// owner, every row is dumped when they are left empty.
+	Table string
+	Owner string
+}
+
+type DumpedRow struct {
+	Table  string                 `json:"table"`
+	Owner  string                 `json:"owner"`
+	RowID  uint32                 `json:"row"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// Dump reads every matching row, the values of rows within registered tables
+// are decoded into their field's type and all other values are left raw.
+func Dump(db DB, tables Tables, opts DumpOptions) ([]DumpedRow, error) {
+	var prefix []byte
+	if len(opts.Table) > 0 {
+		prefix = []byte(opts.Table + ".")
+	}
+
+	rows := map[rowKey]*DumpedRow{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100, Prefix: prefix})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			k, ok := parseRowKey(it.Item().Key())
+			if !ok || (len(opts.Owner) > 0 && k.owner != opts.Owner) {
+				continue
+			}
+
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			rk := rowKey{table: k.table, owner: k.owner, rowID: k.rowID}
+			row, ok := rows[rk]
+			if !ok {
+				row = &DumpedRow{Table: k.table, Owner: k.owner, RowID: k.rowID, Values: map[string]interface{}{}}
+				rows[rk] = row
+			}
+
+			value, _ := decodeColumn(tables, k, data)
+			if value == nil {
+				value = rawValue(data)
+			}
+			row.Values[k.column] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dumped := make([]DumpedRow, 0, len(rows))
+	for _, row := range rows {
+		dumped = append(dumped, *row)
+	}
+	sort.Slice(dumped, func(i, j int) bool {
+		a, b := dumped[i], dumped[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		return a.RowID < b.RowID
+	})
+
+	return dumped, nil
+}
+
+// Inspect reads the value of a single key, decoding it the same way as Dump.
+func Inspect(db DB, tables Tables, key string) (interface{}, error) {
+	var value interface{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		value = rawValue(data)
+		if k, ok := parseRowKey([]byte(key)); ok {
+			if decoded, _ := decodeColumn(tables, k, data); decoded != nil {
+				value = decoded
+			}
+		}
+		return nil
+	})
+	return value, err
+}
+
+type TableStats struct {
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+	Keys  int    `json:"keys"`
+	// Bytes is the size of the table's keys and values, including
+	// those of the table's index entries and row sequence.
+	Bytes int64 `json:"bytes"`
+}
+
+func Stats(db DB) ([]TableStats, error) {
+	byTable := map[string]*TableStats{}
+	rows := map[rowKey]bool{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			table := keyTable(key)
+			if k, ok := parseRowKey(key); ok {
+				rows[rowKey{table: k.table, owner: k.owner, rowID: k.rowID}] = true
+			}
+
+			stats, ok := byTable[table]
+			if !ok {
+				stats = &TableStats{Table: table}
+				byTable[table] = stats
+			}
+			stats.Keys++
+			stats.Bytes += int64(len(key)) + it.Item().ValueSize()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range rows {
+		byTable[k.table].Rows++
+	}
+
+	stats := make([]TableStats, 0, len(byTable))
+	for _, s := range byTable {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Table < stats[j].Table })
+
+	return stats, nil
+}
+
+type Problem struct {
+	Key string
+	Err error
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("%s: %v", p.Key, p.Err)
+}
+
+// Verify reads every key within the store and reports each one which is
+// malformed, can not be decoded, or is an index or row ID entry for a missing row.
+func Verify(db DB, tables Tables) ([]Problem, error) {
+	problems := []Problem{}
+	rows := map[rowKey]bool{}
+	indexed := map[string]rowKey{}
+	listed := map[string]rowKey{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+
+			if bytes.HasPrefix(key, []byte(indexKeyPrefix+".")) {
+				k, ok := parseIndexKey(key)
+				if !ok {
+					problems = append(problems, Problem{Key: string(key), Err: errors.New("malformed index key")})
+					continue
+				}
+				indexed[string(key)] = rowKey{table: k.table, owner: k.owner, rowID: k.rowID}
+				continue
+			}
+
+			if bytes.HasPrefix(key, []byte(rowIDKeyPrefix+".")) {
+				k, ok := parseRowIDKey(key)
+				if !ok {
+					problems = append(problems, Problem{Key: string(key), Err: errors.New("malformed row ID key")})
+					continue
+				}
+				listed[string(key)] = k
+				continue
+			}
+
+			// sequences are kept under the bare table name and
+			// every other internal key starts with an underscore
+			if bytes.HasPrefix(key, []byte("_")) || !bytes.Contains(key, []byte(".")) {
+				continue
+			}
+
+			k, ok := parseRowKey(key)
+			if !ok {
+				problems = append(problems, Problem{Key: string(key), Err: errors.New("malformed row key")})
+				continue
+			}
+			rows[rowKey{table: k.table, owner: k.owner, rowID: k.rowID}] = true
+
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if _, err := decodeColumn(tables, k, data); err != nil {
+				problems = append(problems, Problem{Key: string(key), Err: err})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, rk := range indexed {
+		if !rows[rk] {
+			problems = append(problems, Problem{Key: key, Err: errors.New("index entry for missing row")})
+		}
+	}
+	for key, rk := range listed {
+		if !rows[rk] {
+			problems = append(problems, Problem{Key: key, Err: errors.New("row ID entry for missing row")})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+
+	return problems, nil
+}
+
+func decodeColumn(tables Tables, k rowKey, data []byte) (interface{}, error) {
+	t, ok := tables.Lookup(k.table)
+	if !ok {
+		return nil, fmt.Errorf("table %s is not registered", k.table)
+	}
+
+	c, ok, err := resolveColumn(t, k.column)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s does not have a column %q", t, k.column)
+	}
+
+	v := reflect.New(t)
+	err = LoadEntry(v.Interface(), Entry{TableName: k.table, ColumnName: k.column, Data: data})
+	// redacted values stay hidden even when they fail to decode
+	if c.opts.Redact {
+		return RedactedValue, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.Elem().FieldByIndex(c.index).Interface(), nil
+}
+
+// rawValue keeps values which are not valid text readable as hex.
+func rawValue(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return fmt.Sprintf("%x", data)
+}
@@ -0,0 +1,126 @@
+package kvs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+type inspectTestAccount struct {
+	Username string
+	Password string `mdb:"redact"`
+}
+
+func inspectTestTables() kvs.Tables {
+	tables := kvs.Tables{}
+	tables.Register("accounts", inspectTestAccount{})
+	tables.Register("messages", queryTestRow{})
+	return tables
+}
+
+func TestDumpDecodesAndRedactsRows(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	_, err = kvs.NewRepo[inspectTestAccount](db, "accounts").Save(kvs.RootOwner{}, inspectTestAccount{Username: "alice", Password: "hunter2"})
+	is.NoErr(err)
+
+	owner, other := uuid.New(), uuid.New()
+	date := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	messages := kvs.NewRepo[queryTestRow](db, "messages")
+	_, err = messages.Save(owner, queryTestRow{Subject: "hello", Date: date})
+	is.NoErr(err)
+	_, err = messages.Save(other, queryTestRow{Subject: "other", Date: date})
+	is.NoErr(err)
+
+	rows, err := kvs.Dump(db, inspectTestTables(), kvs.DumpOptions{Table: "accounts"})
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+	is.Equal(rows[0].Owner, "root")
+	is.Equal(rows[0].Values["username"], "alice")
+	is.Equal(rows[0].Values["password"], kvs.RedactedValue)
+
+	rows, err = kvs.Dump(db, inspectTestTables(), kvs.DumpOptions{Table: "messages", Owner: owner.String()})
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+	is.Equal(rows[0].Values["subject"], "hello")
+	is.Equal(rows[0].Values["date"], date) // values should be decoded into their field's type
+
+	rows, err = kvs.Dump(db, kvs.Tables{}, kvs.DumpOptions{})
+	is.NoErr(err)
+	is.Equal(len(rows), 3)
+	is.Equal(rows[0].Values["password"], "hunter2") // unregistered tables are left raw
+
+	value, err := kvs.Inspect(db, inspectTestTables(), "accounts.password.root.0")
+	is.NoErr(err)
+	is.Equal(value, kvs.RedactedValue)
+}
+
+func TestStatsCountsRowsPerTable(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	messages := kvs.NewRepo[queryTestRow](db, "messages")
+	for i := 0; i < 3; i++ {
+		_, err := messages.Save(uuid.New(), queryTestRow{Subject: "subject", Date: time.Now()})
+		is.NoErr(err)
+	}
+
+	stats, err := kvs.Stats(db)
+	is.NoErr(err)
+	is.Equal(len(stats), 1)
+	is.Equal(stats[0].Table, "messages")
+	is.Equal(stats[0].Rows, 3)
+	is.Equal(stats[0].Keys, 13) // two columns, one index and one row ID entry per row, plus the sequence
+	is.True(stats[0].Bytes > 0)
+}
+
+func TestVerifyReportsUndecodableValuesAndDanglingIndexEntries(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	messages := kvs.NewRepo[queryTestRow](db, "messages")
+	for i := 0; i < 2; i++ {
+		_, err := messages.Save(owner, queryTestRow{Subject: "subject", Date: time.Now()})
+		is.NoErr(err)
+	}
+
+	problems, err := kvs.Verify(db, inspectTestTables())
+	is.NoErr(err)
+	is.Equal(len(problems), 0)
+
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("messages.date."+owner.String()+".0"), []byte("not a date")); err != nil {
+			return err
+		}
+		if err := txn.Set([]byte("messages.unknown."+owner.String()+".0"), []byte("?")); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte("messages.subject." + owner.String() + ".1")); err != nil {
+			return err
+		}
+		return txn.Delete([]byte("messages.date." + owner.String() + ".1"))
+	}))
+
+	problems, err = kvs.Verify(db, inspectTestTables())
+	is.NoErr(err)
+	is.Equal(len(problems), 4)
+	is.Equal(problems[0].Err.Error(), "index entry for missing row")
+	is.Equal(problems[1].Err.Error(), "row ID entry for missing row")
+	is.Equal(problems[2].Key, "messages.date."+owner.String()+".0")
+	is.Equal(problems[3].Key, "messages.unknown."+owner.String()+".0")
+}
@@ -0,0 +1,86 @@
+package kvs
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+type rowKey struct {
+	table, column, owner string
+	rowID                uint32
+}
+
+// parseRowKey splits a key of the form table.column.owner.row, where the column
+// itself may contain dots. The internal keys of indexes, sequences and the
+// schema are not row keys.
+func parseRowKey(key []byte) (rowKey, bool) {
+	if bytes.HasPrefix(key, []byte("_")) {
+		return rowKey{}, false
+	}
+
+	parts := strings.Split(string(key), ".")
+	if len(parts) < 4 {
+		return rowKey{}, false
+	}
+
+	rowID, err := strconv.ParseUint(parts[len(parts)-1], 10, 32)
+	if err != nil {
+		return rowKey{}, false
+	}
+
+	return rowKey{
+		table:  parts[0],
+		column: strings.Join(parts[1:len(parts)-2], "."),
+		owner:  parts[len(parts)-2],
+		rowID:  uint32(rowID),
+	}, true
+}
+
+type indexKey struct {
+	table, column, owner string
+	rowID                uint32
+}
+
+// parseIndexKey splits a key of the form _idx.table.column.owner.value.row.
+func parseIndexKey(key []byte) (indexKey, bool) {
+	if !bytes.HasPrefix(key, []byte(indexKeyPrefix+".")) {
+		return indexKey{}, false
+	}
+
+	parts := strings.Split(string(key), ".")
+	if len(parts) < 6 {
+		return indexKey{}, false
+	}
+
+	rowID, err := strconv.ParseUint(parts[len(parts)-1], 10, 32)
+	if err != nil {
+		return indexKey{}, false
+	}
+
+	return indexKey{
+		table:  parts[1],
+		column: strings.Join(parts[2:len(parts)-3], "."),
+		owner:  parts[len(parts)-3],
+		rowID:  uint32(rowID),
+	}, true
+}
+
+// parseRowIDKey splits a key of the form _rows.table.owner.row.
+func parseRowIDKey(key []byte) (rowKey, bool) {
+	if !bytes.HasPrefix(key, []byte(rowIDKeyPrefix+".")) {
+		return rowKey{}, false
+	}
+
+	parts := strings.Split(string(key), ".")
+	if len(parts) != 4 {
+		return rowKey{}, false
+	}
+
+	rowID, err := strconv.ParseUint(parts[3], 10, 32)
+	if err != nil {
+		return rowKey{}, false
+	}
+
+	return rowKey{table: parts[1], owner: parts[2], rowID: uint32(rowID)}, true
+}
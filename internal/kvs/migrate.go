@@ -0,0 +1,295 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	schemaVersionKey  = "_schema.version"
+	schemaProgressKey = "_schema.progress"
+	// migrationChunkSize is how many keys a step is given per transaction,
+	// it is halved each time a chunk turns out too large to commit.
+	migrationChunkSize = 1000
+)
+
+var ErrNewerSchema = errors.New("database was written by a newer schema")
+
+type Migration struct {
+	Version uint32
+	Name    string
+	Migrate func(txn *badger.Txn) error
+	// Steps are run in order after Migrate, for changes to every row of a
+	// table which are too many to be made within a single transaction.
+	Steps []Step
+}
+
+// Step calls Apply with every key starting with Prefix, committing the
+// changes a chunk of keys at a time along with how far it has got, so a
+// migration which is interrupted resumes after the last chunk committed.
+// Apply is given a key again whenever its chunk is too large to commit and
+// is retried as smaller ones, and may be given keys written by earlier
+// chunks, so it has to leave a key which is already migrated as it is.
+type Step struct {
+	Prefix string
+	Apply  func(txn *badger.Txn, key []byte) error
+}
+
+// migrationProgress is how far the steps of a migration have got.
+type migrationProgress struct {
+	Version uint32 `json:"version"`
+	Step    int    `json:"step"`
+	// After is the last key committed of the step, nil before its first chunk.
+	After []byte `json:"after"`
+}
+
+// Migrations is an ordered registry of schema migrations, versions must
+// start at 1 and increase by exactly 1 for each migration registered.
+type Migrations struct {
+	list []Migration
+}
+
+func (m *Migrations) Register(mg Migration) {
+	if expected := m.Latest() + 1; mg.Version != expected {
+		panic(fmt.Sprintf("migration %q registered with version %d, expected version %d", mg.Name, mg.Version, expected))
+	}
+	m.list = append(m.list, mg)
+}
+
+func (m Migrations) Latest() uint32 {
+	if len(m.list) == 0 {
+		return 0
+	}
+	return m.list[len(m.list)-1].Version
+}
+
+type MigrateOptions struct {
+	// DryRun runs every pending migration within a single transaction
+	// which is then discarded instead of committed. The chunks of steps
+	// are each run within a discarded transaction of their own, so they
+	// see the store as it is rather than as earlier migrations leave it.
+	DryRun bool
+}
+
+type MigrateResult struct {
+	From, To uint32
+	Applied  []Migration
+}
+
+func SchemaVersion(db DB) (uint32, error) {
+	var version uint32
+	err := db.conn.View(func(txn *badger.Txn) error {
+		v, err := readSchemaVersion(txn)
+		version = v
+		return err
+	})
+	return version, err
+}
+
+// Migrate brings the database up to the latest version in migrations, each
+// migration is committed in the same transaction as its version bump, or
+// for those with steps, the bump is committed along with the last chunk.
+func Migrate(db DB, migrations Migrations, opts MigrateOptions) (MigrateResult, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return MigrateResult{}, err
+	}
+
+	result := MigrateResult{From: current, To: current}
+	if latest := migrations.Latest(); current > latest {
+		return result, fmt.Errorf("%w: database is at version %d, latest known version is %d", ErrNewerSchema, current, latest)
+	}
+
+	pending := migrations.list[current:]
+	if opts.DryRun {
+		txn := db.conn.NewTransaction(true)
+		defer txn.Discard()
+		discard := func(fn func(txn *badger.Txn) error) error {
+			txn := db.conn.NewTransaction(true)
+			defer txn.Discard()
+			return fn(txn)
+		}
+		for _, mg := range pending {
+			if err := runMigrate(txn, mg); err != nil {
+				return result, err
+			}
+			if err := runSteps(mg, migrationProgress{Version: mg.Version}, discard); err != nil {
+				return result, err
+			}
+			result.Applied = append(result.Applied, mg)
+			result.To = mg.Version
+		}
+		return result, nil
+	}
+
+	for _, mg := range pending {
+		if err := runMigration(db, mg); err != nil {
+			return result, err
+		}
+		result.Applied = append(result.Applied, mg)
+		result.To = mg.Version
+	}
+
+	return result, nil
+}
+
+// runMigration runs mg, resuming its steps from where they got to
+// should an earlier run of it have been interrupted part way.
+func runMigration(db DB, mg Migration) error {
+	progress, err := readMigrationProgress(db)
+	if err != nil {
+		return err
+	}
+
+	if progress.Version != mg.Version {
+		progress = migrationProgress{Version: mg.Version}
+		if err := db.conn.Update(func(txn *badger.Txn) error {
+			if err := runMigrate(txn, mg); err != nil {
+				return err
+			}
+			return saveMigrationProgress(txn, mg, progress)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return runSteps(mg, progress, db.conn.Update)
+}
+
+func runMigrate(txn *badger.Txn, mg Migration) error {
+	if mg.Migrate == nil {
+		return nil
+	}
+	if err := mg.Migrate(txn); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", mg.Version, mg.Name, err)
+	}
+	return nil
+}
+
+// runSteps runs the steps of mg from progress onwards, with each chunk
+// run by run, which commits it unless the migration is a dry run.
+func runSteps(mg Migration, progress migrationProgress, run func(fn func(txn *badger.Txn) error) error) error {
+	chunkSize := migrationChunkSize
+	for progress.Step < len(mg.Steps) {
+		next := progress
+		err := run(func(txn *badger.Txn) error {
+			last, err := applyChunk(txn, mg.Steps[progress.Step], progress.After, chunkSize)
+			if err != nil {
+				return err
+			}
+			next.After = last
+			if last == nil {
+				next.Step++
+			}
+			return saveMigrationProgress(txn, mg, next)
+		})
+		if errors.Is(err, badger.ErrTxnTooBig) && chunkSize > 1 {
+			chunkSize /= 2
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", mg.Version, mg.Name, err)
+		}
+		progress = next
+	}
+	return nil
+}
+
+// applyChunk calls the step's Apply with up to size of the keys following after,
+// returning the last of them, or nil once the step has been given every key.
+func applyChunk(txn *badger.Txn, step Step, after []byte, size int) ([]byte, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(step.Prefix)
+	it := txn.NewIterator(opts)
+
+	seekTo := after
+	if seekTo == nil {
+		seekTo = opts.Prefix
+	}
+	keys := make([][]byte, 0, size)
+	for it.Seek(seekTo); it.Valid() && len(keys) < size; it.Next() {
+		if after != nil && bytes.Equal(it.Item().Key(), after) {
+			continue
+		}
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	// Apply is free to iterate itself, which a read-write
+	// transaction only allows one iterator at a time for
+	it.Close()
+
+	for _, key := range keys {
+		if err := step.Apply(txn, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) < size {
+		return nil, nil
+	}
+	return keys[len(keys)-1], nil
+}
+
+// saveMigrationProgress records progress within txn, or once every
+// step of mg is done, clears it and bumps the schema version instead.
+func saveMigrationProgress(txn *badger.Txn, mg Migration, progress migrationProgress) error {
+	if progress.Step < len(mg.Steps) {
+		data, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(schemaProgressKey), data)
+	}
+
+	if err := txn.Delete([]byte(schemaProgressKey)); err != nil {
+		return err
+	}
+	return writeSchemaVersion(txn, mg.Version)
+}
+
+func readMigrationProgress(db DB) (migrationProgress, error) {
+	var progress migrationProgress
+	err := db.conn.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(schemaProgressKey))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &progress)
+		})
+	})
+	return progress, err
+}
+
+func readSchemaVersion(txn *badger.Txn) (uint32, error) {
+	item, err := txn.Get([]byte(schemaVersionKey))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var version uint32
+	err = item.Value(func(val []byte) error {
+		if len(val) != 4 {
+			return fmt.Errorf("malformed schema version: %v", val)
+		}
+		version = binary.BigEndian.Uint32(val)
+		return nil
+	})
+	return version, err
+}
+
+func writeSchemaVersion(txn *badger.Txn, version uint32) error {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, version)
+	return txn.Set([]byte(schemaVersionKey), v)
+}
@@ -0,0 +1,288 @@
+package kvs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+func buildTestMigrations(calls *[]uint32) kvs.Migrations {
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{
+		Version: 1, Name: "add fruits",
+		Migrate: func(txn *badger.Txn) error {
+			*calls = append(*calls, 1)
+			return txn.Set([]byte("fruits.color.root.0"), []byte("red"))
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 2, Name: "recolor fruits",
+		Migrate: func(txn *badger.Txn) error {
+			*calls = append(*calls, 2)
+			return txn.Set([]byte("fruits.color.root.0"), []byte("green"))
+		},
+	})
+	return migrations
+}
+
+func TestMigrateAppliesPendingMigrationsInOrder(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	calls := []uint32{}
+	result, err := kvs.Migrate(db, buildTestMigrations(&calls), kvs.MigrateOptions{})
+	is.NoErr(err)
+	is.Equal(result.From, uint32(0))
+	is.Equal(result.To, uint32(2))
+	is.Equal(len(result.Applied), 2)
+	is.Equal(calls, []uint32{1, 2})
+
+	version, err := kvs.SchemaVersion(db)
+	is.NoErr(err)
+	is.Equal(version, uint32(2))
+
+	e := kvs.Entry{TableName: "fruits", ColumnName: "color", OwnerUUID: kvs.RootOwner{}}
+	is.NoErr(kvs.Get(db, &e))
+	is.Equal(e.Data, []byte("green"))
+
+	// running again should be a no-op
+	result, err = kvs.Migrate(db, buildTestMigrations(&calls), kvs.MigrateOptions{})
+	is.NoErr(err)
+	is.Equal(len(result.Applied), 0)
+	is.Equal(calls, []uint32{1, 2})
+}
+
+func TestMigrateDryRunDoesNotCommit(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	calls := []uint32{}
+	result, err := kvs.Migrate(db, buildTestMigrations(&calls), kvs.MigrateOptions{DryRun: true})
+	is.NoErr(err)
+	is.Equal(result.To, uint32(2))
+	is.Equal(calls, []uint32{1, 2})
+
+	version, err := kvs.SchemaVersion(db)
+	is.NoErr(err)
+	is.Equal(version, uint32(0))
+
+	e := kvs.Entry{TableName: "fruits", ColumnName: "color", OwnerUUID: kvs.RootOwner{}}
+	is.True(kvs.Get(db, &e) != nil) // dry run should not have stored anything
+}
+
+func TestMigrateStopsOnFailedMigration(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{Version: 1, Name: "noop"})
+	migrations.Register(kvs.Migration{
+		Version: 2, Name: "broken",
+		Migrate: func(txn *badger.Txn) error {
+			if err := txn.Set([]byte("fruits.color.root.0"), []byte("red")); err != nil {
+				return err
+			}
+			return errors.New("something went wrong")
+		},
+	})
+
+	_, err = kvs.Migrate(db, migrations, kvs.MigrateOptions{})
+	is.True(err != nil)
+	is.Equal(err.Error(), "migration 2 (broken) failed: something went wrong")
+
+	version, err := kvs.SchemaVersion(db)
+	is.NoErr(err)
+	is.Equal(version, uint32(1))
+
+	e := kvs.Entry{TableName: "fruits", ColumnName: "color", OwnerUUID: kvs.RootOwner{}}
+	is.True(kvs.Get(db, &e) != nil) // failed migration should have been rolled back
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	calls := []uint32{}
+	_, err = kvs.Migrate(db, buildTestMigrations(&calls), kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	older := kvs.Migrations{}
+	older.Register(kvs.Migration{Version: 1, Name: "add fruits"})
+
+	_, err = kvs.Migrate(db, older, kvs.MigrateOptions{})
+	is.True(errors.Is(err, kvs.ErrNewerSchema))
+	is.Equal(err.Error(), "database was written by a newer schema: database is at version 2, latest known version is 1")
+}
+
+func TestReindexIndexesRowsStoredBeforeColumnWasIndexed(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	type unindexedRow struct {
+		Subject string
+		Date    time.Time
+	}
+
+	owner := uuid.New()
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := kvs.NewRepo[unindexedRow](db, "messages")
+	for _, days := range []int{2, 0, 1} {
+		_, err := r.Save(owner, unindexedRow{Subject: fmt.Sprintf("day %d", days), Date: base.AddDate(0, 0, days)})
+		is.NoErr(err)
+	}
+
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{
+		Version: 1, Name: "index dates",
+		Migrate: func(txn *badger.Txn) error {
+			return kvs.Reindex(txn, "messages", queryTestRow{})
+		},
+	})
+	_, err = kvs.Migrate(db, migrations, kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	rows, _, err := kvs.NewRepo[queryTestRow](db, "messages").FetchPageByOwner(owner, kvs.Query{OrderBy: "date"})
+	is.NoErr(err)
+	is.Equal(len(rows), 3)
+	is.Equal(rows[0].Subject, "day 0")
+	is.Equal(rows[1].Subject, "day 1")
+	is.Equal(rows[2].Subject, "day 2")
+}
+
+// storeLargeTable stores more rows of unindexedRow than fit within a single
+// transaction of a store from kvs.NewMemDBWithMemTableSize(1 << 20).
+func storeLargeTable(t *testing.T, is *is.I, db kvs.DB, owner kvs.UUID) int {
+	t.Helper()
+
+	const rows = 5000
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := kvs.NewRepo[unindexedRow](db, "messages")
+	for i := 0; i < rows; i++ {
+		_, err := r.Save(owner, unindexedRow{Subject: fmt.Sprintf("message %d", i), Date: base.Add(-time.Duration(i) * time.Minute)})
+		is.NoErr(err)
+	}
+	return rows
+}
+
+type unindexedRow struct {
+	Subject string
+	Date    time.Time
+}
+
+func TestMigrateStepsReindexTablesLargerThanATransaction(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDBWithMemTableSize(1 << 20)
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	rows := storeLargeTable(t, is, db, owner)
+
+	// the whole table within the one transaction is too much
+	single := kvs.Migrations{}
+	single.Register(kvs.Migration{
+		Version: 1, Name: "index dates",
+		Migrate: func(txn *badger.Txn) error {
+			return kvs.Reindex(txn, "messages", queryTestRow{})
+		},
+	})
+	_, err = kvs.Migrate(db, single, kvs.MigrateOptions{})
+	is.True(errors.Is(err, badger.ErrTxnTooBig))
+
+	stepped := kvs.Migrations{}
+	stepped.Register(kvs.Migration{
+		Version: 1, Name: "index dates",
+		Steps: []kvs.Step{kvs.ReindexStep("messages", queryTestRow{})},
+	})
+	result, err := kvs.Migrate(db, stepped, kvs.MigrateOptions{})
+	is.NoErr(err)
+	is.Equal(result.To, uint32(1))
+
+	count := 0
+	var last time.Time
+	is.NoErr(kvs.NewRepo[queryTestRow](db, "messages").Iterate(owner, kvs.Query{OrderBy: "date"}, func(_ uint32, v queryTestRow) error {
+		is.True(!v.Date.Before(last))
+		last = v.Date
+		count++
+		return nil
+	}))
+	is.Equal(count, rows)
+}
+
+func TestMigrateResumesInterruptedSteps(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDBWithMemTableSize(1 << 20)
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	rows := storeLargeTable(t, is, db, owner)
+
+	applied := 0
+	interruptAt := 2500
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{
+		Version: 1, Name: "mark subjects",
+		Steps: []kvs.Step{{
+			Prefix: "messages.subject.",
+			Apply: func(txn *badger.Txn, key []byte) error {
+				applied++
+				if applied == interruptAt {
+					return errors.New("interrupted")
+				}
+				return txn.Set(append([]byte("marked."), key...), nil)
+			},
+		}},
+	})
+
+	_, err = kvs.Migrate(db, migrations, kvs.MigrateOptions{})
+	is.True(err != nil)
+	version, err := kvs.SchemaVersion(db)
+	is.NoErr(err)
+	is.Equal(version, uint32(0))
+
+	applied, interruptAt = 0, -1
+	_, err = kvs.Migrate(db, migrations, kvs.MigrateOptions{})
+	is.NoErr(err)
+	// the chunks committed before the interruption are not applied again
+	is.True(applied < rows)
+	version, err = kvs.SchemaVersion(db)
+	is.NoErr(err)
+	is.Equal(version, uint32(1))
+
+	marked := 0
+	is.NoErr(db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("marked.")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			marked++
+		}
+		return nil
+	}))
+	is.Equal(marked, rows)
+}
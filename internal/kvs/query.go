@@ -0,0 +1,200 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type Order int
+
+const (
+	Ascending Order = iota
+	Descending
+)
+
+// Cursor is an opaque position within the results of a query,
+// a nil cursor starts from the very first row.
+type Cursor []byte
+
+type Query struct {
+	// OrderBy is the name of a column tagged with `mdb:"index"`,
+	// if left empty rows are ordered by their row ID instead.
+	OrderBy string
+	Order   Order
+	// Limit of zero returns every remaining row.
+	Limit int
+	After Cursor
+}
+
+type Page struct {
+	RowIDs []uint32
+	// Next is nil once there are no more rows to read.
+	Next Cursor
+}
+
+// Select resolves a single page of row IDs from the given table which belong
+// to owner, x is the type of the value stored in the table's rows.
+func Select(db DB, tableName string, owner UUID, x interface{}, q Query) (Page, error) {
+	var page Page
+	err := db.conn.View(func(txn *badger.Txn) error {
+		var err error
+		page, err = selectPage(txn, tableName, owner, x, q)
+		return err
+	})
+	return page, err
+}
+
+// SelectEqual resolves the IDs of the rows of the given table belonging to owner
+// whose column, which must be tagged with `mdb:"index"`, holds value, seeking
+// straight to them through the column's index rather than reading each row.
+func SelectEqual(db DB, tableName string, owner UUID, x interface{}, columnName string, value interface{}) ([]uint32, error) {
+	var rowIDs []uint32
+	err := db.conn.View(func(txn *badger.Txn) error {
+		var err error
+		rowIDs, err = selectEqual(txn, tableName, owner, x, columnName, value)
+		return err
+	})
+	return rowIDs, err
+}
+
+func selectEqual(txn *badger.Txn, tableName string, owner UUID, x interface{}, columnName string, value interface{}) ([]uint32, error) {
+	indexed, err := isIndexedColumn(x, columnName)
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		return nil, fmt.Errorf("unable to look up column %s: column is not indexed", columnName)
+	}
+
+	iv, err := convertToIndexValue(reflect.ValueOf(value))
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up column %s: %w", columnName, err)
+	}
+	prefix := IndexEntry{TableName: tableName, ColumnName: columnName, OwnerUUID: owner}.PrefixKey()
+	prefix = append(prefix, fmt.Sprintf("%x.", iv)...)
+
+	it := newPageIterator(txn, prefix, Ascending)
+	defer it.Close()
+
+	rowIDs := []uint32{}
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		rowID, err := parseIndexKeyRowID(it.Item().Key())
+		if err != nil {
+			return nil, err
+		}
+		rowIDs = append(rowIDs, rowID)
+	}
+	return rowIDs, nil
+}
+
+func selectPage(txn *badger.Txn, tableName string, owner UUID, x interface{}, q Query) (Page, error) {
+	if len(q.OrderBy) > 0 {
+		indexed, err := isIndexedColumn(x, q.OrderBy)
+		if err != nil {
+			return Page{}, err
+		}
+		if !indexed {
+			return Page{}, fmt.Errorf("unable to order by column %s: column is not indexed", q.OrderBy)
+		}
+		return selectByIndex(txn, tableName, owner, q)
+	}
+	return selectByRowID(txn, tableName, owner, q)
+}
+
+// selectByRowID seeks through the row ID keys of the table, written along
+// with each row, so a page is read without reading any of the rows before it.
+func selectByRowID(txn *badger.Txn, tableName string, owner UUID, q Query) (Page, error) {
+	prefix := rowIDPrefixKey(tableName, owner)
+	var after []byte
+	if q.After != nil {
+		if len(q.After) != 4 {
+			return Page{}, errors.New("invalid cursor")
+		}
+		after = rowIDKey(tableName, owner, binary.BigEndian.Uint32(q.After))
+	}
+
+	it := newPageIterator(txn, prefix, q.Order)
+	defer it.Close()
+
+	page := Page{RowIDs: []uint32{}}
+	for seekPage(it, prefix, after, q.Order); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		if after != nil && bytes.Equal(key, after) {
+			continue
+		}
+
+		if q.Limit > 0 && len(page.RowIDs) == q.Limit {
+			page.Next = make(Cursor, 4)
+			binary.BigEndian.PutUint32(page.Next, page.RowIDs[q.Limit-1])
+			break
+		}
+
+		rowID, err := strconv.ParseUint(string(key[len(prefix):]), 10, 32)
+		if err != nil {
+			return Page{}, fmt.Errorf("malformed key %s: %w", key, err)
+		}
+		page.RowIDs = append(page.RowIDs, uint32(rowID))
+	}
+
+	return page, nil
+}
+
+func selectByIndex(txn *badger.Txn, tableName string, owner UUID, q Query) (Page, error) {
+	prefix := IndexEntry{TableName: tableName, ColumnName: q.OrderBy, OwnerUUID: owner}.PrefixKey()
+	if q.After != nil && !bytes.HasPrefix(q.After, prefix) {
+		return Page{}, errors.New("invalid cursor")
+	}
+
+	it := newPageIterator(txn, prefix, q.Order)
+	defer it.Close()
+
+	page := Page{RowIDs: []uint32{}}
+	var lastKey []byte
+	for seekPage(it, prefix, q.After, q.Order); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		if q.After != nil && bytes.Equal(key, q.After) {
+			continue
+		}
+
+		if q.Limit > 0 && len(page.RowIDs) == q.Limit {
+			page.Next = lastKey
+			break
+		}
+
+		rowID, err := parseIndexKeyRowID(key)
+		if err != nil {
+			return Page{}, err
+		}
+		page.RowIDs = append(page.RowIDs, rowID)
+		lastKey = it.Item().KeyCopy(lastKey[:0])
+	}
+
+	return page, nil
+}
+
+func newPageIterator(txn *badger.Txn, prefix []byte, order Order) *badger.Iterator {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	opts.Reverse = order == Descending
+	return txn.NewIterator(opts)
+}
+
+// seekPage moves it to after, or the first key of prefix in the given order.
+func seekPage(it *badger.Iterator, prefix, after []byte, order Order) {
+	if after != nil {
+		it.Seek(after)
+		return
+	}
+	if order == Descending {
+		it.Seek(append(append([]byte{}, prefix...), 0xFF))
+		return
+	}
+	it.Seek(prefix)
+}
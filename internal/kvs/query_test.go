@@ -0,0 +1,157 @@
+package kvs_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+type queryTestRow struct {
+	Subject string
+	Date    time.Time `mdb:"index"`
+}
+
+func storeQueryTestRows(t *testing.T, db kvs.DB, owner kvs.UUID, rows []queryTestRow) {
+	t.Helper()
+	for i, r := range rows {
+		if err := kvs.StoreRow(db, "messages", owner, uint32(i), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSelectPagesByRowIDInNumericOrder(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	rows := make([]queryTestRow, 25)
+	for i := range rows {
+		rows[i] = queryTestRow{Subject: fmt.Sprintf("subject %d", i)}
+	}
+	storeQueryTestRows(t, db, owner, rows)
+
+	page, err := kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{Limit: 10})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	is.True(page.Next != nil)
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{Limit: 10, After: page.Next})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{10, 11, 12, 13, 14, 15, 16, 17, 18, 19})
+	is.True(page.Next != nil)
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{Limit: 10, After: page.Next})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{20, 21, 22, 23, 24})
+	is.True(page.Next == nil) // last page should not have a next cursor
+}
+
+func TestSelectPagesByRowIDDescending(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	storeQueryTestRows(t, db, owner, make([]queryTestRow, 12))
+
+	page, err := kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{Order: kvs.Descending, Limit: 5})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{11, 10, 9, 8, 7})
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{Order: kvs.Descending, After: page.Next})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{6, 5, 4, 3, 2, 1, 0})
+	is.True(page.Next == nil)
+}
+
+func TestSelectPagesByIndexedColumn(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	base := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	storeQueryTestRows(t, db, owner, []queryTestRow{
+		{Subject: "third", Date: base.Add(2 * time.Hour)},
+		{Subject: "first", Date: base.Add(-48 * time.Hour)},
+		{Subject: "fourth", Date: base.Add(72 * time.Hour)},
+		{Subject: "second", Date: base},
+	})
+	storeQueryTestRows(t, db, uuid.New(), []queryTestRow{{Subject: "other owner", Date: base}})
+
+	page, err := kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date", Limit: 3})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{1, 3, 0})
+	is.True(page.Next != nil)
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date", Limit: 3, After: page.Next})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{2})
+	is.True(page.Next == nil)
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date", Order: kvs.Descending, Limit: 2})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{2, 0})
+
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date", Order: kvs.Descending, After: page.Next})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{3, 1})
+
+	row := queryTestRow{}
+	is.NoErr(kvs.LoadRow(db, "messages", owner, page.RowIDs[1], &row))
+	is.Equal(row.Subject, "first")
+	is.True(row.Date.Equal(base.Add(-48 * time.Hour)))
+}
+
+func TestSelectByNonIndexedColumnReturnsError(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	_, err = kvs.Select(db, "messages", uuid.New(), queryTestRow{}, kvs.Query{OrderBy: "subject"})
+	is.True(err != nil)
+	is.Equal(err.Error(), "unable to order by column subject: column is not indexed")
+}
+
+func TestSelectEqualReturnsTheRowsHoldingAValue(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	owner := uuid.New()
+	base := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	storeQueryTestRows(t, db, owner, []queryTestRow{
+		{Subject: "first", Date: base},
+		{Subject: "second", Date: base.Add(time.Hour)},
+		{Subject: "third", Date: base},
+	})
+	storeQueryTestRows(t, db, uuid.New(), []queryTestRow{{Subject: "other owner", Date: base}})
+
+	rowIDs, err := kvs.SelectEqual(db, "messages", owner, queryTestRow{}, "date", base)
+	is.NoErr(err)
+	is.Equal(rowIDs, []uint32{0, 2})
+
+	rowIDs, err = kvs.SelectEqual(db, "messages", owner, queryTestRow{}, "date", base.Add(time.Minute))
+	is.NoErr(err)
+	is.Equal(rowIDs, []uint32{})
+
+	_, err = kvs.SelectEqual(db, "messages", owner, queryTestRow{}, "subject", "first")
+	is.True(err != nil)
+	is.Equal(err.Error(), "unable to look up column subject: column is not indexed")
+}
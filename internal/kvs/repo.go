@@ -0,0 +1,174 @@
+package kvs
+
+import "github.com/dgraph-io/badger/v3"
+
+const iteratePageSize = 100
+
+// Repo stores values of T as rows within a single table, with each
+// row belonging to an owner, such as the account a mailbox belongs to.
+type Repo[T any] struct {
+	db        DB
+	tableName string
+}
+
+func NewRepo[T any](db DB, tableName string) *Repo[T] {
+	return &Repo[T]{db: db, tableName: tableName}
+}
+
+func (r *Repo[T]) TableName() string {
+	return r.tableName
+}
+
+// Save stores v as a new row and returns the row's ID.
+func (r *Repo[T]) Save(owner UUID, v T) (uint32, error) {
+	rowID, err := r.db.NextRowID(r.tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	return rowID, StoreRow(r.db, r.tableName, owner, rowID, v)
+}
+
+func (r *Repo[T]) Get(owner UUID, rowID uint32) (T, error) {
+	v := new(T)
+	err := r.db.View(func(txn *badger.Txn) error {
+		found, err := loadRow(txn, r.tableName, owner, rowID, v)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrRowNotFound
+		}
+		return nil
+	})
+	return *v, err
+}
+
+func (r *Repo[T]) Update(owner UUID, rowID uint32, v T) error {
+	return UpdateRow(r.db, r.tableName, owner, rowID, v)
+}
+
+// Move stores v as the same row of dest, removing the row from owner.
+func (r *Repo[T]) Move(owner UUID, rowID uint32, dest UUID, v T) error {
+	return MoveRow(r.db, r.tableName, owner, dest, rowID, v)
+}
+
+func (r *Repo[T]) Delete(owner UUID, rowID uint32) error {
+	return DeleteRow(r.db, r.tableName, owner, rowID, new(T))
+}
+
+func (r *Repo[T]) FetchByOwner(owner UUID) ([]T, error) {
+	dest := []T{}
+	err := r.Iterate(owner, Query{}, func(_ uint32, v T) error {
+		dest = append(dest, v)
+		return nil
+	})
+	return dest, err
+}
+
+func (r *Repo[T]) FetchPageByOwner(owner UUID, q Query) ([]T, Cursor, error) {
+	rows, next, err := r.FetchRowPageByOwner(owner, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dest := make([]T, len(rows))
+	for i, row := range rows {
+		dest[i] = row.Value
+	}
+
+	return dest, next, nil
+}
+
+// Row is a stored value along with the ID of the row it was loaded from.
+type Row[T any] struct {
+	ID    uint32
+	Value T
+}
+
+// FetchRowPageByOwner behaves the same as FetchPageByOwner, apart from
+// returning the row ID of each value for looking it up again later.
+func (r *Repo[T]) FetchRowPageByOwner(owner UUID, q Query) ([]Row[T], Cursor, error) {
+	var dest []Row[T]
+	var next Cursor
+	err := r.db.View(func(txn *badger.Txn) error {
+		page, err := selectPage(txn, r.tableName, owner, new(T), q)
+		if err != nil {
+			return err
+		}
+
+		dest = make([]Row[T], len(page.RowIDs))
+		for i, rowID := range page.RowIDs {
+			dest[i].ID = rowID
+			if _, err := loadRow(txn, r.tableName, owner, rowID, &dest[i].Value); err != nil {
+				return err
+			}
+		}
+		next = page.Next
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dest, next, nil
+}
+
+// FetchRowsByColumn returns every row belonging to owner whose column, which must
+// be tagged with `mdb:"index"`, holds value, along with the ID of each row.
+func (r *Repo[T]) FetchRowsByColumn(owner UUID, columnName string, value interface{}) ([]Row[T], error) {
+	var dest []Row[T]
+	err := r.db.View(func(txn *badger.Txn) error {
+		rowIDs, err := selectEqual(txn, r.tableName, owner, new(T), columnName, value)
+		if err != nil {
+			return err
+		}
+
+		dest = make([]Row[T], len(rowIDs))
+		for i, rowID := range rowIDs {
+			dest[i].ID = rowID
+			if _, err := loadRow(txn, r.tableName, owner, rowID, &dest[i].Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dest, nil
+}
+
+// Iterate calls fn with every row belonging to owner in the order given by q,
+// loading a page at a time so the whole table is never held in memory. Each
+// page seeks to where the last left off, so a walk of the table reads it once.
+func (r *Repo[T]) Iterate(owner UUID, q Query, fn func(rowID uint32, v T) error) error {
+	if q.Limit == 0 {
+		q.Limit = iteratePageSize
+	}
+
+	for {
+		rows, next, err := r.FetchRowPageByOwner(owner, q)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := fn(row.ID, row.Value); err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		q.After = next
+	}
+}
+
+// Close is a no-op kept for the repos built on Repo, the table's
+// row sequence is owned and released by the DB.
+func (r *Repo[T]) Close() error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package kvs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+func TestRepoSaveAndGet(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	defer r.Close()
+
+	owner := uuid.New()
+	date := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := r.Save(owner, queryTestRow{Subject: "first", Date: date})
+	is.NoErr(err)
+	second, err := r.Save(owner, queryTestRow{Subject: "second", Date: date})
+	is.NoErr(err)
+	is.Equal(first, uint32(0))
+	is.Equal(second, uint32(1))
+
+	row, err := r.Get(owner, second)
+	is.NoErr(err)
+	is.Equal(row.Subject, "second")
+	is.True(row.Date.Equal(date))
+
+	_, err = r.Get(uuid.New(), second)
+	is.True(errors.Is(err, kvs.ErrRowNotFound)) // rows should not be visible to other owners
+}
+
+func TestRepoUpdateReplacesIndexEntries(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	defer r.Close()
+
+	owner := uuid.New()
+	base := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		_, err := r.Save(owner, queryTestRow{Subject: fmt.Sprintf("subject %d", i), Date: base.Add(time.Duration(i) * time.Hour)})
+		is.NoErr(err)
+	}
+
+	is.NoErr(r.Update(owner, 0, queryTestRow{Subject: "moved", Date: base.Add(24 * time.Hour)}))
+
+	rows, _, err := r.FetchPageByOwner(owner, kvs.Query{OrderBy: "date"})
+	is.NoErr(err)
+	is.Equal(len(rows), 3) // the old index entry should no longer be listed
+	is.Equal(rows[0].Subject, "subject 1")
+	is.Equal(rows[2].Subject, "moved")
+
+	err = r.Update(owner, 10, queryTestRow{Subject: "missing"})
+	is.True(errors.Is(err, kvs.ErrRowNotFound))
+}
+
+func TestRepoDelete(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	defer r.Close()
+
+	owner := uuid.New()
+	for i := 0; i < 3; i++ {
+		_, err := r.Save(owner, queryTestRow{Subject: fmt.Sprintf("subject %d", i), Date: time.Now()})
+		is.NoErr(err)
+	}
+
+	is.NoErr(r.Delete(owner, 1))
+
+	_, err = r.Get(owner, 1)
+	is.True(errors.Is(err, kvs.ErrRowNotFound))
+
+	rows, err := r.FetchByOwner(owner)
+	is.NoErr(err)
+	is.Equal(len(rows), 2)
+	is.Equal(rows[0].Subject, "subject 0")
+	is.Equal(rows[1].Subject, "subject 2")
+
+	page, err := kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date"})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{0, 2})
+}
+
+func TestRepoMoveKeepsRowIDUnderNewOwner(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	defer r.Close()
+
+	owner, dest := uuid.New(), uuid.New()
+	for i := 0; i < 2; i++ {
+		_, err := r.Save(owner, queryTestRow{Subject: fmt.Sprintf("subject %d", i), Date: time.Now()})
+		is.NoErr(err)
+	}
+
+	is.NoErr(r.Move(owner, 1, dest, queryTestRow{Subject: "moved", Date: time.Now()}))
+
+	_, err = r.Get(owner, 1)
+	is.True(errors.Is(err, kvs.ErrRowNotFound))
+	row, err := r.Get(dest, 1)
+	is.NoErr(err)
+	is.Equal(row.Subject, "moved")
+
+	// the index entries move along with the row
+	page, err := kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{OrderBy: "date"})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{0})
+	page, err = kvs.Select(db, "messages", dest, queryTestRow{}, kvs.Query{OrderBy: "date"})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{1})
+
+	// and so do the row ID entries
+	page, err = kvs.Select(db, "messages", owner, queryTestRow{}, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{0})
+	page, err = kvs.Select(db, "messages", dest, queryTestRow{}, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(page.RowIDs, []uint32{1})
+
+	is.True(errors.Is(r.Move(owner, 1, dest, queryTestRow{}), kvs.ErrRowNotFound))
+}
+
+func TestRepoIterateVisitsEveryRowAcrossPages(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	defer r.Close()
+
+	owner := uuid.New()
+	for i := 0; i < 250; i++ {
+		_, err := r.Save(owner, queryTestRow{Subject: fmt.Sprintf("subject %d", i)})
+		is.NoErr(err)
+	}
+	_, err = r.Save(uuid.New(), queryTestRow{Subject: "other owner"})
+	is.NoErr(err)
+
+	visited := []uint32{}
+	is.NoErr(r.Iterate(owner, kvs.Query{}, func(rowID uint32, row queryTestRow) error {
+		is.Equal(row.Subject, fmt.Sprintf("subject %d", rowID))
+		visited = append(visited, rowID)
+		return nil
+	}))
+	is.Equal(len(visited), 250)
+	is.Equal(visited[249], uint32(249))
+
+	stop := errors.New("stop")
+	count := 0
+	err = r.Iterate(owner, kvs.Query{}, func(uint32, queryTestRow) error {
+		count++
+		if count == 5 {
+			return stop
+		}
+		return nil
+	})
+	is.True(errors.Is(err, stop))
+	is.Equal(count, 5)
+}
+
+func BenchmarkRepoIterate(b *testing.B) {
+	db, err := kvs.NewMemDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	r := kvs.NewRepo[queryTestRow](db, "messages")
+	owner := uuid.New()
+	for i := 0; i < 30000; i++ {
+		if _, err := r.Save(owner, queryTestRow{Subject: fmt.Sprintf("subject %d", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.Iterate(owner, kvs.Query{}, func(uint32, queryTestRow) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package kvs
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+var ErrRowNotFound = errors.New("row not found")
+
+// StoreRow writes every column and index entry of v for the given row within
+// a single transaction, replacing any index entries of a previously stored value.
+func StoreRow(db DB, tableName string, owner UUID, rowID uint32, v interface{}) error {
+	return db.conn.Update(func(txn *badger.Txn) error {
+		return storeRow(txn, tableName, owner, rowID, v)
+	})
+}
+
+// UpdateRow behaves the same as StoreRow, apart from returning
+// ErrRowNotFound if the row has not been stored before.
+func UpdateRow(db DB, tableName string, owner UUID, rowID uint32, v interface{}) error {
+	return db.conn.Update(func(txn *badger.Txn) error {
+		exists, err := rowExists(txn, tableName, owner, rowID, v)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRowNotFound
+		}
+		return storeRow(txn, tableName, owner, rowID, v)
+	})
+}
+
+// DeleteRow removes every column and index entry of the given row,
+// x is the type of the value stored in the table's rows.
+func DeleteRow(db DB, tableName string, owner UUID, rowID uint32, x interface{}) error {
+	return db.conn.Update(func(txn *badger.Txn) error {
+		return deleteRow(txn, tableName, owner, rowID, x)
+	})
+}
+
+// MoveRow stores v as the same row of dest and removes the row from owner
+// within a single transaction, returning ErrRowNotFound if owner has no such
+// row. Row IDs are unique across a table, so the row keeps its ID.
+func MoveRow(db DB, tableName string, owner, dest UUID, rowID uint32, v interface{}) error {
+	return db.conn.Update(func(txn *badger.Txn) error {
+		exists, err := rowExists(txn, tableName, owner, rowID, v)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRowNotFound
+		}
+		if err := deleteRow(txn, tableName, owner, rowID, v); err != nil {
+			return err
+		}
+		return storeRow(txn, tableName, dest, rowID, v)
+	})
+}
+
+func deleteRow(txn *badger.Txn, tableName string, owner UUID, rowID uint32, x interface{}) error {
+	blankEntries, err := ConvertToBlankEntriesWithUUID(tableName, owner, rowID, x)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteStoredIndexEntries(txn, tableName, owner, rowID, x); err != nil {
+		return err
+	}
+
+	for _, e := range blankEntries {
+		if err := txn.Delete(e.Key()); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(rowIDKey(tableName, owner, rowID))
+}
+
+// LoadRow populates dest with each of the stored column values of a single row,
+// columns which have never been stored for the row are left untouched.
+func LoadRow(db DB, tableName string, owner UUID, rowID uint32, dest interface{}) error {
+	return db.conn.View(func(txn *badger.Txn) error {
+		_, err := loadRow(txn, tableName, owner, rowID, dest)
+		return err
+	})
+}
+
+func storeRow(txn *badger.Txn, tableName string, owner UUID, rowID uint32, v interface{}) error {
+	entries, err := ConvertToEntriesWithUUID(tableName, owner, rowID, v)
+	if err != nil {
+		return err
+	}
+
+	indexEntries, err := ConvertToIndexEntriesWithUUID(tableName, owner, rowID, v)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteStoredIndexEntries(txn, tableName, owner, rowID, v); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := setValue(txn, e.Key(), e.Data); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range indexEntries {
+		if err := txn.Set(e.Key(), nil); err != nil {
+			return err
+		}
+	}
+
+	return txn.Set(rowIDKey(tableName, owner, rowID), nil)
+}
+
+// loadRow reports whether any of the row's columns were found.
+func loadRow(txn *badger.Txn, tableName string, owner UUID, rowID uint32, dest interface{}) (bool, error) {
+	blankEntries, err := ConvertToBlankEntriesWithUUID(tableName, owner, rowID, dest)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for _, e := range blankEntries {
+		item, err := txn.Get(e.Key())
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			return found, err
+		}
+		found = true
+
+		if e.Data, err = item.ValueCopy(nil); err != nil {
+			return found, err
+		}
+
+		if err := LoadEntry(dest, e); err != nil {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+func rowExists(txn *badger.Txn, tableName string, owner UUID, rowID uint32, x interface{}) (bool, error) {
+	blankEntries, err := ConvertToBlankEntriesWithUUID(tableName, owner, rowID, x)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range blankEntries {
+		_, err := txn.Get(e.Key())
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// deleteStoredIndexEntries removes the index entries of the value currently
+// stored for the row, which have to be resolved from the stored column values.
+func deleteStoredIndexEntries(txn *badger.Txn, tableName string, owner UUID, rowID uint32, x interface{}) error {
+	t := reflect.TypeOf(x)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	columns, err := resolveColumns(t)
+	if err != nil {
+		return err
+	}
+
+	hasIndex := false
+	for _, c := range columns {
+		hasIndex = hasIndex || c.opts.Index
+	}
+	if !hasIndex {
+		return nil
+	}
+
+	stored := reflect.New(t).Interface()
+	found, err := loadRow(txn, tableName, owner, rowID, stored)
+	if err != nil || !found {
+		return err
+	}
+
+	indexEntries, err := ConvertToIndexEntriesWithUUID(tableName, owner, rowID, stored)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range indexEntries {
+		if err := txn.Delete(e.Key()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
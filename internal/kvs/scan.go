@@ -0,0 +1,91 @@
+package kvs
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// StoredRow lists which columns are actually stored for a single row.
+type StoredRow struct {
+	Owner   string
+	RowID   uint32
+	Columns []string
+}
+
+// ScanRows lists every row of the given table regardless of its owner,
+// without reading any of the stored values.
+func ScanRows(db DB, tableName string) ([]StoredRow, error) {
+	rows := map[rowKey]*StoredRow{}
+	err := db.conn.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(tableName + ".")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			k, ok := parseRowKey(it.Item().Key())
+			if !ok {
+				continue
+			}
+
+			rk := rowKey{owner: k.owner, rowID: k.rowID}
+			row, ok := rows[rk]
+			if !ok {
+				row = &StoredRow{Owner: k.owner, RowID: k.rowID}
+				rows[rk] = row
+			}
+			row.Columns = append(row.Columns, k.column)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scanned := make([]StoredRow, 0, len(rows))
+	for _, row := range rows {
+		sort.Strings(row.Columns)
+		scanned = append(scanned, *row)
+	}
+	sort.Slice(scanned, func(i, j int) bool {
+		if scanned[i].Owner != scanned[j].Owner {
+			return scanned[i].Owner < scanned[j].Owner
+		}
+		return scanned[i].RowID < scanned[j].RowID
+	})
+
+	return scanned, nil
+}
+
+// MissingColumns lists the columns of x's type which are not within stored.
+func MissingColumns(x interface{}, stored []string) ([]string, error) {
+	columns, err := resolveColumns(reflect.TypeOf(x))
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(stored))
+	for _, c := range stored {
+		present[c] = true
+	}
+
+	missing := []string{}
+	for _, c := range columns {
+		if !present[c.name] {
+			missing = append(missing, c.name)
+		}
+	}
+	return missing, nil
+}
+
+// ParseOwner is the reverse of how an owner is written within keys.
+func ParseOwner(owner string) (UUID, error) {
+	if owner == (RootOwner{}).String() {
+		return RootOwner{}, nil
+	}
+	return uuid.Parse(owner)
+}
@@ -0,0 +1,65 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// rowSequenceBandwidth is kept at one so that no IDs are skipped
+// when the store is closed without releasing its sequences.
+const rowSequenceBandwidth = 1
+
+var ErrDBClosed = errors.New("database is closed")
+
+// sequences hands out a single badger sequence per table for the lifetime
+// of a DB, so every repo of a table draws its row IDs from the same lease.
+type sequences struct {
+	mu      sync.Mutex
+	closed  bool
+	byTable map[string]*badger.Sequence
+}
+
+func newSequences() *sequences {
+	return &sequences{byTable: map[string]*badger.Sequence{}}
+}
+
+func (s *sequences) get(conn *badger.DB, tableName string) (*badger.Sequence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrDBClosed
+	}
+
+	if seq, ok := s.byTable[tableName]; ok {
+		return seq, nil
+	}
+
+	seq, err := conn.GetSequence([]byte(tableName), rowSequenceBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	s.byTable[tableName] = seq
+	return seq, nil
+}
+
+// releaseAll returns the unused part of every lease, so the next
+// time the store is opened each table continues from its last ID.
+func (s *sequences) releaseAll() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	var firstErr error
+	for tableName, seq := range s.byTable {
+		if err := seq.Release(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("unable to release %s sequence: %w", tableName, err)
+		}
+		delete(s.byTable, tableName)
+	}
+	return firstErr
+}
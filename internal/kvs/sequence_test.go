@@ -0,0 +1,73 @@
+package kvs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+func TestRowIDsAreSharedAcrossReposOfTheSameTable(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	first := kvs.NewRepo[queryTestRow](db, "messages")
+	second := kvs.NewRepo[queryTestRow](db, "messages")
+	other := kvs.NewRepo[queryTestRow](db, "drafts")
+
+	ids := []uint32{}
+	for _, r := range []*kvs.Repo[queryTestRow]{first, second, first, other} {
+		id, err := r.Save(kvs.RootOwner{}, queryTestRow{})
+		is.NoErr(err)
+		ids = append(ids, id)
+	}
+	is.Equal(ids, []uint32{0, 1, 2, 0}) // each table should have its own sequence
+}
+
+func TestRowIDsIncreaseAcrossReopenedStore(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	ids := []uint32{}
+	for cycle := 0; cycle < 3; cycle++ {
+		db, err := kvs.NewDiskDB(dir, nil)
+		is.NoErr(err)
+
+		r := kvs.NewRepo[queryTestRow](db, "messages")
+		for i := 0; i < 4; i++ {
+			id, err := r.Save(kvs.RootOwner{}, queryTestRow{})
+			is.NoErr(err)
+			ids = append(ids, id)
+		}
+		is.NoErr(r.Close())
+		is.NoErr(db.Close())
+	}
+
+	is.Equal(ids, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})
+
+	db, err := kvs.NewDiskDB(dir, nil)
+	is.NoErr(err)
+	defer db.Close()
+
+	rows, err := kvs.NewRepo[queryTestRow](db, "messages").FetchByOwner(kvs.RootOwner{})
+	is.NoErr(err)
+	is.Equal(len(rows), 12) // no row should have been overwritten by a reused ID
+}
+
+func TestNextRowIDFailsOnceClosed(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+
+	_, err = db.NextRowID("messages")
+	is.NoErr(err)
+	is.NoErr(db.Close())
+
+	_, err = db.NextRowID("messages")
+	is.True(errors.Is(err, kvs.ErrDBClosed))
+}
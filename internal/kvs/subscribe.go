@@ -0,0 +1,159 @@
+package kvs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/google/uuid"
+)
+
+const (
+	userMetaValue byte = 1
+
+	subscribeProbePrefix   = "_subscribe."
+	subscribeProbeInterval = 10 * time.Millisecond
+	changeBufferSize       = 64
+)
+
+type Op int
+
+const (
+	OpPut Op = iota
+	OpDelete
+)
+
+func (o Op) String() string {
+	if o == OpDelete {
+		return "delete"
+	}
+	return "put"
+}
+
+// Change describes a write to one of a table's rows, a single write
+// of several columns of the same row is reported as one change.
+type Change struct {
+	Table string
+	// Owner is the owner as it appears within the row's keys,
+	// either a UUID string or "root".
+	Owner string
+	RowID uint32
+	Op    Op
+}
+
+// Subscription delivers the changes made to every row with a key matching
+// its prefix until it is closed, C has to be drained as writes to the store
+// are held up once too many changes are left unread.
+type Subscription struct {
+	C <-chan Change
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Subscribe starts watching every row whose key starts with prefix,
+// for example "messages" or "messages.subject". Only changes committed
+// after Subscribe returns are guaranteed to be delivered.
+func (db DB) Subscribe(prefix string) (*Subscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan Change, changeBufferSize)
+	sub := &Subscription{C: changes, cancel: cancel, done: make(chan struct{})}
+
+	// badger registers subscribers asynchronously, so a probe key is written
+	// until it is seen to know the subscription has actually started
+	probe := []byte(subscribeProbePrefix + uuid.NewString())
+	ready := make(chan struct{})
+	readyOnce := sync.Once{}
+
+	go func() {
+		defer close(sub.done)
+		defer close(changes)
+
+		err := db.conn.Subscribe(ctx, func(list *pb.KVList) error {
+			for _, c := range parseChanges(list.Kv, probe, func() { readyOnce.Do(func() { close(ready) }) }) {
+				select {
+				case changes <- c:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}, []pb.Match{{Prefix: []byte(prefix)}, {Prefix: probe}})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			sub.err = err
+		}
+	}()
+
+	ticker := time.NewTicker(subscribeProbeInterval)
+	defer ticker.Stop()
+	for {
+		if err := db.conn.Update(func(txn *badger.Txn) error {
+			return txn.Set(probe, nil)
+		}); err != nil {
+			sub.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ready:
+			if err := db.conn.Update(func(txn *badger.Txn) error {
+				return txn.Delete(probe)
+			}); err != nil {
+				sub.Close()
+				return nil, err
+			}
+			return sub, nil
+		case <-sub.done:
+			return nil, sub.err
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the subscription and closes its channel, the subscription
+// is also stopped once the DB it was made from is closed.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	return s.err
+}
+
+// parseChanges resolves the row changes of a single batch of writes,
+// the internal keys of indexes, sequences and the schema are skipped.
+func parseChanges(kvs []*pb.KV, probe []byte, probed func()) []Change {
+	changes := []Change{}
+	seen := map[Change]bool{}
+	for _, kv := range kvs {
+		if bytes.Equal(kv.Key, probe) {
+			probed()
+			continue
+		}
+
+		c, ok := parseChange(kv)
+		if !ok || seen[c] {
+			continue
+		}
+		seen[c] = true
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func parseChange(kv *pb.KV) (Change, bool) {
+	k, ok := parseRowKey(kv.Key)
+	if !ok {
+		return Change{}, false
+	}
+
+	c := Change{Table: k.table, Owner: k.owner, RowID: k.rowID}
+	// deletes are published as an empty value without any user meta
+	if len(kv.Value) == 0 && (len(kv.Meta) == 0 || kv.Meta[0]&userMetaValue == 0) {
+		c.Op = OpDelete
+	}
+	return c, true
+}
@@ -0,0 +1,75 @@
+package kvs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+func receiveChange(t *testing.T, sub *kvs.Subscription) kvs.Change {
+	t.Helper()
+	select {
+	case c, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed before receiving change")
+		}
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	return kvs.Change{}
+}
+
+func TestSubscribeReceivesRowChanges(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	sub, err := db.Subscribe("messages")
+	is.NoErr(err)
+	defer sub.Close()
+
+	owner := uuid.New()
+	messages := kvs.NewRepo[queryTestRow](db, "messages")
+	drafts := kvs.NewRepo[queryTestRow](db, "drafts")
+
+	_, err = drafts.Save(owner, queryTestRow{Subject: "unrelated"})
+	is.NoErr(err)
+	rowID, err := messages.Save(owner, queryTestRow{Subject: "hello", Date: time.Now()})
+	is.NoErr(err)
+
+	// every column of the saved row should be reported as a single change
+	is.Equal(receiveChange(t, sub), kvs.Change{Table: "messages", Owner: owner.String(), RowID: rowID, Op: kvs.OpPut})
+
+	is.NoErr(messages.Update(owner, rowID, queryTestRow{Subject: ""}))
+	is.Equal(receiveChange(t, sub), kvs.Change{Table: "messages", Owner: owner.String(), RowID: rowID, Op: kvs.OpPut})
+
+	is.NoErr(messages.Delete(owner, rowID))
+	is.Equal(receiveChange(t, sub), kvs.Change{Table: "messages", Owner: owner.String(), RowID: rowID, Op: kvs.OpDelete})
+
+	select {
+	case c := <-sub.C:
+		t.Fatalf("unexpected change: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionCloses(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	sub, err := db.Subscribe("")
+	is.NoErr(err)
+	is.NoErr(sub.Close())
+
+	_, ok := <-sub.C
+	is.True(!ok) // channel should be closed once the subscription is
+}
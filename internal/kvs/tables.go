@@ -0,0 +1,42 @@
+package kvs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Tables is a registry of the type of value stored within each table's
+// rows, which lets tools given only the raw store decode them again.
+type Tables struct {
+	types map[string]reflect.Type
+}
+
+func (t *Tables) Register(tableName string, x interface{}) {
+	if t.types == nil {
+		t.types = map[string]reflect.Type{}
+	}
+	if _, ok := t.types[tableName]; ok {
+		panic(fmt.Sprintf("table %q registered more than once", tableName))
+	}
+
+	typ := reflect.TypeOf(x)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	t.types[tableName] = typ
+}
+
+func (t Tables) Lookup(tableName string) (reflect.Type, bool) {
+	typ, ok := t.types[tableName]
+	return typ, ok
+}
+
+func (t Tables) Names() []string {
+	names := make([]string, 0, len(t.types))
+	for name := range t.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -82,9 +82,9 @@ func (bk *xbackend) CreateMailbox(username, mbname string) error {
 }
 
 func (bk *xbackend) StoreMessage(username, mbname, body string) {
-	msgs := bk.users[username].mailboxes[mbname].messages
-	msgs = append(msgs, &message{
-		Uid:   uint32(len(msgs)),
+	mbox := bk.users[username].mailboxes[strings.ToUpper(mbname)]
+	mbox.messages = append(mbox.messages, &message{
+		Uid:   mbox.uidNext(),
 		Date:  time.Now(),
 		Flags: []string{"\\Seen"},
 		Size:  uint32(len(body)),
@@ -197,11 +197,19 @@ func (mbox *mailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, op imap.
 }
 
 func (mbox *mailbox) CopyMessages(uid bool, seqset *imap.SeqSet, destName string) error {
+	_, _, err := mbox.copyMessages(uid, seqset, destName)
+	return err
+}
+
+// copyMessages copies messages into destName, returning the UIDs
+// of those copied along with the UIDs of their copies.
+func (mbox *mailbox) copyMessages(uid bool, seqset *imap.SeqSet, destName string) ([]uint32, []uint32, error) {
 	dest, ok := mbox.user.mailboxes[destName]
 	if !ok {
-		return backend.ErrNoSuchMailbox
+		return nil, nil, backend.ErrNoSuchMailbox
 	}
 
+	var src, copied []uint32
 	for i, msg := range mbox.messages {
 		var id uint32
 		if uid {
@@ -216,6 +224,28 @@ func (mbox *mailbox) CopyMessages(uid bool, seqset *imap.SeqSet, destName string
 		msgCopy := *msg
 		msgCopy.Uid = dest.uidNext()
 		dest.messages = append(dest.messages, &msgCopy)
+		src = append(src, msg.Uid)
+		copied = append(copied, msgCopy.Uid)
+	}
+
+	return src, copied, nil
+}
+
+// MoveMessages has the mailbox implement backend.MoveMailbox,
+// as the server always advertises MOVE.
+func (mbox *mailbox) MoveMessages(uid bool, seqset *imap.SeqSet, destName string) error {
+	moved, _, err := mbox.copyMessages(uid, seqset, destName)
+	if err != nil {
+		return err
+	}
+
+	for i := len(mbox.messages) - 1; i >= 0; i-- {
+		for _, id := range moved {
+			if mbox.messages[i].Uid == id {
+				mbox.messages = append(mbox.messages[:i], mbox.messages[i+1:]...)
+				break
+			}
+		}
 	}
 
 	return nil
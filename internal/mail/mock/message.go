@@ -69,6 +69,9 @@ func (m *message) Fetch(seqNum uint32, items []imap.FetchItem) (*imap.Message, e
 }
 
 func (m *message) Match(seqNum uint32, c *imap.SearchCriteria) (bool, error) {
-	e, _ := m.entity()
+	e, err := m.entity()
+	if err != nil && !imapmsg.IsUnknownCharset(err) {
+		return false, err
+	}
 	return backendutil.Match(e, seqNum, m.Uid, m.Date, m.Flags, c)
 }
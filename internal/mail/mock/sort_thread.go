@@ -0,0 +1,219 @@
+package mock
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// SortThread returns an extension which has the server advertise and answer
+// SORT and THREAD=REFERENCES. Its threading is only as good as needed for
+// testing, linking each message to the one named by its In-Reply-To header.
+func SortThread() server.Extension {
+	return sortThreadExtension{}
+}
+
+type sortThreadExtension struct{}
+
+func (sortThreadExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState == 0 {
+		return nil
+	}
+	return []string{"SORT", "THREAD=REFERENCES"}
+}
+
+func (sortThreadExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SORT":
+		return func() server.Handler { return &sortHandler{} }
+	case "THREAD":
+		return func() server.Handler { return &threadHandler{} }
+	}
+	return nil
+}
+
+func selectedMailbox(conn server.Conn) (*mailbox, error) {
+	mbox, ok := conn.Context().Mailbox.(*mailbox)
+	if !ok {
+		return nil, server.ErrNoMailboxSelected
+	}
+	return mbox, nil
+}
+
+// messageID returns the number a message is known by, its UID or its sequence number.
+func messageID(mbox *mailbox, i int, uid bool) uint32 {
+	if uid {
+		return mbox.messages[i].Uid
+	}
+	return uint32(i + 1)
+}
+
+func (m *message) header(key string) string {
+	hdr, _, err := m.headerAndBody()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(hdr.Get(key))
+}
+
+type sortHandler struct {
+	keys    []string
+	reverse []bool
+}
+
+func (h *sortHandler) Parse(fields []interface{}) error {
+	if len(fields) < 3 {
+		return errors.New("not enough arguments")
+	}
+	criteria, ok := fields[0].([]interface{})
+	if !ok {
+		return errors.New("sort criteria must be a list")
+	}
+
+	reverse := false
+	for _, f := range criteria {
+		key, _ := f.(string)
+		key = strings.ToUpper(key)
+		if key == "REVERSE" {
+			reverse = true
+			continue
+		}
+		h.keys = append(h.keys, key)
+		h.reverse = append(h.reverse, reverse)
+		reverse = false
+	}
+	return nil
+}
+
+func (h *sortHandler) Handle(conn server.Conn) error {
+	return h.handle(conn, false)
+}
+
+func (h *sortHandler) UidHandle(conn server.Conn) error {
+	return h.handle(conn, true)
+}
+
+func (h *sortHandler) handle(conn server.Conn, uid bool) error {
+	mbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, len(mbox.messages))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := mbox.messages[order[i]], mbox.messages[order[j]]
+		for k, key := range h.keys {
+			cmp := compareMessages(a, b, key)
+			if h.reverse[k] {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+
+	fields := []interface{}{imap.RawString("SORT")}
+	for _, i := range order {
+		fields = append(fields, messageID(mbox, i, uid))
+	}
+	return conn.WriteResp(&imap.DataResp{Fields: fields})
+}
+
+func compareMessages(a, b *message, key string) int {
+	switch key {
+	case "SIZE":
+		switch {
+		case a.Size < b.Size:
+			return -1
+		case a.Size > b.Size:
+			return 1
+		}
+		return 0
+	case "FROM", "SUBJECT":
+		header := "From"
+		if key == "SUBJECT" {
+			header = "Subject"
+		}
+		return strings.Compare(strings.ToLower(a.header(header)), strings.ToLower(b.header(header)))
+	default:
+		switch {
+		case a.Date.Before(b.Date):
+			return -1
+		case a.Date.After(b.Date):
+			return 1
+		}
+		return 0
+	}
+}
+
+type threadHandler struct{}
+
+func (h *threadHandler) Parse(fields []interface{}) error {
+	if len(fields) < 3 {
+		return errors.New("not enough arguments")
+	}
+	if algorithm, _ := fields[0].(string); !strings.EqualFold(algorithm, "REFERENCES") {
+		return errors.New("unsupported threading algorithm")
+	}
+	return nil
+}
+
+func (h *threadHandler) Handle(conn server.Conn) error {
+	return h.handle(conn, false)
+}
+
+func (h *threadHandler) UidHandle(conn server.Conn) error {
+	return h.handle(conn, true)
+}
+
+func (h *threadHandler) handle(conn server.Conn, uid bool) error {
+	mbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]int, len(mbox.messages))
+	for i, msg := range mbox.messages {
+		if id := msg.header("Message-Id"); id != "" {
+			ids[id] = i
+		}
+	}
+
+	children := make(map[int][]int, len(mbox.messages))
+	roots := []int{}
+	for i, msg := range mbox.messages {
+		parent, ok := ids[msg.header("In-Reply-To")]
+		if !ok || parent == i {
+			roots = append(roots, i)
+			continue
+		}
+		children[parent] = append(children[parent], i)
+	}
+
+	var thread func(i int) []interface{}
+	thread = func(i int) []interface{} {
+		fields := []interface{}{messageID(mbox, i, uid)}
+		replies := children[i]
+		if len(replies) == 1 {
+			return append(fields, thread(replies[0])...)
+		}
+		for _, reply := range replies {
+			fields = append(fields, thread(reply))
+		}
+		return fields
+	}
+
+	fields := []interface{}{imap.RawString("THREAD")}
+	for _, i := range roots {
+		fields = append(fields, thread(i))
+	}
+	return conn.WriteResp(&imap.DataResp{Fields: fields})
+}
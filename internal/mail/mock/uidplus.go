@@ -0,0 +1,136 @@
+package mock
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/server"
+)
+
+// UIDPlus returns an extension which has the server advertise UIDPLUS,
+// answering a UID COPY with the UIDs of the copies and taking UID EXPUNGE
+// to expunge only the messages given to it.
+func UIDPlus() server.Extension {
+	return uidPlusExtension{}
+}
+
+type uidPlusExtension struct{}
+
+func (uidPlusExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState == 0 {
+		return nil
+	}
+	return []string{"UIDPLUS"}
+}
+
+func (uidPlusExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "COPY":
+		return func() server.Handler { return &copyHandler{} }
+	case "EXPUNGE":
+		return func() server.Handler { return &expungeHandler{} }
+	}
+	return nil
+}
+
+type copyHandler struct {
+	commands.Copy
+}
+
+func (h *copyHandler) Handle(conn server.Conn) error {
+	mbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+	return mbox.CopyMessages(false, h.SeqSet, h.Mailbox)
+}
+
+func (h *copyHandler) UidHandle(conn server.Conn) error {
+	mbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	src, copied, err := mbox.copyMessages(true, h.SeqSet, h.Mailbox)
+	if err != nil {
+		return err
+	}
+	if len(src) == 0 {
+		return nil
+	}
+
+	srcSet, copiedSet := imap.SeqSet{}, imap.SeqSet{}
+	srcSet.AddNum(src...)
+	copiedSet.AddNum(copied...)
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "COPYUID",
+		Arguments: []interface{}{uint32(1), imap.RawString(srcSet.String()), imap.RawString(copiedSet.String())},
+		Info:      "UID COPY completed",
+	})
+}
+
+type expungeHandler struct {
+	seqset *imap.SeqSet
+}
+
+func (h *expungeHandler) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	set, ok := fields[0].(string)
+	if !ok {
+		return errors.New("UID set must be an atom")
+	}
+	seqset, err := imap.ParseSeqSet(set)
+	if err != nil {
+		return err
+	}
+	h.seqset = seqset
+	return nil
+}
+
+func (h *expungeHandler) Handle(conn server.Conn) error {
+	return h.expunge(conn, nil)
+}
+
+func (h *expungeHandler) UidHandle(conn server.Conn) error {
+	if h.seqset == nil {
+		return errors.New("UID EXPUNGE needs a set of UIDs")
+	}
+	return h.expunge(conn, h.seqset)
+}
+
+// expunge removes the messages marked as deleted, only those with UIDs within
+// uids unless it is nil, telling the client the sequence number of each.
+func (h *expungeHandler) expunge(conn server.Conn, uids *imap.SeqSet) error {
+	mbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	for i := len(mbox.messages) - 1; i >= 0; i-- {
+		msg := mbox.messages[i]
+		if uids != nil && !uids.Contains(msg.Uid) {
+			continue
+		}
+
+		deleted := false
+		for _, flag := range msg.Flags {
+			if flag == imap.DeletedFlag {
+				deleted = true
+				break
+			}
+		}
+		if !deleted {
+			continue
+		}
+
+		mbox.messages = append(mbox.messages[:i], mbox.messages[i+1:]...)
+		if err := conn.WriteResp(&imap.DataResp{Fields: []interface{}{uint32(i + 1), imap.RawString("EXPUNGE")}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
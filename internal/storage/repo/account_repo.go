@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"github.com/dgraph-io/badger/v3"
 	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/internal/storage/models"
 )
@@ -11,100 +10,46 @@ const (
 )
 
 type Accounts struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	DB   kvs.DB
+	rows *kvs.Repo[models.Account]
 }
 
 func (r *Accounts) Save(user *models.Account) error {
-	rowID, err := r.nextRowID()
+	rowID, err := r.repo().Save(kvs.RootOwner{}, *user)
 	if err != nil {
 		return err
 	}
 
-	return saveValueWithUUID(r.DB, r.tableName(), kvs.RootOwner{}, rowID, user)
+	user.SetID(rowID)
+	return nil
 }
 
 func (r *Accounts) GetByID(rowID uint32) (models.Account, error) {
-	acc := models.Account{
-		ID: uint32(rowID),
-	}
-	blankEntries := kvs.ConvertToBlankEntriesWithUUID(r.tableName(), kvs.RootOwner{}, rowID, acc)
-	for _, e := range blankEntries {
-		if err := kvs.Get(r.DB, &e); err != nil {
-			return acc, err
-		}
-
-		if err := kvs.LoadEntry(&acc, e); err != nil {
-			return acc, err
-		}
-	}
-
-	return acc, nil
+	acc, err := r.repo().Get(kvs.RootOwner{}, rowID)
+	acc.SetID(rowID)
+	return acc, err
 }
 
 func (r *Accounts) GetAll() ([]models.Account, error) {
 	accounts := []models.Account{}
-
-	blankEntries := kvs.ConvertToBlankEntriesWithUUID(r.tableName(), kvs.RootOwner{}, 0, models.Account{})
-	for _, ent := range blankEntries {
-		// iterate over all stored values for this entry
-		prefix := ent.PrefixKey()
-		r.DB.View(func(txn *badger.Txn) error {
-			it := txn.NewIterator(badger.DefaultIteratorOptions)
-			defer it.Close()
-
-			var rows uint32 = 0
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				// be very clear with our append conditions
-				if len(accounts) == 0 || rows >= uint32(len(accounts)) {
-					accounts = append(accounts, models.Account{
-						ID: rows,
-					})
-				}
-				item := it.Item()
-				ent.RowID = rows
-				if err := item.Value(func(val []byte) error {
-					ent.Data = val
-					return nil
-				}); err != nil {
-					return err
-				}
-				if err := kvs.LoadEntry(&accounts[rows], ent); err != nil {
-					return err
-				}
-				rows++
-			}
-
-			return nil
-		})
-	}
-
-	return accounts, nil
-}
-
-func (r *Accounts) tableName() string {
-	return accountsTableName
+	err := r.repo().Iterate(kvs.RootOwner{}, kvs.Query{}, func(rowID uint32, acc models.Account) error {
+		acc.SetID(rowID)
+		accounts = append(accounts, acc)
+		return nil
+	})
+	return accounts, err
 }
 
-func (r *Accounts) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(accountsTableName), 1)
-		if err != nil {
-			return 0, err
-		}
-		r.seq = seq
-	}
-
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
+func (r *Accounts) repo() *kvs.Repo[models.Account] {
+	if r.rows == nil {
+		r.rows = kvs.NewRepo[models.Account](r.DB, accountsTableName)
 	}
-	return uint32(s), nil
+	return r.rows
 }
 
-func (r Accounts) Close() {
-	if r.seq == nil {
+func (r *Accounts) Close() {
+	if r.rows == nil {
 		return
 	}
-	r.seq.Release()
+	r.rows.Close()
 }
@@ -37,10 +37,11 @@ func TestSaveUser(t *testing.T) {
 	is.NoErr(r.Save(&user))
 
 	is.NoErr(compareContentsWithExpected(r.DB, map[string][]byte{
-		"accounts":                 {0, 0, 0, 0, 0, 0, 0, 1},
-		"accounts.email.root.0":    []byte("test@place.com"),
-		"accounts.nick.root.0":     []byte("Test User"),
-		"accounts.password.root.0": []byte("fefweiofeifwwef"),
+		"accounts":                       {0, 0, 0, 0, 0, 0, 0, 1},
+		"accounts.email.root.0":          []byte("test@place.com"),
+		"accounts.nick.root.0":           []byte("Test User"),
+		"accounts.password.root.0":       []byte("fefweiofeifwwef"),
+		"_rows.accounts.root.0000000000": {},
 	}))
 }
 
@@ -74,13 +75,15 @@ func TestGetAllUsers(t *testing.T) {
 	defer r.Close()
 
 	is.NoErr(insertContents(r.DB, map[string][]byte{
-		"accounts":                 {0, 0, 0, 0, 0, 0, 0, 1},
-		"accounts.email.root.0":    []byte("first@place.com"),
-		"accounts.nick.root.0":     []byte("First User"),
-		"accounts.password.root.0": []byte("wwqdwdqdqwdqd"),
-		"accounts.email.root.1":    []byte("second@place.com"),
-		"accounts.nick.root.1":     []byte("Second User"),
-		"accounts.password.root.1": []byte("gigioregioigr"),
+		"accounts":                       {0, 0, 0, 0, 0, 0, 0, 1},
+		"accounts.email.root.0":          []byte("first@place.com"),
+		"accounts.nick.root.0":           []byte("First User"),
+		"accounts.password.root.0":       []byte("wwqdwdqdqwdqd"),
+		"accounts.email.root.1":          []byte("second@place.com"),
+		"accounts.nick.root.1":           []byte("Second User"),
+		"accounts.password.root.1":       []byte("gigioregioigr"),
+		"_rows.accounts.root.0000000000": {},
+		"_rows.accounts.root.0000000001": {},
 	}))
 
 	accs, err := r.GetAll()
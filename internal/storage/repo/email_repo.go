@@ -1,7 +1,7 @@
 package repo
 
 import (
-	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
 	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/internal/storage/models"
 )
@@ -10,106 +10,51 @@ const (
 	emailsTableName = "emails"
 )
 
+// legacyOwner is the owner every row of this package's tables has always
+// been stored under, as integer owner IDs never made it into the keys.
+var legacyOwner = uuid.UUID{}
+
 type Emails struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	DB   kvs.DB
+	rows *kvs.Repo[models.Email]
 }
 
 func (r *Emails) Save(accountID uint32, email *models.Email) error {
-	ownerID := accountID
-	rowID, err := r.nextRowID()
+	rowID, err := r.repo().Save(legacyOwner, *email)
 	if err != nil {
 		return err
 	}
 
-	return saveValue(r.DB, r.tableName(), rowID, ownerID, email)
+	email.SetID(rowID)
+	return nil
 }
 
 func (r *Emails) GetByID(rowID uint32) (models.Email, error) {
-	acc := models.Email{
-		ID: rowID,
-	}
-	blankEntries := kvs.ConvertToBlankEntries(r.tableName(), 0, rowID, acc)
-	for _, e := range blankEntries {
-		if err := kvs.Get(r.DB, &e); err != nil {
-			return acc, err
-		}
-
-		if err := kvs.LoadEntry(&acc, e); err != nil {
-			return acc, err
-		}
-	}
-
-	return acc, nil
+	email, err := r.repo().Get(legacyOwner, rowID)
+	email.SetID(rowID)
+	return email, err
 }
 
-// TODO:(tauraamui) really all of the generic "Getters" and "Setters" methods
-// of these repos are identical thanks to using the kvs backend
-// so should move each of these into using Go generics rather than
-// copying them for each type.
 func (r *Emails) GetAll(accountID uint32) ([]models.Email, error) {
-	emails := make([]models.Email, 1)
-
-	blankEntries := kvs.ConvertToBlankEntries(r.tableName(), 0, 0, emails[0])
-	for _, ent := range blankEntries {
-		// iterate over all stored values for this entry
-		prefix := ent.PrefixKey()
-		r.DB.View(func(txn *badger.Txn) error {
-			it := txn.NewIterator(badger.DefaultIteratorOptions)
-			defer it.Close()
-
-			var rows uint32 = 0
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				if rows >= uint32(len(emails)) {
-					emails = append(emails, models.Email{
-						ID: rows,
-					})
-				}
-				item := it.Item()
-				ent.RowID = rows
-				if err := item.Value(func(val []byte) error {
-					ent.Data = val
-					return nil
-				}); err != nil {
-					return err
-				}
-				if err := kvs.LoadEntry(&emails[rows], ent); err != nil {
-					return err
-				}
-				rows++
-			}
-
-			return nil
-		})
-	}
-
-	return emails, nil
-}
-
-func (r *Emails) tableName() string {
-	return emailsTableName
+	emails := []models.Email{}
+	err := r.repo().Iterate(legacyOwner, kvs.Query{}, func(rowID uint32, email models.Email) error {
+		email.SetID(rowID)
+		emails = append(emails, email)
+		return nil
+	})
+	return emails, err
 }
 
-func (r *Emails) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(r.tableName()), 100)
-		if err != nil {
-			return 0, err
-		}
-		r.seq = seq
+func (r *Emails) repo() *kvs.Repo[models.Email] {
+	if r.rows == nil {
+		r.rows = kvs.NewRepo[models.Email](r.DB, emailsTableName)
 	}
-
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
-	}
-
-	return uint32(s), nil
+	return r.rows
 }
 
 func (r *Emails) Close() {
-	if r.seq == nil {
+	if r.rows == nil {
 		return
 	}
-	r.seq.Release()
+	r.rows.Close()
 }
@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"github.com/dgraph-io/badger/v3"
 	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/internal/storage/models"
 )
@@ -15,100 +14,46 @@ const (
 //	messages, when saving a message it would just simply set the owner
 //	ID to be the mailboxes' ID
 type Mailboxes struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	DB   kvs.DB
+	rows *kvs.Repo[models.Mailbox]
 }
 
 func (r *Mailboxes) Save(accountID uint32, mailbox *models.Mailbox) error {
-	ownerID := accountID
-	rowID, err := r.nextRowID()
+	rowID, err := r.repo().Save(legacyOwner, *mailbox)
 	if err != nil {
 		return err
 	}
 
-	return saveValue(r.DB, r.tableName(), rowID, ownerID, mailbox)
+	mailbox.SetID(rowID)
+	return nil
 }
 
 func (r *Mailboxes) GetByID(rowID uint32) (models.Mailbox, error) {
-	mb := models.Mailbox{
-		ID: rowID,
-	}
-	blankEntries := kvs.ConvertToBlankEntries(r.tableName(), 0, rowID, mb)
-	for _, e := range blankEntries {
-		if err := kvs.Get(r.DB, &e); err != nil {
-			return mb, err
-		}
-
-		if err := kvs.LoadEntry(&mb, e); err != nil {
-			return mb, err
-		}
-	}
-	return mb, nil
+	mb, err := r.repo().Get(legacyOwner, rowID)
+	mb.SetID(rowID)
+	return mb, err
 }
 
 func (r *Mailboxes) GetAll(accountID uint32) ([]models.Mailbox, error) {
-	mailboxes := make([]models.Mailbox, 1)
-
-	blankEntries := kvs.ConvertToBlankEntries(r.tableName(), 0, 0, mailboxes[0])
-	for _, ent := range blankEntries {
-		// iterate over all stored values for this entry
-		prefix := ent.PrefixKey()
-		r.DB.View(func(txn *badger.Txn) error {
-			it := txn.NewIterator(badger.DefaultIteratorOptions)
-			defer it.Close()
-
-			var rows uint32 = 0
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				if rows >= uint32(len(mailboxes)) {
-					mailboxes = append(mailboxes, models.Mailbox{
-						ID: rows,
-					})
-				}
-				item := it.Item()
-				ent.RowID = rows
-				if err := item.Value(func(val []byte) error {
-					ent.Data = val
-					return nil
-				}); err != nil {
-					return err
-				}
-				if err := kvs.LoadEntry(&mailboxes[rows], ent); err != nil {
-					return err
-				}
-				rows++
-			}
-
-			return nil
-		})
-	}
-
-	return mailboxes, nil
-}
-
-func (r *Mailboxes) tableName() string {
-	return mailboxesTableName
+	mailboxes := []models.Mailbox{}
+	err := r.repo().Iterate(legacyOwner, kvs.Query{}, func(rowID uint32, mb models.Mailbox) error {
+		mb.SetID(rowID)
+		mailboxes = append(mailboxes, mb)
+		return nil
+	})
+	return mailboxes, err
 }
 
-func (r *Mailboxes) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(r.tableName()), 100)
-		if err != nil {
-			return 0, err
-		}
-		r.seq = seq
+func (r *Mailboxes) repo() *kvs.Repo[models.Mailbox] {
+	if r.rows == nil {
+		r.rows = kvs.NewRepo[models.Mailbox](r.DB, mailboxesTableName)
 	}
-
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
-	}
-
-	return uint32(s), nil
+	return r.rows
 }
 
 func (r *Mailboxes) Close() {
-	if r.seq == nil {
+	if r.rows == nil {
 		return
 	}
-	r.seq.Release()
+	r.rows.Close()
 }
@@ -1,60 +1,53 @@
 package mail
 
 import (
-	"github.com/dgraph-io/badger/v3"
+	"errors"
+
 	"github.com/tauraamui/maildew/internal/kvs"
 )
 
 const (
-	accountsTableName = "accounts"
+	AccountsTableName = "accounts"
 )
 
 type AccountRepo interface {
 	Save(user Account) error
+	// Update replaces the account with the same UUID.
+	Update(user Account) error
 	Close()
 }
 
 func NewAccountRepo(db kvs.DB) AccountRepo {
-	return accountRepo{DB: db}
+	return accountRepo{rows: kvs.NewRepo[Account](db, AccountsTableName)}
 }
 
 type accountRepo struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	rows *kvs.Repo[Account]
 }
 
 func (r accountRepo) Save(user Account) error {
-	rowID, err := r.nextRowID()
-	if err != nil {
-		return err
-	}
-
-	return saveValueWithUUID(r.DB, r.tableName(), kvs.RootOwner{}, rowID, user)
+	_, err := r.rows.Save(kvs.RootOwner{}, user)
+	return err
 }
 
-func (r accountRepo) tableName() string {
-	return accountsTableName
-}
-
-func (r accountRepo) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(accountsTableName), 1)
-		if err != nil {
-			return 0, err
+func (r accountRepo) Update(user Account) error {
+	var found *uint32
+	err := r.rows.Iterate(kvs.RootOwner{}, kvs.Query{}, func(rowID uint32, acc Account) error {
+		if acc.UUID != nil && user.UUID != nil && acc.UUID.String() == user.UUID.String() {
+			found = &rowID
+			return errStopIterating
 		}
-		r.seq = seq
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIterating) {
+		return err
 	}
-
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
+	if found == nil {
+		return kvs.ErrRowNotFound
 	}
-	return uint32(s), nil
+	return r.rows.Update(kvs.RootOwner{}, *found, user)
 }
 
 func (r accountRepo) Close() {
-	if r.seq == nil {
-		return
-	}
-	r.seq.Release()
+	r.rows.Close()
 }
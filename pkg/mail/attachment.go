@@ -0,0 +1,272 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+)
+
+const (
+	// attachmentChunkSize bounds how much of an attachment
+	// is held in memory at once while it is being saved.
+	attachmentChunkSize = 256 << 10
+	maxFilenameLen      = 255
+	defaultFilename     = "attachment"
+)
+
+// SanitizeFilename makes the filename given by an attachment safe to create
+// within a directory, by keeping only its last path element and replacing
+// any characters which are not safe within filenames.
+func SanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	// a leading dot would hide the file, a trailing one is dropped by windows
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return defaultFilename
+	}
+
+	if len(name) > maxFilenameLen {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFilenameLen/2 {
+			ext = ""
+		}
+		base := name[:maxFilenameLen-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// DefaultDownloadDir returns the directory attachments
+// are saved to when none has been configured.
+func DefaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	return filepath.Join(home, "Downloads")
+}
+
+// AttachmentSaver saves attachments by fetching them from the server.
+type AttachmentSaver struct {
+	conn RemoteBodyFetcherConnection
+	dir  string
+}
+
+func NewAttachmentSaver(conn RemoteBodyFetcherConnection, dir string) *AttachmentSaver {
+	if dir == "" {
+		dir = DefaultDownloadDir()
+	}
+	return &AttachmentSaver{conn: conn, dir: dir}
+}
+
+// Save fetches the part of the message with the given UID and writes it
+// decoded to the download directory, returning the path it was saved to.
+// The part is fetched a chunk at a time, so that large attachments are
+// never held in memory whole, and an existing file is never replaced.
+func (s *AttachmentSaver) Save(mb Mailbox, uid uint32, part *Part) (string, error) {
+	path, err := sectionPath(part.Section)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.conn.Select(mb.Name, true); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create download directory: %w", err)
+	}
+	name := part.Filename
+	if name == "" {
+		name = defaultFilename
+	}
+	f, err := createUnique(s.dir, SanitizeFilename(name))
+	if err != nil {
+		return "", err
+	}
+
+	// only the transfer encoding is undone, text is kept in its own charset
+	h := message.Header{}
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Transfer-Encoding", part.Encoding)
+	e, err := message.New(h, &sectionReader{conn: s.conn, uid: uid, path: path})
+	if err != nil && !message.IsUnknownEncoding(err) {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if _, err := io.Copy(f, e.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("unable to save %s: %w", name, err)
+	}
+	return f.Name(), f.Close()
+}
+
+// sectionPath parses a section number such as 1.2.
+func sectionPath(section string) ([]int, error) {
+	path := []int{}
+	for _, s := range strings.Split(section, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid section %q", section)
+		}
+		path = append(path, n)
+	}
+	return path, nil
+}
+
+// createUnique creates name within dir, numbering the
+// name instead of replacing a file which already exists.
+func createUnique(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("unable to find a free name for %s", name)
+}
+
+// sectionReader reads the still encoded body of a single part of a message,
+// fetching the next chunk of it with BODY.PEEK[section]<offset.size> as each
+// chunk is read.
+type sectionReader struct {
+	conn   RemoteBodyFetcherConnection
+	uid    uint32
+	path   []int
+	offset int
+	buf    []byte
+	done   bool
+}
+
+func (r *sectionReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.fetch(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *sectionReader) fetch() error {
+	seqset := imap.SeqSet{}
+	seqset.AddNum(r.uid)
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Path: r.path},
+		Peek:         true,
+		Partial:      []int{r.offset, attachmentChunkSize},
+	}
+
+	msgc := make(chan *imap.Message, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.conn.UidFetch(&seqset, []imap.FetchItem{section.FetchItem()}, msgc)
+	}()
+
+	var data []byte
+	var readErr error
+	found := false
+	// msgc has to be drained for the fetch to finish, even after a failed read
+	for msg := range msgc {
+		if msg == nil || readErr != nil {
+			continue
+		}
+		found = true
+		if literal := msg.GetBody(section); literal != nil {
+			data, readErr = io.ReadAll(literal)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if !found {
+		return fmt.Errorf("message %d not found", r.uid)
+	}
+
+	r.buf = data
+	r.offset += len(data)
+	r.done = len(data) < attachmentChunkSize
+	return nil
+}
+
+// AttachmentOpenCommand returns the command to open the file at path with,
+// from commands by its exact media type, then by its type such as image/*,
+// falling back to the system's default opener. Any argument of %s within
+// the command is replaced with the path, otherwise the path is appended.
+func AttachmentOpenCommand(path, contentType string, commands map[string][]string) *exec.Cmd {
+	contentType = strings.ToLower(contentType)
+	command := commands[contentType]
+	if len(command) == 0 {
+		if typ, _, ok := strings.Cut(contentType, "/"); ok {
+			command = commands[typ+"/*"]
+		}
+	}
+	if len(command) == 0 {
+		command = []string{defaultOpener()}
+	}
+
+	args := make([]string, 0, len(command))
+	substituted := false
+	for _, arg := range command[1:] {
+		if arg == "%s" {
+			arg, substituted = path, true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, path)
+	}
+	return exec.Command(command[0], args...)
+}
+
+func defaultOpener() string {
+	if runtime.GOOS == "darwin" {
+		return "open"
+	}
+	return "xdg-open"
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	is := is.New(t)
+
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../.ssh/authorized_keys", "authorized_keys"},
+		{`..\..\windows\system32\evil.dll`, "evil.dll"},
+		{"/etc/passwd", "passwd"},
+		{"..", "attachment"},
+		{"", "attachment"},
+		{".bashrc", "bashrc"},
+		{"inv\x00oice\r\n.pdf", "invoice.pdf"},
+		{`what?<"now">.txt`, "what___now__.txt"},
+		{"naïve café.txt", "naïve café.txt"},
+		{strings.Repeat("é", 200) + ".pdf", strings.Repeat("é", 125) + ".pdf"},
+	} {
+		is.Equal(SanitizeFilename(tt.name), tt.want) // tt.name
+	}
+}
+
+func TestAttachmentOpenCommand(t *testing.T) {
+	is := is.New(t)
+
+	commands := map[string][]string{
+		"application/pdf": {"zathura"},
+		"image/*":         {"feh", "--scale-down", "%s"},
+	}
+
+	cmd := AttachmentOpenCommand("/tmp/a.pdf", "application/PDF", commands)
+	is.Equal(cmd.Args, []string{"zathura", "/tmp/a.pdf"})
+
+	cmd = AttachmentOpenCommand("/tmp/a.png", "image/png", commands)
+	is.Equal(cmd.Args, []string{"feh", "--scale-down", "/tmp/a.png"})
+
+	cmd = AttachmentOpenCommand("/tmp/a.zip", "application/zip", commands)
+	is.Equal(cmd.Args, []string{defaultOpener(), "/tmp/a.zip"})
+}
+
+func TestAttachmentSaverStreamsPartFromServer(t *testing.T) {
+	is := is.New(t)
+
+	// large enough to be fetched over several chunks
+	data := make([]byte, 3*attachmentChunkSize/2)
+	rand.New(rand.NewSource(1)).Read(data)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var wrapped strings.Builder
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+
+	raw := "From: alice@example.org\r\n" +
+		"Subject: Photos\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See attached\r\n" +
+		"--b\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Disposition: attachment; filename=\"../../holiday.jpg\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		wrapped.String() +
+		"--b--\r\n"
+
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	backend.StoreMessage("username", "INBOX", raw)
+
+	err, shutdown := startLocalServerWithBackend(l, backend)
+	is.NoErr(err)
+	defer shutdown()
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	parsed, err := ParseMessage(strings.NewReader(raw))
+	is.NoErr(err)
+	attachments := parsed.Attachments()
+	is.Equal(len(attachments), 1)
+	is.Equal(attachments[0].Section, "2")
+	is.Equal(attachments[0].Encoding, "base64")
+
+	dir := filepath.Join(t.TempDir(), "downloads")
+	saver := NewAttachmentSaver(cc.(RemoteBodyFetcherConnection), dir)
+	inbox := Mailbox{Name: "INBOX"}
+
+	path, err := saver.Save(inbox, 1, attachments[0])
+	is.NoErr(err)
+	is.Equal(path, filepath.Join(dir, "holiday.jpg"))
+	saved, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.True(bytes.Equal(saved, data))
+
+	// saving again keeps the first copy
+	path, err = saver.Save(inbox, 1, attachments[0])
+	is.NoErr(err)
+	is.Equal(path, filepath.Join(dir, "holiday (1).jpg"))
+}
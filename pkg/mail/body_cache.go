@@ -0,0 +1,412 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+const (
+	// both body tables share the owner and row ID of the message
+	// they belong to, so a body is found from its message alone
+	BodiesTableName    = "bodies"
+	BodyMetaTableName  = "body_meta"
+	defaultEvictPeriod = 10 * time.Minute
+)
+
+// BodyCachePolicy decides which cached message bodies are evicted, the
+// envelopes within the messages table are never evicted along with them.
+type BodyCachePolicy struct {
+	// MaxTotalSize in bytes of every cached body, the least recently
+	// opened bodies are evicted first to get back under it. Zero is unlimited.
+	MaxTotalSize int64
+	// MaxAge since a body was last opened, zero is unlimited.
+	MaxAge time.Duration
+	// MailboxMaxAge overrides MaxAge for the mailboxes with the given names.
+	MailboxMaxAge map[string]time.Duration
+	KeepFlagged   bool
+	KeepRecent    bool
+}
+
+func (p BodyCachePolicy) maxAge(mailboxName string) time.Duration {
+	if age, ok := p.MailboxMaxAge[mailboxName]; ok {
+		return age
+	}
+	return p.MaxAge
+}
+
+func (p BodyCachePolicy) keep(msg Message) bool {
+	return (p.KeepFlagged && msg.HasFlag(imap.FlaggedFlag)) || (p.KeepRecent && msg.HasFlag(imap.RecentFlag))
+}
+
+// BodyMeta is kept apart from the body itself so that eviction
+// never has to read the bodies it is deciding about.
+type BodyMeta struct {
+	Size       int64
+	FetchedAt  time.Time
+	AccessedAt time.Time
+}
+
+type body struct {
+	Data []byte
+}
+
+// ErrBodyNotCached is returned when a body which is not
+// cached is opened with no way of fetching it.
+var ErrBodyNotCached = errors.New("message body is not cached")
+
+// BodyFetcher retrieves a message's body from the remote server.
+type BodyFetcher interface {
+	FetchBody(mailboxName string, uid uint32) ([]byte, error)
+}
+
+type BodyCache struct {
+	log      logging.I
+	db       kvs.DB
+	policy   BodyCachePolicy
+	mu       sync.Mutex
+	fetcher  BodyFetcher
+	messages *kvs.Repo[Message]
+	bodies   *kvs.Repo[body]
+	meta     *kvs.Repo[BodyMeta]
+	index    *SearchIndex
+	now      func() time.Time
+}
+
+func NewBodyCache(log logging.I, db kvs.DB, policy BodyCachePolicy, fetcher BodyFetcher) *BodyCache {
+	return &BodyCache{
+		log:      log,
+		db:       db,
+		policy:   policy,
+		fetcher:  fetcher,
+		messages: kvs.NewRepo[Message](db, MessagesTableName),
+		bodies:   kvs.NewRepo[body](db, BodiesTableName),
+		meta:     kvs.NewRepo[BodyMeta](db, BodyMetaTableName),
+		index:    NewSearchIndex(db),
+		now:      time.Now,
+	}
+}
+
+// Store caches the body of the message stored at rowID within mb and
+// indexes it for searching, the index is kept after the body is evicted.
+func (c *BodyCache) Store(mb Mailbox, rowID uint32, data []byte) error {
+	if err := kvs.StoreRow(c.db, BodiesTableName, mb.UUID, rowID, body{Data: data}); err != nil {
+		return err
+	}
+
+	// a body which can not be decoded is still worth caching
+	if err := c.index.AddBody(mb.UUID, rowID, data); err != nil {
+		c.log.Error().Msgf("unable to index message body: %v", err)
+	}
+
+	now := c.now()
+	return kvs.StoreRow(c.db, BodyMetaTableName, mb.UUID, rowID, BodyMeta{Size: int64(len(data)), FetchedAt: now, AccessedAt: now})
+}
+
+// Open returns the body of the message stored at rowID within mb, fetching
+// and caching it again if it has been evicted, and marks it as accessed.
+func (c *BodyCache) Open(mb Mailbox, rowID uint32) ([]byte, error) {
+	meta, err := c.meta.Get(mb.UUID, rowID)
+	if errors.Is(err, kvs.ErrRowNotFound) {
+		return c.refetch(mb, rowID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.bodies.Get(mb.UUID, rowID)
+	if errors.Is(err, kvs.ErrRowNotFound) {
+		return c.refetch(mb, rowID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	meta.AccessedAt = c.now()
+	if err := c.meta.Update(mb.UUID, rowID, meta); err != nil {
+		return nil, err
+	}
+
+	return b.Data, nil
+}
+
+// SetFetcher sets what bodies which are not cached are fetched with,
+// for when the connection to the server is made after the cache.
+func (c *BodyCache) SetFetcher(fetcher BodyFetcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetcher = fetcher
+}
+
+func (c *BodyCache) refetch(mb Mailbox, rowID uint32) ([]byte, error) {
+	msg, err := c.messages.Get(mb.UUID, rowID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load message %d: %w", rowID, err)
+	}
+
+	c.mu.Lock()
+	fetcher := c.fetcher
+	c.mu.Unlock()
+	if fetcher == nil {
+		return nil, ErrBodyNotCached
+	}
+
+	data, err := fetcher.FetchBody(mb.Name, msg.RemoteUID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch body of message %d: %w", msg.RemoteUID, err)
+	}
+
+	return data, c.Store(mb, rowID, data)
+}
+
+type EvictResult struct {
+	Evicted    int
+	FreedBytes int64
+	TotalSize  int64
+}
+
+type cachedBody struct {
+	owner   kvs.UUID
+	rowID   uint32
+	mailbox string
+	meta    BodyMeta
+	keep    bool
+}
+
+// Evict drops every body older than its mailbox's max age, then the least
+// recently opened bodies until the total size is within the limit.
+func (c *BodyCache) Evict() (EvictResult, error) {
+	result := EvictResult{}
+
+	cached, err := c.cachedBodies()
+	if err != nil {
+		return result, err
+	}
+
+	now := c.now()
+	remaining := []cachedBody{}
+	for _, cb := range cached {
+		maxAge := c.policy.maxAge(cb.mailbox)
+		if !cb.keep && maxAge > 0 && now.Sub(cb.meta.AccessedAt) > maxAge {
+			if err := c.evict(cb, &result); err != nil {
+				return result, err
+			}
+			continue
+		}
+		remaining = append(remaining, cb)
+		result.TotalSize += cb.meta.Size
+	}
+
+	if c.policy.MaxTotalSize <= 0 || result.TotalSize <= c.policy.MaxTotalSize {
+		return result, nil
+	}
+
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].meta.AccessedAt.Before(remaining[j].meta.AccessedAt)
+	})
+	for _, cb := range remaining {
+		if result.TotalSize <= c.policy.MaxTotalSize {
+			break
+		}
+		if cb.keep {
+			continue
+		}
+		if err := c.evict(cb, &result); err != nil {
+			return result, err
+		}
+		result.TotalSize -= cb.meta.Size
+	}
+
+	return result, nil
+}
+
+func (c *BodyCache) evict(cb cachedBody, result *EvictResult) error {
+	if err := c.bodies.Delete(cb.owner, cb.rowID); err != nil {
+		return err
+	}
+	if err := c.meta.Delete(cb.owner, cb.rowID); err != nil {
+		return err
+	}
+	result.Evicted++
+	result.FreedBytes += cb.meta.Size
+	return nil
+}
+
+func (c *BodyCache) cachedBodies() ([]cachedBody, error) {
+	mailboxNames, err := c.mailboxNames()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := kvs.ScanRows(c.db, BodyMetaTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make([]cachedBody, 0, len(rows))
+	for _, row := range rows {
+		owner, err := kvs.ParseOwner(row.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := c.meta.Get(owner, row.RowID)
+		if err != nil {
+			return nil, err
+		}
+
+		cb := cachedBody{owner: owner, rowID: row.RowID, mailbox: mailboxNames[row.Owner], meta: meta}
+		msg, err := c.messages.Get(owner, row.RowID)
+		if err != nil && !errors.Is(err, kvs.ErrRowNotFound) {
+			return nil, err
+		}
+		cb.keep = err == nil && c.policy.keep(msg)
+
+		cached = append(cached, cb)
+	}
+
+	return cached, nil
+}
+
+// mailboxNames maps the UUID of every stored mailbox to its name.
+func (c *BodyCache) mailboxNames() (map[string]string, error) {
+	rows, err := kvs.ScanRows(c.db, MailboxesTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, row := range rows {
+		owner, err := kvs.ParseOwner(row.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		mb := Mailbox{}
+		if err := kvs.LoadRow(c.db, MailboxesTableName, owner, row.RowID, &mb); err != nil {
+			return nil, err
+		}
+		if mb.UUID != nil {
+			names[mb.UUID.String()] = mb.Name
+		}
+	}
+	return names, nil
+}
+
+// RunEvictor evicts bodies every period until ctx is done.
+func (c *BodyCache) RunEvictor(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		period = defaultEvictPeriod
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := c.Evict()
+			if err != nil {
+				c.log.Error().Msgf("unable to evict message bodies: %v", err)
+				continue
+			}
+			if result.Evicted > 0 {
+				c.log.Debug().Msgf("evicted %d message bodies, freeing %d bytes", result.Evicted, result.FreedBytes)
+			}
+		}
+	}
+}
+
+// DefaultBodyCachePolicy is used whenever no policy has been configured.
+func DefaultBodyCachePolicy() BodyCachePolicy {
+	return BodyCachePolicy{
+		MaxTotalSize: 512 << 20,
+		MaxAge:       30 * 24 * time.Hour,
+		KeepFlagged:  true,
+		KeepRecent:   true,
+	}
+}
+
+// BodyCachePolicyFromConfig converts the configured body cache values,
+// which are kept in megabytes and days, into a policy.
+func BodyCachePolicyFromConfig(values configdef.BodyCacheValues) BodyCachePolicy {
+	const day = 24 * time.Hour
+	policy := BodyCachePolicy{
+		MaxTotalSize: values.MaxSizeMB << 20,
+		MaxAge:       time.Duration(values.MaxAgeDays) * day,
+		KeepFlagged:  values.KeepFlagged,
+		KeepRecent:   values.KeepRecent,
+	}
+	if len(values.MailboxMaxAgeDays) > 0 {
+		policy.MailboxMaxAge = map[string]time.Duration{}
+		for name, days := range values.MailboxMaxAgeDays {
+			policy.MailboxMaxAge[name] = time.Duration(days) * day
+		}
+	}
+	return policy
+}
+
+// RemoteBodyFetcherConnection is the part of an IMAP client
+// needed to fetch a single message's body by its UID.
+type RemoteBodyFetcherConnection interface {
+	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
+	UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
+}
+
+func NewRemoteBodyFetcher(conn RemoteBodyFetcherConnection) BodyFetcher {
+	return remoteBodyFetcher{conn: conn}
+}
+
+type remoteBodyFetcher struct {
+	conn RemoteBodyFetcherConnection
+}
+
+func (f remoteBodyFetcher) FetchBody(mailboxName string, uid uint32) ([]byte, error) {
+	if _, err := f.conn.Select(mailboxName, true); err != nil {
+		return nil, err
+	}
+
+	seqset := imap.SeqSet{}
+	seqset.AddNum(uid)
+	// peeking leaves the message's \Seen flag untouched
+	section := &imap.BodySectionName{Peek: true}
+
+	msgc := make(chan *imap.Message, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- f.conn.UidFetch(&seqset, []imap.FetchItem{section.FetchItem()}, msgc)
+	}()
+
+	var data []byte
+	var readErr error
+	// msgc has to be drained for the fetch to finish, even after a failed read
+	for msg := range msgc {
+		if msg == nil || readErr != nil {
+			continue
+		}
+		if literal := msg.GetBody(section); literal != nil {
+			data, readErr = io.ReadAll(literal)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if data == nil {
+		return nil, fmt.Errorf("message %d not found within %s", uid, mailboxName)
+	}
+	return data, nil
+}
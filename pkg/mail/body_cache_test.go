@@ -0,0 +1,190 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+type fakeBodyFetcher struct {
+	fetched []string
+}
+
+func (f *fakeBodyFetcher) FetchBody(mailboxName string, uid uint32) ([]byte, error) {
+	f.fetched = append(f.fetched, fmt.Sprintf("%s/%d", mailboxName, uid))
+	return []byte(fmt.Sprintf("body of %d", uid)), nil
+}
+
+type bodyCacheFixture struct {
+	cache   *BodyCache
+	fetcher *fakeBodyFetcher
+	now     time.Time
+	inbox   Mailbox
+	archive Mailbox
+}
+
+// newBodyCacheFixture stores the given messages within an inbox and
+// an archive mailbox, each message's row ID is its position within msgs.
+func newBodyCacheFixture(t *testing.T, policy BodyCachePolicy, inbox, archive []Message) *bodyCacheFixture {
+	t.Helper()
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	t.Cleanup(func() { db.Close() })
+
+	f := &bodyCacheFixture{
+		fetcher: &fakeBodyFetcher{},
+		now:     time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC),
+		inbox:   Mailbox{UUID: uuid.New(), Name: "INBOX"},
+		archive: Mailbox{UUID: uuid.New(), Name: "ARCHIVE"},
+	}
+	f.cache = NewBodyCache(logging.New(logging.Options{Writer: &bytes.Buffer{}}), db, policy, f.fetcher)
+	f.cache.now = func() time.Time { return f.now }
+
+	mbRepo := NewMailboxRepo(db)
+	is.NoErr(mbRepo.Save(uuid.New(), f.inbox))
+	is.NoErr(mbRepo.Save(uuid.New(), f.archive))
+
+	for mb, msgs := range map[Mailbox][]Message{f.inbox: inbox, f.archive: archive} {
+		for i, msg := range msgs {
+			is.NoErr(kvs.StoreRow(db, MessagesTableName, mb.UUID, uint32(i), msg))
+		}
+	}
+
+	return f
+}
+
+func TestBodyCacheOpenRefetchesEvictedBodies(t *testing.T) {
+	is := is.New(t)
+
+	f := newBodyCacheFixture(t, BodyCachePolicy{}, []Message{{UUID: uuid.New(), RemoteUID: 42}}, nil)
+
+	is.NoErr(f.cache.Store(f.inbox, 0, []byte("cached body")))
+	body, err := f.cache.Open(f.inbox, 0)
+	is.NoErr(err)
+	is.Equal(string(body), "cached body")
+	is.Equal(len(f.fetcher.fetched), 0) // cached body should not be fetched
+
+	is.NoErr(f.cache.bodies.Delete(f.inbox.UUID, 0))
+	is.NoErr(f.cache.meta.Delete(f.inbox.UUID, 0))
+
+	body, err = f.cache.Open(f.inbox, 0)
+	is.NoErr(err)
+	is.Equal(string(body), "body of 42")
+	is.Equal(f.fetcher.fetched, []string{"INBOX/42"})
+
+	body, err = f.cache.Open(f.inbox, 0)
+	is.NoErr(err)
+	is.Equal(string(body), "body of 42")
+	is.Equal(len(f.fetcher.fetched), 1) // refetched body should have been cached again
+}
+
+func TestBodyCacheWithoutFetcherReportsUncachedBodies(t *testing.T) {
+	is := is.New(t)
+
+	f := newBodyCacheFixture(t, BodyCachePolicy{}, []Message{{UUID: uuid.New(), RemoteUID: 7}}, nil)
+	f.cache.SetFetcher(nil)
+
+	_, err := f.cache.Open(f.inbox, 0)
+	is.True(errors.Is(err, ErrBodyNotCached))
+
+	f.cache.SetFetcher(f.fetcher)
+	body, err := f.cache.Open(f.inbox, 0)
+	is.NoErr(err)
+	is.Equal(string(body), "body of 7")
+}
+
+func TestBodyCacheTracksAccessTimes(t *testing.T) {
+	is := is.New(t)
+
+	f := newBodyCacheFixture(t, BodyCachePolicy{}, []Message{{UUID: uuid.New()}}, nil)
+	is.NoErr(f.cache.Store(f.inbox, 0, []byte("body")))
+
+	f.now = f.now.Add(time.Hour)
+	_, err := f.cache.Open(f.inbox, 0)
+	is.NoErr(err)
+
+	meta, err := f.cache.meta.Get(f.inbox.UUID, 0)
+	is.NoErr(err)
+	is.True(meta.AccessedAt.Equal(f.now))
+	is.True(meta.FetchedAt.Equal(f.now.Add(-time.Hour)))
+}
+
+func TestBodyCacheEvictsByMailboxAge(t *testing.T) {
+	is := is.New(t)
+
+	msgs := []Message{
+		{UUID: uuid.New()},
+		{UUID: uuid.New(), Flags: []string{imap.FlaggedFlag}},
+		{UUID: uuid.New(), Flags: []string{imap.RecentFlag}},
+	}
+	f := newBodyCacheFixture(t, BodyCachePolicy{
+		MaxAge:        24 * time.Hour,
+		MailboxMaxAge: map[string]time.Duration{"INBOX": time.Hour},
+		KeepFlagged:   true,
+		KeepRecent:    true,
+	}, msgs, msgs)
+
+	for i := range msgs {
+		is.NoErr(f.cache.Store(f.inbox, uint32(i), []byte("body")))
+		is.NoErr(f.cache.Store(f.archive, uint32(i), []byte("body")))
+	}
+
+	f.now = f.now.Add(2 * time.Hour)
+	result, err := f.cache.Evict()
+	is.NoErr(err)
+	is.Equal(result.Evicted, 1) // only the unflagged inbox body is past its mailbox's max age
+	is.Equal(result.FreedBytes, int64(4))
+	is.Equal(result.TotalSize, int64(20))
+
+	_, err = f.cache.bodies.Get(f.inbox.UUID, 0)
+	is.True(err == kvs.ErrRowNotFound)
+
+	_, err = f.cache.messages.Get(f.inbox.UUID, 0)
+	is.NoErr(err) // envelope should be kept
+
+	f.now = f.now.Add(48 * time.Hour)
+	result, err = f.cache.Evict()
+	is.NoErr(err)
+	is.Equal(result.Evicted, 1) // flagged and recent bodies should always be kept
+}
+
+func TestBodyCacheEvictsLeastRecentlyOpenedOverMaxSize(t *testing.T) {
+	is := is.New(t)
+
+	msgs := []Message{
+		{UUID: uuid.New(), Flags: []string{imap.FlaggedFlag}},
+		{UUID: uuid.New()},
+		{UUID: uuid.New()},
+		{UUID: uuid.New()},
+	}
+	f := newBodyCacheFixture(t, BodyCachePolicy{MaxTotalSize: 250, KeepFlagged: true}, msgs, nil)
+
+	for i := range msgs {
+		is.NoErr(f.cache.Store(f.inbox, uint32(i), bytes.Repeat([]byte("x"), 100)))
+		f.now = f.now.Add(time.Minute)
+	}
+
+	// opening the oldest unflagged body should make it the most recent
+	_, err := f.cache.Open(f.inbox, 1)
+	is.NoErr(err)
+
+	result, err := f.cache.Evict()
+	is.NoErr(err)
+	is.Equal(result.Evicted, 2)
+	is.Equal(result.TotalSize, int64(200))
+
+	for rowID, kept := range map[uint32]bool{0: true, 1: true, 2: false, 3: false} {
+		_, err := f.cache.bodies.Get(f.inbox.UUID, rowID)
+		is.Equal(err == nil, kept)
+	}
+}
@@ -0,0 +1,233 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	gomail "github.com/emersion/go-message/mail"
+	"github.com/tauraamui/maildew/internal/configdef"
+)
+
+// DefaultAttachmentSizeWarning is the total size of attachments beyond
+// which many servers refuse a message, so sending it is worth a warning.
+const DefaultAttachmentSizeWarning = 25 << 20
+
+// AttachmentSizeWarningFromConfig returns the configured
+// size warning in bytes, or the default if none is set.
+func AttachmentSizeWarningFromConfig(values configdef.ComposeValues) int64 {
+	if values.AttachmentSizeWarningMB == 0 {
+		return DefaultAttachmentSizeWarning
+	}
+	return values.AttachmentSizeWarningMB << 20
+}
+
+// sniffLen is as much of a file as content type detection looks at.
+const sniffLen = 512
+
+// OutgoingAttachment is a file to attach to a message being written.
+type OutgoingAttachment struct {
+	Path        string
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// NewOutgoingAttachment describes the file at path for attaching, its content
+// type is found from its extension, or from its contents if that is unknown.
+func NewOutgoingAttachment(path string) (OutgoingAttachment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return OutgoingAttachment{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return OutgoingAttachment{}, err
+	}
+	if info.IsDir() {
+		return OutgoingAttachment{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return OutgoingAttachment{}, err
+		}
+		contentType = http.DetectContentType(head[:n])
+	}
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		// the charset guessed for text is kept, the rest of the parameters are of no use
+		contentType = mediaType
+		if charset := params["charset"]; charset != "" {
+			contentType = mime.FormatMediaType(mediaType, map[string]string{"charset": charset})
+		}
+	}
+
+	return OutgoingAttachment{
+		Path:        path,
+		Name:        filepath.Base(path),
+		ContentType: contentType,
+		Size:        info.Size(),
+	}, nil
+}
+
+// Draft is a message being written.
+type Draft struct {
+	From        string
+	To, Cc      []string
+	Subject     string
+	Body        string
+	Attachments []OutgoingAttachment
+}
+
+// Attach adds the file at path to the draft's attachments.
+func (d *Draft) Attach(path string) (OutgoingAttachment, error) {
+	a, err := NewOutgoingAttachment(path)
+	if err != nil {
+		return OutgoingAttachment{}, err
+	}
+	d.Attachments = append(d.Attachments, a)
+	return a, nil
+}
+
+// AttachmentsSize returns the total size of the draft's attachments, the
+// message sent will be about a third larger once they have been encoded.
+func (d Draft) AttachmentsSize() int64 {
+	var total int64
+	for _, a := range d.Attachments {
+		total += a.Size
+	}
+	return total
+}
+
+// ExceedsAttachmentSize reports whether the draft's attachments are larger
+// than limit in total, a limit of zero or less is never exceeded.
+func (d Draft) ExceedsAttachmentSize(limit int64) bool {
+	return limit > 0 && d.AttachmentsSize() > limit
+}
+
+// WriteMessage writes the draft as an RFC 5322 message, a multipart/mixed message
+// if it has attachments, which are read from disk as they are written.
+func (d Draft) WriteMessage(w io.Writer, date time.Time) error {
+	h, err := d.header(date)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Attachments) == 0 {
+		h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+		body, err := gomail.CreateSingleInlineWriter(w, h)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(body, d.Body); err != nil {
+			return err
+		}
+		return body.Close()
+	}
+
+	mw, err := gomail.CreateWriter(w, h)
+	if err != nil {
+		return err
+	}
+
+	th := gomail.InlineHeader{}
+	th.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	body, err := mw.CreateSingleInline(th)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(body, d.Body); err != nil {
+		return err
+	}
+	if err := body.Close(); err != nil {
+		return err
+	}
+
+	for _, a := range d.Attachments {
+		if err := writeAttachment(mw, a); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// RemoteAppender is the part of an IMAP client needed to add a message to a mailbox.
+type RemoteAppender interface {
+	Append(mbox string, flags []string, date time.Time, msg imap.Literal) error
+}
+
+// SaveDraft writes the draft and adds it, flagged as a draft, to the mailbox
+// named mailboxName on the server, which is expected to exist already.
+func SaveDraft(conn RemoteAppender, mailboxName string, d Draft, date time.Time) error {
+	var b bytes.Buffer
+	if err := d.WriteMessage(&b, date); err != nil {
+		return err
+	}
+	return conn.Append(mailboxName, []string{imap.DraftFlag, imap.SeenFlag}, date, &b)
+}
+
+func (d Draft) header(date time.Time) (gomail.Header, error) {
+	h := gomail.Header{}
+	from, err := gomail.ParseAddress(d.From)
+	if err != nil {
+		return h, fmt.Errorf("invalid from address %q: %w", d.From, err)
+	}
+	h.SetAddressList("From", []*gomail.Address{from})
+
+	for _, field := range []struct {
+		key  string
+		list []string
+	}{{"To", d.To}, {"Cc", d.Cc}} {
+		if len(field.list) == 0 {
+			continue
+		}
+		addrs, err := gomail.ParseAddressList(strings.Join(field.list, ", "))
+		if err != nil {
+			return h, fmt.Errorf("invalid %s addresses: %w", strings.ToLower(field.key), err)
+		}
+		h.SetAddressList(field.key, addrs)
+	}
+
+	h.SetSubject(d.Subject)
+	h.SetDate(date)
+	if err := h.GenerateMessageID(); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+func writeAttachment(mw *gomail.Writer, a OutgoingAttachment) error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ah := gomail.AttachmentHeader{}
+	ah.Set("Content-Type", a.ContentType)
+	// the standard library encodes non-ASCII names as RFC 2231 parameters,
+	// which go-message would otherwise write as RFC 2047 encoded words
+	ah.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	ah.Set("Content-Transfer-Encoding", "base64")
+
+	aw, err := mw.CreateAttachment(ah)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(aw, f); err != nil {
+		return fmt.Errorf("unable to attach %s: %w", a.Name, err)
+	}
+	return aw.Close()
+}
@@ -0,0 +1,187 @@
+package mail_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	gomail "github.com/emersion/go-message/mail"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestNewOutgoingAttachmentDetectsContentType(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	pdf := filepath.Join(dir, "agenda.pdf")
+	is.NoErr(os.WriteFile(pdf, []byte("%PDF-1.4"), 0o644))
+	// no extension, so only its contents give it away
+	png := filepath.Join(dir, "screenshot")
+	is.NoErr(os.WriteFile(png, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0o644))
+
+	a, err := mail.NewOutgoingAttachment(pdf)
+	is.NoErr(err)
+	is.Equal(a.ContentType, "application/pdf")
+	is.Equal(a.Name, "agenda.pdf")
+	is.Equal(a.Size, int64(8))
+
+	a, err = mail.NewOutgoingAttachment(png)
+	is.NoErr(err)
+	is.Equal(a.ContentType, "image/png")
+
+	_, err = mail.NewOutgoingAttachment(dir)
+	is.True(err != nil)
+}
+
+func TestDraftAttachmentSizeWarning(t *testing.T) {
+	is := is.New(t)
+
+	d := mail.Draft{Attachments: []mail.OutgoingAttachment{{Size: 20 << 20}, {Size: 10 << 20}}}
+	is.Equal(d.AttachmentsSize(), int64(30<<20))
+
+	is.True(d.ExceedsAttachmentSize(mail.AttachmentSizeWarningFromConfig(configdef.ComposeValues{})))
+	is.True(!d.ExceedsAttachmentSize(mail.AttachmentSizeWarningFromConfig(configdef.ComposeValues{AttachmentSizeWarningMB: 50})))
+	is.True(!d.ExceedsAttachmentSize(0))
+}
+
+func TestDraftWritesMultipartMessageWithAttachments(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	report := filepath.Join(dir, "Übersicht März.pdf")
+	is.NoErr(os.WriteFile(report, []byte("%PDF-1.4 März"), 0o644))
+	photo := filepath.Join(dir, "photo.jpg")
+	photoData := bytes.Repeat([]byte{0xff, 0xd8, 0x00, 0x7f}, 1000)
+	is.NoErr(os.WriteFile(photo, photoData, 0o644))
+
+	d := mail.Draft{
+		From:    "Zoë <zoe@example.org>",
+		To:      []string{"bob@example.org"},
+		Cc:      []string{"Carol <carol@example.org>"},
+		Subject: "Quarterly numbers",
+		Body:    "Both attached, grüße",
+	}
+	_, err := d.Attach(report)
+	is.NoErr(err)
+	_, err = d.Attach(photo)
+	is.NoErr(err)
+
+	var buf bytes.Buffer
+	date := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	is.NoErr(d.WriteMessage(&buf, date))
+
+	// non-ASCII filenames are written as RFC 2231 parameters
+	is.True(strings.Contains(buf.String(), "filename*=utf-8''%C3%9Cbersicht%20M%C3%A4rz.pdf"))
+
+	r, err := gomail.CreateReader(&buf)
+	is.NoErr(err)
+	mt, _, err := r.Header.ContentType()
+	is.NoErr(err)
+	is.Equal(mt, "multipart/mixed")
+	subject, err := r.Header.Subject()
+	is.NoErr(err)
+	is.Equal(subject, "Quarterly numbers")
+	from, err := r.Header.AddressList("From")
+	is.NoErr(err)
+	is.Equal(from[0].Name, "Zoë")
+	cc, err := r.Header.AddressList("Cc")
+	is.NoErr(err)
+	is.Equal(cc[0].Address, "carol@example.org")
+	sent, err := r.Header.Date()
+	is.NoErr(err)
+	is.True(sent.Equal(date))
+
+	type part struct {
+		filename, contentType string
+		body                  []byte
+	}
+	parts := []part{}
+	for {
+		p, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		is.NoErr(err)
+		b, err := io.ReadAll(p.Body)
+		is.NoErr(err)
+
+		switch h := p.Header.(type) {
+		case *gomail.InlineHeader:
+			ct, _, _ := h.ContentType()
+			parts = append(parts, part{contentType: ct, body: b})
+		case *gomail.AttachmentHeader:
+			ct, _, _ := h.ContentType()
+			filename, err := h.Filename()
+			is.NoErr(err)
+			parts = append(parts, part{filename: filename, contentType: ct, body: b})
+		}
+	}
+
+	is.Equal(len(parts), 3)
+	is.Equal(parts[0], part{contentType: "text/plain", body: []byte("Both attached, grüße")})
+	is.Equal(parts[1].filename, "Übersicht März.pdf")
+	is.Equal(parts[1].contentType, "application/pdf")
+	is.Equal(string(parts[1].body), "%PDF-1.4 März")
+	is.Equal(parts[2].filename, "photo.jpg")
+	is.Equal(parts[2].contentType, "image/jpeg")
+	is.True(bytes.Equal(parts[2].body, photoData))
+}
+
+func TestSaveDraftAddsTheDraftToTheServer(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	// the mock names mailboxes in upper case
+	is.NoErr(backend.CreateMailbox("username", "DRAFTS"))
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{Username: "username", Password: "password"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), acc)(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	is.NoErr(os.WriteFile(notes, []byte("agenda"), 0o644))
+	d := mail.Draft{From: "zoe@example.org", To: []string{"bob@example.org"}, Subject: "Meeting", Body: "See attached"}
+	_, err = d.Attach(notes)
+	is.NoErr(err)
+
+	date := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	is.NoErr(mail.SaveDraft(cc.(mail.RemoteAppender), "DRAFTS", d, date))
+	is.True(mail.SaveDraft(cc.(mail.RemoteAppender), "Missing", d, date) != nil)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+	drafts := mail.Mailbox{UUID: uuid.New(), Name: "DRAFTS"}
+	msgRepo := mail.NewMessageRepo(db)
+	is.NoErr(mail.SyncMessages(logging.New(logging.Options{Writer: io.Discard}), cc, msgRepo, nil, drafts))
+
+	msgs, err := msgRepo.FetchByOwner(drafts.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 1)
+	is.Equal(msgs[0].Subject, "Meeting")
+	is.True(msgs[0].HasFlag(imap.DraftFlag))
+	is.True(msgs[0].Date.Equal(date))
+}
@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// RemoteFlagStorer is the part of an IMAP client needed to change the flags of messages.
+type RemoteFlagStorer interface {
+	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
+	UidStore(seqset *imap.SeqSet, item imap.StoreItem, value interface{}, ch chan *imap.Message) error
+}
+
+// StoreFlags adds flags to, or removes them from, the messages of mb stored at
+// the given rows, op being either imap.AddFlags or imap.RemoveFlags. The stored
+// copies are changed before the server is told, so should the server fail to
+// change them as well they are put back as they were by the next SyncMessages.
+func StoreFlags(conn RemoteFlagStorer, msgr MessageRepo, mb Mailbox, rowIDs []uint32, op imap.FlagsOp, flags ...string) error {
+	uids, err := ApplyFlags(msgr, mb, rowIDs, op, flags...)
+	if err != nil {
+		return err
+	}
+	return StoreRemoteFlags(conn, mb.Name, uids, op, flags...)
+}
+
+// ApplyFlags changes the flags of the stored copies of the messages of mb at
+// the given rows alone, returning the UIDs of those messages on the server.
+func ApplyFlags(msgr MessageRepo, mb Mailbox, rowIDs []uint32, op imap.FlagsOp, flags ...string) ([]uint32, error) {
+	flags, err := canonicalFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	uids := make([]uint32, 0, len(rowIDs))
+	for _, rowID := range rowIDs {
+		msg, err := msgr.Get(mb.UUID, rowID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load message %d: %w", rowID, err)
+		}
+		if msg.RemoteUID > 0 {
+			uids = append(uids, msg.RemoteUID)
+		}
+
+		updated := updateFlags(msg.Flags, op, flags)
+		if sameFlags(updated, msg.Flags) {
+			continue
+		}
+		msg.Flags = updated
+		if err := msgr.Update(mb.UUID, rowID, msg); err != nil {
+			return nil, fmt.Errorf("unable to update message %d: %w", rowID, err)
+		}
+	}
+	return uids, nil
+}
+
+// StoreRemoteFlags changes the flags of the messages with the given UIDs on the server alone.
+func StoreRemoteFlags(conn RemoteFlagStorer, mailboxName string, uids []uint32, op imap.FlagsOp, flags ...string) error {
+	flags, err := canonicalFlags(flags)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 || len(flags) == 0 {
+		return nil
+	}
+
+	if _, err := conn.Select(mailboxName, false); err != nil {
+		return err
+	}
+
+	seqset := imap.SeqSet{}
+	seqset.AddNum(uids...)
+	value := make([]interface{}, len(flags))
+	for i, f := range flags {
+		value[i] = f
+	}
+	return conn.UidStore(&seqset, imap.FormatFlagsOp(op, true), value, nil)
+}
+
+// storableSystemFlags are the flags starting with a backslash a client may
+// set, \Recent is left out as only the server is able to set it.
+var storableSystemFlags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+
+// canonicalFlags returns flags with the system flags in their usual case and
+// keywords lower cased, as they are not case sensitive, or an error if any of
+// them are neither a system flag nor a valid keyword.
+func canonicalFlags(flags []string) ([]string, error) {
+	canonical := make([]string, 0, len(flags))
+	for _, f := range flags {
+		f = imap.CanonicalFlag(f)
+		if !ValidFlag(f) {
+			return nil, fmt.Errorf("invalid flag %q", f)
+		}
+		canonical = append(canonical, f)
+	}
+	return canonical, nil
+}
+
+// ValidFlag reports whether flag can be stored on a message, which is
+// either a system flag or a keyword made of any printable characters
+// besides spaces and those with a meaning of their own within IMAP.
+func ValidFlag(flag string) bool {
+	if strings.HasPrefix(flag, `\`) {
+		return hasFlag(storableSystemFlags, imap.CanonicalFlag(flag))
+	}
+	if flag == "" {
+		return false
+	}
+	for _, r := range flag {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func updateFlags(current []string, op imap.FlagsOp, flags []string) []string {
+	updated := make([]string, 0, len(current)+len(flags))
+	for _, f := range current {
+		if op == imap.RemoveFlags && hasFlag(flags, f) {
+			continue
+		}
+		updated = append(updated, f)
+	}
+	if op == imap.AddFlags {
+		for _, f := range flags {
+			if !hasFlag(updated, f) {
+				updated = append(updated, f)
+			}
+		}
+	}
+	return updated
+}
+
+// sameFlags reports whether a and b hold the same flags, in any order.
+func sameFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, f := range a {
+		if !hasFlag(b, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+func TestStoreFlagsChangesStoredCopyAndServer(t *testing.T) {
+	is := is.New(t)
+
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	backend.StoreMessage("username", "INBOX", "Subject: Release plan\r\n\r\nWhen do we ship?")
+	backend.StoreMessage("username", "INBOX", "Subject: Lunch\r\n\r\nAnyone hungry?")
+
+	err, shutdown := startLocalServerWithBackend(l, backend)
+	is.NoErr(err)
+	defer shutdown()
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+	conn, ok := cc.(RemoteFlagStorer)
+	is.True(ok)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: &bytes.Buffer{}})
+	msgRepo := NewMessageRepo(db)
+	inbox := Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(SyncMessages(log, cc, msgRepo, nil, inbox))
+
+	rows, _, err := msgRepo.FetchRowPageByOwner(inbox.UUID, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(len(rows), 2)
+	rowIDs := []uint32{rows[0].ID, rows[1].ID}
+
+	is.NoErr(StoreFlags(conn, msgRepo, inbox, rowIDs, imap.AddFlags, `\flagged`, "$Todo"))
+	is.NoErr(StoreFlags(conn, msgRepo, inbox, rowIDs[:1], imap.RemoveFlags, imap.SeenFlag))
+
+	first, err := msgRepo.Get(inbox.UUID, rowIDs[0])
+	is.NoErr(err)
+	// keywords are not case sensitive, so are kept lower cased
+	is.True(sameFlags(first.Flags, []string{imap.FlaggedFlag, "$todo"}))
+
+	// syncing again finds the server agreeing with what is stored
+	is.NoErr(SyncMessages(log, cc, msgRepo, nil, inbox))
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+	is.True(sameFlags(msgs[0].Flags, []string{imap.FlaggedFlag, "$todo"}))
+	is.True(sameFlags(msgs[1].Flags, []string{imap.SeenFlag, imap.FlaggedFlag, "$todo"}))
+
+	is.True(StoreFlags(conn, msgRepo, inbox, rowIDs, imap.AddFlags, "not valid") != nil)
+}
+
+func TestSyncMessagesPutsBackFlagsOnlyChangedLocally(t *testing.T) {
+	is := is.New(t)
+
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	backend.StoreMessage("username", "INBOX", "Subject: Release plan\r\n\r\nWhen do we ship?")
+
+	err, shutdown := startLocalServerWithBackend(l, backend)
+	is.NoErr(err)
+	defer shutdown()
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: &bytes.Buffer{}})
+	msgRepo := NewMessageRepo(db)
+	inbox := Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(SyncMessages(log, cc, msgRepo, nil, inbox))
+
+	rows, _, err := msgRepo.FetchRowPageByOwner(inbox.UUID, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+
+	// as if the server had refused the change
+	uids, err := ApplyFlags(msgRepo, inbox, []uint32{rows[0].ID}, imap.RemoveFlags, imap.SeenFlag)
+	is.NoErr(err)
+	is.Equal(uids, []uint32{rows[0].Value.RemoteUID})
+	msg, err := msgRepo.Get(inbox.UUID, rows[0].ID)
+	is.NoErr(err)
+	is.True(!msg.HasFlag(imap.SeenFlag))
+
+	is.NoErr(SyncMessages(log, cc, msgRepo, nil, inbox))
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 1)
+	is.True(msgs[0].HasFlag(imap.SeenFlag))
+}
+
+func TestValidFlag(t *testing.T) {
+	for _, tt := range []struct {
+		flag  string
+		valid bool
+	}{
+		{flag: imap.SeenFlag, valid: true},
+		{flag: `\flagged`, valid: true},
+		{flag: "$Todo", valid: true},
+		{flag: "work", valid: true},
+		{flag: imap.RecentFlag},
+		{flag: `\Custom`},
+		{flag: ""},
+		{flag: "two words"},
+		{flag: "(paren"},
+		{flag: "quo\"te"},
+		{flag: "brack]et"},
+		{flag: "naïve"},
+	} {
+		t.Run(tt.flag, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(ValidFlag(tt.flag), tt.valid)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+type ProblemKind int
+
+const (
+	// Orphaned rows are owned by a row which no longer exists, or
+	// for cached bodies, belong to a message which no longer exists.
+	Orphaned ProblemKind = iota
+	MissingColumns
+	// MissingUUID rows can not be repaired, as nothing they owned
+	// could ever be found again, so they are deleted as orphans are.
+	MissingUUID
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case Orphaned:
+		return "orphaned row"
+	case MissingColumns:
+		return "missing columns"
+	case MissingUUID:
+		return "missing uuid"
+	}
+	return "unknown problem"
+}
+
+type Problem struct {
+	Kind  ProblemKind
+	Table string
+	Owner string
+	RowID uint32
+	// Columns lists the missing columns of a MissingColumns or MissingUUID problem.
+	Columns []string
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s: %s row %d owned by %s", p.Kind, p.Table, p.RowID, p.Owner)
+	if len(p.Columns) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(p.Columns, ", "))
+	}
+	return s
+}
+
+type FsckOptions struct {
+	// Repair deletes orphaned rows and rows missing their UUID, and stores the
+	// zero value of every other missing column, instead of only reporting them.
+	Repair bool
+}
+
+type FsckReport struct {
+	Problems []Problem
+	Repaired int
+}
+
+// Fsck checks every mailbox and saved search is owned by a stored account,
+// every message is owned by one of those mailboxes, every cached body belongs
+// to one of those messages and that no row is missing columns. Messages within
+// orphaned mailboxes are orphaned along with them, as are their bodies.
+func Fsck(db kvs.DB, opts FsckOptions) (FsckReport, error) {
+	report := FsckReport{Problems: []Problem{}}
+
+	accounts, err := checkTable[Account](db, AccountsTableName, ownedBy(map[string]bool{kvs.RootOwner{}.String(): true}), &report)
+	if err != nil {
+		return report, err
+	}
+
+	mailboxes, err := checkTable[Mailbox](db, MailboxesTableName, ownedBy(accounts.uuids), &report)
+	if err != nil {
+		return report, err
+	}
+
+	if _, err := checkTable[SavedSearch](db, SavedSearchesTableName, ownedBy(accounts.uuids), &report); err != nil {
+		return report, err
+	}
+
+	messages, err := checkTable[Message](db, MessagesTableName, ownedBy(mailboxes.uuids), &report)
+	if err != nil {
+		return report, err
+	}
+
+	// a body is stored under the same owner and row as its message
+	ofMessage := func(row kvs.StoredRow) bool {
+		return messages.rows[storedRowRef{owner: row.Owner, rowID: row.RowID}]
+	}
+	if _, err := checkTable[body](db, BodiesTableName, ofMessage, &report); err != nil {
+		return report, err
+	}
+	if _, err := checkTable[BodyMeta](db, BodyMetaTableName, ofMessage, &report); err != nil {
+		return report, err
+	}
+
+	if !opts.Repair {
+		return report, nil
+	}
+
+	for _, p := range report.Problems {
+		if err := repair(db, p); err != nil {
+			return report, fmt.Errorf("unable to repair %s: %w", p, err)
+		}
+		report.Repaired++
+	}
+
+	return report, nil
+}
+
+type storedRowRef struct {
+	owner string
+	rowID uint32
+}
+
+// checkedRows are the rows of a table which are kept, as they are
+// neither orphaned nor missing their UUID.
+type checkedRows struct {
+	uuids map[string]bool
+	rows  map[storedRowRef]bool
+}
+
+// ownedBy reports whether a row is owned by one of the given owners.
+func ownedBy(owners map[string]bool) func(row kvs.StoredRow) bool {
+	return func(row kvs.StoredRow) bool {
+		return owners[row.Owner]
+	}
+}
+
+// checkTable reports the problems of every row of the table, those owned
+// returns false for are orphaned, and returns the rows which are kept.
+func checkTable[T any](db kvs.DB, tableName string, owned func(row kvs.StoredRow) bool, report *FsckReport) (checkedRows, error) {
+	checked := checkedRows{uuids: map[string]bool{}, rows: map[storedRowRef]bool{}}
+	rows, err := kvs.ScanRows(db, tableName)
+	if err != nil {
+		return checked, err
+	}
+
+	for _, row := range rows {
+		if !owned(row) {
+			report.Problems = append(report.Problems, Problem{Kind: Orphaned, Table: tableName, Owner: row.Owner, RowID: row.RowID})
+			continue
+		}
+
+		missing, err := kvs.MissingColumns(new(T), row.Columns)
+		if err != nil {
+			return checked, err
+		}
+		if _, hasUUID := rowUUID(new(T)); hasUUID && contains(missing, "uuid") {
+			report.Problems = append(report.Problems, Problem{Kind: MissingUUID, Table: tableName, Owner: row.Owner, RowID: row.RowID, Columns: missing})
+			continue
+		}
+		if len(missing) > 0 {
+			report.Problems = append(report.Problems, Problem{Kind: MissingColumns, Table: tableName, Owner: row.Owner, RowID: row.RowID, Columns: missing})
+		}
+		checked.rows[storedRowRef{owner: row.Owner, rowID: row.RowID}] = true
+
+		v := new(T)
+		if _, hasUUID := rowUUID(v); !hasUUID {
+			continue
+		}
+
+		owner, err := kvs.ParseOwner(row.Owner)
+		if err != nil {
+			return checked, err
+		}
+		if err := kvs.LoadRow(db, tableName, owner, row.RowID, v); err != nil {
+			return checked, err
+		}
+		// a row with a zero UUID can not own anything
+		if id, _ := rowUUID(v); id != nil && id != (uuid.UUID{}) {
+			checked.uuids[id.String()] = true
+		}
+	}
+
+	return checked, nil
+}
+
+// rowUUID returns the UUID of v, reporting false if its type has none.
+func rowUUID(v interface{}) (kvs.UUID, bool) {
+	switch v := v.(type) {
+	case *Account:
+		return v.UUID, true
+	case *Mailbox:
+		return v.UUID, true
+	case *Message:
+		return v.UUID, true
+	case *SavedSearch:
+		return v.UUID, true
+	}
+	return nil, false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func repair(db kvs.DB, p Problem) error {
+	owner, err := kvs.ParseOwner(p.Owner)
+	if err != nil {
+		return err
+	}
+
+	x, err := newTableValue(p.Table)
+	if err != nil {
+		return err
+	}
+
+	if p.Kind != MissingColumns {
+		if err := kvs.DeleteRow(db, p.Table, owner, p.RowID, x); err != nil {
+			return err
+		}
+		if p.Table == MessagesTableName {
+			return NewSearchIndex(db).Remove(owner, p.RowID)
+		}
+		return nil
+	}
+
+	// storing the partially loaded row writes the zero
+	// value of each column which could not be loaded
+	if err := kvs.LoadRow(db, p.Table, owner, p.RowID, x); err != nil {
+		return err
+	}
+	// a nil UUID would be stored as null, which no longer loads
+	if id, hasUUID := rowUUID(x); hasUUID && id == nil {
+		return errors.New("row has no uuid")
+	}
+	return kvs.StoreRow(db, p.Table, owner, p.RowID, x)
+}
+
+func newTableValue(tableName string) (interface{}, error) {
+	t, ok := Tables().Lookup(tableName)
+	if !ok {
+		return nil, fmt.Errorf("table %s is not registered", tableName)
+	}
+	return reflect.New(t).Interface(), nil
+}
@@ -0,0 +1,202 @@
+package mail_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// storeCorruptedMail stores one healthy mailbox with two messages, then
+// corrupts the store with an orphaned mailbox holding a message, a message
+// within a deleted mailbox and a mailbox missing its name column.
+func storeCorruptedMail(t *testing.T, db kvs.DB) (acc mail.Account, healthy mail.Mailbox) {
+	t.Helper()
+	is := is.New(t)
+
+	accRepo, mbRepo, msgRepo := mail.NewAccountRepo(db), mail.NewMailboxRepo(db), mail.NewMessageRepo(db)
+
+	acc = mail.Account{UUID: uuid.New(), Username: "username", Password: "password"}
+	is.NoErr(accRepo.Save(acc))
+
+	healthy = mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(mbRepo.Save(acc.UUID, healthy))
+	_, err := msgRepo.Save(healthy.UUID, mail.Message{UUID: uuid.New(), RemoteUID: 1})
+	is.NoErr(err)
+	_, err = msgRepo.Save(healthy.UUID, mail.Message{UUID: uuid.New(), RemoteUID: 2})
+	is.NoErr(err)
+
+	orphaned := mail.Mailbox{UUID: uuid.New(), Name: "ORPHANED"}
+	is.NoErr(mbRepo.Save(uuid.New(), orphaned))
+	_, err = msgRepo.Save(orphaned.UUID, mail.Message{UUID: uuid.New(), RemoteUID: 3})
+	is.NoErr(err)
+
+	_, err = msgRepo.Save(uuid.New(), mail.Message{UUID: uuid.New(), RemoteUID: 4})
+	is.NoErr(err)
+
+	is.NoErr(mbRepo.Save(acc.UUID, mail.Mailbox{UUID: uuid.New(), Name: "ARCHIVE"}))
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte("mailboxes.name." + acc.UUID.String() + ".2"))
+	}))
+
+	return acc, healthy
+}
+
+func TestFsckReportsCorruption(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	acc, healthy := storeCorruptedMail(t, db)
+
+	report, err := mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	is.Equal(report.Repaired, 0)
+	is.Equal(len(report.Problems), 4)
+
+	orphanedRows := map[string]int{}
+	for _, p := range report.Problems {
+		switch p.Kind {
+		case mail.Orphaned:
+			orphanedRows[p.Table]++
+		case mail.MissingColumns:
+			is.Equal(p.Table, "mailboxes")
+			is.Equal(p.Owner, acc.UUID.String())
+			is.Equal(p.RowID, uint32(2))
+			is.Equal(p.Columns, []string{"name"})
+		}
+	}
+	is.Equal(orphanedRows, map[string]int{"mailboxes": 1, "messages": 2})
+
+	// nothing should have been changed without asking for a repair
+	msgs, err := mail.NewMessageRepo(db).FetchByOwner(healthy.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+	report, err = mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	is.Equal(len(report.Problems), 4)
+}
+
+func TestFsckRepairsCorruption(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	acc, healthy := storeCorruptedMail(t, db)
+
+	report, err := mail.Fsck(db, mail.FsckOptions{Repair: true})
+	is.NoErr(err)
+	is.Equal(report.Repaired, 4)
+
+	report, err = mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	is.Equal(len(report.Problems), 0) // store should be consistent once repaired
+
+	mboxes, err := mail.NewMailboxRepo(db).FetchByOwner(acc.UUID)
+	is.NoErr(err)
+	is.Equal(len(mboxes), 2)
+	is.Equal(mboxes[0].Name, "INBOX")
+	is.Equal(mboxes[1].Name, "") // missing column should be backfilled with its zero value
+
+	msgs, err := mail.NewMessageRepo(db).FetchByOwner(healthy.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+
+	rows, err := kvs.ScanRows(db, mail.MessagesTableName)
+	is.NoErr(err)
+	is.Equal(len(rows), 2)
+}
+
+func TestFsckDeletesRowsMissingTheirUUID(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username", Password: "password"}
+	is.NoErr(mail.NewAccountRepo(db).Save(acc))
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(mail.NewMailboxRepo(db).Save(acc.UUID, inbox))
+
+	msgRepo := mail.NewMessageRepo(db)
+	_, err = msgRepo.Save(inbox.UUID, mail.Message{UUID: uuid.New(), Subject: "kept"})
+	is.NoErr(err)
+	rowID, err := msgRepo.Save(inbox.UUID, mail.Message{UUID: uuid.New(), Subject: "lost its uuid"})
+	is.NoErr(err)
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf("messages.uuid.%s.%d", inbox.UUID, rowID)))
+	}))
+
+	report, err := mail.Fsck(db, mail.FsckOptions{Repair: true})
+	is.NoErr(err)
+	is.Equal(len(report.Problems), 1)
+	is.Equal(report.Problems[0].Kind, mail.MissingUUID)
+	is.Equal(report.Problems[0].RowID, rowID)
+	is.Equal(report.Repaired, 1)
+
+	// the row is gone rather than stored again with a null uuid, which would fail to load
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 1)
+	is.Equal(msgs[0].Subject, "kept")
+
+	report, err = mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	is.Equal(len(report.Problems), 0)
+}
+
+func TestFsckRepairsBodiesOfMissingMessages(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	_, healthy := storeCorruptedMail(t, db)
+	cache := mail.NewBodyCache(logging.New(logging.Options{Writer: &bytes.Buffer{}}), db, mail.DefaultBodyCachePolicy(), nil)
+
+	msgRepo := mail.NewMessageRepo(db)
+	rows, _, err := msgRepo.FetchRowPageByOwner(healthy.UUID, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(len(rows), 2)
+	kept, deleted := rows[0].ID, rows[1].ID
+	is.NoErr(cache.Store(healthy, kept, []byte("Subject: kept\r\n\r\n")))
+	is.NoErr(cache.Store(healthy, deleted, []byte("Subject: deleted\r\n\r\n")))
+	// only the message's own row is removed, leaving its cached body behind
+	is.NoErr(kvs.DeleteRow(db, mail.MessagesTableName, healthy.UUID, deleted, mail.Message{}))
+
+	report, err := mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	orphaned := map[string][]uint32{}
+	for _, p := range report.Problems {
+		if p.Kind == mail.Orphaned {
+			orphaned[p.Table] = append(orphaned[p.Table], p.RowID)
+		}
+	}
+	is.Equal(orphaned[mail.BodiesTableName], []uint32{deleted})
+	is.Equal(orphaned[mail.BodyMetaTableName], []uint32{deleted})
+
+	_, err = mail.Fsck(db, mail.FsckOptions{Repair: true})
+	is.NoErr(err)
+	report, err = mail.Fsck(db, mail.FsckOptions{})
+	is.NoErr(err)
+	is.Equal(len(report.Problems), 0)
+
+	bodies, err := kvs.ScanRows(db, mail.BodiesTableName)
+	is.NoErr(err)
+	is.Equal(len(bodies), 1)
+	data, err := cache.Open(healthy, kept)
+	is.NoErr(err)
+	is.Equal(string(data), "Subject: kept\r\n\r\n")
+}
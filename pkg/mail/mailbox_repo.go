@@ -3,12 +3,11 @@ package mail
 import (
 	"io"
 
-	"github.com/dgraph-io/badger/v3"
 	"github.com/tauraamui/maildew/internal/kvs"
 )
 
 const (
-	mailboxesTableName = "mailboxes"
+	MailboxesTableName = "mailboxes"
 )
 
 type MailboxRepo interface {
@@ -19,12 +18,12 @@ type MailboxRepo interface {
 }
 
 func NewMailboxRepo(db kvs.DB) MailboxRepo {
-	return mailboxRepo{DB: db}
+	return mailboxRepo{DB: db, rows: kvs.NewRepo[Mailbox](db, MailboxesTableName)}
 }
 
 type mailboxRepo struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	DB   kvs.DB
+	rows *kvs.Repo[Mailbox]
 }
 
 func (r mailboxRepo) DumpTo(w io.Writer) error {
@@ -32,55 +31,14 @@ func (r mailboxRepo) DumpTo(w io.Writer) error {
 }
 
 func (r mailboxRepo) Save(owner kvs.UUID, mailbox Mailbox) error {
-	rowID, err := r.nextRowID()
-	if err != nil {
-		return err
-	}
-
-	return saveValueWithUUID(r.DB, r.tableName(), owner, rowID, mailbox)
+	_, err := r.rows.Save(owner, mailbox)
+	return err
 }
 
 func (r mailboxRepo) FetchByOwner(owner kvs.UUID) ([]Mailbox, error) {
-	return fetchByOwner[Mailbox](r.DB, r.tableName(), owner)
-}
-
-func saveValueWithUUID(db kvs.DB, tableName string, ownerID kvs.UUID, rowID uint32, v interface{}) error {
-	if v == nil {
-		return nil
-	}
-	entries := kvs.ConvertToEntriesWithUUID(tableName, ownerID, rowID, v)
-	for _, e := range entries {
-		if err := kvs.Store(db, e); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (r mailboxRepo) tableName() string {
-	return mailboxesTableName
-}
-
-func (r mailboxRepo) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(r.tableName()), 1)
-		if err != nil {
-			return 0, err
-		}
-		r.seq = seq
-	}
-
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
-	}
-	return uint32(s), nil
+	return r.rows.FetchByOwner(owner)
 }
 
 func (r mailboxRepo) Close() {
-	if r.seq == nil {
-		return
-	}
-	r.seq.Release()
+	r.rows.Close()
 }
@@ -33,8 +33,9 @@ func TestSaveMailbox(t *testing.T) {
 	is.True(db != nil)
 	is.NoErr(compareContentsWithExpected(db, map[string][]byte{
 		"mailboxes": {0, 0, 0, 0, 0, 0, 0, 1},
-		"mailboxes.uuid.f47ac10b-58cc-0372-8567-0e02b2c3d479.0": helperConvertToBytes(t, fmt.Sprintf("\"%s\"", uuidID.String())),
-		"mailboxes.name.f47ac10b-58cc-0372-8567-0e02b2c3d479.0": helperConvertToBytes(t, "Fake mailbox"),
+		"mailboxes.uuid.f47ac10b-58cc-0372-8567-0e02b2c3d479.0":           helperConvertToBytes(t, fmt.Sprintf("\"%s\"", uuidID.String())),
+		"mailboxes.name.f47ac10b-58cc-0372-8567-0e02b2c3d479.0":           helperConvertToBytes(t, "Fake mailbox"),
+		"_rows.mailboxes.f47ac10b-58cc-0372-8567-0e02b2c3d479.0000000000": {},
 	}))
 
 }
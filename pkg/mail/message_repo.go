@@ -2,72 +2,164 @@ package mail
 
 import (
 	"io"
+	"net/mail"
+	"strings"
 
-	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
 	"github.com/tauraamui/maildew/internal/kvs"
 )
 
 const (
-	messagesTableName = "messages"
+	MessagesTableName = "messages"
 )
 
 type MessageRepo interface {
 	DumpTo(w io.Writer) error
-	Save(owner kvs.UUID, msg Message) error
+	// Save stores msg and returns the row ID it was stored at.
+	Save(owner kvs.UUID, msg Message) (uint32, error)
+	Get(owner kvs.UUID, rowID uint32) (Message, error)
+	// Update replaces the message stored at rowID.
+	Update(owner kvs.UUID, rowID uint32, msg Message) error
+	// Move stores msg as the same row of dest, carrying along the message's
+	// cached body and what is indexed of it, Copy stores it as a new row
+	// of dest and returns that row's ID, keeping the original.
+	Move(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg Message) error
+	Copy(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg Message) (uint32, error)
+	// Delete removes the message stored at rowID, its cached body and what is indexed of it.
+	Delete(owner kvs.UUID, rowID uint32) error
 	FetchByOwner(owner kvs.UUID) ([]Message, error)
+	FetchPageByOwner(owner kvs.UUID, q kvs.Query) ([]Message, kvs.Cursor, error)
+	// FetchRowPageByOwner is the same as FetchPageByOwner,
+	// along with the row ID each message is stored at.
+	FetchRowPageByOwner(owner kvs.UUID, q kvs.Query) ([]kvs.Row[Message], kvs.Cursor, error)
+	// FetchRowsByRemoteUID returns the messages of owner which were given uid
+	// by the server, along with the row ID each is stored at. Messages the
+	// server has not said the UID of yet are those with a UID of zero.
+	FetchRowsByRemoteUID(owner kvs.UUID, uid uint32) ([]kvs.Row[Message], error)
 	Close() error
 }
 
 func NewMessageRepo(db kvs.DB) MessageRepo {
-	return messageRepo{DB: db}
+	return messageRepo{DB: db, rows: kvs.NewRepo[Message](db, MessagesTableName)}
 }
 
 type messageRepo struct {
-	DB  kvs.DB
-	seq *badger.Sequence
+	DB   kvs.DB
+	rows *kvs.Repo[Message]
 }
 
 func (r messageRepo) DumpTo(w io.Writer) error {
 	return r.DB.DumpTo(w)
 }
 
-func (r messageRepo) Save(owner kvs.UUID, msg Message) error {
-	rowID, err := r.nextRowID()
-	if err != nil {
+func (r messageRepo) Save(owner kvs.UUID, msg Message) (uint32, error) {
+	msg.Sender = SenderSortKey(msg.From)
+	return r.rows.Save(owner, msg)
+}
+
+func (r messageRepo) Get(owner kvs.UUID, rowID uint32) (Message, error) {
+	return r.rows.Get(owner, rowID)
+}
+
+func (r messageRepo) Update(owner kvs.UUID, rowID uint32, msg Message) error {
+	msg.Sender = SenderSortKey(msg.From)
+	return r.rows.Update(owner, rowID, msg)
+}
+
+func (r messageRepo) Move(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg Message) error {
+	msg.Sender = SenderSortKey(msg.From)
+	if err := r.rows.Move(owner, rowID, dest, msg); err != nil {
 		return err
 	}
-
-	return saveValueWithUUID(r.DB, r.tableName(), owner, rowID, msg)
+	return r.relocateCached(owner, rowID, dest, rowID, false)
 }
 
-func (r messageRepo) FetchByOwner(owner kvs.UUID) ([]Message, error) {
-	return fetchByOwner[Message](r.DB, r.tableName(), owner)
+func (r messageRepo) Copy(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg Message) (uint32, error) {
+	msg.UUID = uuid.New()
+	destRowID, err := r.Save(dest, msg)
+	if err != nil {
+		return 0, err
+	}
+	return destRowID, r.relocateCached(owner, rowID, dest, destRowID, true)
 }
 
-func (r messageRepo) tableName() string {
-	return messagesTableName
+func (r messageRepo) Delete(owner kvs.UUID, rowID uint32) error {
+	if err := r.rows.Delete(owner, rowID); err != nil {
+		return err
+	}
+	for table, x := range map[string]interface{}{BodiesTableName: body{}, BodyMetaTableName: BodyMeta{}} {
+		if err := kvs.DeleteRow(r.DB, table, owner, rowID, x); err != nil {
+			return err
+		}
+	}
+	return NewSearchIndex(r.DB).Remove(owner, rowID)
 }
 
-func (r messageRepo) nextRowID() (uint32, error) {
-	if r.seq == nil {
-		seq, err := r.DB.GetSeq([]byte(messagesTableName), 1)
-		if err != nil {
-			return 0, err
+// relocateCached moves or copies the cached body of a message to another row, along
+// with what is indexed of it, so that it is neither fetched nor decoded again. A
+// moved message keeps its row ID, so destRowID only differs from rowID for a copy.
+func (r messageRepo) relocateCached(owner kvs.UUID, rowID uint32, dest kvs.UUID, destRowID uint32, keep bool) error {
+	b, meta := &body{}, &BodyMeta{}
+	for table, v := range map[string]interface{}{BodiesTableName: b, BodyMetaTableName: meta} {
+		if err := kvs.LoadRow(r.DB, table, owner, rowID, v); err != nil {
+			return err
 		}
-		r.seq = seq
 	}
 
-	s, err := r.seq.Next()
-	if err != nil {
-		return 0, err
+	// bodies which are not cached have nothing more than their envelope indexed
+	if b.Data != nil {
+		for table, v := range map[string]interface{}{BodiesTableName: *b, BodyMetaTableName: *meta} {
+			var err error
+			if keep {
+				err = kvs.StoreRow(r.DB, table, dest, destRowID, v)
+			} else {
+				err = kvs.MoveRow(r.DB, table, owner, dest, rowID, v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	idx := NewSearchIndex(r.DB)
+	if keep {
+		return idx.Copy(owner, rowID, dest, destRowID)
 	}
-	return uint32(s), nil
+	return idx.Move(owner, rowID, dest, destRowID)
+}
+
+func (r messageRepo) FetchByOwner(owner kvs.UUID) ([]Message, error) {
+	return r.rows.FetchByOwner(owner)
+}
+
+func (r messageRepo) FetchPageByOwner(owner kvs.UUID, q kvs.Query) ([]Message, kvs.Cursor, error) {
+	return r.rows.FetchPageByOwner(owner, q)
+}
+
+func (r messageRepo) FetchRowPageByOwner(owner kvs.UUID, q kvs.Query) ([]kvs.Row[Message], kvs.Cursor, error) {
+	return r.rows.FetchRowPageByOwner(owner, q)
+}
+
+func (r messageRepo) FetchRowsByRemoteUID(owner kvs.UUID, uid uint32) ([]kvs.Row[Message], error) {
+	return r.rows.FetchRowsByColumn(owner, "remoteuid", uid)
 }
 
 func (r messageRepo) Close() error {
-	if r.seq == nil {
-		return nil
+	return r.rows.Close()
+}
+
+// SenderSortKey returns the lower cased name of the first sender in from,
+// or their address if they have no name, for ordering messages by sender.
+func SenderSortKey(from []string) string {
+	if len(from) == 0 {
+		return ""
+	}
+	addr, err := mail.ParseAddress(from[0])
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(from[0]))
+	}
+	if addr.Name != "" {
+		return strings.ToLower(addr.Name)
 	}
-	r.seq.Release()
-	return nil
+	return strings.ToLower(addr.Address)
 }
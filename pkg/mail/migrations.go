@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+// Migrations returns every schema migration for the stored account, mailbox
+// and message tables. New migrations must only ever be appended to the end.
+func Migrations() kvs.Migrations {
+	// the zero time always marshals
+	zeroDate, _ := time.Time{}.MarshalText()
+
+	migrations := kvs.Migrations{}
+	migrations.Register(kvs.Migration{
+		// nothing to convert, this only stamps stores created
+		// before schema versioning was introduced
+		Version: 1, Name: "initial schema",
+	})
+	migrations.Register(kvs.Migration{
+		Version: 2, Name: "add message flags",
+		Steps: []kvs.Step{
+			backfillStep(MessagesTableName, "uuid", map[string][]byte{"flags": []byte("[]")}),
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 3, Name: "add message envelopes",
+		Steps: []kvs.Step{
+			backfillStep(MessagesTableName, "uuid", map[string][]byte{
+				"subject": {}, "from": []byte("[]"), "to": []byte("[]"), "date": zeroDate,
+			}),
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 4, Name: "index message envelopes",
+		Steps: []kvs.Step{
+			backfillStep(MessagesTableName, "uuid", map[string][]byte{"size": []byte("0")}),
+			sendersStep(),
+			kvs.ReindexStep(MessagesTableName, Message{}),
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 5, Name: "add message threading headers",
+		Steps: []kvs.Step{
+			backfillStep(MessagesTableName, "uuid", map[string][]byte{
+				"messageID": {}, "inReplyTo": {}, "references": []byte("[]"),
+			}),
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 6, Name: "add account trash and archive mailboxes",
+		Migrate: func(txn *badger.Txn) error {
+			for _, column := range []string{"trashMailbox", "archiveMailbox"} {
+				if err := backfillColumn(AccountsTableName, "uuid", column, []byte{})(txn); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 7, Name: "index row IDs",
+		Steps: []kvs.Step{
+			kvs.RowIDsStep(AccountsTableName, Account{}),
+			kvs.RowIDsStep(MailboxesTableName, Mailbox{}),
+			kvs.RowIDsStep(MessagesTableName, Message{}),
+			kvs.RowIDsStep(BodiesTableName, body{}),
+			kvs.RowIDsStep(BodyMetaTableName, BodyMeta{}),
+			kvs.RowIDsStep(SavedSearchesTableName, SavedSearch{}),
+		},
+	})
+	migrations.Register(kvs.Migration{
+		Version: 8, Name: "index message UIDs",
+		Steps: []kvs.Step{
+			kvs.ReindexStep(MessagesTableName, Message{}),
+		},
+	})
+	return migrations
+}
+
+// sendersStep stores the sender sort key of every message from its stored from column.
+func sendersStep() kvs.Step {
+	prefix := MessagesTableName + ".from."
+	return kvs.Step{
+		Prefix: prefix,
+		Apply: func(txn *badger.Txn, key []byte) error {
+			item, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+
+			var from []string
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &from)
+			}); err != nil {
+				return err
+			}
+
+			ownerAndRow := key[len(prefix):]
+			senderKey := append([]byte(MessagesTableName+".sender."), ownerAndRow...)
+			return txn.Set(senderKey, []byte(SenderSortKey(from)))
+		},
+	}
+}
+
+// backfillStep stores the value given for each of the columns of every row of the
+// table which does not have it yet, using from as a column every row is known to have.
+func backfillStep(tableName, from string, columns map[string][]byte) kvs.Step {
+	prefix := tableName + "." + from + "."
+	return kvs.Step{
+		Prefix: prefix,
+		Apply: func(txn *badger.Txn, key []byte) error {
+			ownerAndRow := key[len(prefix):]
+			for column, value := range columns {
+				columnKey := append([]byte(tableName+"."+column+"."), ownerAndRow...)
+				if _, err := txn.Get(columnKey); err == nil {
+					continue
+				} else if !errors.Is(err, badger.ErrKeyNotFound) {
+					return err
+				}
+
+				if err := txn.Set(columnKey, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// backfillColumn stores value as the given column of every row of the table
+// which does not have it yet, using from as a column every row is known to have.
+func backfillColumn(tableName, from, column string, value []byte) func(txn *badger.Txn) error {
+	return func(txn *badger.Txn) error {
+		prefix := []byte(tableName + "." + from + ".")
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			ownerAndRow := it.Item().Key()[len(prefix):]
+			key := append([]byte(tableName+"."+column+"."), ownerAndRow...)
+			if _, err := txn.Get(key); err == nil {
+				continue
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			if err := txn.Set(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package mail_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestMigrationsIndexMessagesStoredBeforeEnvelopesWereIndexed(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	// messages as they were stored at schema version 3
+	owner := uuid.New()
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		for rowID, from := range []string{`["Zed <zed@example.org>"]`, `["alice@example.org"]`} {
+			for column, value := range map[string]string{
+				"uuid":    uuid.NewString(),
+				"subject": fmt.Sprintf("message %d", rowID),
+				"from":    from,
+				"to":      "[]",
+				"flags":   "[]",
+				"date":    "0001-01-01T00:00:00Z",
+			} {
+				key := fmt.Sprintf("%s.%s.%s.%d", mail.MessagesTableName, column, owner, rowID)
+				if err := txn.Set([]byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("_schema.version"), []byte{0, 0, 0, 3})
+	}))
+
+	_, err = kvs.Migrate(db, mail.Migrations(), kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	msgs, _, err := mail.NewMessageRepo(db).FetchPageByOwner(owner, kvs.Query{OrderBy: "sender"})
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+	is.Equal(msgs[0].Subject, "message 1")
+	is.Equal(msgs[0].Sender, "alice@example.org")
+	is.Equal(msgs[1].Sender, "zed")
+	is.Equal(msgs[1].Size, uint32(0))
+
+	rows, _, err := mail.NewMessageRepo(db).FetchRowPageByOwner(owner, kvs.Query{OrderBy: "subject", Order: kvs.Descending, Limit: 1})
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+	is.Equal(rows[0].ID, uint32(1))
+}
+
+func TestMigrationsIndexMessageUIDs(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	// messages as they were stored at schema version 7, before their UIDs were indexed
+	owner := uuid.New()
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		for rowID, uid := range []string{"7", "42", "0"} {
+			for column, value := range map[string]string{
+				"uuid":      uuid.NewString(),
+				"remoteuid": uid,
+				"subject":   fmt.Sprintf("message %d", rowID),
+				"from":      "[]",
+				"to":        "[]",
+				"flags":     "[]",
+				"date":      "0001-01-01T00:00:00Z",
+				"size":      "0",
+			} {
+				key := fmt.Sprintf("%s.%s.%s.%d", mail.MessagesTableName, column, owner, rowID)
+				if err := txn.Set([]byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+		}
+		return txn.Set([]byte("_schema.version"), []byte{0, 0, 0, 7})
+	}))
+
+	_, err = kvs.Migrate(db, mail.Migrations(), kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	msgRepo := mail.NewMessageRepo(db)
+	rows, err := msgRepo.FetchRowsByRemoteUID(owner, 42)
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+	is.Equal(rows[0].ID, uint32(1))
+	is.Equal(rows[0].Value.Subject, "message 1")
+
+	rows, err = msgRepo.FetchRowsByRemoteUID(owner, 0)
+	is.NoErr(err)
+	is.Equal(len(rows), 1)
+	is.Equal(rows[0].ID, uint32(2))
+}
+
+func TestMigrationsMigrateStoresLargerThanATransaction(t *testing.T) {
+	is := is.New(t)
+
+	// small enough for a few thousand messages to be more than fits within one transaction
+	db, err := kvs.NewMemDBWithMemTableSize(1 << 20)
+	is.NoErr(err)
+	defer db.Close()
+
+	// messages stored at schema version 1, along
+	// with the subject and sender to order them by
+	const stored = 5000
+	owner := uuid.New()
+	for rowID := 0; rowID < stored; rowID++ {
+		is.NoErr(db.Update(func(txn *badger.Txn) error {
+			for column, value := range map[string]string{
+				"uuid":    uuid.NewString(),
+				"subject": fmt.Sprintf("message %04d", rowID),
+				"from":    fmt.Sprintf(`["sender%04d@example.org"]`, stored-rowID),
+			} {
+				key := fmt.Sprintf("%s.%s.%s.%d", mail.MessagesTableName, column, owner, rowID)
+				if err := txn.Set([]byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	is.NoErr(db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("_schema.version"), []byte{0, 0, 0, 1})
+	}))
+
+	_, err = kvs.Migrate(db, mail.Migrations(), kvs.MigrateOptions{})
+	is.NoErr(err)
+
+	msgs, _, err := mail.NewMessageRepo(db).FetchPageByOwner(owner, kvs.Query{OrderBy: "sender", Limit: 2})
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+	is.Equal(msgs[0].Subject, fmt.Sprintf("message %04d", stored-1))
+	is.Equal(msgs[0].Sender, "sender0001@example.org")
+	is.Equal(msgs[1].Subject, fmt.Sprintf("message %04d", stored-2))
+
+	all, err := mail.NewMessageRepo(db).FetchByOwner(owner)
+	is.NoErr(err)
+	is.Equal(len(all), stored)
+}
@@ -0,0 +1,245 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	gomail "github.com/emersion/go-message/mail"
+)
+
+// Part is a single part of a message's MIME tree. The bodies of text parts
+// are decoded into Text, every other part is only measured, never kept.
+type Part struct {
+	// Section is the part's IMAP section number, such as 1.2.
+	Section     string
+	ContentType string
+	Params      map[string]string
+	Disposition string
+	Filename    string
+	// Encoding is the part's Content-Transfer-Encoding,
+	// needed to decode the part when fetched on its own.
+	Encoding string
+	// Size in bytes of the decoded body.
+	Size  int64
+	Text  string
+	Parts []*Part
+	// Err is set when the part could not be fully decoded, such
+	// as when it is in an unknown charset, which leaves Text as it is.
+	Err error
+}
+
+func (p *Part) IsMultipart() bool {
+	return strings.HasPrefix(p.ContentType, "multipart/")
+}
+
+func (p *Part) IsText() bool {
+	return strings.HasPrefix(p.ContentType, "text/")
+}
+
+// IsAttachment reports whether the part is meant to be saved rather than
+// displayed, either by its disposition or by it having a filename.
+func (p *Part) IsAttachment() bool {
+	if p.IsMultipart() {
+		return false
+	}
+	return p.Disposition == "attachment" || p.Filename != ""
+}
+
+// Walk calls fn with p and each of its descendants, depth first.
+func (p *Part) Walk(fn func(p *Part, depth int)) {
+	p.walk(fn, 0)
+}
+
+func (p *Part) walk(fn func(p *Part, depth int), depth int) {
+	fn(p, depth)
+	for _, child := range p.Parts {
+		child.walk(fn, depth+1)
+	}
+}
+
+// ParsedMessage is a message decoded from its raw RFC 822 form.
+type ParsedMessage struct {
+	Subject    string
+	From, To   []string
+	Cc         []string
+	Date       time.Time
+	MessageID  string
+	InReplyTo  []string
+	References []string
+	Header     message.Header
+	Root       *Part
+}
+
+// ParseMessage decodes the raw message read from r, walking each part of a
+// multipart message and decoding each text part's transfer encoding and
+// charset into UTF-8.
+func ParseMessage(r io.Reader) (*ParsedMessage, error) {
+	e, charsetErr := message.Read(r)
+	if charsetErr != nil && !message.IsUnknownCharset(charsetErr) {
+		return nil, charsetErr
+	}
+
+	h := gomail.Header{Header: e.Header}
+	m := &ParsedMessage{Header: e.Header}
+	var err error
+	if m.Subject, err = h.Subject(); err != nil {
+		m.Subject = h.Get("Subject")
+	}
+	m.From = headerAddresses(h, "From")
+	m.To = headerAddresses(h, "To")
+	m.Cc = headerAddresses(h, "Cc")
+	// a missing or malformed date is left as the zero time
+	m.Date, _ = h.Date()
+	m.MessageID, _ = h.MessageID()
+	m.InReplyTo, _ = h.MsgIDList("In-Reply-To")
+	m.References, _ = h.MsgIDList("References")
+
+	root, err := parsePart(e, "", charsetErr)
+	if err != nil {
+		return nil, err
+	}
+	m.Root = root
+
+	return m, nil
+}
+
+func headerAddresses(h gomail.Header, key string) []string {
+	addrs, err := h.AddressList(key)
+	if err != nil {
+		// keep what was written even if it can not be parsed
+		if v := h.Get(key); v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.Name == "" {
+			formatted = append(formatted, addr.Address)
+			continue
+		}
+		formatted = append(formatted, addr.Name+" <"+addr.Address+">")
+	}
+	return formatted
+}
+
+// parsePart reads e's body, section is the section number of e's parent and
+// charsetErr is the unknown charset error reading e was met with, if any.
+func parsePart(e *message.Entity, section string, charsetErr error) (*Part, error) {
+	p := &Part{Err: charsetErr}
+	p.ContentType, p.Params, _ = e.Header.ContentType()
+	if p.ContentType == "" {
+		p.ContentType = "text/plain"
+	}
+	p.Disposition, _, _ = e.Header.ContentDisposition()
+	p.Filename = partFilename(e.Header)
+	p.Encoding = strings.ToLower(strings.TrimSpace(e.Header.Get("Content-Transfer-Encoding")))
+
+	if mr := e.MultipartReader(); mr != nil {
+		p.Section = section
+		for i := 1; ; i++ {
+			child, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil && !message.IsUnknownCharset(err) {
+				return nil, err
+			}
+
+			cp, err := parsePart(child, childSection(section, i), err)
+			if err != nil {
+				return nil, err
+			}
+			p.Parts = append(p.Parts, cp)
+		}
+		return p, nil
+	}
+
+	// a single part message's body is section 1
+	p.Section = section
+	if p.Section == "" {
+		p.Section = "1"
+	}
+
+	if !p.IsText() || p.IsAttachment() {
+		n, err := io.Copy(io.Discard, e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read part %s: %w", p.Section, err)
+		}
+		p.Size = n
+		return p, nil
+	}
+
+	var text bytes.Buffer
+	n, err := io.Copy(&text, e.Body)
+	if err != nil && p.Err == nil {
+		// an undecodable body is still worth showing as it is
+		p.Err = err
+	}
+	p.Size = n
+	p.Text = text.String()
+	return p, nil
+}
+
+func childSection(parent string, i int) string {
+	if parent == "" {
+		return strconv.Itoa(i)
+	}
+	return parent + "." + strconv.Itoa(i)
+}
+
+func partFilename(h message.Header) string {
+	if _, params, err := h.ContentDisposition(); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	if _, params, err := h.ContentType(); err == nil {
+		return params["name"]
+	}
+	return ""
+}
+
+// TextPart returns the part best suited for displaying the message, the
+// first plain text part which is not an attachment, or failing that the
+// first HTML part. It is nil if the message has neither.
+func (m *ParsedMessage) TextPart() *Part {
+	var plain, html *Part
+	m.Root.Walk(func(p *Part, _ int) {
+		if p.IsAttachment() {
+			return
+		}
+		switch p.ContentType {
+		case "text/plain":
+			if plain == nil {
+				plain = p
+			}
+		case "text/html":
+			if html == nil {
+				html = p
+			}
+		}
+	})
+
+	if plain != nil {
+		return plain
+	}
+	return html
+}
+
+// Attachments returns every part which is an attachment.
+func (m *ParsedMessage) Attachments() []*Part {
+	attachments := []*Part{}
+	m.Root.Walk(func(p *Part, _ int) {
+		if p.IsAttachment() {
+			attachments = append(attachments, p)
+		}
+	})
+	return attachments
+}
@@ -0,0 +1,125 @@
+package mail_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+const multipartMessage = "From: =?utf-8?q?Zo=C3=AB?= <zoe@example.org>\r\n" +
+	"To: bob@example.org, Carol <carol@example.org>\r\n" +
+	"Subject: =?iso-8859-1?q?R=E9union?=\r\n" +
+	"Date: Mon, 02 Jan 2023 15:04:05 +0000\r\n" +
+	"Message-Id: <abc@example.org>\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Caf&eacute;</p>\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=windows-1252\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"=93quoted=94 =96 caf=E9\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=agenda.pdf\r\n" +
+	"Content-Disposition: attachment; filename=agenda.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQgZmFrZQ==\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessageWalksMultipartTree(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mail.ParseMessage(strings.NewReader(multipartMessage))
+	is.NoErr(err)
+
+	is.Equal(m.Subject, "Réunion")
+	is.Equal(m.From, []string{"Zoë <zoe@example.org>"})
+	is.Equal(m.To, []string{"bob@example.org", "Carol <carol@example.org>"})
+	is.Equal(m.MessageID, "abc@example.org")
+	is.Equal(m.Date.Year(), 2023)
+
+	type node struct {
+		section, contentType string
+		depth                int
+	}
+	nodes := []node{}
+	m.Root.Walk(func(p *mail.Part, depth int) {
+		nodes = append(nodes, node{p.Section, p.ContentType, depth})
+	})
+	is.Equal(nodes, []node{
+		{"", "multipart/mixed", 0},
+		{"1", "multipart/alternative", 1},
+		{"1.1", "text/html", 2},
+		{"1.2", "text/plain", 2},
+		{"2", "application/pdf", 1},
+	})
+
+	text := m.TextPart()
+	is.True(text != nil)
+	is.Equal(text.Section, "1.2")
+	is.Equal(strings.TrimSpace(text.Text), "“quoted” – café")
+
+	attachments := m.Attachments()
+	is.Equal(len(attachments), 1)
+	is.Equal(attachments[0].Filename, "agenda.pdf")
+	is.Equal(attachments[0].Disposition, "attachment")
+	is.Equal(attachments[0].Size, int64(len("%PDF-1.4 fake")))
+	is.Equal(attachments[0].Text, "")
+}
+
+func TestParseMessageDecodesLegacyCharsets(t *testing.T) {
+	is := is.New(t)
+
+	for _, tt := range []struct {
+		name, charset, encoding, body, want string
+	}{
+		{name: "iso-8859-2", charset: "iso-8859-2", encoding: "quoted-printable", body: "Za=BF=F3=B3=E6", want: "Zażółć"},
+		{name: "shift_jis", charset: "shift_jis", encoding: "base64", body: "k/qWe4zqgsyDgYFbg4s=", want: "日本語のメール"},
+		{name: "windows-1251", charset: "windows-1251", encoding: "8bit", body: "\xcf\xf0\xe8\xe2\xe5\xf2", want: "Привет"},
+	} {
+		raw := "Subject: charsets\r\n" +
+			"Content-Type: text/plain; charset=" + tt.charset + "\r\n" +
+			"Content-Transfer-Encoding: " + tt.encoding + "\r\n" +
+			"\r\n" + tt.body + "\r\n"
+
+		m, err := mail.ParseMessage(strings.NewReader(raw))
+		is.NoErr(err)
+
+		text := m.TextPart()
+		is.True(text != nil)
+		is.Equal(text.Section, "1")
+		is.Equal(strings.TrimSpace(text.Text), tt.want) // tt.name
+	}
+}
+
+func TestParseMessageFallsBackToHTMLAndKeepsUnknownCharsets(t *testing.T) {
+	is := is.New(t)
+
+	m, err := mail.ParseMessage(strings.NewReader("Content-Type: text/html\r\n\r\n<b>hi</b>\r\n"))
+	is.NoErr(err)
+	is.Equal(m.TextPart().ContentType, "text/html")
+
+	m, err = mail.ParseMessage(strings.NewReader("Content-Type: text/plain; charset=x-unheard-of\r\n\r\nas is\r\n"))
+	is.NoErr(err)
+	is.Equal(strings.TrimSpace(m.TextPart().Text), "as is")
+	is.True(message.IsUnknownCharset(m.TextPart().Err))
+
+	m, err = mail.ParseMessage(strings.NewReader("Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=x-unheard-of\r\n\r\nas is\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nknown\r\n--b--\r\n"))
+	is.NoErr(err)
+	is.True(message.IsUnknownCharset(m.Root.Parts[0].Err))
+	is.NoErr(m.Root.Parts[1].Err)
+	is.NoErr(m.Root.Err)
+}
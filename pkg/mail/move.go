@@ -0,0 +1,336 @@
+package mail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+const (
+	moveCapability    = "MOVE"
+	uidPlusCapability = "UIDPLUS"
+	copyUIDCode       = "COPYUID"
+)
+
+// RemoteMover is the part of an IMAP client needed to move and copy messages
+// between mailboxes. MOVE, COPY and UID EXPUNGE are run through Execute, so the
+// UIDs the server gives the messages within the destination are not lost.
+type RemoteMover interface {
+	RemoteFlagStorer
+	Support(capability string) (bool, error)
+	Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error)
+}
+
+// RelocateResult is what is left to be done on the server once messages have
+// been moved, copied or deleted.
+type RelocateResult struct {
+	// Unexpunged is how many of the messages are left within Mailbox marked
+	// as deleted, as the server lacks UIDPLUS to expunge them alone, rather
+	// than along with every other message marked as deleted.
+	Unexpunged int
+	Mailbox    string
+}
+
+// Status says what is left to be done on the server, if anything.
+func (r RelocateResult) Status() string {
+	if r.Unexpunged == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of the messages are left within %s marked as deleted until it is expunged, as the server is unable to expunge them alone", r.Unexpunged, r.Mailbox)
+}
+
+// MoveMessages moves the messages of from stored at the given rows into to. The
+// server moves them with UID MOVE if it supports MOVE, otherwise they are copied,
+// marked as deleted and expunged with UID EXPUNGE, so that no other message marked
+// as deleted is expunged along with them.
+//
+// The stored messages keep their rows and cached bodies, only within to, so none
+// of them are fetched again. Should to not be stored they are removed instead.
+// Servers without UIDPLUS have no UID EXPUNGE, so on those the originals are left
+// behind marked as deleted, which the stored messages are kept as too, with copies
+// of them stored within to, as the result says.
+func MoveMessages(conn RemoteMover, msgr MessageRepo, from, to Mailbox, rowIDs []uint32) (RelocateResult, error) {
+	msgs, uids, err := loadMessages(msgr, from, rowIDs)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+
+	copied, expunged, err := moveRemote(conn, from.Name, to.Name, uids)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+	if !expunged {
+		return copyUnexpunged(msgr, from, to, rowIDs, msgs, copied)
+	}
+
+	for i, rowID := range rowIDs {
+		if to.UUID == nil {
+			if err := msgr.Delete(from.UUID, rowID); err != nil {
+				return RelocateResult{}, fmt.Errorf("unable to delete message %d: %w", rowID, err)
+			}
+			continue
+		}
+
+		msg := msgs[i]
+		// left as 0 if the server did not say, the next sync
+		// of to finds the message by its Message-ID instead
+		msg.RemoteUID = copied[msg.RemoteUID]
+		if err := msgr.Move(from.UUID, rowID, to.UUID, msg); err != nil {
+			return RelocateResult{}, fmt.Errorf("unable to move message %d: %w", rowID, err)
+		}
+	}
+	return RelocateResult{}, nil
+}
+
+// copyUnexpunged keeps the stored messages which were copied rather than moved
+// on the server, as it was unable to expunge the originals, within from marked
+// as deleted the same as the originals are, storing the copies within to.
+func copyUnexpunged(msgr MessageRepo, from, to Mailbox, rowIDs []uint32, msgs []Message, copied map[uint32]uint32) (RelocateResult, error) {
+	uids, err := ApplyFlags(msgr, from, rowIDs, imap.AddFlags, imap.DeletedFlag)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+
+	if to.UUID != nil {
+		for i, rowID := range rowIDs {
+			// the copies were made before the originals were marked as deleted
+			msg := msgs[i]
+			msg.RemoteUID = copied[msg.RemoteUID]
+			if _, err := msgr.Copy(from.UUID, rowID, to.UUID, msg); err != nil {
+				return RelocateResult{}, fmt.Errorf("unable to copy message %d: %w", rowID, err)
+			}
+		}
+	}
+	return RelocateResult{Unexpunged: len(uids), Mailbox: from.Name}, nil
+}
+
+// CopyMessages copies the messages of from stored at the given rows into to,
+// storing the copies along with the cached bodies of the originals if to is.
+// Nothing is ever left to be done on the server by copying.
+func CopyMessages(conn RemoteMover, msgr MessageRepo, from, to Mailbox, rowIDs []uint32) (RelocateResult, error) {
+	msgs, uids, err := loadMessages(msgr, from, rowIDs)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+
+	copied := map[uint32]uint32{}
+	if len(uids) > 0 {
+		if _, err := conn.Select(from.Name, false); err != nil {
+			return RelocateResult{}, err
+		}
+		if copied, err = copyRemote(conn, to.Name, uidSet(uids)); err != nil {
+			return RelocateResult{}, err
+		}
+	}
+
+	if to.UUID == nil {
+		return RelocateResult{}, nil
+	}
+	for i, rowID := range rowIDs {
+		msg := msgs[i]
+		msg.RemoteUID = copied[msg.RemoteUID]
+		if _, err := msgr.Copy(from.UUID, rowID, to.UUID, msg); err != nil {
+			return RelocateResult{}, fmt.Errorf("unable to copy message %d: %w", rowID, err)
+		}
+	}
+	return RelocateResult{}, nil
+}
+
+// DeleteMessages moves the messages of from stored at the given rows into trash,
+// see Account.Trash. Those within trash already are deleted for good, which
+// needs UIDPLUS, without it they are only marked as deleted, as the result says.
+func DeleteMessages(conn RemoteMover, msgr MessageRepo, from, trash Mailbox, rowIDs []uint32) (RelocateResult, error) {
+	if from.Name != trash.Name {
+		return MoveMessages(conn, msgr, from, trash, rowIDs)
+	}
+
+	uids, err := ApplyFlags(msgr, from, rowIDs, imap.AddFlags, imap.DeletedFlag)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+	if err := StoreRemoteFlags(conn, from.Name, uids, imap.AddFlags, imap.DeletedFlag); err != nil {
+		return RelocateResult{}, err
+	}
+
+	if len(uids) > 0 {
+		expunged, err := expungeRemote(conn, uidSet(uids))
+		if err != nil {
+			return RelocateResult{}, err
+		}
+		if !expunged {
+			return RelocateResult{Unexpunged: len(uids), Mailbox: from.Name}, nil
+		}
+	}
+	for _, rowID := range rowIDs {
+		if err := msgr.Delete(from.UUID, rowID); err != nil {
+			return RelocateResult{}, fmt.Errorf("unable to delete message %d: %w", rowID, err)
+		}
+	}
+	return RelocateResult{}, nil
+}
+
+// loadMessages returns the messages of mb stored at the given rows,
+// along with the UIDs on the server of those which have one.
+func loadMessages(msgr MessageRepo, mb Mailbox, rowIDs []uint32) ([]Message, []uint32, error) {
+	msgs := make([]Message, 0, len(rowIDs))
+	uids := make([]uint32, 0, len(rowIDs))
+	for _, rowID := range rowIDs {
+		msg, err := msgr.Get(mb.UUID, rowID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load message %d: %w", rowID, err)
+		}
+		msgs = append(msgs, msg)
+		if msg.RemoteUID > 0 {
+			uids = append(uids, msg.RemoteUID)
+		}
+	}
+	return msgs, uids, nil
+}
+
+func uidSet(uids []uint32) *imap.SeqSet {
+	seqset := imap.SeqSet{}
+	seqset.AddNum(uids...)
+	return &seqset
+}
+
+// moveRemote moves the messages with the given UIDs on the server, returning
+// the UIDs of the moved messages within to by their UIDs within from, as far
+// as the server said what they are. It reports false if the server was only
+// able to copy them, leaving the originals marked as deleted, see expungeRemote.
+func moveRemote(conn RemoteMover, from, to string, uids []uint32) (map[uint32]uint32, bool, error) {
+	if len(uids) == 0 {
+		return map[uint32]uint32{}, true, nil
+	}
+	if _, err := conn.Select(from, false); err != nil {
+		return nil, false, err
+	}
+
+	seqset := uidSet(uids)
+	supported, err := conn.Support(moveCapability)
+	if err != nil {
+		return nil, false, err
+	}
+	if supported {
+		// the COPYUID of a MOVE is untagged, given before the messages are expunged
+		res := &copyUIDResponse{copied: map[uint32]uint32{}}
+		if err := execute(conn, &commands.Uid{Cmd: &commands.Move{SeqSet: seqset, Mailbox: to}}, res); err != nil {
+			return nil, false, err
+		}
+		return res.copied, true, nil
+	}
+
+	copied, err := copyRemote(conn, to, seqset)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return nil, false, err
+	}
+	expunged, err := expungeRemote(conn, seqset)
+	if err != nil {
+		return nil, false, err
+	}
+	return copied, expunged, nil
+}
+
+// copyRemote copies the messages of the selected mailbox with the given UIDs into to.
+func copyRemote(conn RemoteMover, to string, seqset *imap.SeqSet) (map[uint32]uint32, error) {
+	res := &copyUIDResponse{copied: map[uint32]uint32{}}
+	status, err := conn.Execute(&commands.Uid{Cmd: &commands.Copy{SeqSet: seqset, Mailbox: to}}, res)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	// the COPYUID of a COPY is given along with its tagged OK
+	res.read(status)
+	return res.copied, nil
+}
+
+// expungeRemote expunges the messages of the selected mailbox with the given UIDs,
+// which are marked as deleted, reporting false if the server lacks UIDPLUS and so
+// is unable to expunge them alone.
+func expungeRemote(conn RemoteMover, seqset *imap.SeqSet) (bool, error) {
+	supported, err := conn.Support(uidPlusCapability)
+	if err != nil || !supported {
+		return false, err
+	}
+	if err := execute(conn, &commands.Uid{Cmd: uidExpungeCommand{seqset: seqset}}, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// uidExpungeCommand is the EXPUNGE which is wrapped by commands.Uid
+// to make up the UID EXPUNGE of RFC 4315.
+type uidExpungeCommand struct {
+	seqset *imap.SeqSet
+}
+
+func (cmd uidExpungeCommand) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.seqset}}
+}
+
+// copyUIDResponse collects the COPYUID response code of RFC 4315, mapping
+// the UIDs of the messages copied or moved to the UIDs of their copies.
+type copyUIDResponse struct {
+	copied map[uint32]uint32
+}
+
+func (r *copyUIDResponse) Handle(resp imap.Resp) error {
+	status, ok := resp.(*imap.StatusResp)
+	if !ok || !r.read(status) {
+		return responses.ErrUnhandled
+	}
+	return nil
+}
+
+func (r *copyUIDResponse) read(status *imap.StatusResp) bool {
+	if status.Code != copyUIDCode || len(status.Arguments) < 3 {
+		return false
+	}
+	src, err := parseUIDList(fmt.Sprint(status.Arguments[1]))
+	if err != nil {
+		return false
+	}
+	dest, err := parseUIDList(fmt.Sprint(status.Arguments[2]))
+	if err != nil || len(src) != len(dest) {
+		return false
+	}
+	for i, uid := range src {
+		r.copied[uid] = dest[i]
+	}
+	return true
+}
+
+// parseUIDList returns the UIDs within a set of them in the order they are
+// given, rather than sorted as imap.ParseSeqSet leaves them, since the UIDs
+// of a COPYUID are paired by their positions.
+func parseUIDList(s string) ([]uint32, error) {
+	uids := []uint32{}
+	for _, part := range strings.Split(s, ",") {
+		start, stop, isRange := strings.Cut(part, ":")
+		first, err := strconv.ParseUint(start, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UID %q", start)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(stop, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid UID %q", stop)
+			}
+		}
+		if first > last {
+			first, last = last, first
+		}
+		for uid := first; uid <= last; uid++ {
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
@@ -0,0 +1,248 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+// withoutMove hides MOVE from whoever asks, as the mock server always advertises it.
+type withoutMove struct {
+	RemoteMover
+}
+
+func (c withoutMove) Support(capability string) (bool, error) {
+	if capability == moveCapability {
+		return false, nil
+	}
+	return c.RemoteMover.Support(capability)
+}
+
+type moveFixture struct {
+	log     logging.I
+	conn    RemoteConnection
+	mover   RemoteMover
+	msgRepo MessageRepo
+	cache   *BodyCache
+	inbox   Mailbox
+	archive Mailbox
+	trash   Mailbox
+	rowIDs  []uint32
+}
+
+func newMoveFixture(is *is.I, exts ...server.Extension) (*moveFixture, func()) {
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	for _, name := range []string{"INBOX", "ARCHIVE", "TRASH"} {
+		is.NoErr(backend.CreateMailbox("username", name))
+	}
+	backend.StoreMessage("username", "INBOX", "Message-ID: <1@x>\r\nSubject: Release plan\r\n\r\nWhen do we ship?")
+	backend.StoreMessage("username", "INBOX", "Message-ID: <2@x>\r\nSubject: Lunch\r\n\r\nAnyone hungry?")
+	backend.StoreMessage("username", "INBOX", "Message-ID: <3@x>\r\nSubject: Standup\r\n\r\nRunning late")
+
+	err, shutdown := startLocalServerWithBackend(l, backend, exts...)
+	is.NoErr(err)
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	mover, ok := cc.(RemoteMover)
+	is.True(ok)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+
+	log := logging.New(logging.Options{Writer: &bytes.Buffer{}})
+	f := &moveFixture{
+		log:     log,
+		conn:    cc,
+		mover:   mover,
+		msgRepo: NewMessageRepo(db),
+		cache:   NewBodyCache(log, db, DefaultBodyCachePolicy(), nil),
+		inbox:   Mailbox{UUID: uuid.New(), Name: "INBOX"},
+		archive: Mailbox{UUID: uuid.New(), Name: "ARCHIVE"},
+		trash:   Mailbox{UUID: uuid.New(), Name: "TRASH"},
+	}
+	is.NoErr(SyncMessages(log, cc, f.msgRepo, nil, f.inbox))
+
+	rows, _, err := f.msgRepo.FetchRowPageByOwner(f.inbox.UUID, kvs.Query{OrderBy: "subject"})
+	is.NoErr(err)
+	is.Equal(len(rows), 3)
+	for _, row := range rows {
+		f.rowIDs = append(f.rowIDs, row.ID)
+	}
+
+	return f, func() {
+		cc.Close()
+		db.Close()
+		shutdown()
+	}
+}
+
+// sync brings mb up to date with the server and returns its stored messages.
+func (f *moveFixture) sync(is *is.I, mb Mailbox) []kvs.Row[Message] {
+	is.NoErr(SyncMessages(f.log, f.conn, f.msgRepo, nil, mb))
+	rows, _, err := f.msgRepo.FetchRowPageByOwner(mb.UUID, kvs.Query{OrderBy: "subject"})
+	is.NoErr(err)
+	return rows
+}
+
+func TestMoveMessagesKeepsRowsAndCachedBodies(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		exts      []server.Extension
+		noMove    bool
+		knownUIDs bool
+	}{
+		{name: "server move"},
+		{name: "copy and uid expunge", exts: []server.Extension{mock.UIDPlus()}, noMove: true, knownUIDs: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			f, shutdown := newMoveFixture(is, tt.exts...)
+			defer shutdown()
+			mover := f.mover
+			if tt.noMove {
+				mover = withoutMove{mover}
+			}
+
+			// ordered by subject, so Lunch, Release plan then Standup
+			lunch, standup := f.rowIDs[0], f.rowIDs[2]
+			is.NoErr(f.cache.Store(f.inbox, lunch, []byte("Subject: Lunch\r\n\r\nAnyone hungry?")))
+			// marked as deleted beforehand, which moving another message must not expunge
+			is.NoErr(StoreFlags(mover, f.msgRepo, f.inbox, []uint32{standup}, imap.AddFlags, imap.DeletedFlag))
+
+			res, err := MoveMessages(mover, f.msgRepo, f.inbox, f.archive, []uint32{lunch})
+			is.NoErr(err)
+			is.Equal(res.Status(), "")
+
+			moved, err := f.msgRepo.Get(f.archive.UUID, lunch)
+			is.NoErr(err)
+			is.Equal(moved.Subject, "Lunch")
+			is.Equal(moved.RemoteUID > 0, tt.knownUIDs)
+			_, err = f.msgRepo.Get(f.inbox.UUID, lunch)
+			is.Equal(err, kvs.ErrRowNotFound)
+
+			data, err := f.cache.Open(f.archive, lunch)
+			is.NoErr(err)
+			is.Equal(string(data), "Subject: Lunch\r\n\r\nAnyone hungry?")
+
+			// the server agrees, and syncing finds the moved row rather than storing it again
+			archived := f.sync(is, f.archive)
+			is.Equal(len(archived), 1)
+			is.Equal(archived[0].ID, lunch)
+			is.Equal(archived[0].Value.RemoteUID, uint32(1))
+
+			remaining := f.sync(is, f.inbox)
+			is.Equal(len(remaining), 2)
+			is.Equal(subjects([]Message{remaining[0].Value, remaining[1].Value}), []string{"Release plan", "Standup"})
+		})
+	}
+}
+
+func TestMoveMessagesWithoutUIDPlusLeavesOriginalsDeleted(t *testing.T) {
+	is := is.New(t)
+
+	f, shutdown := newMoveFixture(is)
+	defer shutdown()
+
+	lunch := f.rowIDs[0]
+	is.NoErr(f.cache.Store(f.inbox, lunch, []byte("Subject: Lunch\r\n\r\nAnyone hungry?")))
+	res, err := MoveMessages(withoutMove{f.mover}, f.msgRepo, f.inbox, f.archive, []uint32{lunch})
+	is.NoErr(err)
+	is.Equal(res, RelocateResult{Unexpunged: 1, Mailbox: "INBOX"})
+	is.True(strings.Contains(res.Status(), "1 of the messages are left within INBOX marked as deleted"))
+
+	// the original is kept where the server left it, as it did, rather than fetched again as new
+	original, err := f.msgRepo.Get(f.inbox.UUID, lunch)
+	is.NoErr(err)
+	is.True(original.HasFlag(imap.DeletedFlag))
+
+	archived := f.sync(is, f.archive)
+	is.Equal(len(archived), 1)
+	is.True(!archived[0].Value.HasFlag(imap.DeletedFlag))
+	data, err := f.cache.Open(f.archive, archived[0].ID)
+	is.NoErr(err)
+	is.Equal(string(data), "Subject: Lunch\r\n\r\nAnyone hungry?")
+
+	remaining := f.sync(is, f.inbox)
+	is.Equal(len(remaining), 3)
+	is.Equal(remaining[0].ID, lunch)
+	is.True(remaining[0].Value.HasFlag(imap.DeletedFlag))
+}
+
+func TestCopyMessagesKeepsOriginals(t *testing.T) {
+	is := is.New(t)
+
+	f, shutdown := newMoveFixture(is, mock.UIDPlus())
+	defer shutdown()
+
+	lunch := f.rowIDs[0]
+	is.NoErr(f.cache.Store(f.inbox, lunch, []byte("Subject: Lunch\r\n\r\nAnyone hungry?")))
+	_, err := CopyMessages(f.mover, f.msgRepo, f.inbox, f.archive, []uint32{lunch})
+	is.NoErr(err)
+
+	copies, _, err := f.msgRepo.FetchRowPageByOwner(f.archive.UUID, kvs.Query{})
+	is.NoErr(err)
+	is.Equal(len(copies), 1)
+	is.True(copies[0].ID != lunch)
+	is.Equal(copies[0].Value.RemoteUID, uint32(1))
+
+	for _, mb := range []Mailbox{f.inbox, f.archive} {
+		rowID := lunch
+		if mb == f.archive {
+			rowID = copies[0].ID
+		}
+		data, err := f.cache.Open(mb, rowID)
+		is.NoErr(err)
+		is.Equal(string(data), "Subject: Lunch\r\n\r\nAnyone hungry?")
+	}
+
+	is.Equal(len(f.sync(is, f.archive)), 1)
+	is.Equal(len(f.sync(is, f.inbox)), 3)
+}
+
+func TestDeleteMessagesMovesToTrashThenExpunges(t *testing.T) {
+	is := is.New(t)
+
+	f, shutdown := newMoveFixture(is, mock.UIDPlus())
+	defer shutdown()
+
+	lunch := f.rowIDs[0]
+	_, err := DeleteMessages(f.mover, f.msgRepo, f.inbox, f.trash, []uint32{lunch})
+	is.NoErr(err)
+	is.Equal(len(f.sync(is, f.inbox)), 2)
+	is.Equal(len(f.sync(is, f.trash)), 1)
+
+	res, err := DeleteMessages(f.mover, f.msgRepo, f.trash, f.trash, []uint32{lunch})
+	is.NoErr(err)
+	is.Equal(res.Status(), "")
+	_, err = f.msgRepo.Get(f.trash.UUID, lunch)
+	is.Equal(err, kvs.ErrRowNotFound)
+	is.Equal(len(f.sync(is, f.trash)), 0)
+}
+
+func TestParseUIDList(t *testing.T) {
+	is := is.New(t)
+
+	uids, err := parseUIDList("4,2:3,9:7")
+	is.NoErr(err)
+	is.Equal(uids, []uint32{4, 2, 3, 7, 8, 9})
+
+	_, err = parseUIDList("1:*")
+	is.True(err != nil)
+
+	_, err = parseUIDList("")
+	is.True(err != nil)
+}
@@ -1,8 +1,11 @@
 package mail
 
 import (
+	"bufio"
 	"fmt"
+	"net/textproto"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-imap"
 	imapclient "github.com/emersion/go-imap/client"
@@ -26,9 +29,36 @@ type RemoteMessagesFetcher interface {
 	Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
 }
 
+const (
+	DefaultTrashMailbox   = "Trash"
+	DefaultArchiveMailbox = "Archive"
+	DefaultDraftsMailbox  = "Drafts"
+)
+
 type Account struct {
-	UUID               kvs.UUID
-	Username, Password string
+	UUID     kvs.UUID
+	Username string
+	Password string `mdb:"redact"`
+	// TrashMailbox is where deleted messages are moved to and ArchiveMailbox
+	// where archived ones are, the defaults are used while they are empty.
+	TrashMailbox   string `mdb:"column=trashMailbox"`
+	ArchiveMailbox string `mdb:"column=archiveMailbox"`
+}
+
+// Trash returns the name of the mailbox deleted messages are moved to.
+func (a Account) Trash() string {
+	if a.TrashMailbox == "" {
+		return DefaultTrashMailbox
+	}
+	return a.TrashMailbox
+}
+
+// Archive returns the name of the mailbox archived messages are moved to.
+func (a Account) Archive() string {
+	if a.ArchiveMailbox == "" {
+		return DefaultArchiveMailbox
+	}
+	return a.ArchiveMailbox
 }
 
 type Mailbox struct {
@@ -38,7 +68,107 @@ type Mailbox struct {
 
 type Message struct {
 	UUID      kvs.UUID
-	RemoteUID uint32
+	RemoteUID uint32 `mdb:"index"`
+	Flags     []string
+	Subject   string `mdb:"index"`
+	From, To  []string
+	// Sender is only for ordering by, it is set from From whenever
+	// a message is saved, see SenderSortKey.
+	Sender string    `mdb:"index"`
+	Date   time.Time `mdb:"index"`
+	// Size is the RFC822.SIZE of the message in bytes.
+	Size uint32 `mdb:"index"`
+	// MessageID, InReplyTo and References are kept without their angle
+	// brackets, they are what messages are threaded by.
+	MessageID  string `mdb:"column=messageID"`
+	InReplyTo  string `mdb:"column=inReplyTo"`
+	References []string
+}
+
+func messageFromRemote(msg *imap.Message) Message {
+	m := Message{RemoteUID: msg.Uid, Flags: msg.Flags, Size: msg.Size}
+	if env := msg.Envelope; env != nil {
+		m.Subject = env.Subject
+		m.From = formatAddresses(env.From)
+		m.To = formatAddresses(env.To)
+		m.Date = env.Date
+		if ids := parseMessageIDs(env.MessageId); len(ids) > 0 {
+			m.MessageID = ids[0]
+		}
+		// the last is the one replied to if several are given
+		if ids := parseMessageIDs(env.InReplyTo); len(ids) > 0 {
+			m.InReplyTo = ids[len(ids)-1]
+		}
+	}
+	m.References = referencesFromRemote(msg)
+	return m
+}
+
+// referencesSection is the References header, which is fetched
+// along with each envelope as they leave it out.
+var referencesSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: []string{"References"}},
+	Peek:         true,
+}
+
+func referencesFromRemote(msg *imap.Message) []string {
+	lit := msg.GetBody(referencesSection)
+	if lit == nil {
+		return []string{}
+	}
+	h, err := textproto.NewReader(bufio.NewReader(lit)).ReadMIMEHeader()
+	if err != nil && len(h) == 0 {
+		return []string{}
+	}
+	return parseMessageIDs(h.Get("References"))
+}
+
+// parseMessageIDs returns each ID within a list of them, such as a References
+// header, without their angle brackets. IDs which are missing their brackets
+// are split on whitespace instead.
+func parseMessageIDs(s string) []string {
+	ids := []string{}
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(s[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		s = s[start+end+1:]
+	}
+	if len(ids) == 0 {
+		return append(ids, strings.Fields(s)...)
+	}
+	return ids
+}
+
+func (m Message) searchDocument(hasAttachment bool) SearchDocument {
+	return SearchDocument{
+		Subject:       m.Subject,
+		From:          m.From,
+		To:            m.To,
+		Date:          m.Date,
+		HasAttachment: hasAttachment,
+	}
+}
+
+func (m Message) HasFlag(flag string) bool {
+	return hasFlag(m.Flags, flag)
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
 }
 
 func resolveAddressFromUsername(username string) string {
@@ -51,6 +181,17 @@ func resolveAddressFromUsername(username string) string {
 
 type ClientConnector func(useSSL bool) (RemoteConnection, error)
 
+// Connect logs into the account's server at addr, or at the server
+// resolved from the account's username over TLS if addr is empty.
+func Connect(addr string, acc Account) (RemoteConnection, error) {
+	useSSL := false
+	if len(addr) == 0 {
+		addr = resolveAddressFromUsername(acc.Username)
+		useSSL = true
+	}
+	return ResolveClientConnector(addr, acc)(useSSL)
+}
+
 func ResolveClientConnector(addr string, acc Account) ClientConnector {
 	return func(useSSL bool) (RemoteConnection, error) {
 		var cc *imapclient.Client
@@ -156,9 +297,10 @@ func persistMailbox(conn RemoteConnection, mbRepo MailboxRepo, owner kvs.UUID, m
 	return nil
 }
 
-func storeMessage(msgr MessageRepo, owner kvs.UUID, msg Message) (kvs.UUID, error) {
+func storeMessage(msgr MessageRepo, owner kvs.UUID, msg Message) (kvs.UUID, uint32, error) {
 	msg.UUID = uuid.New()
-	return msg.UUID, msgr.Save(owner, msg)
+	rowID, err := msgr.Save(owner, msg)
+	return msg.UUID, rowID, err
 }
 
 func fetchMailboxMessages(fetcher RemoteMessagesFetcher, mbName string, dest chan *imap.Message, errch chan<- error) {
@@ -173,5 +315,5 @@ func fetchMailboxMessages(fetcher RemoteMessagesFetcher, mbName string, dest cha
 	seqset := imap.SeqSet{}
 	seqset.AddRange(from, to)
 
-	errch <- fetcher.Fetch(&seqset, []imap.FetchItem{imap.FetchEnvelope}, dest)
+	errch <- fetcher.Fetch(&seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchRFC822Size, referencesSection.FetchItem()}, dest)
 }
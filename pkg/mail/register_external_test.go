@@ -56,9 +56,10 @@ func setupListener() (net.Listener, error) {
 	return l, nil
 }
 
-func startLocalServerWithBackend(l net.Listener, backend backend.Backend) (error, func() error) {
+func startLocalServerWithBackend(l net.Listener, backend backend.Backend, exts ...server.Extension) (error, func() error) {
 	s := server.New(backend)
 	s.AllowInsecureAuth = true
+	s.Enable(exts...)
 
 	go s.Serve(l)
 
@@ -65,6 +65,10 @@ func (mar mockAccountRepo) Save(user mail.Account) error {
 	return nil
 }
 
+func (mar mockAccountRepo) Update(user mail.Account) error {
+	return nil
+}
+
 func (mar mockAccountRepo) DumpTo(w io.Writer) error {
 	return nil
 }
@@ -108,9 +112,29 @@ type mockMessageRepo struct {
 	err      error
 }
 
-func (mmsgr *mockMessageRepo) Save(owner kvs.UUID, msg mail.Message) error {
+func (mmsgr *mockMessageRepo) Save(owner kvs.UUID, msg mail.Message) (uint32, error) {
 	defer func() { mmsgr.savedNum++ }()
 	mmsgr.saved = append(mmsgr.saved, msg)
+	return uint32(mmsgr.savedNum), mmsgr.err
+}
+
+func (mmsgr *mockMessageRepo) Get(owner kvs.UUID, rowID uint32) (mail.Message, error) {
+	return mail.Message{}, kvs.ErrRowNotFound
+}
+
+func (mmsgr *mockMessageRepo) Update(owner kvs.UUID, rowID uint32, msg mail.Message) error {
+	return mmsgr.err
+}
+
+func (mmsgr *mockMessageRepo) Move(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg mail.Message) error {
+	return mmsgr.err
+}
+
+func (mmsgr *mockMessageRepo) Copy(owner kvs.UUID, rowID uint32, dest kvs.UUID, msg mail.Message) (uint32, error) {
+	return mmsgr.Save(dest, msg)
+}
+
+func (mmsgr *mockMessageRepo) Delete(owner kvs.UUID, rowID uint32) error {
 	return mmsgr.err
 }
 
@@ -118,6 +142,14 @@ func (mmsgr *mockMessageRepo) FetchByOwner(owner kvs.UUID) ([]mail.Message, erro
 	return nil, nil
 }
 
+func (mmsgr *mockMessageRepo) FetchPageByOwner(owner kvs.UUID, q kvs.Query) ([]mail.Message, kvs.Cursor, error) {
+	return nil, nil, nil
+}
+
+func (mmsgr *mockMessageRepo) FetchRowPageByOwner(owner kvs.UUID, q kvs.Query) ([]kvs.Row[mail.Message], kvs.Cursor, error) {
+	return nil, nil, nil
+}
+
 func (mmsgr *mockMessageRepo) DumpTo(w io.Writer) error {
 	return mmsgr.err
 }
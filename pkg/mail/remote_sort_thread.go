@@ -0,0 +1,430 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mime"
+	netmail "net/mail"
+	"net/textproto"
+	"sort"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// SortKey is what the messages of a mailbox are ordered by, named
+// as they are given to the SORT extension of RFC 5256.
+type SortKey string
+
+const (
+	SortByDate    SortKey = "DATE"
+	SortBySender  SortKey = "FROM"
+	SortBySubject SortKey = "SUBJECT"
+	SortBySize    SortKey = "SIZE"
+)
+
+const (
+	sortCapability   = "SORT"
+	threadCapability = "THREAD=REFERENCES"
+)
+
+// RemoteSortThreader is the part of an IMAP client needed to have the server
+// sort and thread a mailbox. go-imap leaves the SORT and THREAD extensions
+// out, so their commands are run through Execute.
+type RemoteSortThreader interface {
+	RemoteBodyFetcherConnection
+	Support(capability string) (bool, error)
+	Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error)
+}
+
+// RemoteSort returns the UIDs of the messages within the mailbox ordered by
+// key. The server orders them when it supports SORT, otherwise they are
+// ordered here after fetching no more of each message than what is sorted by.
+// The envelopes of the messages are left to be fetched with FetchEnvelopes,
+// a page at a time, as those of a large mailbox take a while to download.
+func RemoteSort(conn RemoteSortThreader, mailboxName string, key SortKey, reverse bool) ([]uint32, error) {
+	mb, err := conn.Select(mailboxName, true)
+	if err != nil || mb.Messages == 0 {
+		return []uint32{}, err
+	}
+
+	supported, err := conn.Support(sortCapability)
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		msgs, err := fetchHeaders(conn, allUIDs(), sortFetchItems(key))
+		if err != nil {
+			return nil, err
+		}
+		sortMessages(msgs, key, reverse)
+		return remoteUIDs(msgs), nil
+	}
+
+	res := &sortResponse{uids: []uint32{}}
+	if err := execute(conn, &commands.Uid{Cmd: sortCommand{key: key, reverse: reverse}}, res); err != nil {
+		return nil, err
+	}
+	return res.uids, nil
+}
+
+// RemoteThreads returns the UIDs of the messages within the mailbox along with
+// the threads they make, in the same form as ThreadMessages returns them with
+// each message being the index of its UID. The server threads them when it
+// supports THREAD=REFERENCES, in which case the threads are ordered by their
+// most recently delivered message as their dates are not known until their
+// envelopes are fetched. Otherwise they are threaded here with ThreadMessages,
+// after fetching no more of each message than what it threads them by.
+func RemoteThreads(conn RemoteSortThreader, mailboxName string) ([]uint32, []*ThreadNode, error) {
+	mb, err := conn.Select(mailboxName, true)
+	if err != nil || mb.Messages == 0 {
+		return []uint32{}, []*ThreadNode{}, err
+	}
+
+	supported, err := conn.Support(threadCapability)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !supported {
+		msgs, err := fetchHeaders(conn, allUIDs(), []imap.FetchItem{threadingSection.FetchItem()})
+		if err != nil {
+			return nil, nil, err
+		}
+		return remoteUIDs(msgs), ThreadMessages(msgs), nil
+	}
+
+	res := &threadResponse{}
+	if err := execute(conn, &commands.Uid{Cmd: threadCommand{}}, res); err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(res.threads, func(i, j int) bool {
+		return res.threads[i].latestUID() < res.threads[j].latestUID()
+	})
+	uids := []uint32{}
+	threads := make([]*ThreadNode, 0, len(res.threads))
+	for _, thread := range res.threads {
+		if n := thread.threadNode(&uids); n != nil {
+			threads = append(threads, n)
+		}
+	}
+	return uids, threads, nil
+}
+
+// FetchEnvelopes returns the envelopes of the messages within the mailbox with
+// the given UIDs, leaving out any which have been expunged since they were listed.
+func FetchEnvelopes(conn RemoteBodyFetcherConnection, mailboxName string, uids []uint32) ([]Message, error) {
+	if len(uids) == 0 {
+		return []Message{}, nil
+	}
+	if _, err := conn.Select(mailboxName, true); err != nil {
+		return nil, err
+	}
+
+	seqset := imap.SeqSet{}
+	seqset.AddNum(uids...)
+	msgc := make(chan *imap.Message)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- conn.UidFetch(&seqset, envelopeFetchItems(), msgc)
+	}()
+
+	msgs := []Message{}
+	for msg := range msgc {
+		if msg == nil || msg.Envelope == nil {
+			continue
+		}
+		msgs = append(msgs, messageFromRemote(msg))
+	}
+	return msgs, <-errc
+}
+
+func allUIDs() *imap.SeqSet {
+	seqset := imap.SeqSet{}
+	seqset.AddRange(1, 0)
+	return &seqset
+}
+
+func remoteUIDs(msgs []Message) []uint32 {
+	uids := make([]uint32, len(msgs))
+	for i, msg := range msgs {
+		uids[i] = msg.RemoteUID
+	}
+	return uids
+}
+
+// threadingSection is the headers messages are threaded by.
+var threadingSection = headerSection("Date", "Subject", "Message-ID", "In-Reply-To", "References")
+
+func headerSection(fields ...string) *imap.BodySectionName {
+	return &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: fields},
+		Peek:         true,
+	}
+}
+
+// sortFetchItems is what is fetched of each message to order them by key.
+func sortFetchItems(key SortKey) []imap.FetchItem {
+	switch key {
+	case SortBySize:
+		return []imap.FetchItem{imap.FetchRFC822Size}
+	case SortBySender:
+		return []imap.FetchItem{headerSection("From").FetchItem()}
+	case SortBySubject:
+		return []imap.FetchItem{headerSection("Subject").FetchItem()}
+	default:
+		return []imap.FetchItem{headerSection("Date").FetchItem()}
+	}
+}
+
+// fetchHeaders fetches the given items of the messages with the given UIDs,
+// returning each as a message with no more than what was fetched of it set.
+func fetchHeaders(conn RemoteBodyFetcherConnection, uids *imap.SeqSet, items []imap.FetchItem) ([]Message, error) {
+	msgc := make(chan *imap.Message)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- conn.UidFetch(uids, append([]imap.FetchItem{imap.FetchUid}, items...), msgc)
+	}()
+
+	msgs := []Message{}
+	for msg := range msgc {
+		if msg == nil {
+			continue
+		}
+		msgs = append(msgs, messageFromHeaders(msg))
+	}
+	return msgs, <-errc
+}
+
+// messageFromHeaders reads a message from the header fields fetched of it.
+func messageFromHeaders(msg *imap.Message) Message {
+	m := Message{RemoteUID: msg.Uid, Size: msg.Size, From: []string{}, References: []string{}}
+	var h textproto.MIMEHeader
+	for _, lit := range msg.Body {
+		h, _ = textproto.NewReader(bufio.NewReader(lit)).ReadMIMEHeader()
+	}
+	if h == nil {
+		return m
+	}
+
+	dec := new(mime.WordDecoder)
+	decode := func(field string) string {
+		v := h.Get(field)
+		if decoded, err := dec.DecodeHeader(v); err == nil {
+			return decoded
+		}
+		return v
+	}
+	m.Subject = decode("Subject")
+	if from := decode("From"); from != "" {
+		m.From = []string{from}
+	}
+	if date, err := netmail.ParseDate(h.Get("Date")); err == nil {
+		m.Date = date
+	}
+	if ids := parseMessageIDs(h.Get("Message-ID")); len(ids) > 0 {
+		m.MessageID = ids[0]
+	}
+	if ids := parseMessageIDs(h.Get("In-Reply-To")); len(ids) > 0 {
+		m.InReplyTo = ids[len(ids)-1]
+	}
+	m.References = parseMessageIDs(h.Get("References"))
+	return m
+}
+
+// remoteExecutor runs the commands go-imap has no method of its own for.
+type remoteExecutor interface {
+	Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error)
+}
+
+func execute(conn remoteExecutor, cmdr imap.Commander, h responses.Handler) error {
+	status, err := conn.Execute(cmdr, h)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// sortMessages orders msgs in close to the same way a server
+// supporting SORT would, for when the server does not.
+func sortMessages(msgs []Message, key SortKey, reverse bool) {
+	less := func(a, b Message) bool {
+		switch key {
+		case SortBySender:
+			return SenderSortKey(a.From) < SenderSortKey(b.From)
+		case SortBySubject:
+			as, _ := baseSubject(a.Subject)
+			bs, _ := baseSubject(b.Subject)
+			return as < bs
+		case SortBySize:
+			return a.Size < b.Size
+		default:
+			return a.Date.Before(b.Date)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if reverse {
+			return less(msgs[j], msgs[i])
+		}
+		return less(msgs[i], msgs[j])
+	})
+}
+
+type sortCommand struct {
+	key     SortKey
+	reverse bool
+}
+
+func (c sortCommand) Command() *imap.Command {
+	criteria := []interface{}{imap.RawString(c.key)}
+	if c.reverse {
+		criteria = append([]interface{}{imap.RawString("REVERSE")}, criteria...)
+	}
+	return &imap.Command{
+		Name:      "SORT",
+		Arguments: []interface{}{criteria, imap.RawString("UTF-8"), imap.RawString("ALL")},
+	}
+}
+
+type sortResponse struct {
+	uids []uint32
+}
+
+func (r *sortResponse) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "SORT" {
+		return responses.ErrUnhandled
+	}
+
+	for _, f := range fields {
+		uid, err := imap.ParseNumber(f)
+		if err != nil {
+			return err
+		}
+		r.uids = append(r.uids, uid)
+	}
+	return nil
+}
+
+type threadCommand struct{}
+
+func (threadCommand) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "THREAD",
+		Arguments: []interface{}{imap.RawString("REFERENCES"), imap.RawString("UTF-8"), imap.RawString("ALL")},
+	}
+}
+
+// uidThreadNode is a message within a thread as the server returns it,
+// a UID of zero is a message the server knows of but does not have.
+type uidThreadNode struct {
+	uid      uint32
+	children []*uidThreadNode
+}
+
+func (n *uidThreadNode) walk(fn func(n *uidThreadNode)) {
+	fn(n)
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+// latestUID returns the UID of the most recently delivered message of the thread.
+func (n *uidThreadNode) latestUID() uint32 {
+	latest := uint32(0)
+	n.walk(func(n *uidThreadNode) {
+		if n.uid > latest {
+			latest = n.uid
+		}
+	})
+	return latest
+}
+
+// threadNode converts n to the tree ThreadMessages returns,
+// appending the UID of each message within it to uids.
+func (n *uidThreadNode) threadNode(uids *[]uint32) *ThreadNode {
+	tn := &ThreadNode{Message: -1}
+	if n.uid > 0 {
+		tn.Message = len(*uids)
+		*uids = append(*uids, n.uid)
+	}
+	for _, child := range n.children {
+		if c := child.threadNode(uids); c != nil {
+			tn.Children = append(tn.Children, c)
+		}
+	}
+
+	switch {
+	case tn.Message >= 0 || len(tn.Children) > 1:
+		return tn
+	case len(tn.Children) == 1:
+		return tn.Children[0]
+	default:
+		return nil
+	}
+}
+
+type threadResponse struct {
+	threads []*uidThreadNode
+}
+
+func (r *threadResponse) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "THREAD" {
+		return responses.ErrUnhandled
+	}
+
+	for _, f := range fields {
+		list, ok := f.([]interface{})
+		if !ok {
+			return fmt.Errorf("malformed thread %v", f)
+		}
+		thread, err := parseThreadList(list)
+		if err != nil {
+			return err
+		}
+		r.threads = append(r.threads, thread)
+	}
+	return nil
+}
+
+// parseThreadList parses a single thread of a THREAD response, such as
+// (3 6 (4 23)(44 7 96)), where each message is the parent of the one
+// after it and nested lists are branches from the message before them.
+func parseThreadList(fields []interface{}) (*uidThreadNode, error) {
+	var root, last *uidThreadNode
+	for _, f := range fields {
+		if list, ok := f.([]interface{}); ok {
+			// branches with nothing before them belong to a missing message
+			if last == nil {
+				root = &uidThreadNode{}
+				last = root
+			}
+			child, err := parseThreadList(list)
+			if err != nil {
+				return nil, err
+			}
+			last.children = append(last.children, child)
+			continue
+		}
+
+		uid, err := imap.ParseNumber(f)
+		if err != nil {
+			return nil, err
+		}
+		n := &uidThreadNode{uid: uid}
+		if last == nil {
+			root = n
+		} else {
+			last.children = append(last.children, n)
+		}
+		last = n
+	}
+
+	if root == nil {
+		return nil, errors.New("empty thread")
+	}
+	return root, nil
+}
@@ -0,0 +1,201 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+)
+
+func startSortThreadServer(is *is.I, exts ...server.Extension) (RemoteSortThreader, func()) {
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	is.NoErr(backend.CreateMailbox("username", "ARCHIVE"))
+	store := func(date, from, subject, id, inReplyTo, body string) {
+		hdr := "Date: " + date + "\r\nFrom: " + from + "\r\nSubject: " + subject + "\r\nMessage-ID: <" + id + ">\r\n"
+		if inReplyTo != "" {
+			hdr += "In-Reply-To: <" + inReplyTo + ">\r\n"
+		}
+		backend.StoreMessage("username", "INBOX", hdr+"\r\n"+body)
+	}
+	store("Mon, 01 May 2023 09:00:00 +0000", "Carol <carol@example.org>", "Release plan", "1@x", "", "When do we ship?")
+	store("Mon, 01 May 2023 10:00:00 +0000", "Alice <alice@example.org>", "Lunch", "2@x", "", "Anyone hungry?")
+	store("Mon, 01 May 2023 11:00:00 +0000", "Bob <bob@example.org>", "Re: Release plan", "3@x", "1@x", "Friday, once the last fixes are reviewed and merged")
+	store("Mon, 01 May 2023 12:00:00 +0000", "Alice <alice@example.org>", "Re: Release plan", "4@x", "1@x", "Works")
+
+	err, shutdown := startLocalServerWithBackend(l, backend, exts...)
+	is.NoErr(err)
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+
+	conn, ok := cc.(RemoteSortThreader)
+	is.True(ok)
+	return conn, func() {
+		cc.Close()
+		shutdown()
+	}
+}
+
+// envelopes fetches the envelopes of uids, in the order they are given.
+func envelopes(is *is.I, conn RemoteSortThreader, uids []uint32) []Message {
+	fetched, err := FetchEnvelopes(conn, "INBOX", uids)
+	is.NoErr(err)
+	byUID := make(map[uint32]Message, len(fetched))
+	for _, msg := range fetched {
+		byUID[msg.RemoteUID] = msg
+	}
+	msgs := make([]Message, len(uids))
+	for i, uid := range uids {
+		msgs[i] = byUID[uid]
+	}
+	return msgs
+}
+
+func subjects(msgs []Message) []string {
+	s := make([]string, len(msgs))
+	for i, msg := range msgs {
+		s[i] = msg.Subject
+	}
+	return s
+}
+
+func threadSubjects(msgs []Message, threads []*ThreadNode) string {
+	lines := []string{}
+	for _, thread := range threads {
+		thread.Walk(func(n *ThreadNode, depth int) {
+			lines = append(lines, strings.Repeat("  ", depth)+msgs[n.Message].Subject)
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestRemoteSortOrdersMessagesWithOrWithoutServerSort(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		exts []server.Extension
+	}{
+		{name: "server sort", exts: []server.Extension{mock.SortThread()}},
+		{name: "local fallback"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			conn, shutdown := startSortThreadServer(is, tt.exts...)
+			defer shutdown()
+
+			uids, err := RemoteSort(conn, "INBOX", SortBySize, true)
+			is.NoErr(err)
+			is.Equal(len(uids), 4)
+			msgs := envelopes(is, conn, uids)
+			is.Equal(msgs[0].MessageID, "3@x")
+			is.True(msgs[0].Size > msgs[1].Size)
+
+			uids, err = RemoteSort(conn, "INBOX", SortByDate, false)
+			is.NoErr(err)
+			is.Equal(subjects(envelopes(is, conn, uids)), []string{"Release plan", "Lunch", "Re: Release plan", "Re: Release plan"})
+
+			uids, err = RemoteSort(conn, "INBOX", SortBySender, false)
+			is.NoErr(err)
+			is.Equal(subjects(envelopes(is, conn, uids))[3], "Release plan")
+
+			uids, err = RemoteSort(conn, "INBOX", SortBySubject, true)
+			is.NoErr(err)
+			is.Equal(subjects(envelopes(is, conn, uids))[3], "Lunch")
+
+			uids, err = RemoteSort(conn, "ARCHIVE", SortByDate, false)
+			is.NoErr(err)
+			is.Equal(len(uids), 0)
+		})
+	}
+}
+
+func TestRemoteThreadsWithOrWithoutServerThread(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		exts []server.Extension
+	}{
+		{name: "server thread", exts: []server.Extension{mock.SortThread()}},
+		{name: "local fallback"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			conn, shutdown := startSortThreadServer(is, tt.exts...)
+			defer shutdown()
+
+			uids, threads, err := RemoteThreads(conn, "INBOX")
+			is.NoErr(err)
+			is.Equal(len(uids), 4)
+			is.Equal(threadSubjects(envelopes(is, conn, uids), threads), strings.Join([]string{
+				"Lunch",
+				"Release plan",
+				"  Re: Release plan",
+				"  Re: Release plan",
+			}, "\n"))
+
+			uids, threads, err = RemoteThreads(conn, "ARCHIVE")
+			is.NoErr(err)
+			is.Equal(len(uids), 0)
+			is.Equal(len(threads), 0)
+		})
+	}
+}
+
+func TestFetchEnvelopesFetchesOnlyThoseAsked(t *testing.T) {
+	is := is.New(t)
+
+	conn, shutdown := startSortThreadServer(is)
+	defer shutdown()
+
+	msgs, err := FetchEnvelopes(conn, "INBOX", []uint32{2, 4, 9})
+	is.NoErr(err)
+	is.Equal(len(msgs), 2)
+	is.Equal(subjects(msgs), []string{"Lunch", "Re: Release plan"})
+	is.Equal(msgs[1].InReplyTo, "1@x")
+
+	msgs, err = FetchEnvelopes(conn, "INBOX", nil)
+	is.NoErr(err)
+	is.Equal(len(msgs), 0)
+}
+
+func TestParseThreadList(t *testing.T) {
+	is := is.New(t)
+
+	render := func(n *uidThreadNode) string {
+		var sb strings.Builder
+		var write func(n *uidThreadNode)
+		write = func(n *uidThreadNode) {
+			fmt.Fprintf(&sb, "(%d", n.uid)
+			for _, child := range n.children {
+				write(child)
+			}
+			sb.WriteString(")")
+		}
+		write(n)
+		return sb.String()
+	}
+
+	// the example from RFC 5256, with the numbers shrunk to single digits
+	thread, err := parseThreadList([]interface{}{"3", "6", []interface{}{"4", "2"}, []interface{}{"5", "7", "9"}})
+	is.NoErr(err)
+	is.Equal(render(thread), "(3(6(4(2))(5(7(9)))))")
+
+	thread, err = parseThreadList([]interface{}{[]interface{}{"3"}, []interface{}{"5"}})
+	is.NoErr(err)
+	is.Equal(render(thread), "(0(3)(5))")
+
+	_, err = parseThreadList([]interface{}{})
+	is.True(err != nil)
+
+	_, err = parseThreadList([]interface{}{imap.RawString("x")})
+	is.True(err != nil)
+}
@@ -42,3 +42,32 @@ func TestFetchByOwner(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(len(fetchedMboxes), 0)
 }
+
+func TestFetchPageByOwner(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+
+	mbRepo := NewMailboxRepo(db)
+	owner := uuid.New()
+	for i := 0; i < 15; i++ {
+		is.NoErr(mbRepo.Save(owner, Mailbox{
+			UUID: uuid.New(),
+			Name: fmt.Sprintf("INBOX%d", i),
+		}))
+	}
+
+	fetched, cursor, err := mbRepo.(mailboxRepo).rows.FetchPageByOwner(owner, kvs.Query{Limit: 10})
+	is.NoErr(err)
+	is.Equal(len(fetched), 10)
+	is.Equal(fetched[0].Name, "INBOX0")
+	is.Equal(fetched[9].Name, "INBOX9")
+
+	fetched, cursor, err = mbRepo.(mailboxRepo).rows.FetchPageByOwner(owner, kvs.Query{Limit: 10, After: cursor})
+	is.NoErr(err)
+	is.Equal(len(fetched), 5)
+	is.Equal(fetched[0].Name, "INBOX10")
+	is.Equal(fetched[4].Name, "INBOX14")
+	is.True(cursor == nil)
+}
@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"errors"
+
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+const (
+	SavedSearchesTableName = "saved_searches"
+)
+
+var errStopIterating = errors.New("stop iterating")
+
+// SavedSearch is a query kept under a name, it is shown as a virtual
+// mailbox holding every message the query matches at the time.
+type SavedSearch struct {
+	UUID  kvs.UUID
+	Name  string
+	Query string
+}
+
+type SavedSearchRepo interface {
+	Save(owner kvs.UUID, search SavedSearch) error
+	// Update replaces the saved search with the same UUID.
+	Update(owner kvs.UUID, search SavedSearch) error
+	Delete(owner kvs.UUID, searchUUID kvs.UUID) error
+	FetchByOwner(owner kvs.UUID) ([]SavedSearch, error)
+	Close()
+}
+
+func NewSavedSearchRepo(db kvs.DB) SavedSearchRepo {
+	return savedSearchRepo{rows: kvs.NewRepo[SavedSearch](db, SavedSearchesTableName)}
+}
+
+type savedSearchRepo struct {
+	rows *kvs.Repo[SavedSearch]
+}
+
+func (r savedSearchRepo) Save(owner kvs.UUID, search SavedSearch) error {
+	_, err := r.rows.Save(owner, search)
+	return err
+}
+
+func (r savedSearchRepo) Update(owner kvs.UUID, search SavedSearch) error {
+	rowID, err := r.findRowID(owner, search.UUID)
+	if err != nil {
+		return err
+	}
+	return r.rows.Update(owner, rowID, search)
+}
+
+func (r savedSearchRepo) Delete(owner kvs.UUID, searchUUID kvs.UUID) error {
+	rowID, err := r.findRowID(owner, searchUUID)
+	if err != nil {
+		return err
+	}
+	return r.rows.Delete(owner, rowID)
+}
+
+func (r savedSearchRepo) FetchByOwner(owner kvs.UUID) ([]SavedSearch, error) {
+	return r.rows.FetchByOwner(owner)
+}
+
+func (r savedSearchRepo) Close() {
+	r.rows.Close()
+}
+
+// findRowID returns the row the saved search with the given UUID is stored at,
+// an account only ever has a handful of them so they are simply scanned.
+func (r savedSearchRepo) findRowID(owner kvs.UUID, searchUUID kvs.UUID) (uint32, error) {
+	var found *uint32
+	err := r.rows.Iterate(owner, kvs.Query{}, func(rowID uint32, s SavedSearch) error {
+		if s.UUID == searchUUID {
+			found = &rowID
+			return errStopIterating
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIterating) {
+		return 0, err
+	}
+	if found == nil {
+		return 0, kvs.ErrRowNotFound
+	}
+	return *found, nil
+}
@@ -0,0 +1,95 @@
+package mail_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestSavedSearchRepoSaveUpdateAndDelete(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	repo := mail.NewSavedSearchRepo(db)
+	acc := uuid.New()
+
+	ci := mail.SavedSearch{UUID: uuid.New(), Name: "CI", Query: "from:ci-bot is:unread"}
+	invoices := mail.SavedSearch{UUID: uuid.New(), Name: "Invoices", Query: "invoice*"}
+	is.NoErr(repo.Save(acc, ci))
+	is.NoErr(repo.Save(acc, invoices))
+	is.NoErr(repo.Save(uuid.New(), mail.SavedSearch{UUID: uuid.New(), Name: "Other account"}))
+
+	ci.Query = "from:ci-bot is:unread newer_than:7d"
+	is.NoErr(repo.Update(acc, ci))
+	is.NoErr(repo.Delete(acc, invoices.UUID))
+
+	searches, err := repo.FetchByOwner(acc)
+	is.NoErr(err)
+	is.Equal(searches, []mail.SavedSearch{ci})
+
+	is.True(errors.Is(repo.Delete(acc, invoices.UUID), kvs.ErrRowNotFound))
+}
+
+func TestParseSearchQueryFlagsAndRelativeDates(t *testing.T) {
+	is := is.New(t)
+
+	before := time.Now()
+	q, err := mail.ParseSearchQuery("is:unread is:flagged newer_than:7d older_than:1w")
+	is.NoErr(err)
+
+	is.Equal(q.WithFlags, []string{imap.FlaggedFlag})
+	is.Equal(q.WithoutFlags, []string{imap.SeenFlag})
+	week := 7 * 24 * time.Hour
+	is.True(!q.After.Before(before.Add(-week)) && !q.After.After(time.Now().Add(-week)))
+	is.Equal(q.Before, q.After)
+
+	_, err = mail.ParseSearchQuery("newer_than:soon")
+	is.True(errors.Is(err, mail.ErrInvalidSearchQuery))
+	_, err = mail.ParseSearchQuery("is:important")
+	is.True(errors.Is(err, mail.ErrInvalidSearchQuery))
+}
+
+func TestSearcherEvaluatesSavedSearchWithUnreadCount(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	msgRepo, idx := mail.NewMessageRepo(db), mail.NewSearchIndex(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, msg := range []mail.Message{
+		{RemoteUID: 1, Subject: "Build failed", From: []string{"ci-bot@build.example"}, Date: now.Add(-48 * time.Hour)},
+		{RemoteUID: 2, Subject: "Build fixed", From: []string{"ci-bot@build.example"}, Date: now.Add(-24 * time.Hour), Flags: []string{imap.SeenFlag}},
+		{RemoteUID: 3, Subject: "Build failed", From: []string{"ci-bot@build.example"}, Date: now.Add(-30 * 24 * time.Hour)},
+		{RemoteUID: 4, Subject: "Lunch", From: []string{"alice@example.org"}, Date: now.Add(-time.Hour)},
+	} {
+		msg.UUID = uuid.New()
+		rowID, err := msgRepo.Save(inbox.UUID, msg)
+		is.NoErr(err)
+		is.NoErr(idx.Add(inbox.UUID, rowID, mail.SearchDocument{Subject: msg.Subject, From: msg.From, Date: msg.Date}))
+	}
+
+	searcher := mail.NewSearcher(db, nil)
+	vm, err := searcher.Evaluate(mail.SavedSearch{Name: "CI", Query: "from:ci-bot newer_than:7d"}, []mail.Mailbox{inbox})
+	is.NoErr(err)
+	is.Equal(len(vm.Matches), 2)
+	is.Equal(vm.Matches[0].UID, uint32(2))
+	is.Equal(vm.Matches[1].UID, uint32(1))
+	is.Equal(vm.Unread, 1)
+
+	vm, err = searcher.Evaluate(mail.SavedSearch{Name: "Unread CI", Query: "from:ci-bot is:unread"}, []mail.Mailbox{inbox})
+	is.NoErr(err)
+	is.Equal(len(vm.Matches), 2)
+	is.Equal(vm.Unread, 2)
+}
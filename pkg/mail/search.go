@@ -0,0 +1,231 @@
+package mail
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+// RemoteSearcher searches a mailbox on the server, returning
+// the UIDs of the messages within it matching criteria.
+type RemoteSearcher interface {
+	SearchMailbox(mailboxName string, criteria *imap.SearchCriteria) ([]uint32, error)
+}
+
+// RemoteSearcherConnection is the part of an IMAP client needed to search a mailbox on the server.
+type RemoteSearcherConnection interface {
+	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
+	UidSearch(criteria *imap.SearchCriteria) ([]uint32, error)
+}
+
+func NewRemoteSearcher(conn RemoteSearcherConnection) RemoteSearcher {
+	return remoteSearcher{conn: conn}
+}
+
+type remoteSearcher struct {
+	conn RemoteSearcherConnection
+}
+
+func (s remoteSearcher) SearchMailbox(mailboxName string, criteria *imap.SearchCriteria) ([]uint32, error) {
+	if _, err := s.conn.Select(mailboxName, true); err != nil {
+		return nil, err
+	}
+	return s.conn.UidSearch(criteria)
+}
+
+// Criteria translates q into the criteria of an IMAP SEARCH. The server
+// matches each term as a substring of the text it was written as, and
+// since IMAP has no notion of attachments, has:attachment only matches
+// messages with a multipart/mixed body.
+func (q SearchQuery) Criteria() *imap.SearchCriteria {
+	c := imap.NewSearchCriteria()
+	for _, term := range q.Terms {
+		switch term.Field {
+		case SearchFieldSubject:
+			c.Header.Add("Subject", term.Text)
+		case SearchFieldFrom:
+			c.Header.Add("From", term.Text)
+		case SearchFieldTo:
+			c.Header.Add("To", term.Text)
+		case SearchFieldBody:
+			c.Body = append(c.Body, term.Text)
+		default:
+			c.Text = append(c.Text, term.Text)
+		}
+	}
+
+	c.SentBefore = q.Before
+	c.SentSince = q.After
+	c.WithFlags = append(c.WithFlags, q.WithFlags...)
+	c.WithoutFlags = append(c.WithoutFlags, q.WithoutFlags...)
+	if q.HasAttachment {
+		c.Header.Add("Content-Type", "multipart/mixed")
+	}
+	return c
+}
+
+func (q SearchQuery) hasDateRange() bool {
+	return !q.Before.IsZero() || !q.After.IsZero()
+}
+
+// SearchMatch is a message found by a search, either
+// within the local index, on the server or both.
+type SearchMatch struct {
+	Mailbox Mailbox
+	UID     uint32
+	// Cached is set when the message is stored locally, at RowID.
+	Cached bool
+	RowID  uint32
+	// Date and Flags are only known for cached messages.
+	Date  time.Time
+	Flags []string
+}
+
+// Searcher searches the local index, and the server for
+// whatever the local index is unable to fully cover.
+type Searcher struct {
+	index    *SearchIndex
+	messages *kvs.Repo[Message]
+	mu       sync.Mutex
+	remote   RemoteSearcher
+}
+
+// NewSearcher returns a Searcher which only searches locally if remote is nil.
+func NewSearcher(db kvs.DB, remote RemoteSearcher) *Searcher {
+	return &Searcher{
+		index:    NewSearchIndex(db),
+		messages: kvs.NewRepo[Message](db, MessagesTableName),
+		remote:   remote,
+	}
+}
+
+// SetRemote sets what the server is searched with, for
+// when the connection to it is made after the searcher.
+func (s *Searcher) SetRemote(remote RemoteSearcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remote = remote
+}
+
+// Search finds every message matching q within the given mailboxes. The
+// server is searched as well for mailboxes with no messages synced yet, and
+// for queries with a date range, which may reach past the synced messages.
+// Messages found both locally and on the server are only returned once.
+// Cached messages come first, most recent first, followed by messages only
+// found on the server, most recently delivered first.
+func (s *Searcher) Search(mailboxes []Mailbox, q SearchQuery) ([]SearchMatch, error) {
+	byUUID := make(map[kvs.UUID]Mailbox, len(mailboxes))
+	for _, mb := range mailboxes {
+		byUUID[mb.UUID] = mb
+	}
+
+	results, err := s.index.Search(q, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	type matchKey struct {
+		mailbox kvs.UUID
+		uid     uint32
+	}
+	seen := map[matchKey]bool{}
+	matches := []SearchMatch{}
+	for _, r := range results {
+		mb, ok := byUUID[r.Mailbox]
+		if !ok {
+			continue
+		}
+
+		msg, err := s.messages.Get(r.Mailbox, r.RowID)
+		if err != nil {
+			return nil, err
+		}
+		// flags change far too often to be kept within the index
+		if !q.matchesFlags(msg) {
+			continue
+		}
+
+		seen[matchKey{mb.UUID, msg.RemoteUID}] = true
+		matches = append(matches, SearchMatch{
+			Mailbox: mb, UID: msg.RemoteUID, Cached: true, RowID: r.RowID, Date: r.Date, Flags: msg.Flags,
+		})
+	}
+
+	s.mu.Lock()
+	searcher := s.remote
+	s.mu.Unlock()
+	if searcher == nil {
+		return matches, nil
+	}
+
+	remote := []SearchMatch{}
+	for _, mb := range mailboxes {
+		search := q.hasDateRange()
+		if !search {
+			cached, err := s.hasMessages(mb)
+			if err != nil {
+				return nil, err
+			}
+			search = !cached
+		}
+		if !search {
+			continue
+		}
+
+		uids, err := searcher.SearchMailbox(mb.Name, q.Criteria())
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range uids {
+			if seen[matchKey{mb.UUID, uid}] {
+				continue
+			}
+			seen[matchKey{mb.UUID, uid}] = true
+			remote = append(remote, SearchMatch{Mailbox: mb, UID: uid})
+		}
+	}
+
+	// UIDs only ever increase as messages are delivered
+	sort.SliceStable(remote, func(i, j int) bool { return remote[i].UID > remote[j].UID })
+	return append(matches, remote...), nil
+}
+
+func (s *Searcher) hasMessages(mb Mailbox) (bool, error) {
+	msgs, _, err := s.messages.FetchPageByOwner(mb.UUID, kvs.Query{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	return len(msgs) > 0, nil
+}
+
+// VirtualMailbox is a saved search along with the messages it matches.
+type VirtualMailbox struct {
+	Search  SavedSearch
+	Matches []SearchMatch
+	// Unread counts the cached matches which have not been seen.
+	Unread int
+}
+
+// Evaluate runs the saved search against the given mailboxes.
+func (s *Searcher) Evaluate(search SavedSearch, mailboxes []Mailbox) (VirtualMailbox, error) {
+	q, err := ParseSearchQuery(search.Query)
+	if err != nil {
+		return VirtualMailbox{}, err
+	}
+
+	matches, err := s.Search(mailboxes, q)
+	if err != nil {
+		return VirtualMailbox{}, err
+	}
+
+	vm := VirtualMailbox{Search: search, Matches: matches}
+	for _, m := range matches {
+		if m.Cached && !hasFlag(m.Flags, imap.SeenFlag) {
+			vm.Unread++
+		}
+	}
+	return vm, nil
+}
@@ -0,0 +1,145 @@
+package mail_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+const benchCorpusSize = 100_000
+
+var benchWords = strings.Fields(`
+	account agenda alpha approval archive budget build calendar change client
+	contract deadline deploy design draft email estimate feedback forecast
+	holiday invoice issue launch meeting merge minutes monthly notes offer
+	order outage payment plan policy proposal quarterly receipt release report
+	request review roadmap sales schedule security shipment status summary
+	support survey team ticket travel update vendor weekly workshop`)
+
+var benchSenders = []string{
+	"Alice <alice@example.org>", "bob@example.org", "ci-bot@build.example",
+	"billing@shop.example", "Carol Smith <carol@corp.example>", "dave@corp.example",
+}
+
+var (
+	benchIndexOnce sync.Once
+	benchIndex     *mail.SearchIndex
+)
+
+// benchVocabulary follows the common words with a long tail of rarer
+// ones, so that word frequencies are closer to those of real mail.
+var benchVocabulary = func() []string {
+	vocabulary := append([]string{}, benchWords...)
+	for i := 0; i < 20_000; i++ {
+		vocabulary = append(vocabulary, fmt.Sprintf("%s%d", benchWords[i%len(benchWords)], i))
+	}
+	return vocabulary
+}()
+
+func benchDocument(r *rand.Rand, zipf *rand.Zipf, i int) mail.SearchDocument {
+	words := func(n int) string {
+		ws := make([]string, n)
+		for j := range ws {
+			ws[j] = benchVocabulary[zipf.Uint64()]
+		}
+		return strings.Join(ws, " ")
+	}
+
+	return mail.SearchDocument{
+		Subject:       words(3 + r.Intn(5)),
+		From:          []string{benchSenders[r.Intn(len(benchSenders))]},
+		To:            []string{benchSenders[r.Intn(len(benchSenders))]},
+		Date:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * 17 * time.Minute),
+		Body:          words(20 + r.Intn(40)),
+		HasAttachment: r.Intn(10) == 0,
+	}
+}
+
+func newBenchZipf(r *rand.Rand) *rand.Zipf {
+	return rand.NewZipf(r, 1.07, 2, uint64(len(benchVocabulary)-1))
+}
+
+// loadBenchIndex indexes a generated corpus of benchCorpusSize messages
+// spread across ten mailboxes, the corpus is only generated once.
+func loadBenchIndex(b *testing.B) *mail.SearchIndex {
+	b.Helper()
+	benchIndexOnce.Do(func() {
+		db, err := kvs.NewMemDB()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		idx := mail.NewSearchIndex(db)
+		r := rand.New(rand.NewSource(1))
+		zipf := newBenchZipf(r)
+		mailboxes := make([]kvs.UUID, 10)
+		for i := range mailboxes {
+			mailboxes[i] = uuid.New()
+		}
+		for i := 0; i < benchCorpusSize; i++ {
+			if err := idx.Add(mailboxes[i%len(mailboxes)], uint32(i), benchDocument(r, zipf, i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		benchIndex = idx
+	})
+	return benchIndex
+}
+
+func BenchmarkSearchIndexAdd(b *testing.B) {
+	db, err := kvs.NewMemDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	idx := mail.NewSearchIndex(db)
+	mailbox := uuid.New()
+	r := rand.New(rand.NewSource(1))
+	zipf := newBenchZipf(r)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := idx.Add(mailbox, uint32(i), benchDocument(r, zipf, i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, query := range []string{
+		"outage",
+		"subject:outage",
+		`"quarterly report"`,
+		"ship*",
+		"from:ci-bot@build.example deploy",
+		"invoice has:attachment",
+		"after:2021-06-01 before:2021-07-01 release",
+	} {
+		b.Run(query, func(b *testing.B) {
+			idx := loadBenchIndex(b)
+			q, err := mail.ParseSearchQuery(query)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				results, err := idx.Search(q, 50)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(results) == 0 {
+					b.Fatalf("no results for %q", query)
+				}
+			}
+		})
+	}
+}
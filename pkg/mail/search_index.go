@@ -0,0 +1,625 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/emersion/go-imap"
+	"github.com/google/uuid"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+// The index is kept alongside the tables within the store, under internal
+// keys which every table scan skips:
+//
+//	_fts.t.field.term.mailbox.row  positions of term within the field
+//	_fts.d.mailbox.row             date and attachment flag of the message
+//	_fts.f.mailbox.row             every term indexed for the message
+const (
+	searchKeyPrefix     = "_fts."
+	searchPostingPrefix = searchKeyPrefix + "t."
+	searchDocPrefix     = searchKeyPrefix + "d."
+	searchTermsPrefix   = searchKeyPrefix + "f."
+
+	scanDocsThreshold = 1000
+)
+
+// SearchDocument holds the parts of a message which are searchable.
+type SearchDocument struct {
+	Subject       string
+	From, To      []string
+	Date          time.Time
+	Body          string
+	HasAttachment bool
+}
+
+func (d SearchDocument) fields() map[string]string {
+	return map[string]string{
+		SearchFieldSubject: d.Subject,
+		SearchFieldFrom:    strings.Join(d.From, " "),
+		SearchFieldTo:      strings.Join(d.To, " "),
+		SearchFieldBody:    d.Body,
+	}
+}
+
+type SearchResult struct {
+	Mailbox kvs.UUID
+	RowID   uint32
+	Date    time.Time
+}
+
+// SearchIndex is an inverted index of the messages stored within each
+// mailbox, where a message is identified by its mailbox and row ID.
+type SearchIndex struct {
+	db kvs.DB
+}
+
+func NewSearchIndex(db kvs.DB) *SearchIndex {
+	return &SearchIndex{db: db}
+}
+
+type docRef struct {
+	mailbox string
+	rowID   uint32
+}
+
+func (r docRef) suffix() string {
+	return fmt.Sprintf("%s.%010d", r.mailbox, r.rowID)
+}
+
+// indexedTerms is every term of a message by field, kept so
+// that the message's postings can be found to remove them.
+type indexedTerms map[string][]string
+
+// Add indexes doc as the message stored at rowID within
+// mailbox, replacing anything indexed for it before.
+func (x *SearchIndex) Add(mailbox kvs.UUID, rowID uint32, doc SearchDocument) error {
+	ref := docRef{mailbox: mailbox.String(), rowID: rowID}
+	return x.db.Update(func(txn *badger.Txn) error {
+		terms, err := loadIndexedTerms(txn, ref)
+		if err != nil {
+			return err
+		}
+		if err := deletePostings(txn, ref, terms); err != nil {
+			return err
+		}
+
+		terms = indexedTerms{}
+		for field, text := range doc.fields() {
+			if err := putPostings(txn, ref, field, text, terms); err != nil {
+				return err
+			}
+		}
+
+		return putDoc(txn, ref, doc.Date, doc.HasAttachment, terms)
+	})
+}
+
+// AddBody indexes the decoded plain text parts of the raw RFC 822 message
+// body as the body of an already indexed message, along with whether it
+// has any attachments. The rest of the message's index is left as it is.
+func (x *SearchIndex) AddBody(mailbox kvs.UUID, rowID uint32, raw []byte) error {
+	text, hasAttachment, err := bodyText(raw)
+	if err != nil {
+		return fmt.Errorf("unable to decode body of message %d: %w", rowID, err)
+	}
+
+	ref := docRef{mailbox: mailbox.String(), rowID: rowID}
+	return x.db.Update(func(txn *badger.Txn) error {
+		terms, err := loadIndexedTerms(txn, ref)
+		if err != nil {
+			return err
+		}
+		if err := deletePostings(txn, ref, indexedTerms{SearchFieldBody: terms[SearchFieldBody]}); err != nil {
+			return err
+		}
+		delete(terms, SearchFieldBody)
+
+		if err := putPostings(txn, ref, SearchFieldBody, text, terms); err != nil {
+			return err
+		}
+
+		date, attached, err := loadDoc(txn, ref)
+		if err != nil {
+			return err
+		}
+		return putDoc(txn, ref, date, attached || hasAttachment, terms)
+	})
+}
+
+// Remove drops everything indexed for the message stored at rowID within mailbox.
+func (x *SearchIndex) Remove(mailbox kvs.UUID, rowID uint32) error {
+	ref := docRef{mailbox: mailbox.String(), rowID: rowID}
+	return x.db.Update(func(txn *badger.Txn) error {
+		terms, err := loadIndexedTerms(txn, ref)
+		if err != nil {
+			return err
+		}
+		if err := deletePostings(txn, ref, terms); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(searchDocPrefix + ref.suffix())); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(searchTermsPrefix + ref.suffix()))
+	})
+}
+
+// Move moves everything indexed for the message stored at rowID within from
+// over to the message stored at toRowID within to, so that a message which
+// has been moved never needs its body decoding again.
+func (x *SearchIndex) Move(from kvs.UUID, rowID uint32, to kvs.UUID, toRowID uint32) error {
+	return x.relocate(docRef{mailbox: from.String(), rowID: rowID}, docRef{mailbox: to.String(), rowID: toRowID}, false)
+}
+
+// Copy is the same as Move, apart from keeping what is indexed for the original message.
+func (x *SearchIndex) Copy(from kvs.UUID, rowID uint32, to kvs.UUID, toRowID uint32) error {
+	return x.relocate(docRef{mailbox: from.String(), rowID: rowID}, docRef{mailbox: to.String(), rowID: toRowID}, true)
+}
+
+func (x *SearchIndex) relocate(from, to docRef, keep bool) error {
+	return x.db.Update(func(txn *badger.Txn) error {
+		keys := [][2][]byte{
+			{[]byte(searchDocPrefix + from.suffix()), []byte(searchDocPrefix + to.suffix())},
+			{[]byte(searchTermsPrefix + from.suffix()), []byte(searchTermsPrefix + to.suffix())},
+		}
+		terms, err := loadIndexedTerms(txn, from)
+		if err != nil {
+			return err
+		}
+		for field, words := range terms {
+			for _, word := range words {
+				keys = append(keys, [2][]byte{postingKey(field, word, from), postingKey(field, word, to)})
+			}
+		}
+
+		for _, k := range keys {
+			item, err := txn.Get(k[0])
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(k[1], v); err != nil {
+				return err
+			}
+			if keep {
+				continue
+			}
+			if err := txn.Delete(k[0]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Search returns every indexed message matching q, most recent first,
+// up to limit messages or all of them if limit is zero.
+func (x *SearchIndex) Search(q SearchQuery, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+	mailboxes := map[string]kvs.UUID{}
+	err := x.db.View(func(txn *badger.Txn) error {
+		var candidates map[docRef]struct{}
+		for _, term := range q.Terms {
+			matched, err := matchTerm(txn, term)
+			if err != nil {
+				return err
+			}
+			candidates = intersect(candidates, matched)
+			if len(candidates) == 0 {
+				return nil
+			}
+		}
+
+		return forEachDoc(txn, candidates, func(ref docRef, date time.Time, attached bool) error {
+			if !q.matchesDate(date) || (q.HasAttachment && !attached) {
+				return nil
+			}
+
+			mailbox, ok := mailboxes[ref.mailbox]
+			if !ok {
+				parsed, err := uuid.Parse(ref.mailbox)
+				if err != nil {
+					return err
+				}
+				mailbox = parsed
+				mailboxes[ref.mailbox] = mailbox
+			}
+			results = append(results, SearchResult{Mailbox: mailbox, RowID: ref.rowID, Date: date})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Date.Equal(results[j].Date) {
+			return results[i].Date.After(results[j].Date)
+		}
+		return results[i].RowID > results[j].RowID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func putPostings(txn *badger.Txn, ref docRef, field, text string, terms indexedTerms) error {
+	positions := map[string][]uint32{}
+	for i, word := range tokenize(text) {
+		if _, ok := positions[word]; !ok {
+			terms[field] = append(terms[field], word)
+		}
+		positions[word] = append(positions[word], uint32(i))
+	}
+
+	for word, pos := range positions {
+		if err := txn.Set(postingKey(field, word, ref), encodePositions(pos)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deletePostings(txn *badger.Txn, ref docRef, terms indexedTerms) error {
+	for field, words := range terms {
+		for _, word := range words {
+			if err := txn.Delete(postingKey(field, word, ref)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func putDoc(txn *badger.Txn, ref docRef, date time.Time, hasAttachment bool, terms indexedTerms) error {
+	// seconds are enough to rank by, and unlike nanoseconds
+	// can represent any date including the zero time
+	doc := make([]byte, 9)
+	binary.BigEndian.PutUint64(doc, uint64(date.Unix()))
+	if hasAttachment {
+		doc[8] = 1
+	}
+	if err := txn.Set([]byte(searchDocPrefix+ref.suffix()), doc); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(searchTermsPrefix+ref.suffix()), data)
+}
+
+func loadDoc(txn *badger.Txn, ref docRef) (time.Time, bool, error) {
+	item, err := txn.Get([]byte(searchDocPrefix + ref.suffix()))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var date time.Time
+	var hasAttachment bool
+	err = item.Value(func(v []byte) error {
+		var err error
+		date, hasAttachment, err = decodeDoc(ref, v)
+		return err
+	})
+	return date, hasAttachment, err
+}
+
+func decodeDoc(ref docRef, v []byte) (time.Time, bool, error) {
+	if len(v) != 9 {
+		return time.Time{}, false, fmt.Errorf("malformed search index entry for %s", ref.suffix())
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(v)), 0).UTC(), v[8] == 1, nil
+}
+
+func loadIndexedTerms(txn *badger.Txn, ref docRef) (indexedTerms, error) {
+	terms := indexedTerms{}
+	item, err := txn.Get([]byte(searchTermsPrefix + ref.suffix()))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return terms, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return terms, item.Value(func(v []byte) error {
+		return json.Unmarshal(v, &terms)
+	})
+}
+
+// forEachDoc calls fn with the date and attachment flag of every candidate
+// message, or of every indexed message when candidates is nil.
+func forEachDoc(txn *badger.Txn, candidates map[docRef]struct{}, fn func(ref docRef, date time.Time, attached bool) error) error {
+	// reading through every entry in order is cheaper
+	// than looking up a large number of them one by one
+	if candidates != nil && len(candidates) < scanDocsThreshold {
+		for ref := range candidates {
+			date, attached, err := loadDoc(txn, ref)
+			if err != nil {
+				return err
+			}
+			if err := fn(ref, date, attached); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(searchDocPrefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		ref, ok := parseDocRef(it.Item().Key()[len(opts.Prefix):])
+		if !ok {
+			continue
+		}
+		if _, ok := candidates[ref]; candidates != nil && !ok {
+			continue
+		}
+
+		var date time.Time
+		var attached bool
+		err := it.Item().Value(func(v []byte) error {
+			var err error
+			date, attached, err = decodeDoc(ref, v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(ref, date, attached); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchTerm returns every message which contains the term's words as a
+// phrase within one of the fields it applies to.
+func matchTerm(txn *badger.Txn, term SearchTerm) (map[docRef]struct{}, error) {
+	fields := searchFields
+	if term.Field != "" {
+		fields = []string{term.Field}
+	}
+
+	matched := map[docRef]struct{}{}
+	for _, field := range fields {
+		phrase := len(term.Words) > 1
+		postings := make([]map[docRef][]uint32, len(term.Words))
+		for i, word := range term.Words {
+			p, err := readPostings(txn, field, word, term.Prefix && i == len(term.Words)-1, phrase)
+			if err != nil {
+				return nil, err
+			}
+			postings[i] = p
+		}
+
+		for ref, first := range postings[0] {
+			if !phrase || containsPhrase(ref, first, postings[1:]) {
+				matched[ref] = struct{}{}
+			}
+		}
+	}
+	return matched, nil
+}
+
+// containsPhrase reports whether each of the following words
+// directly follows the one before it at least once.
+func containsPhrase(ref docRef, first []uint32, following []map[docRef][]uint32) bool {
+	for _, start := range first {
+		found := true
+		for i, postings := range following {
+			positions, ok := postings[ref]
+			if !ok {
+				return false
+			}
+			want := start + uint32(i) + 1
+			j := sort.Search(len(positions), func(k int) bool { return positions[k] >= want })
+			if j == len(positions) || positions[j] != want {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// readPostings returns every message containing word within field, only
+// reading the word's positions within each message when withPositions is set.
+func readPostings(txn *badger.Txn, field, word string, prefix, withPositions bool) (map[docRef][]uint32, error) {
+	keyPrefix := searchPostingPrefix + field + "." + word
+	if !prefix {
+		keyPrefix += "."
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = withPositions
+	opts.Prefix = []byte(keyPrefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	postings := map[docRef][]uint32{}
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		// skip past the rest of the term when matching a prefix
+		rest := key[len(searchPostingPrefix)+len(field)+1:]
+		i := bytes.IndexByte(rest, '.')
+		if i < 0 {
+			continue
+		}
+		ref, ok := parseDocRef(rest[i+1:])
+		if !ok {
+			continue
+		}
+
+		if !withPositions {
+			postings[ref] = nil
+			continue
+		}
+
+		err := it.Item().Value(func(v []byte) error {
+			positions, err := decodePositions(v)
+			if err != nil {
+				return err
+			}
+			// a prefix can match several words within the same message
+			postings[ref] = mergePositions(postings[ref], positions)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return postings, nil
+}
+
+func intersect(a, b map[docRef]struct{}) map[docRef]struct{} {
+	if a == nil {
+		return b
+	}
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	both := map[docRef]struct{}{}
+	for ref := range a {
+		if _, ok := b[ref]; ok {
+			both[ref] = struct{}{}
+		}
+	}
+	return both
+}
+
+func postingKey(field, word string, ref docRef) []byte {
+	return []byte(searchPostingPrefix + field + "." + word + "." + ref.suffix())
+}
+
+func parseDocRef(suffix []byte) (docRef, bool) {
+	mailbox, row, ok := strings.Cut(string(suffix), ".")
+	if !ok {
+		return docRef{}, false
+	}
+	rowID, err := strconv.ParseUint(row, 10, 32)
+	if err != nil {
+		return docRef{}, false
+	}
+	return docRef{mailbox: mailbox, rowID: uint32(rowID)}, true
+}
+
+// encodePositions stores the ascending positions as varint deltas.
+func encodePositions(positions []uint32) []byte {
+	buf := make([]byte, 0, len(positions)*2)
+	last := uint32(0)
+	for _, p := range positions {
+		buf = binary.AppendUvarint(buf, uint64(p-last))
+		last = p
+	}
+	return buf
+}
+
+func decodePositions(data []byte) ([]uint32, error) {
+	positions := []uint32{}
+	last := uint32(0)
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("malformed search index positions")
+		}
+		last += uint32(delta)
+		positions = append(positions, last)
+		data = data[n:]
+	}
+	return positions, nil
+}
+
+func mergePositions(a, b []uint32) []uint32 {
+	if len(a) == 0 {
+		return b
+	}
+	merged := append(append(make([]uint32, 0, len(a)+len(b)), a...), b...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i] < merged[j] })
+	return merged
+}
+
+// bodyText decodes and joins every inline text/plain part of
+// the raw message, and reports if any part is an attachment.
+func bodyText(raw []byte) (string, bool, error) {
+	m, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", false, err
+	}
+
+	var text strings.Builder
+	hasAttachment := false
+	m.Root.Walk(func(p *Part, _ int) {
+		if p.IsAttachment() {
+			hasAttachment = true
+			return
+		}
+		// text in an unknown charset is still indexed as it is
+		if p.ContentType == "text/plain" {
+			text.WriteString(p.Text)
+			text.WriteString("\n")
+		}
+	})
+
+	return text.String(), hasAttachment, nil
+}
+
+// bodyStructureHasAttachment reports whether any part of
+// the structure fetched from the server is an attachment.
+func bodyStructureHasAttachment(bs *imap.BodyStructure) bool {
+	if bs == nil {
+		return false
+	}
+	if strings.EqualFold(bs.Disposition, "attachment") {
+		return true
+	}
+	for _, part := range bs.Parts {
+		if bodyStructureHasAttachment(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatAddresses formats each address the way it would appear in a
+// header, so both the name and the address itself are searchable.
+func formatAddresses(addrs []*imap.Address) []string {
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == nil {
+			continue
+		}
+		if addr.PersonalName == "" {
+			formatted = append(formatted, addr.Address())
+			continue
+		}
+		formatted = append(formatted, addr.PersonalName+" <"+addr.Address()+">")
+	}
+	return formatted
+}
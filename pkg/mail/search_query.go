@@ -0,0 +1,243 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	SearchFieldSubject = "subject"
+	SearchFieldFrom    = "from"
+	SearchFieldTo      = "to"
+	SearchFieldBody    = "body"
+
+	searchDateLayout = "2006-01-02"
+	maxSearchTermLen = 64
+)
+
+var searchFields = []string{SearchFieldSubject, SearchFieldFrom, SearchFieldTo, SearchFieldBody}
+
+var (
+	ErrInvalidSearchQuery = errors.New("invalid search query")
+	errInvalidSearchAge   = errors.New("age must be a number of days or weeks such as 7d or 2w")
+)
+
+// SearchTerm matches messages containing Words next to each other, in that
+// order, within Field, or within any field when Field is empty. With Prefix
+// set the last word matches any word it is the start of.
+type SearchTerm struct {
+	Field  string
+	Words  []string
+	Prefix bool
+	// Text is the term as it was written, which the
+	// server is given to search for instead of Words.
+	Text string
+}
+
+// SearchQuery is a parsed search, a message must match every part of it.
+type SearchQuery struct {
+	Terms []SearchTerm
+	// Before excludes messages sent on or after it, After excludes
+	// messages sent before it, either is ignored when zero.
+	Before, After time.Time
+	HasAttachment bool
+	// WithFlags and WithoutFlags are flags a message must, or must not, have.
+	WithFlags, WithoutFlags []string
+}
+
+func (q SearchQuery) IsEmpty() bool {
+	return len(q.Terms) == 0 && q.Before.IsZero() && q.After.IsZero() && !q.HasAttachment &&
+		len(q.WithFlags) == 0 && len(q.WithoutFlags) == 0
+}
+
+func (q SearchQuery) matchesFlags(msg Message) bool {
+	for _, f := range q.WithFlags {
+		if !msg.HasFlag(f) {
+			return false
+		}
+	}
+	for _, f := range q.WithoutFlags {
+		if msg.HasFlag(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q SearchQuery) matchesDate(date time.Time) bool {
+	if !q.Before.IsZero() && !date.Before(q.Before) {
+		return false
+	}
+	if !q.After.IsZero() && date.Before(q.After) {
+		return false
+	}
+	return true
+}
+
+// ParseSearchQuery parses whitespace separated terms, where each is a word,
+// a "quoted phrase" or either of those qualified with one of the fields,
+// such as from:alice or subject:"weekly report". A trailing * makes a word
+// match as a prefix. The qualifiers before: and after: take a YYYY-MM-DD
+// date, newer_than: and older_than: take an age relative to now such as 7d
+// or 2w, has:attachment only matches messages with attachments and is:
+// matches messages which are read, unread, flagged or unflagged.
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	return parseSearchQuery(s, time.Now())
+}
+
+func parseSearchQuery(s string, now time.Time) (SearchQuery, error) {
+	q := SearchQuery{}
+	for _, token := range splitSearchQuery(s) {
+		field, value, qualified := strings.Cut(token, ":")
+		if !qualified || strings.HasPrefix(token, `"`) {
+			field, value = "", token
+		}
+		field = strings.ToLower(field)
+
+		switch field {
+		case "before", "after":
+			date, err := time.Parse(searchDateLayout, unquote(value))
+			if err != nil {
+				return SearchQuery{}, fmt.Errorf("%w: %s: date must be YYYY-MM-DD", ErrInvalidSearchQuery, token)
+			}
+			if field == "before" {
+				q.Before = date
+			} else {
+				q.After = date
+			}
+			continue
+		case "newer_than", "older_than":
+			age, err := parseSearchAge(unquote(value))
+			if err != nil {
+				return SearchQuery{}, fmt.Errorf("%w: %s: %v", ErrInvalidSearchQuery, token, err)
+			}
+			if field == "newer_than" {
+				q.After = now.Add(-age)
+			} else {
+				q.Before = now.Add(-age)
+			}
+			continue
+		case "is":
+			switch strings.ToLower(unquote(value)) {
+			case "read":
+				q.WithFlags = append(q.WithFlags, imap.SeenFlag)
+			case "unread":
+				q.WithoutFlags = append(q.WithoutFlags, imap.SeenFlag)
+			case "flagged":
+				q.WithFlags = append(q.WithFlags, imap.FlaggedFlag)
+			case "unflagged":
+				q.WithoutFlags = append(q.WithoutFlags, imap.FlaggedFlag)
+			default:
+				return SearchQuery{}, fmt.Errorf("%w: %s: must be one of is:read, is:unread, is:flagged or is:unflagged", ErrInvalidSearchQuery, token)
+			}
+			continue
+		case "has":
+			if strings.ToLower(unquote(value)) != "attachment" {
+				return SearchQuery{}, fmt.Errorf("%w: %s: only has:attachment is supported", ErrInvalidSearchQuery, token)
+			}
+			q.HasAttachment = true
+			continue
+		case "", SearchFieldSubject, SearchFieldFrom, SearchFieldTo, SearchFieldBody:
+		default:
+			// an unknown qualifier is most likely just part
+			// of the text being searched for, such as re:
+			field, value = "", token
+		}
+
+		value = unquote(value)
+		prefix := strings.HasSuffix(value, "*")
+		text := strings.TrimSuffix(value, "*")
+		words := tokenize(text)
+		if len(words) == 0 {
+			continue
+		}
+		q.Terms = append(q.Terms, SearchTerm{Field: field, Words: words, Prefix: prefix, Text: text})
+	}
+
+	return q, nil
+}
+
+// parseSearchAge parses a number of days or weeks, such as 7d or 2w.
+func parseSearchAge(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, errInvalidSearchAge
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, errInvalidSearchAge
+	}
+
+	day := 24 * time.Hour
+	switch s[len(s)-1] {
+	case 'd':
+		return time.Duration(n) * day, nil
+	case 'w':
+		return time.Duration(n) * 7 * day, nil
+	}
+	return 0, errInvalidSearchAge
+}
+
+// splitSearchQuery splits s on whitespace outside of double quotes.
+func splitSearchQuery(s string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+// tokenize lower cases s and splits it into words of letters and digits,
+// anything else separates words, so an address such as alice@example.org
+// becomes the phrase alice example org.
+func tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if len(w) > maxSearchTermLen {
+			words[i] = truncateTerm(w)
+		}
+	}
+	return words
+}
+
+// truncateTerm cuts w down to at most maxSearchTermLen bytes
+// without splitting a multi-byte character.
+func truncateTerm(w string) string {
+	cut := 0
+	for i, r := range w {
+		if i+utf8.RuneLen(r) > maxSearchTermLen {
+			break
+		}
+		cut = i + utf8.RuneLen(r)
+	}
+	return w[:cut]
+}
@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+func mockMessageBody(from, subject string, date time.Time) string {
+	return fmt.Sprintf("From: %s\r\n"+
+		"To: username@example.org\r\n"+
+		"Subject: %s\r\n"+
+		"Date: %s\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"Hi there :)", from, subject, date.Format(time.RFC1123Z))
+}
+
+func TestSearcherMergesLocalAndRemoteResults(t *testing.T) {
+	is := is.New(t)
+
+	l, err := setupListener()
+	is.NoErr(err)
+
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 9, 0, 0, 0, time.UTC) }
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	is.NoErr(backend.CreateMailbox("username", "ARCHIVE"))
+	backend.StoreMessage("username", "INBOX", mockMessageBody("alice@example.org", "Weekly report", day(2)))
+	backend.StoreMessage("username", "INBOX", mockMessageBody("bob@example.org", "Lunch?", day(3)))
+	backend.StoreMessage("username", "ARCHIVE", mockMessageBody("alice@example.org", "Old weekly report", day(1)))
+
+	err, shutdown := startLocalServerWithBackend(l, backend)
+	is.NoErr(err)
+	defer shutdown()
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	inbox := Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	archive := Mailbox{UUID: uuid.New(), Name: "ARCHIVE"}
+	log := logging.New(logging.Options{Writer: &bytes.Buffer{}})
+	is.NoErr(SyncMessages(log, cc, NewMessageRepo(db), NewSearchIndex(db), inbox))
+
+	// delivered after the inbox was synced, so only the server knows of it
+	backend.StoreMessage("username", "INBOX", mockMessageBody("alice@example.org", "Weekly report again", day(4)))
+
+	searcher := NewSearcher(db, NewRemoteSearcher(cc.(RemoteSearcherConnection)))
+	search := func(query string) []SearchMatch {
+		q, err := ParseSearchQuery(query)
+		is.NoErr(err)
+		matches, err := searcher.Search([]Mailbox{inbox, archive}, q)
+		is.NoErr(err)
+		return matches
+	}
+
+	// the inbox has been synced so is only searched locally
+	matches := search("subject:report")
+	is.Equal(len(matches), 2)
+	is.Equal(matches[0].Mailbox, inbox)
+	is.Equal(matches[0].UID, uint32(1))
+	is.True(matches[0].Cached)
+	is.Equal(matches[0].Date, day(2))
+	is.Equal(matches[1].Mailbox, archive)
+	is.Equal(matches[1].UID, uint32(1))
+	is.True(!matches[1].Cached)
+
+	// a date range has the synced inbox searched on the server too, which
+	// finds the newer message while the cached one is only returned once
+	matches = search("from:alice@example.org after:2023-01-02")
+	is.Equal(len(matches), 2)
+	is.Equal(matches[0].Mailbox, inbox)
+	is.Equal(matches[0].UID, uint32(1))
+	is.True(matches[0].Cached)
+	is.Equal(matches[1].Mailbox, inbox)
+	is.Equal(matches[1].UID, uint32(3))
+	is.True(!matches[1].Cached)
+}
+
+func TestSearchQueryCriteria(t *testing.T) {
+	is := is.New(t)
+
+	q, err := ParseSearchQuery(`from:alice@example.org subject:"weekly report" body:invoice* hello before:2023-02-01 after:2023-01-01 has:attachment`)
+	is.NoErr(err)
+
+	c := q.Criteria()
+	is.Equal(c.Header.Values("From"), []string{"alice@example.org"})
+	is.Equal(c.Header.Values("Subject"), []string{"weekly report"})
+	is.Equal(c.Header.Values("Content-Type"), []string{"multipart/mixed"})
+	is.Equal(c.Body, []string{"invoice"})
+	is.Equal(c.Text, []string{"hello"})
+	is.Equal(c.SentBefore, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	is.Equal(c.SentSince, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,144 @@
+package mail_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	is := is.New(t)
+
+	q, err := mail.ParseSearchQuery(`invoice* from:alice@example.org subject:"Weekly Report" re:budget before:2023-02-01 after:2023-01-01 has:attachment`)
+	is.NoErr(err)
+
+	is.Equal(q.Terms, []mail.SearchTerm{
+		{Words: []string{"invoice"}, Prefix: true, Text: "invoice"},
+		{Field: mail.SearchFieldFrom, Words: []string{"alice", "example", "org"}, Text: "alice@example.org"},
+		{Field: mail.SearchFieldSubject, Words: []string{"weekly", "report"}, Text: "Weekly Report"},
+		{Words: []string{"re", "budget"}, Text: "re:budget"},
+	})
+	is.Equal(q.Before, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	is.Equal(q.After, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	is.True(q.HasAttachment)
+}
+
+func TestParseSearchQueryRejectsInvalidQualifiers(t *testing.T) {
+	is := is.New(t)
+
+	_, err := mail.ParseSearchQuery("before:yesterday")
+	is.True(errors.Is(err, mail.ErrInvalidSearchQuery))
+
+	_, err = mail.ParseSearchQuery("has:pictures")
+	is.True(errors.Is(err, mail.ErrInvalidSearchQuery))
+}
+
+type searchFixture struct {
+	index *mail.SearchIndex
+	inbox kvs.UUID
+}
+
+func newSearchFixture(t *testing.T) searchFixture {
+	t.Helper()
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	t.Cleanup(func() { db.Close() })
+
+	f := searchFixture{index: mail.NewSearchIndex(db), inbox: uuid.New()}
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 9, 0, 0, 0, time.UTC) }
+	for rowID, doc := range []mail.SearchDocument{
+		{Subject: "Weekly report", From: []string{"Alice <alice@example.org>"}, Date: day(2), Body: "numbers are up this week"},
+		{Subject: "Re: Weekly report", From: []string{"bob@example.org"}, Date: day(3), Body: "the report looks good"},
+		{Subject: "Invoice 1042", From: []string{"billing@shop.example"}, Date: day(5), HasAttachment: true},
+		{Subject: "Invoices overdue", From: []string{"billing@shop.example"}, Date: day(9)},
+	} {
+		is.NoErr(f.index.Add(f.inbox, uint32(rowID), doc))
+	}
+
+	return f
+}
+
+func (f searchFixture) search(t *testing.T, query string) []uint32 {
+	t.Helper()
+	is := is.New(t)
+
+	q, err := mail.ParseSearchQuery(query)
+	is.NoErr(err)
+	results, err := f.index.Search(q, 0)
+	is.NoErr(err)
+
+	rowIDs := []uint32{}
+	for _, r := range results {
+		is.Equal(r.Mailbox, f.inbox)
+		rowIDs = append(rowIDs, r.RowID)
+	}
+	return rowIDs
+}
+
+func TestSearchIndexMatchesQueriesMostRecentFirst(t *testing.T) {
+	is := is.New(t)
+	f := newSearchFixture(t)
+
+	is.Equal(f.search(t, "report"), []uint32{1, 0})
+	is.Equal(f.search(t, "subject:report"), []uint32{1, 0})
+	is.Equal(f.search(t, `"weekly report"`), []uint32{1, 0})
+	is.Equal(f.search(t, `"report weekly"`), []uint32{})
+	is.Equal(f.search(t, "invoice*"), []uint32{3, 2})
+	is.Equal(f.search(t, "invoice"), []uint32{2})
+	is.Equal(f.search(t, "from:alice"), []uint32{0})
+	is.Equal(f.search(t, "from:billing@shop.example"), []uint32{3, 2})
+	is.Equal(f.search(t, "body:looks report"), []uint32{1})
+	is.Equal(f.search(t, "has:attachment"), []uint32{2})
+	is.Equal(f.search(t, "before:2023-01-05"), []uint32{1, 0})
+	is.Equal(f.search(t, "after:2023-01-05 billing"), []uint32{3, 2})
+	is.Equal(f.search(t, "unknown"), []uint32{})
+}
+
+func TestSearchIndexReplacesAndRemovesMessages(t *testing.T) {
+	is := is.New(t)
+	f := newSearchFixture(t)
+
+	is.NoErr(f.index.Add(f.inbox, 0, mail.SearchDocument{Subject: "Monthly summary"}))
+	is.Equal(f.search(t, "weekly"), []uint32{1})
+	is.Equal(f.search(t, "monthly"), []uint32{0})
+
+	is.NoErr(f.index.Remove(f.inbox, 1))
+	is.Equal(f.search(t, "weekly"), []uint32{})
+	is.Equal(f.search(t, "report"), []uint32{})
+}
+
+func TestSearchIndexAddBodyDecodesTextParts(t *testing.T) {
+	is := is.New(t)
+	f := newSearchFixture(t)
+
+	raw := "From: alice@example.org\r\n" +
+		"Subject: Holiday photos\r\n" +
+		"Content-Type: multipart/mixed; boundary=sep\r\n" +
+		"\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Photos from the caf=E9 are attached\r\n" +
+		"--sep\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Disposition: attachment; filename=cafe.jpg\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"aGVsbG8=\r\n" +
+		"--sep--\r\n"
+	is.NoErr(f.index.AddBody(f.inbox, 0, []byte(raw)))
+
+	is.Equal(f.search(t, `body:"from the café"`), []uint32{0})
+	is.Equal(f.search(t, "has:attachment"), []uint32{2, 0})
+	// the rest of the message stays indexed, the old body does not
+	is.Equal(f.search(t, "subject:weekly"), []uint32{1, 0})
+	is.Equal(f.search(t, "numbers"), []uint32{})
+}
@@ -2,19 +2,59 @@ package mail
 
 import (
 	"github.com/emersion/go-imap"
+	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/pkg/logging"
 )
 
+// SyncMessages stores the envelope of every message within mb, indexing
+// each one as it is stored unless idx is nil. Messages which are stored
+// already only have their flags brought up to date with the server's, those
+// moved or copied here without the server saying which UIDs they were given
+// are told apart by their Message-ID instead.
 func SyncMessages(
 	log logging.I,
 	conn RemoteConnection,
 	msgr MessageRepo,
+	idx *SearchIndex,
 	mb Mailbox,
 ) error {
-	if err := forEachMessage(conn, mb.Name, func(name string) error {
-		if err := msgr.Save(mb.UUID, Message{}); err != nil {
+	withoutUID, err := storedWithoutUID(msgr, mb.UUID)
+	if err != nil {
+		return err
+	}
+
+	if err := forEachMessage(conn, mb.Name, func(remote *imap.Message) error {
+		msg := messageFromRemote(remote)
+		stored, err := msgr.FetchRowsByRemoteUID(mb.UUID, remote.Uid)
+		if err != nil {
 			return err
 		}
+		if len(stored) > 0 {
+			row := stored[0]
+			if sameFlags(row.Value.Flags, msg.Flags) {
+				return nil
+			}
+			row.Value.Flags = msg.Flags
+			return msgr.Update(mb.UUID, row.ID, row.Value)
+		}
+		if row, ok := withoutUID[msg.MessageID]; ok && msg.MessageID != "" {
+			delete(withoutUID, msg.MessageID)
+			row.Value.RemoteUID = msg.RemoteUID
+			row.Value.Flags = msg.Flags
+			return msgr.Update(mb.UUID, row.ID, row.Value)
+		}
+
+		_, rowID, err := storeMessage(msgr, mb.UUID, msg)
+		if err != nil {
+			return err
+		}
+
+		if idx == nil {
+			return nil
+		}
+		if err := idx.Add(mb.UUID, rowID, msg.searchDocument(bodyStructureHasAttachment(remote.BodyStructure))); err != nil {
+			log.Error().Msgf("unable to index message %d: %v", remote.Uid, err)
+		}
 		return nil
 	}); err != nil {
 		return err
@@ -22,7 +62,23 @@ func SyncMessages(
 	return nil
 }
 
-func forEachMessage(conn RemoteConnection, mailboxName string, callback func(name string) error) error {
+// storedWithoutUID returns the messages stored for a mailbox which have no UID
+// yet by their Message-IDs, those stored with a UID are looked up by it instead.
+func storedWithoutUID(msgr MessageRepo, owner kvs.UUID) (map[string]kvs.Row[Message], error) {
+	rows, err := msgr.FetchRowsByRemoteUID(owner, 0)
+	if err != nil {
+		return nil, err
+	}
+	withoutUID := make(map[string]kvs.Row[Message], len(rows))
+	for _, row := range rows {
+		if row.Value.MessageID != "" {
+			withoutUID[row.Value.MessageID] = row
+		}
+	}
+	return withoutUID, nil
+}
+
+func forEachMessage(conn RemoteConnection, mailboxName string, callback func(msg *imap.Message) error) error {
 	mb, err := conn.Select(mailboxName, true)
 	if err != nil {
 		return err
@@ -32,7 +88,7 @@ func forEachMessage(conn RemoteConnection, mailboxName string, callback func(nam
 	errc := make(chan error)
 	defer close(errc)
 	go func() {
-		errc <- conn.Fetch(buildSequence(mb.Messages), []imap.FetchItem{imap.FetchEnvelope}, msgc)
+		errc <- conn.Fetch(buildSequence(mb.Messages), append(envelopeFetchItems(), imap.FetchBodyStructure), msgc)
 	}()
 
 	// if an error is encountered, msgc should be closed automatically
@@ -41,7 +97,7 @@ func forEachMessage(conn RemoteConnection, mailboxName string, callback func(nam
 			continue
 		}
 
-		if err := callback(msg.Envelope.Subject); err != nil {
+		if err := callback(msg); err != nil {
 			return err
 		}
 	}
@@ -49,6 +105,13 @@ func forEachMessage(conn RemoteConnection, mailboxName string, callback func(nam
 	return <-errc
 }
 
+// envelopeFetchItems are what is fetched of each message to store it.
+func envelopeFetchItems() []imap.FetchItem {
+	return []imap.FetchItem{
+		imap.FetchUid, imap.FetchFlags, imap.FetchEnvelope, imap.FetchRFC822Size, referencesSection.FetchItem(),
+	}
+}
+
 func buildSequence(msgs uint32) *imap.SeqSet {
 	from := uint32(1)
 	to := msgs
@@ -1,12 +1,17 @@
 package mail
 
 import (
+	"bytes"
 	"errors"
 	"sort"
 	"testing"
 
 	"github.com/emersion/go-imap"
+	"github.com/google/uuid"
 	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
 )
 
 func TestForEachMessageWithFetchingSuccessful(t *testing.T) {
@@ -21,8 +26,8 @@ func TestForEachMessageWithFetchingSuccessful(t *testing.T) {
 	}
 
 	fetchedSubjects := []string{}
-	is.NoErr(forEachMessage(mconn, "INBOX", func(name string) error {
-		fetchedSubjects = append(fetchedSubjects, name)
+	is.NoErr(forEachMessage(mconn, "INBOX", func(msg *imap.Message) error {
+		fetchedSubjects = append(fetchedSubjects, msg.Envelope.Subject)
 		return nil
 	}))
 
@@ -48,8 +53,8 @@ func TestForEachMessageWithFetchingEncountersAnImmediateErrorOnStart(t *testing.
 	}
 
 	fetchedSubjects := []string{}
-	err := forEachMessage(mconn, "INBOX", func(name string) error {
-		fetchedSubjects = append(fetchedSubjects, name)
+	err := forEachMessage(mconn, "INBOX", func(msg *imap.Message) error {
+		fetchedSubjects = append(fetchedSubjects, msg.Envelope.Subject)
 		return nil
 	})
 	is.True(err != nil)
@@ -79,8 +84,8 @@ func TestForEachMessageWithFetchingEncountersAnErrorDuringProcess(t *testing.T)
 	}
 
 	fetchedSubjects := []string{}
-	err := forEachMessage(mconn, "INBOX", func(name string) error {
-		fetchedSubjects = append(fetchedSubjects, name)
+	err := forEachMessage(mconn, "INBOX", func(msg *imap.Message) error {
+		fetchedSubjects = append(fetchedSubjects, msg.Envelope.Subject)
 		return nil
 	})
 	is.True(err != nil)
@@ -101,14 +106,92 @@ func TestForEachMessageWithFetchingEncountersAnErrorDuringProcess(t *testing.T)
 	}
 }
 
-func contains(s []string, str string) bool {
-	for _, v := range s {
-		if v == str {
-			return true
-		}
+func TestSyncMessagesStoresAndIndexesEnvelopes(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	mconn := &mockRemoteConnection{
+		mailboxes: makeRemoteConnectionData(map[uint32]string{
+			3353: "Cats & Dogs",
+			5393: "Re: neighbour noise complaint",
+			3283: "Library - Book Overdue!",
+		}),
 	}
 
-	return false
+	msgRepo, idx := NewMessageRepo(db), NewSearchIndex(db)
+	inbox := Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(SyncMessages(logging.New(logging.Options{Writer: &bytes.Buffer{}}), mconn, msgRepo, idx, inbox))
+
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 3)
+
+	subjects := []string{}
+	for _, msg := range msgs {
+		subjects = append(subjects, msg.Subject)
+	}
+	is.True(contains(subjects, "Cats & Dogs"))
+
+	results, err := idx.Search(SearchQuery{Terms: []SearchTerm{{Field: SearchFieldSubject, Words: []string{"dogs"}}}}, 0)
+	is.NoErr(err)
+	is.Equal(len(results), 1)
+
+	msg, err := kvs.NewRepo[Message](db, MessagesTableName).Get(inbox.UUID, results[0].RowID)
+	is.NoErr(err)
+	is.Equal(msg.RemoteUID, uint32(3353))
+}
+
+func TestSyncMessagesStoresThreadingHeaders(t *testing.T) {
+	is := is.New(t)
+
+	l, err := setupListener()
+	is.NoErr(err)
+
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	backend.StoreMessage("username", "INBOX", "From: alice@example.org\r\n"+
+		"Subject: Re: Release plan\r\n"+
+		"Message-ID: <3@example.org>\r\n"+
+		"In-Reply-To: <2@example.org>\r\n"+
+		"References: <1@example.org>\r\n <2@example.org>\r\n"+
+		"\r\n"+
+		"Sounds good")
+
+	err, shutdown := startLocalServerWithBackend(l, backend)
+	is.NoErr(err)
+	defer shutdown()
+
+	cc, err := ResolveClientConnector(l.Addr().String(), Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	msgRepo := NewMessageRepo(db)
+	inbox := Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	is.NoErr(SyncMessages(logging.New(logging.Options{Writer: &bytes.Buffer{}}), cc, msgRepo, nil, inbox))
+
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 1)
+	is.Equal(msgs[0].MessageID, "3@example.org")
+	is.Equal(msgs[0].InReplyTo, "2@example.org")
+	is.Equal(msgs[0].References, []string{"1@example.org", "2@example.org"})
+}
+
+func TestParseMessageIDs(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(parseMessageIDs("<a@x> <b@x>\t<c@x>"), []string{"a@x", "b@x", "c@x"})
+	is.Equal(parseMessageIDs(" <a@x> (comment) <b@x"), []string{"a@x"})
+	is.Equal(parseMessageIDs("a@x b@x"), []string{"a@x", "b@x"})
+	is.Equal(parseMessageIDs(""), []string{})
 }
 
 func doesNotContain(s []string, str string) bool {
@@ -0,0 +1,16 @@
+package mail
+
+import "github.com/tauraamui/maildew/internal/kvs"
+
+// Tables returns the type stored within each of the account,
+// mailbox and message tables, for decoding them when inspecting.
+func Tables() kvs.Tables {
+	tables := kvs.Tables{}
+	tables.Register(AccountsTableName, Account{})
+	tables.Register(MailboxesTableName, Mailbox{})
+	tables.Register(MessagesTableName, Message{})
+	tables.Register(BodiesTableName, body{})
+	tables.Register(BodyMetaTableName, BodyMeta{})
+	tables.Register(SavedSearchesTableName, SavedSearch{})
+	return tables
+}
@@ -0,0 +1,324 @@
+package mail
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// ThreadNode is a message within a conversation along with its replies.
+type ThreadNode struct {
+	// Message is the index of the message within those threaded, or -1 for
+	// one which is only known of from being referenced by its replies.
+	Message  int
+	Children []*ThreadNode
+	// Latest is the date of the newest message within the node's subtree.
+	Latest time.Time
+}
+
+// Walk calls fn with n and each of its descendants, depth first.
+func (n *ThreadNode) Walk(fn func(n *ThreadNode, depth int)) {
+	n.walk(fn, 0)
+}
+
+func (n *ThreadNode) walk(fn func(n *ThreadNode, depth int), depth int) {
+	fn(n, depth)
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Count returns how many of the messages threaded are within the node's subtree.
+func (n *ThreadNode) Count() int {
+	count := 0
+	n.Walk(func(n *ThreadNode, _ int) {
+		if n.Message >= 0 {
+			count++
+		}
+	})
+	return count
+}
+
+// FirstMessage returns the index of the first message within the
+// node's subtree, which is the node's own message unless it has none.
+func (n *ThreadNode) FirstMessage() int {
+	if n.Message >= 0 || len(n.Children) == 0 {
+		return n.Message
+	}
+	return n.Children[0].FirstMessage()
+}
+
+// container is a node of the tree as it is being built,
+// before empty containers have been pruned away.
+type container struct {
+	index    int
+	parent   *container
+	children []*container
+}
+
+func (c *container) isEmpty() bool {
+	return c.index < 0
+}
+
+// isAncestorOf reports whether c is other or any of its parents.
+func (c *container) isAncestorOf(other *container) bool {
+	for ; other != nil; other = other.parent {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *container) addChild(child *container) {
+	child.parent = c
+	c.children = append(c.children, child)
+}
+
+func (c *container) unlink() {
+	if c.parent == nil {
+		return
+	}
+	siblings := c.parent.children
+	for i, sibling := range siblings {
+		if sibling == c {
+			c.parent.children = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	c.parent = nil
+}
+
+// ThreadMessages groups msgs into conversations using Jamie Zawinski's threading
+// algorithm over their Message-ID, In-Reply-To and References, falling back to
+// grouping by subject for replies whose parents are unknown. Replies are ordered
+// oldest first and the returned threads by their newest message, oldest first.
+func ThreadMessages(msgs []Message) []*ThreadNode {
+	ids := make(map[string]*container, len(msgs))
+	all := make([]*container, 0, len(msgs))
+	containerFor := func(id string) *container {
+		c, ok := ids[id]
+		if !ok {
+			c = &container{index: -1}
+			ids[id] = c
+			all = append(all, c)
+		}
+		return c
+	}
+
+	for i, msg := range msgs {
+		var c *container
+		if existing, ok := ids[msg.MessageID]; msg.MessageID == "" || (ok && !existing.isEmpty()) {
+			// without an ID of its own, a message can only be threaded by what it references
+			c = &container{index: -1}
+			all = append(all, c)
+		} else {
+			c = containerFor(msg.MessageID)
+		}
+		c.index = i
+
+		var prev *container
+		for _, ref := range msg.threadReferences() {
+			rc := containerFor(ref)
+			// links already made are kept, as are any which would make a loop
+			if prev != nil && rc.parent == nil && !rc.isAncestorOf(prev) {
+				prev.addChild(rc)
+			}
+			prev = rc
+		}
+
+		// the message itself knows its parent better than anything referencing it
+		c.unlink()
+		if prev != nil && !c.isAncestorOf(prev) {
+			prev.addChild(c)
+		}
+	}
+
+	roots := []*container{}
+	for _, c := range all {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+	roots = pruneContainers(roots, true)
+	roots = groupBySubject(msgs, roots)
+
+	threads := make([]*ThreadNode, len(roots))
+	for i, c := range roots {
+		threads[i] = buildThreadNode(msgs, c)
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].Latest.Before(threads[j].Latest)
+	})
+	return threads
+}
+
+// threadReferences returns the IDs of the message's ancestors, oldest first.
+func (m Message) threadReferences() []string {
+	refs := make([]string, 0, len(m.References)+1)
+	for _, ref := range m.References {
+		if ref != m.MessageID {
+			refs = append(refs, ref)
+		}
+	}
+	if m.InReplyTo != "" && m.InReplyTo != m.MessageID && (len(refs) == 0 || refs[len(refs)-1] != m.InReplyTo) {
+		refs = append(refs, m.InReplyTo)
+	}
+	return refs
+}
+
+// pruneContainers removes empty containers, moving any children they have into
+// their place. At the root an empty container is only kept if it has several
+// children, as it is then the only thing tying those messages together.
+func pruneContainers(cs []*container, root bool) []*container {
+	pruned := make([]*container, 0, len(cs))
+	for _, c := range cs {
+		c.children = pruneContainers(c.children, false)
+		if !c.isEmpty() || (root && len(c.children) > 1) {
+			pruned = append(pruned, c)
+			continue
+		}
+		for _, child := range c.children {
+			child.parent = c.parent
+			pruned = append(pruned, child)
+		}
+	}
+	return pruned
+}
+
+// groupBySubject gathers threads which share a subject, which ties
+// replies together whose parents have never been seen.
+func groupBySubject(msgs []Message, roots []*container) []*container {
+	type subjectRoot struct {
+		c       *container
+		subject string
+		reply   bool
+	}
+
+	bySubject := make([]subjectRoot, len(roots))
+	for i, c := range roots {
+		first := c
+		if first.isEmpty() {
+			first = first.children[0]
+		}
+		subject, reply := baseSubject(msgs[first.index].Subject)
+		bySubject[i] = subjectRoot{c: c, subject: subject, reply: reply}
+	}
+
+	subjects := make(map[string]subjectRoot, len(roots))
+	for _, sr := range bySubject {
+		if sr.subject == "" {
+			continue
+		}
+		existing, ok := subjects[sr.subject]
+		// an empty container is preferred, then a message which is not a reply
+		if !ok || (!existing.c.isEmpty() && (sr.c.isEmpty() || (existing.reply && !sr.reply))) {
+			subjects[sr.subject] = sr
+		}
+	}
+
+	grouped := make([]*container, 0, len(roots))
+	position := make(map[*container]int, len(roots))
+	for _, sr := range bySubject {
+		c := sr.c
+		if c.parent != nil {
+			// already gathered into another thread
+			continue
+		}
+		other, ok := subjects[sr.subject]
+		if sr.subject == "" || !ok || other.c == c {
+			position[c] = len(grouped)
+			grouped = append(grouped, c)
+			continue
+		}
+
+		switch {
+		case other.c.isEmpty() && c.isEmpty():
+			for _, child := range c.children {
+				other.c.addChild(child)
+			}
+		case other.c.isEmpty():
+			other.c.addChild(c)
+		case !other.reply && sr.reply:
+			other.c.addChild(c)
+		default:
+			// neither is the other's reply, so they become siblings
+			dummy := &container{index: -1}
+			dummy.addChild(other.c)
+			dummy.addChild(c)
+			subjects[sr.subject] = subjectRoot{c: dummy, subject: sr.subject, reply: other.reply}
+			if i, ok := position[other.c]; ok {
+				grouped[i] = dummy
+				position[dummy] = i
+			} else {
+				// other comes later within the roots, and is skipped once it is reached
+				position[dummy] = len(grouped)
+				grouped = append(grouped, dummy)
+			}
+		}
+	}
+
+	// roots which were made children of others along the way are no longer roots
+	roots = grouped[:0]
+	for _, c := range grouped {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+	return roots
+}
+
+// replyPrefixes are the lower cased subject prefixes which mark a reply or a
+// forward, including those of a few languages which do not use "re".
+var replyPrefixes = []string{"re:", "fwd:", "fw:", "aw:", "sv:", "vs:"}
+
+// baseSubject returns the subject lower cased without any reply or forward
+// prefixes, or list tags in brackets, and whether any prefixes were removed.
+func baseSubject(subject string) (string, bool) {
+	s := strings.ToLower(strings.TrimSpace(subject))
+	reply := false
+	for {
+		trimmed := strings.TrimSpace(strings.TrimSuffix(s, "(fwd)"))
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.IndexByte(trimmed, ']'); end > 0 {
+				trimmed = strings.TrimSpace(trimmed[end+1:])
+			}
+		}
+		for _, prefix := range replyPrefixes {
+			if strings.HasPrefix(trimmed, prefix) {
+				trimmed = strings.TrimSpace(trimmed[len(prefix):])
+				reply = true
+			}
+		}
+		if trimmed == s {
+			return s, reply
+		}
+		s = trimmed
+	}
+}
+
+func buildThreadNode(msgs []Message, c *container) *ThreadNode {
+	n := &ThreadNode{Message: c.index, Children: make([]*ThreadNode, len(c.children))}
+	if !c.isEmpty() {
+		n.Latest = msgs[c.index].Date
+	}
+	for i, child := range c.children {
+		n.Children[i] = buildThreadNode(msgs, child)
+		if n.Children[i].Latest.After(n.Latest) {
+			n.Latest = n.Children[i].Latest
+		}
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return threadNodeDate(msgs, n.Children[i]).Before(threadNodeDate(msgs, n.Children[j]))
+	})
+	return n
+}
+
+// threadNodeDate is the date of the node's own message, or of
+// the first message within its subtree if it has none.
+func threadNodeDate(msgs []Message, n *ThreadNode) time.Time {
+	if first := n.FirstMessage(); first >= 0 {
+		return msgs[first].Date
+	}
+	return time.Time{}
+}
@@ -0,0 +1,68 @@
+package mail_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// benchThreadMessages generates a mailbox of mailing list traffic, where most
+// messages reply to a recent one, some have lost their parents and a few
+// are only tied to their threads by subject.
+func benchThreadMessages(n int) []mail.Message {
+	r := rand.New(rand.NewSource(1))
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	msgs := make([]mail.Message, n)
+	for i := range msgs {
+		msg := mail.Message{
+			MessageID: fmt.Sprintf("%d@lists.example", i),
+			Subject:   fmt.Sprintf("[dev] %s %d", benchWords[r.Intn(len(benchWords))], i),
+			Date:      base.Add(time.Duration(i) * 7 * time.Minute),
+		}
+		if i > 0 && r.Intn(4) > 0 {
+			parent := msgs[max(i-1-r.Intn(200), 0)]
+			msg.Subject = "Re: " + parent.Subject
+			switch r.Intn(20) {
+			case 0:
+				// the parent never arrived
+				msg.InReplyTo = "missing-" + parent.MessageID
+			case 1:
+				// a client which drops the threading headers
+			default:
+				msg.InReplyTo = parent.MessageID
+				msg.References = append(append([]string{}, parent.References...), parent.MessageID)
+				if len(msg.References) > 20 {
+					msg.References = msg.References[len(msg.References)-20:]
+				}
+			}
+		}
+		msgs[i] = msg
+	}
+
+	// mailboxes are rarely in the order messages were sent
+	r.Shuffle(len(msgs), func(i, j int) { msgs[i], msgs[j] = msgs[j], msgs[i] })
+	return msgs
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func BenchmarkThreadMessages(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, benchCorpusSize} {
+		msgs := benchThreadMessages(n)
+		b.Run(fmt.Sprintf("messages=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mail.ThreadMessages(msgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package mail_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// renderThreads draws threads as one line per node, indented by
+// depth, with nodes for missing messages shown as "-".
+func renderThreads(msgs []mail.Message, threads []*mail.ThreadNode) string {
+	lines := []string{}
+	for _, thread := range threads {
+		thread.Walk(func(n *mail.ThreadNode, depth int) {
+			subject := "-"
+			if n.Message >= 0 {
+				subject = msgs[n.Message].Subject
+			}
+			lines = append(lines, strings.Repeat("  ", depth)+subject)
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+func threadTestMessages(msgs ...mail.Message) []mail.Message {
+	base := time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	for i := range msgs {
+		if msgs[i].Date.IsZero() {
+			msgs[i].Date = base.Add(time.Duration(i) * time.Hour)
+		}
+	}
+	return msgs
+}
+
+func TestThreadMessagesByReferences(t *testing.T) {
+	is := is.New(t)
+
+	msgs := threadTestMessages(
+		mail.Message{Subject: "Release plan", MessageID: "1@x"},
+		mail.Message{Subject: "Lunch?", MessageID: "lunch@x"},
+		mail.Message{Subject: "Re: Release plan", MessageID: "2@x", InReplyTo: "1@x"},
+		mail.Message{Subject: "Re: Release plan", MessageID: "3@x", References: []string{"1@x", "2@x"}},
+		mail.Message{Subject: "Re: Release plan", MessageID: "4@x", InReplyTo: "1@x", References: []string{"1@x"}},
+	)
+
+	threads := mail.ThreadMessages(msgs)
+	is.Equal(len(threads), 2)
+	is.Equal(renderThreads(msgs, threads), strings.Join([]string{
+		"Lunch?",
+		"Release plan",
+		"  Re: Release plan",
+		"    Re: Release plan",
+		"  Re: Release plan",
+	}, "\n"))
+	is.Equal(threads[1].Count(), 4)
+	is.True(threads[1].Latest.Equal(msgs[4].Date))
+	is.Equal(threads[1].Children[0].Children[0].Message, 3)
+}
+
+func TestThreadMessagesKeepsSiblingsOfMissingParentTogether(t *testing.T) {
+	is := is.New(t)
+
+	// the message both reply to has never been seen
+	msgs := threadTestMessages(
+		mail.Message{Subject: "Re: Outage", MessageID: "2@x", References: []string{"0@x", "1@x"}},
+		mail.Message{Subject: "Re: Outage report", MessageID: "3@x", InReplyTo: "1@x"},
+	)
+
+	threads := mail.ThreadMessages(msgs)
+	is.Equal(len(threads), 1)
+	is.Equal(threads[0].Message, -1)
+	is.Equal(threads[0].FirstMessage(), 0)
+	is.Equal(renderThreads(msgs, threads), "-\n  Re: Outage\n  Re: Outage report")
+}
+
+func TestThreadMessagesFallsBackToSubjects(t *testing.T) {
+	is := is.New(t)
+
+	msgs := threadTestMessages(
+		mail.Message{Subject: "[dev] Weekly sync"},
+		mail.Message{Subject: "RE: Fwd: [dev] weekly sync"},
+		mail.Message{Subject: "Weekly sync"},
+		mail.Message{Subject: "Something else"},
+	)
+
+	threads := mail.ThreadMessages(msgs)
+	is.Equal(renderThreads(msgs, threads), strings.Join([]string{
+		"-",
+		"  [dev] Weekly sync",
+		"    RE: Fwd: [dev] weekly sync",
+		"  Weekly sync",
+		"Something else",
+	}, "\n"))
+}
+
+func TestThreadMessagesSurvivesLoopsAndDuplicateIDs(t *testing.T) {
+	is := is.New(t)
+
+	msgs := threadTestMessages(
+		mail.Message{Subject: "a", MessageID: "a@x", References: []string{"b@x"}},
+		mail.Message{Subject: "b", MessageID: "b@x", References: []string{"a@x"}},
+		mail.Message{Subject: "c", MessageID: "c@x", References: []string{"c@x"}},
+		mail.Message{Subject: "c again", MessageID: "c@x"},
+		mail.Message{Subject: "d", MessageID: "d@x", References: []string{"e@x", "d@x", "e@x"}},
+	)
+
+	threads := mail.ThreadMessages(msgs)
+	seen := map[int]bool{}
+	for _, thread := range threads {
+		thread.Walk(func(n *mail.ThreadNode, _ int) {
+			if n.Message >= 0 {
+				is.True(!seen[n.Message])
+				seen[n.Message] = true
+			}
+		})
+	}
+	is.Equal(len(seen), len(msgs))
+}
@@ -2,6 +2,7 @@ package tui
 
 import (
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tauraamui/maildew/internal/kvs"
 	"github.com/tauraamui/maildew/pkg/logging"
 	"github.com/tauraamui/maildew/pkg/mail"
 )
@@ -10,36 +11,45 @@ type model struct {
 	log        logging.I
 	imapAddr   string
 	repos      Repositories
+	changes    *kvs.Subscription
 	windowSize tea.WindowSizeMsg
 	active     tea.Model
 }
 
 type Repositories struct {
-	AccountRepo mail.AccountRepo
-	MailboxRepo mail.MailboxRepo
-	MessageRepo mail.MessageRepo
+	AccountRepo     mail.AccountRepo
+	MailboxRepo     mail.MailboxRepo
+	MessageRepo     mail.MessageRepo
+	SavedSearchRepo mail.SavedSearchRepo
+	// Searcher evaluates the saved searches, which
+	// are not shown at all when it is nil.
+	Searcher  *mail.Searcher
+	BodyCache *mail.BodyCache
 }
 
-func Run(l logging.I, addr string, r Repositories) error {
-	if _, err := tea.NewProgram(initialModel(l, addr, r), tea.WithAltScreen()).Run(); err != nil {
+// Run starts the TUI, when changes is not nil views are
+// refreshed as soon as the rows they are showing change.
+func Run(l logging.I, addr string, r Repositories, changes *kvs.Subscription, opts ReaderOptions, compose ComposeOptions) error {
+	if _, err := tea.NewProgram(initialModel(l, addr, r, changes, opts, compose), tea.WithAltScreen()).Run(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func initialModel(log logging.I, addr string, r Repositories) model {
+func initialModel(log logging.I, addr string, r Repositories, changes *kvs.Subscription, opts ReaderOptions, compose ComposeOptions) model {
 	m := model{
 		log:      log,
 		imapAddr: addr,
 		repos:    r,
+		changes:  changes,
 	}
 
-	m.active = initialRegisterAccountModel(log, m, addr, r)
+	m.active = initialRegisterAccountModel(log, m, addr, r, opts, compose)
 	return m
 }
 
 func (m model) Init() tea.Cmd {
-	return m.active.Init()
+	return tea.Batch(m.active.Init(), waitForChanges(m.changes))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -56,14 +66,33 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case openMailboxListMsg:
 		m.active = msg.mailboxListModel
-		return m, m.active.Init()
+		return m, tea.Batch(m.active.Init(), m.resize())
+	case openViewMsg:
+		m.active = msg.view
+		return m, tea.Batch(m.active.Init(), m.resize())
+	case rowsChangedMsg:
+		return m, tea.Batch(m.updateActive(msg), waitForChanges(m.changes))
 	}
 
-	if hasActive {
-		return m.active.Update(msg)
+	return m, m.updateActive(msg)
+}
+
+// resize lets a newly active view know the size of the window.
+func (m model) resize() tea.Cmd {
+	size := m.windowSize
+	return func() tea.Msg { return size }
+}
+
+// updateActive keeps the active view in place of returning it, so that the
+// app model stays in charge of switching views and receiving changes.
+func (m *model) updateActive(msg tea.Msg) tea.Cmd {
+	if m.active == nil {
+		return nil
 	}
 
-	return m, nil
+	active, cmd := m.active.Update(msg)
+	m.active = active
+	return cmd
 }
 
 func (m model) View() string {
@@ -0,0 +1,183 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// attachmentAddedMsg is sent once a file has been attached to the draft.
+type attachmentAddedMsg struct {
+	attachment mail.OutgoingAttachment
+}
+
+// attachmentPickerModel is a dialog for attaching files to a draft by
+// writing their paths, which tab completes from the files on disk. It
+// warns once the attachments are larger in total than limit.
+type attachmentPickerModel struct {
+	x, y  lipgloss.Position
+	draft *mail.Draft
+	limit int64
+	input textinput.Model
+	// candidates are the files a path could be completed to, shown
+	// when tab finds more than one
+	candidates []string
+	err        error
+}
+
+func newAttachmentPicker(draft *mail.Draft, limit int64) *attachmentPickerModel {
+	input := textinput.New()
+	input.CharLimit = 4096
+	input.Placeholder = "~/Documents/report.pdf"
+	input.PromptStyle = focusedStyle
+	input.TextStyle = focusedStyle
+	input.Focus()
+
+	return &attachmentPickerModel{draft: draft, limit: limit, input: input}
+}
+
+func (m *attachmentPickerModel) SetPosition(x, y lipgloss.Position) {
+	m.x, m.y = x, y
+}
+
+func (m *attachmentPickerModel) Update(msg tea.Msg) tea.Cmd {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			return closeDialogCmd()
+		case "enter":
+			return m.attach()
+		case "tab":
+			m.complete()
+			return nil
+		}
+		m.candidates = nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+// attach adds the file written to the draft, clearing the
+// path so another can be written.
+func (m *attachmentPickerModel) attach() tea.Cmd {
+	path := strings.TrimSpace(m.input.Value())
+	if path == "" {
+		return nil
+	}
+
+	a, err := m.draft.Attach(expandHome(path))
+	m.err = err
+	if err != nil {
+		return nil
+	}
+	m.input.SetValue("")
+	m.candidates = nil
+	return func() tea.Msg { return attachmentAddedMsg{attachment: a} }
+}
+
+// complete completes the path written to the file or directory it is the
+// start of, or as far as the files it could be agree, listing them.
+func (m *attachmentPickerModel) complete() {
+	value := m.input.Value()
+	dir, prefix := filepath.Split(value)
+
+	readDir := expandHome(dir)
+	if dir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		m.candidates = nil
+		return
+	}
+
+	matches := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		// hidden files are only completed to when asked for
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if e.IsDir() {
+			name += string(filepath.Separator)
+		}
+		matches = append(matches, name)
+	}
+
+	switch len(matches) {
+	case 0:
+		m.candidates = nil
+		return
+	case 1:
+		m.candidates = nil
+	default:
+		m.candidates = matches
+	}
+	m.input.SetValue(dir + commonPrefix(matches))
+	m.input.CursorEnd()
+}
+
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	// names which start the same only part way through a character are cut before it
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
+
+// expandHome replaces a leading ~ of path with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+func (m *attachmentPickerModel) View() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Attach files\n\n")
+	for _, a := range m.draft.Attachments {
+		fmt.Fprintf(&b, "%s (%s, %s)\n", a.Name, a.ContentType, formatSize(a.Size))
+	}
+	if len(m.draft.Attachments) > 0 {
+		b.WriteRune('\n')
+	}
+
+	b.WriteString(m.input.View())
+	b.WriteRune('\n')
+	if len(m.candidates) > 0 {
+		fmt.Fprintf(&b, "%s\n", blurredStyle.Render(strings.Join(m.candidates, "  ")))
+	}
+
+	if m.draft.ExceedsAttachmentSize(m.limit) {
+		fmt.Fprintf(&b, "\n%s\n", errorStyle.Render(fmt.Sprintf(
+			"the attachments total %s, more than the %s many servers accept",
+			formatSize(m.draft.AttachmentsSize()), formatSize(m.limit))))
+	}
+	if m.err != nil {
+		fmt.Fprintf(&b, "\n%s\n", errorStyle.Render(m.err.Error()))
+	}
+
+	fmt.Fprintf(&b, "\n%s", blurredStyle.Render("tab complete • enter attach • esc close"))
+	return dialogBoxStyle.Copy().BorderForeground(lipgloss.Color("#874BFD")).Render(b.String())
+}
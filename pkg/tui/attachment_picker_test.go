@@ -0,0 +1,104 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestAttachmentPickerCompletesPaths(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.Mkdir(filepath.Join(dir, "photos"), 0o755))
+	for _, name := range []string{"report-2023.pdf", "report-2024.pdf", "notes.txt", ".hidden", filepath.Join("photos", "beach.jpg")} {
+		is.NoErr(os.WriteFile(filepath.Join(dir, name), []byte("data"), 0o644))
+	}
+
+	m := newAttachmentPicker(&mail.Draft{}, mail.DefaultAttachmentSizeWarning)
+	tab := func() { m.Update(tea.KeyMsg{Type: tea.KeyTab}) }
+	write := func(s string) {
+		for _, r := range s {
+			m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+	}
+
+	m.input.SetValue(filepath.Join(dir, "n"))
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, "notes.txt"))
+	is.Equal(len(m.candidates), 0)
+
+	// the names which could be meant are listed, completed as far as they agree
+	m.input.SetValue(filepath.Join(dir, "rep"))
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, "report-202"))
+	is.Equal(m.candidates, []string{"report-2023.pdf", "report-2024.pdf"})
+	is.True(strings.Contains(stripANSI(m.View()), "report-2023.pdf  report-2024.pdf"))
+	write("4")
+	is.Equal(len(m.candidates), 0)
+
+	// directories are completed into
+	m.input.SetValue(filepath.Join(dir, "ph"))
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, "photos")+string(filepath.Separator))
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, "photos", "beach.jpg"))
+
+	// hidden files are left out unless asked for
+	m.input.SetValue(dir + string(filepath.Separator))
+	tab()
+	is.Equal(len(m.candidates), 4)
+	m.input.SetValue(dir + string(filepath.Separator) + ".")
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, ".hidden"))
+
+	m.input.SetValue(filepath.Join(dir, "missing", "x"))
+	tab()
+	is.Equal(m.input.Value(), filepath.Join(dir, "missing", "x"))
+}
+
+func TestAttachmentPickerAttachesFilesAndWarnsOfTheirSize(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("short"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "scan.pdf"), make([]byte, 2048), 0o644))
+
+	draft := &mail.Draft{}
+	m := newAttachmentPicker(draft, 1024)
+	attach := func(path string) tea.Cmd {
+		m.input.SetValue(path)
+		return m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	}
+
+	cmd := attach(filepath.Join(dir, "notes.txt"))
+	is.True(cmd != nil)
+	added := cmd().(attachmentAddedMsg)
+	is.Equal(added.attachment.Name, "notes.txt")
+	is.Equal(len(draft.Attachments), 1)
+	is.Equal(m.input.Value(), "")
+	view := stripANSI(m.View())
+	is.True(strings.Contains(view, "notes.txt (text/plain; charset=utf-8, 5 B)"))
+	is.True(!strings.Contains(view, "many servers accept"))
+
+	is.True(attach(filepath.Join(dir, "scan.pdf")) != nil)
+	is.Equal(len(draft.Attachments), 2)
+	is.True(strings.Contains(stripANSI(m.View()), "the attachments total 2.0 KB, more than the 1.0 KB many servers accept"))
+
+	// a path which can not be attached is kept to be corrected
+	is.Equal(attach(filepath.Join(dir, "missing.txt")), nil)
+	is.True(m.err != nil)
+	is.Equal(len(draft.Attachments), 2)
+	is.Equal(m.input.Value(), filepath.Join(dir, "missing.txt"))
+	is.True(strings.Contains(stripANSI(m.View()), "no such file"))
+
+	cmd = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	is.True(cmd != nil)
+	_, ok := cmd().(closeDialogMsg)
+	is.True(ok)
+}
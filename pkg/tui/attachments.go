@@ -0,0 +1,173 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// AttachmentOptions configures where attachments are saved and what they are opened with.
+type AttachmentOptions struct {
+	DownloadDir  string
+	OpenCommands map[string][]string
+}
+
+func AttachmentOptionsFromConfig(values configdef.ReaderValues) AttachmentOptions {
+	return AttachmentOptions{DownloadDir: values.DownloadDir, OpenCommands: values.OpenCommands}
+}
+
+type attachmentSavedMsg struct {
+	part *mail.Part
+	path string
+	err  error
+	// open is set when the attachment was saved only to be opened
+	open bool
+}
+
+type attachmentOpenedMsg struct {
+	err error
+}
+
+// attachmentListModel is a dialog listing the attachments of a message,
+// from which they can be saved to the download directory or opened.
+type attachmentListModel struct {
+	x, y   lipgloss.Position
+	log    logging.I
+	s      *session
+	opts   AttachmentOptions
+	mb     mail.Mailbox
+	uid    uint32
+	parts  []*mail.Part
+	cursor int
+	// saving is set while an attachment is being fetched
+	saving bool
+	status string
+	err    error
+}
+
+func newAttachmentList(log logging.I, s *session, opts AttachmentOptions, mb mail.Mailbox, uid uint32, parts []*mail.Part) *attachmentListModel {
+	return &attachmentListModel{log: log, s: s, opts: opts, mb: mb, uid: uid, parts: parts}
+}
+
+func (m *attachmentListModel) SetPosition(x, y lipgloss.Position) {
+	m.x, m.y = x, y
+}
+
+func (m *attachmentListModel) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case attachmentSavedMsg:
+		m.saving = false
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to save attachment %s: %v", msg.part.Filename, msg.err)
+			m.status, m.err = "", msg.err
+			return nil
+		}
+		if msg.open {
+			return m.open(msg.path, msg.part.ContentType)
+		}
+		m.status, m.err = "saved to "+msg.path, nil
+	case attachmentOpenedMsg:
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to open attachment: %v", msg.err)
+			m.status, m.err = "", msg.err
+		}
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc", "q":
+			return closeDialogCmd()
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.parts)-1 {
+				m.cursor++
+			}
+		case "s", "enter":
+			return m.save(m.opts.DownloadDir, false)
+		case "o":
+			// opened attachments are kept out of the way of the saved ones
+			return m.save(filepath.Join(os.TempDir(), "maildew"), true)
+		}
+	}
+	return nil
+}
+
+func (m *attachmentListModel) save(dir string, open bool) tea.Cmd {
+	if m.saving || len(m.parts) == 0 {
+		return nil
+	}
+	if !m.s.online() {
+		m.err = errors.New("attachments can not be fetched while offline")
+		return nil
+	}
+
+	m.saving, m.status, m.err = true, "fetching...", nil
+	s, part, mb, uid := m.s, m.parts[m.cursor], m.mb, m.uid
+	return func() tea.Msg {
+		path, err := s.saveAttachment(dir, mb, uid, part)
+		return attachmentSavedMsg{part: part, path: path, err: err, open: open}
+	}
+}
+
+func (m *attachmentListModel) open(path, contentType string) tea.Cmd {
+	m.status = "opening " + filepath.Base(path)
+	// the terminal is handed over in case the command runs within it
+	cmd := mail.AttachmentOpenCommand(path, contentType, m.opts.OpenCommands)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg { return attachmentOpenedMsg{err: err} })
+}
+
+func (m *attachmentListModel) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", "Attachments")
+
+	if len(m.parts) == 0 {
+		b.WriteString(blurredStyle.Render("This message has no attachments"))
+		b.WriteRune('\n')
+	}
+	for i, p := range m.parts {
+		name := p.Filename
+		if name == "" {
+			name = p.ContentType
+		}
+		name = sanitize(name)
+		line := fmt.Sprintf("%s  %s", name, blurredStyle.Render(formatSize(p.Size)))
+		if i == m.cursor {
+			b.WriteString(focusedStyle.Render("> ") + line)
+		} else {
+			b.WriteString("  " + line)
+		}
+		b.WriteRune('\n')
+	}
+
+	if m.err != nil {
+		fmt.Fprintf(&b, "\n%s\n", errorStyle.Render(m.err.Error()))
+	} else if m.status != "" {
+		fmt.Fprintf(&b, "\n%s\n", m.status)
+	}
+
+	fmt.Fprintf(&b, "\n%s", blurredStyle.Render("s save • o open • esc close"))
+	return dialogBoxStyle.Copy().BorderForeground(lipgloss.Color("#874BFD")).Render(b.String())
+}
+
+// formatSize formats n bytes for display, such as 1.5 MB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
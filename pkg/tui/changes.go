@@ -0,0 +1,51 @@
+package tui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tauraamui/maildew/internal/kvs"
+)
+
+// rowsChangedMsg carries every change which was waiting to be
+// read from the subscription at the time it was received.
+type rowsChangedMsg struct {
+	changes []kvs.Change
+}
+
+// changed reports whether any of the changes were made to
+// the given table's rows belonging to owner.
+func (m rowsChangedMsg) changed(table string, owner kvs.UUID) bool {
+	for _, c := range m.changes {
+		if c.Table == table && c.Owner == owner.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForChanges has to be issued again after each rowsChangedMsg
+// to keep receiving changes, it never completes once sub is closed.
+func waitForChanges(sub *kvs.Subscription) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+
+	return func() tea.Msg {
+		c, ok := <-sub.C
+		if !ok {
+			return nil
+		}
+
+		msg := rowsChangedMsg{changes: []kvs.Change{c}}
+		for {
+			select {
+			case c, ok := <-sub.C:
+				if !ok {
+					return msg
+				}
+				msg.changes = append(msg.changes, c)
+			default:
+				return msg
+			}
+		}
+	}
+}
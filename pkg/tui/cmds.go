@@ -7,3 +7,13 @@ func closeDialogCmd() func() tea.Msg {
 }
 
 type closeDialogMsg struct{}
+
+// openViewMsg makes view the active view, such as
+// when opening a message or returning from one.
+type openViewMsg struct {
+	view tea.Model
+}
+
+func openViewCmd(view tea.Model) func() tea.Msg {
+	return func() tea.Msg { return openViewMsg{view: view} }
+}
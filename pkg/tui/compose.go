@@ -0,0 +1,252 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+// ComposeOptions configures writing messages.
+type ComposeOptions struct {
+	// AttachmentSizeWarning is the total size of attachments in bytes
+	// beyond which a warning is shown, a size of zero never warns.
+	AttachmentSizeWarning int64
+}
+
+func ComposeOptionsFromConfig(values configdef.ComposeValues) ComposeOptions {
+	return ComposeOptions{AttachmentSizeWarning: mail.AttachmentSizeWarningFromConfig(values)}
+}
+
+// draftSavedMsg is sent once the draft has been saved on the server.
+type draftSavedMsg struct {
+	err error
+}
+
+// composeModel writes a message along with its attachments, which is
+// saved to the drafts mailbox on the server as it can not be sent yet.
+type composeModel struct {
+	log        logging.I
+	acc        mail.Account
+	s          *session
+	opts       ComposeOptions
+	parent     tea.Model
+	windowSize tea.WindowSizeMsg
+	// drafts is the name of the mailbox drafts are saved to
+	drafts string
+
+	draft *mail.Draft
+	// inputs are the recipients and subject, with the body after them
+	inputs     []textinput.Model
+	body       textarea.Model
+	focusIndex int
+	picker     dialogModel
+	saving     bool
+	status     string
+}
+
+func newCompose(log logging.I, acc mail.Account, s *session, opts ComposeOptions, parent tea.Model) *composeModel {
+	m := &composeModel{
+		log:    log,
+		acc:    acc,
+		s:      s,
+		opts:   opts,
+		parent: parent,
+		drafts: mail.DefaultDraftsMailbox,
+		draft:  &mail.Draft{From: acc.Username},
+		inputs: make([]textinput.Model, 3),
+		body:   textarea.New(),
+	}
+
+	for i := range m.inputs {
+		t := textinput.New()
+		t.CharLimit = 998
+		switch i {
+		case 0:
+			t.Prompt = "To: "
+			t.Placeholder = "bob@example.org, carol@example.org"
+		case 1:
+			t.Prompt = "Cc: "
+		case 2:
+			t.Prompt = "Subject: "
+		}
+		m.inputs[i] = t
+	}
+	m.body.Placeholder = "Write your message"
+	m.body.CharLimit = 0
+	m.focus(0)
+	return m
+}
+
+func (m *composeModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *composeModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.windowSize = msg
+		m.body.SetWidth(max(msg.Width-2, 10))
+		// leaves room for the title, recipients, subject, attachments and status
+		m.body.SetHeight(max(msg.Height-12, 3))
+		return m, nil
+	case closeDialogMsg:
+		m.picker = nil
+		return m, m.focus(m.focusIndex)
+	case attachmentAddedMsg:
+		m.status = fmt.Sprintf("attached %s", msg.attachment.Name)
+		return m, nil
+	case draftSavedMsg:
+		m.saving = false
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to save draft to %s: %v", m.drafts, msg.err)
+			m.status = fmt.Sprintf("unable to save the draft: %v", msg.err)
+			return m, nil
+		}
+		m.status = fmt.Sprintf("saved to %s", m.drafts)
+		return m, nil
+	case tea.KeyMsg:
+		if m.picker != nil {
+			return m, m.picker.Update(msg)
+		}
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc":
+			return m, openViewCmd(m.parent)
+		case "tab":
+			return m, m.focus(m.focusIndex + 1)
+		case "shift+tab":
+			return m, m.focus(m.focusIndex - 1)
+		case "ctrl+a":
+			m.status = ""
+			m.picker = newAttachmentPicker(m.draft, m.opts.AttachmentSizeWarning)
+			return m, textinput.Blink
+		case "ctrl+s":
+			return m, m.save()
+		}
+	default:
+		if m.picker != nil {
+			return m, m.picker.Update(msg)
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.focusIndex < len(m.inputs) {
+		m.inputs[m.focusIndex], cmd = m.inputs[m.focusIndex].Update(msg)
+	} else {
+		m.body, cmd = m.body.Update(msg)
+	}
+	return m, cmd
+}
+
+// focus moves the cursor to the input at index, the body being after the last of them.
+func (m *composeModel) focus(index int) tea.Cmd {
+	if index > len(m.inputs) {
+		index = 0
+	} else if index < 0 {
+		index = len(m.inputs)
+	}
+	m.focusIndex = index
+
+	var cmd tea.Cmd
+	for i := range m.inputs {
+		if i == m.focusIndex {
+			cmd = m.inputs[i].Focus()
+			m.inputs[i].PromptStyle = focusedStyle
+			continue
+		}
+		m.inputs[i].Blur()
+		m.inputs[i].PromptStyle = noStyle
+	}
+	if m.focusIndex == len(m.inputs) {
+		cmd = m.body.Focus()
+	} else {
+		m.body.Blur()
+	}
+	return cmd
+}
+
+// save saves the draft as written so far, along with its attachments.
+func (m *composeModel) save() tea.Cmd {
+	if m.saving {
+		return nil
+	}
+	if !m.s.online() {
+		m.status = "offline, drafts can only be saved while connected"
+		return nil
+	}
+
+	m.draft.To = splitAddresses(m.inputs[0].Value())
+	m.draft.Cc = splitAddresses(m.inputs[1].Value())
+	m.draft.Subject = strings.TrimSpace(m.inputs[2].Value())
+	m.draft.Body = m.body.Value()
+
+	m.saving, m.status = true, "saving..."
+	s, name, draft := m.s, m.drafts, *m.draft
+	return func() tea.Msg {
+		return draftSavedMsg{err: s.saveDraft(name, draft, time.Now())}
+	}
+}
+
+// splitAddresses splits a comma separated list of addresses.
+func splitAddresses(s string) []string {
+	addrs := []string{}
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (m *composeModel) View() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n\n", "New message")
+	for i := range m.inputs {
+		b.WriteString(m.inputs[i].View())
+		b.WriteRune('\n')
+	}
+	b.WriteRune('\n')
+	b.WriteString(m.body.View())
+	b.WriteString("\n\n")
+
+	if len(m.draft.Attachments) > 0 {
+		names := make([]string, len(m.draft.Attachments))
+		for i, a := range m.draft.Attachments {
+			names[i] = fmt.Sprintf("%s (%s)", a.Name, formatSize(a.Size))
+		}
+		fmt.Fprintf(&b, "%s %s\n", blurredStyle.Render("Attachments:"), strings.Join(names, ", "))
+	}
+	if m.draft.ExceedsAttachmentSize(m.opts.AttachmentSizeWarning) {
+		fmt.Fprintf(&b, "%s\n", errorStyle.Render(fmt.Sprintf(
+			"the attachments total %s, more than the %s many servers accept",
+			formatSize(m.draft.AttachmentsSize()), formatSize(m.opts.AttachmentSizeWarning))))
+	}
+
+	if m.status != "" {
+		b.WriteString(m.status)
+	} else {
+		b.WriteString(blurredStyle.Render("tab next • ctrl+a attach • ctrl+s save draft • esc discard"))
+	}
+
+	view := b.String()
+	if m.picker == nil {
+		return view
+	}
+
+	bg := lipgloss.Place(m.windowSize.Width, m.windowSize.Height, lipgloss.Left, lipgloss.Top, view)
+	fg := m.picker.View()
+	x := (m.windowSize.Width / 2) - (lipgloss.Width(fg) / 2)
+	y := (m.windowSize.Height / 2) - (lipgloss.Height(fg) / 2)
+	m.picker.SetPosition(lipgloss.Position(x), lipgloss.Position(y))
+	return placeOverlay(x, y, fg, bg, false)
+}
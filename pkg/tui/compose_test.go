@@ -0,0 +1,131 @@
+package tui
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestComposeOptionsFromConfig(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(ComposeOptionsFromConfig(configdef.ComposeValues{}).AttachmentSizeWarning, int64(mail.DefaultAttachmentSizeWarning))
+	is.Equal(ComposeOptionsFromConfig(configdef.ComposeValues{AttachmentSizeWarningMB: 10}).AttachmentSizeWarning, int64(10<<20))
+}
+
+func TestComposeAttachesFilesAndSavesDrafts(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "DRAFTS"))
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username@example.org", Password: "password"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), mail.Account{Username: "username", Password: "password"})(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("agenda"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "scan.pdf"), make([]byte, 2048), 0o644))
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	// the list opens the compose view, with the options it was given
+	list := initialMailboxListModel(log, Repositories{}, acc, newSession(cc), ReaderOptions{}, ComposeOptions{AttachmentSizeWarning: 1024})
+	_, open := list.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("w")})
+	m := open().(openViewMsg).view.(*composeModel)
+	m.drafts = "DRAFTS"
+
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	write := func(s string) {
+		for _, r := range s {
+			update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+	}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+
+	update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	write("bob@example.org, carol@example.org")
+	update(tea.KeyMsg{Type: tea.KeyTab})
+	update(tea.KeyMsg{Type: tea.KeyTab})
+	write("Meeting")
+	update(tea.KeyMsg{Type: tea.KeyTab})
+	write("See attached")
+
+	// the picker is typed into instead of the body while open
+	update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	is.True(m.picker != nil)
+	write(filepath.Join(dir, "not"))
+	update(tea.KeyMsg{Type: tea.KeyTab})
+	update(update(enter)())
+	is.Equal(m.status, "attached notes.txt")
+	is.True(!strings.Contains(stripANSI(m.View()), "many servers accept"))
+
+	write(filepath.Join(dir, "scan.pdf"))
+	update(enter)
+	is.Equal(len(m.draft.Attachments), 2)
+	is.True(strings.Contains(stripANSI(m.View()), "the attachments total 2.0 KB, more than the 1.0 KB many servers accept"))
+
+	update(update(tea.KeyMsg{Type: tea.KeyEsc})())
+	is.True(m.picker == nil)
+	view := stripANSI(m.View())
+	is.True(strings.Contains(view, "Attachments: notes.txt (6 B), scan.pdf (2.0 KB)"))
+	is.True(strings.Contains(view, "the attachments total 2.0 KB, more than the 1.0 KB many servers accept"))
+	is.Equal(m.body.Value(), "See attached")
+
+	save := update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	is.True(save != nil)
+	is.True(m.saving)
+	is.Equal(update(tea.KeyMsg{Type: tea.KeyCtrlS}), nil)
+	update(save())
+	is.Equal(m.status, "saved to DRAFTS")
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+	drafts := mail.Mailbox{UUID: uuid.New(), Name: "DRAFTS"}
+	msgRepo := mail.NewMessageRepo(db)
+	is.NoErr(mail.SyncMessages(log, cc, msgRepo, nil, drafts))
+	msgs, err := msgRepo.FetchByOwner(drafts.UUID)
+	is.NoErr(err)
+	is.Equal(len(msgs), 1)
+	is.Equal(msgs[0].Subject, "Meeting")
+	is.Equal(msgs[0].From, []string{"username@example.org"})
+	is.Equal(len(msgs[0].To), 2)
+	is.True(msgs[0].HasFlag(imap.DraftFlag))
+
+	// going back discards the message
+	is.Equal(update(tea.KeyMsg{Type: tea.KeyEsc})().(openViewMsg).view, list)
+}
+
+func TestComposeOnlySavesDraftsWhileOnline(t *testing.T) {
+	is := is.New(t)
+
+	m := newCompose(logging.New(logging.Options{Writer: io.Discard}), mail.Account{}, nil, ComposeOptions{}, nil)
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	is.Equal(cmd, nil)
+	is.Equal(m.status, "offline, drafts can only be saved while connected")
+}
@@ -0,0 +1,577 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/wordwrap"
+	"github.com/muesli/reflow/wrap"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const defaultHTMLCommandTimeout = 5 * time.Second
+
+var (
+	htmlHeadingStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("205"))
+	htmlRefStyle     = blurredStyle
+)
+
+// HTMLOptions configures how HTML-only mail is rendered.
+type HTMLOptions struct {
+	// Command is run in place of the built in renderer when set, with the
+	// HTML on its stdin, the built in renderer is used if it fails.
+	Command []string
+	Timeout time.Duration
+}
+
+func HTMLOptionsFromConfig(values configdef.ReaderValues) HTMLOptions {
+	return HTMLOptions{
+		Command: values.HTMLCommand,
+		Timeout: time.Duration(values.HTMLCommandTimeoutSeconds) * time.Second,
+	}
+}
+
+// renderHTML renders src as text laid out to width, with the links
+// numbered throughout and listed by number at the end.
+func renderHTML(log logging.I, src string, width int, opts HTMLOptions) string {
+	if len(opts.Command) > 0 {
+		out, err := runHTMLCommand(src, width, opts)
+		if err == nil {
+			return out
+		}
+		log.Error().Msgf("unable to render HTML with %s: %v", opts.Command[0], err)
+	}
+
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		// the parser is forgiving enough that this only happens on read errors
+		return src
+	}
+
+	r := &htmlRenderer{width: width, links: &[]string{}}
+	r.walk(doc)
+	r.flush()
+
+	lines := r.lines
+	if len(*r.links) > 0 {
+		lines = append(lines, "")
+		for i, link := range *r.links {
+			lines = append(lines, htmlRefStyle.Render(fmt.Sprintf("[%d] %s", i+1, link)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runHTMLCommand(src string, width int, opts HTMLOptions) (string, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTMLCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+	cmd.Stdin = strings.NewReader(src)
+	cmd.Env = append(os.Environ(), "COLUMNS="+strconv.Itoa(width))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out after %s", timeout)
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// the command passes on whatever control characters the sender wrote
+	return sanitize(strings.TrimRight(stdout.String(), "\n")), nil
+}
+
+type htmlList struct {
+	ordered bool
+	n       int
+}
+
+// htmlRenderer walks a parsed document, gathering inline text until a block
+// ends, when the text is wrapped to width and added to lines.
+type htmlRenderer struct {
+	// width of zero leaves lines unwrapped
+	width  int
+	links  *[]string
+	lines  []string
+	inline strings.Builder
+	// space is owed before the next word
+	space bool
+	// gap is owed before the next line, within the quote it was owed in
+	gap      bool
+	gapQuote string
+	// marker starts the next line, such as a list bullet
+	marker string
+	quote  string
+	lists  []htmlList
+
+	bold, italic, underline, strike, pre int
+}
+
+func (r *htmlRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.DocumentNode:
+		r.children(n)
+		return
+	case html.TextNode:
+		r.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	if hidden(n) {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template, atom.Noscript:
+	case atom.Br:
+		if r.inline.Len() == 0 {
+			r.emit("")
+		}
+		r.flush()
+	case atom.Hr:
+		r.block(true, func() {
+			r.emit(strings.Repeat("─", r.available(20)))
+		})
+	case atom.P, atom.Address, atom.Figure:
+		r.block(true, func() { r.children(n) })
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		r.block(true, func() {
+			r.children(n)
+			r.flushStyled(htmlHeadingStyle)
+		})
+	case atom.Ul, atom.Ol:
+		r.block(len(r.lists) == 0, func() {
+			r.lists = append(r.lists, htmlList{ordered: n.DataAtom == atom.Ol})
+			r.children(n)
+			r.lists = r.lists[:len(r.lists)-1]
+		})
+	case atom.Li:
+		r.flush()
+		r.marker = r.listMarker()
+		r.children(n)
+		r.flush()
+	case atom.Blockquote:
+		r.block(true, func() {
+			quote := r.quote
+			r.quote += "│ "
+			r.children(n)
+			r.flush()
+			r.quote = quote
+		})
+	case atom.Pre:
+		r.block(true, func() {
+			r.pre++
+			r.children(n)
+			r.flush()
+			r.pre--
+		})
+	case atom.Table:
+		r.block(true, func() { r.table(n) })
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav, atom.Center, atom.Form, atom.Dl, atom.Dt, atom.Dd:
+		r.block(false, func() { r.children(n) })
+	case atom.B, atom.Strong:
+		r.bold++
+		r.children(n)
+		r.bold--
+	case atom.I, atom.Em, atom.Cite:
+		r.italic++
+		r.children(n)
+		r.italic--
+	case atom.U, atom.Ins:
+		r.underline++
+		r.children(n)
+		r.underline--
+	case atom.S, atom.Strike, atom.Del:
+		r.strike++
+		r.children(n)
+		r.strike--
+	case atom.A:
+		r.link(n)
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			r.word("[" + alt + "]")
+		}
+	default:
+		r.children(n)
+	}
+}
+
+func (r *htmlRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+func (r *htmlRenderer) link(n *html.Node) {
+	href := strings.TrimSpace(attr(n, "href"))
+	r.underline++
+	r.children(n)
+	r.underline--
+
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return
+	}
+
+	num := 0
+	for i, link := range *r.links {
+		if link == href {
+			num = i + 1
+			break
+		}
+	}
+	if num == 0 {
+		*r.links = append(*r.links, href)
+		num = len(*r.links)
+	}
+
+	// the reference follows the link text without a space
+	space := r.space
+	r.space = false
+	r.inline.WriteString(htmlRefStyle.Render(fmt.Sprintf("[%d]", num)))
+	r.space = space
+}
+
+func (r *htmlRenderer) listMarker() string {
+	if len(r.lists) == 0 {
+		return "• "
+	}
+	l := &r.lists[len(r.lists)-1]
+	indent := strings.Repeat("  ", len(r.lists)-1)
+	if l.ordered {
+		l.n++
+		return fmt.Sprintf("%s%d. ", indent, l.n)
+	}
+	return indent + "• "
+}
+
+// text adds the text of a node, collapsing its whitespace outside of <pre>.
+func (r *htmlRenderer) text(s string) {
+	s = sanitize(strings.Map(dropInvisible, s))
+	if r.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				r.emit(r.inline.String())
+				r.inline.Reset()
+			}
+			r.inline.WriteString(r.render(line))
+		}
+		return
+	}
+
+	if len(s) > 0 && isSpace(s[0]) {
+		r.space = true
+	}
+	for _, w := range strings.Fields(s) {
+		r.word(w)
+		r.space = true
+	}
+	if len(s) > 0 && !isSpace(s[len(s)-1]) {
+		r.space = false
+	}
+}
+
+func (r *htmlRenderer) word(w string) {
+	if r.space && r.inline.Len() > 0 {
+		r.inline.WriteByte(' ')
+	}
+	r.space = false
+	r.inline.WriteString(r.render(w))
+}
+
+// render styles s with whichever inline elements it is within.
+func (r *htmlRenderer) render(s string) string {
+	if r.bold+r.italic+r.underline+r.strike == 0 {
+		return s
+	}
+	return r.style().Render(s)
+}
+
+func (r *htmlRenderer) style() lipgloss.Style {
+	s := lipgloss.NewStyle()
+	if r.bold > 0 {
+		s = s.Bold(true)
+	}
+	if r.italic > 0 {
+		s = s.Italic(true)
+	}
+	// styling the spaces as well would style each rune on its own
+	if r.underline > 0 {
+		s = s.Underline(true).UnderlineSpaces(false)
+	}
+	if r.strike > 0 {
+		s = s.Strikethrough(true).StrikethroughSpaces(false)
+	}
+	return s
+}
+
+// block flushes the text before and within fn, leaving
+// a blank line either side of the block if gap is set.
+func (r *htmlRenderer) block(gap bool, fn func()) {
+	r.flush()
+	if gap {
+		r.oweGap()
+	}
+	fn()
+	r.flush()
+	if gap {
+		r.oweGap()
+	}
+}
+
+// oweGap owes a blank line, which is only quoted if it falls within a quote.
+func (r *htmlRenderer) oweGap() {
+	if !r.gap || len(r.quote) < len(r.gapQuote) {
+		r.gapQuote = r.quote
+	}
+	r.gap = true
+}
+
+func (r *htmlRenderer) flush() {
+	r.flushStyled(noStyle)
+}
+
+// flushStyled wraps the gathered inline text, rendering each line with s.
+func (r *htmlRenderer) flushStyled(s lipgloss.Style) {
+	text := r.inline.String()
+	r.inline.Reset()
+	r.space = false
+	if text == "" || (r.pre == 0 && strings.TrimSpace(text) == "") {
+		return
+	}
+
+	marker := r.marker
+	r.marker = ""
+	indent := strings.Repeat(" ", lipgloss.Width(marker))
+	width := r.available(1) - lipgloss.Width(marker)
+	if r.pre == 0 && r.width > 0 {
+		text = wrap.String(wordwrap.String(text, width), width)
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		prefix := indent
+		if i == 0 {
+			prefix = marker
+		}
+		if line != "" {
+			line = s.Render(line)
+		}
+		r.emit(prefix + line)
+	}
+}
+
+// available returns the width left for text, never less than min.
+func (r *htmlRenderer) available(min int) int {
+	w := r.width - lipgloss.Width(r.quote)
+	if w < min {
+		return min
+	}
+	return w
+}
+
+func (r *htmlRenderer) emit(line string) {
+	if r.gap && len(r.lines) > 0 {
+		r.lines = append(r.lines, strings.TrimRight(r.gapQuote, " "))
+	}
+	r.gap = false
+	r.lines = append(r.lines, strings.TrimRight(r.quote+line, " "))
+}
+
+// table lays out a table of data as columns fitted to the width. Tables
+// used only for layout, with a single column or nested tables, which is
+// most of them in mail, have each of their cells laid out as a block.
+func (r *htmlRenderer) table(n *html.Node) {
+	rows := tableRows(n, nil)
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+
+	if cols < 2 || hasNestedTable(n) {
+		for _, row := range rows {
+			for _, cell := range row {
+				r.block(false, func() { r.children(cell) })
+			}
+		}
+		return
+	}
+
+	// each cell is rendered unwrapped first to find its natural width
+	cells := make([][][]string, len(rows))
+	widths := make([]int, cols)
+	for i, row := range rows {
+		cells[i] = make([][]string, cols)
+		for j, cell := range row {
+			sub := &htmlRenderer{links: r.links}
+			if cell.DataAtom == atom.Th {
+				sub.bold++
+			}
+			sub.children(cell)
+			sub.flush()
+			cells[i][j] = sub.lines
+			for _, line := range sub.lines {
+				if w := lipgloss.Width(line); w > widths[j] {
+					widths[j] = w
+				}
+			}
+		}
+	}
+
+	const sep = " │ "
+	fitColumns(widths, r.available(1)-(cols-1)*len([]rune(sep)))
+
+	for i, row := range cells {
+		wrapped := make([][]string, cols)
+		height := 0
+		for j, lines := range row {
+			text := strings.Join(lines, "\n")
+			if text != "" {
+				wrapped[j] = strings.Split(wrap.String(wordwrap.String(text, widths[j]), widths[j]), "\n")
+			}
+			if len(wrapped[j]) > height {
+				height = len(wrapped[j])
+			}
+		}
+
+		for l := 0; l < height; l++ {
+			segs := make([]string, cols)
+			for j := range segs {
+				seg := ""
+				if l < len(wrapped[j]) {
+					seg = wrapped[j][l]
+				}
+				segs[j] = seg + strings.Repeat(" ", max(widths[j]-lipgloss.Width(seg), 0))
+			}
+			r.emit(strings.Join(segs, sep))
+		}
+
+		if i == 0 && isHeaderRow(rows[0]) {
+			rules := make([]string, cols)
+			for j, w := range widths {
+				rules[j] = strings.Repeat("─", w)
+			}
+			r.emit(strings.Join(rules, "─┼─"))
+		}
+	}
+}
+
+// fitColumns narrows the widest columns until they fit within total,
+// no column is narrowed below a few characters.
+func fitColumns(widths []int, total int) {
+	const minWidth = 4
+	sum := 0
+	for _, w := range widths {
+		sum += w
+	}
+	for sum > total {
+		widest := 0
+		for j, w := range widths {
+			if w > widths[widest] {
+				widest = j
+			}
+		}
+		if widths[widest] <= minWidth {
+			return
+		}
+		widths[widest]--
+		sum--
+	}
+}
+
+// tableRows returns the cells of each row of the table, without
+// descending into any tables nested within them.
+func tableRows(n *html.Node, rows [][]*html.Node) [][]*html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.DataAtom {
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			rows = tableRows(c, rows)
+		case atom.Tr:
+			row := []*html.Node{}
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+					row = append(row, cell)
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func hasNestedTable(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Table || hasNestedTable(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHeaderRow(row []*html.Node) bool {
+	for _, cell := range row {
+		if cell.DataAtom != atom.Th {
+			return false
+		}
+	}
+	return len(row) > 0
+}
+
+// hidden reports whether the element is styled to not be shown, which
+// mail often uses for a preview of the message meant for the inbox.
+func hidden(n *html.Node) bool {
+	style := strings.ToLower(strings.ReplaceAll(attr(n, "style"), " ", ""))
+	if strings.Contains(style, "display:none") {
+		return true
+	}
+	for _, a := range n.Attr {
+		if a.Key == "hidden" {
+			return true
+		}
+	}
+	return false
+}
+
+// attr returns the value of the attribute key of n, sanitized
+// as the alt text and links written by the sender are shown.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return sanitize(a.Val)
+		}
+	}
+	return ""
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+// dropInvisible drops the zero width characters mail pads previews with.
+func dropInvisible(r rune) rune {
+	switch r {
+	case '\u200b', '\u200c', '\u200d', '\u034f', '\ufeff', '\u00ad':
+		return -1
+	}
+	return r
+}
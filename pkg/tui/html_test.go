@@ -0,0 +1,120 @@
+package tui
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/pkg/logging"
+)
+
+// renderPlainHTML renders src without any styling, to compare only the text.
+func renderPlainHTML(src string, width int, opts HTMLOptions) string {
+	log := logging.New(logging.Options{Writer: io.Discard})
+	return stripANSI(renderHTML(log, src, width, opts))
+}
+
+func TestRenderHTMLBlocksListsAndLinks(t *testing.T) {
+	is := is.New(t)
+
+	src := `<html><head><style>p { color: red }</style><title>News</title></head><body>
+<div style="display: none">preview text&#8204;&#8204;</div>
+<h1>Weekly   news</h1>
+<p>Hello <b>there</b>, read <a href="https://example.org/post">the post</a>
+or <a href="https://example.org/post">this one</a>.</p>
+<ul><li>first</li><li>second<ol><li>nested</li></ol></li></ul>
+<blockquote><p>quoted</p></blockquote>
+<pre>  keep
+    spacing</pre>
+<p><a href="https://example.org/unsubscribe">Unsubscribe</a><br>Line two</p>
+</body></html>`
+
+	got := renderPlainHTML(src, 80, HTMLOptions{})
+	is.Equal(got, strings.Join([]string{
+		"Weekly news",
+		"",
+		"Hello there, read the post[1] or this one[1].",
+		"",
+		"• first",
+		"• second",
+		"  1. nested",
+		"",
+		"│ quoted",
+		"",
+		"  keep",
+		"    spacing",
+		"",
+		"Unsubscribe[2]",
+		"Line two",
+		"",
+		"[1] https://example.org/post",
+		"[2] https://example.org/unsubscribe",
+	}, "\n"))
+}
+
+func TestRenderHTMLWrapsToWidth(t *testing.T) {
+	is := is.New(t)
+
+	got := renderPlainHTML(
+		"<ol><li>a list item long enough to wrap</li></ol>", 20, HTMLOptions{})
+	is.Equal(got, strings.Join([]string{
+		"1. a list item long",
+		"   enough to wrap",
+	}, "\n"))
+}
+
+func TestRenderHTMLTables(t *testing.T) {
+	is := is.New(t)
+
+	src := `<table>
+<tr><th>Item</th><th>Price</th></tr>
+<tr><td>Coffee</td><td>£3</td></tr>
+<tr><td>A rather long description</td><td>£12</td></tr>
+</table>
+<table><tr><td><table><tr><td>layout</td></tr></table></td></tr></table>`
+
+	got := renderPlainHTML(src, 20, HTMLOptions{})
+	is.Equal(got, strings.Join([]string{
+		"Item         │ Price",
+		"─────────────┼──────",
+		"Coffee       │ £3",
+		"A rather     │ £12",
+		"long         │",
+		"description  │",
+		"",
+		"layout",
+	}, "\n"))
+}
+
+func TestRenderHTMLFallsBackWhenCommandFails(t *testing.T) {
+	is := is.New(t)
+
+	got := renderPlainHTML("<p>built in</p>", 80, HTMLOptions{Command: []string{"cat"}})
+	is.Equal(got, "<p>built in</p>")
+
+	got = renderPlainHTML("<p>built in</p>", 80, HTMLOptions{Command: []string{"false"}})
+	is.Equal(got, "built in")
+}
+
+func TestRenderHTMLSanitizesControlCharacters(t *testing.T) {
+	is := is.New(t)
+
+	src := `<p>clear &#27;[2J now</p><pre>keep &#x9d;2J out</pre><img alt="&#27;]52;c;aGk=&#7;"><a href="https://example.org/&#27;[2J">link</a>`
+	got := renderPlainHTML(src, 80, HTMLOptions{})
+	is.True(!strings.Contains(got, "\x1b"))
+	is.True(!strings.Contains(got, "\u009d"))
+	is.Equal(got, strings.Join([]string{
+		"clear ␛[2J now",
+		"",
+		"keep �2J out",
+		"",
+		"[␛]52;c;aGk=␇]link[1]",
+		"",
+		"[1] https://example.org/␛[2J",
+	}, "\n"))
+
+	// what the command writes is the sender's too
+	got = renderPlainHTML("<p>clear \x1b[2J now</p>", 80, HTMLOptions{Command: []string{"cat"}})
+	is.Equal(got, "<p>clear ␛[2J now</p>")
+}
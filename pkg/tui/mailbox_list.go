@@ -1,9 +1,13 @@
 package tui
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
 	"github.com/tauraamui/maildew/pkg/logging"
 	"github.com/tauraamui/maildew/pkg/mail"
 )
@@ -11,54 +15,312 @@ import (
 type mailboxListModel struct {
 	log        logging.I
 	windowSize tea.WindowSizeMsg
-	mbrepo     mail.MailboxRepo
+	r          Repositories
 	acc        mail.Account
-	list       []string
+	s          *session
+	opts       ReaderOptions
+	compose    ComposeOptions
+	mailboxes  []mail.Mailbox
+	// searches are shown after the mailboxes as virtual mailboxes
+	searches []mail.VirtualMailbox
+	// searchGen tags each evaluation of the searches, so the results
+	// of one started before the view was opened again are dropped
+	searchGen int
+	// evaluating is set whilst the searches are evaluated, with stale
+	// set if they changed since and have to be evaluated once more
+	evaluating, stale bool
+	cursor            int
+	editor            dialogModel
 }
 
-func initialMailboxListModel(log logging.I, mbrepo mail.MailboxRepo, acc mail.Account) *mailboxListModel {
+// searchesEvaluatedMsg carries the saved searches as evaluated in the background.
+type searchesEvaluatedMsg struct {
+	gen      int
+	searches []mail.VirtualMailbox
+}
+
+func initialMailboxListModel(log logging.I, r Repositories, acc mail.Account, s *session, opts ReaderOptions, compose ComposeOptions) *mailboxListModel {
 	return &mailboxListModel{
-		log:    log,
-		list:   []string{},
-		mbrepo: mbrepo,
-		acc:    acc,
+		log:       log,
+		r:         r,
+		acc:       acc,
+		s:         s,
+		opts:      opts,
+		compose:   compose,
+		mailboxes: []mail.Mailbox{},
+		searches:  []mail.VirtualMailbox{},
 	}
 }
 
 func (m *mailboxListModel) Init() tea.Cmd {
-	mboxes, err := m.mbrepo.FetchByOwner(m.acc.UUID)
+	// an evaluation left running when the view was last closed never reports back
+	m.evaluating, m.stale = false, false
+	return m.reload()
+}
+
+func (m *mailboxListModel) reload() tea.Cmd {
+	mboxes, err := m.r.MailboxRepo.FetchByOwner(m.acc.UUID)
 	m.log.Debug().Msg("fetching mailboxes from repo")
 	if err != nil {
 		m.log.Error().Msgf("unable to fetch mailboxes: %w", err)
 		// not sure how to handle this yet
 	}
+	m.mailboxes = mboxes
+
+	return m.reloadSearches()
+}
+
+// reloadSearches evaluates every saved search against the local index
+// again, which keeps their unread counts live as messages change. Each
+// match is loaded, so they are evaluated in the background, with changes
+// made whilst they are left to a single evaluation once it finishes.
+func (m *mailboxListModel) reloadSearches() tea.Cmd {
+	if m.r.SavedSearchRepo == nil || m.r.Searcher == nil {
+		return nil
+	}
+	if m.evaluating {
+		m.stale = true
+		return nil
+	}
+	m.evaluating = true
+	m.searchGen++
 
-	for _, mbx := range mboxes {
-		m.list = append(m.list, mbx.Name)
+	log, r, owner, mailboxes, gen := m.log, m.r, m.acc.UUID, m.mailboxes, m.searchGen
+	return func() tea.Msg {
+		msg := searchesEvaluatedMsg{gen: gen, searches: []mail.VirtualMailbox{}}
+		searches, err := r.SavedSearchRepo.FetchByOwner(owner)
+		if err != nil {
+			log.Error().Msgf("unable to fetch saved searches: %v", err)
+			return msg
+		}
+
+		for _, s := range searches {
+			vm, err := r.Searcher.Evaluate(s, mailboxes)
+			if err != nil {
+				log.Error().Msgf("unable to evaluate saved search %s: %v", s.Name, err)
+				vm = mail.VirtualMailbox{Search: s}
+			}
+			msg.searches = append(msg.searches, vm)
+		}
+		return msg
 	}
+}
 
+func (m *mailboxListModel) searchesEvaluated(msg searchesEvaluatedMsg) tea.Cmd {
+	if msg.gen != m.searchGen {
+		return nil
+	}
+	m.evaluating = false
+	m.searches = msg.searches
+
+	if total := len(m.mailboxes) + len(m.searches); m.cursor >= total && total > 0 {
+		m.cursor = total - 1
+	}
+
+	if m.stale {
+		m.stale = false
+		return m.reloadSearches()
+	}
 	return nil
 }
 
+// selectedSearch returns the saved search under the cursor, if any.
+func (m *mailboxListModel) selectedSearch() (mail.SavedSearch, bool) {
+	vm, ok := m.selectedVirtualMailbox()
+	return vm.Search, ok
+}
+
+func (m *mailboxListModel) selectedVirtualMailbox() (mail.VirtualMailbox, bool) {
+	i := m.cursor - len(m.mailboxes)
+	if i < 0 || i >= len(m.searches) {
+		return mail.VirtualMailbox{}, false
+	}
+	return m.searches[i], true
+}
+
+// readSearch opens the reader on the cached messages matched by the
+// saved search under the cursor, which are the ones it can show.
+func (m *mailboxListModel) readSearch() tea.Cmd {
+	vm, ok := m.selectedVirtualMailbox()
+	if !ok {
+		return nil
+	}
+
+	items := []readerItem{}
+	for _, match := range vm.Matches {
+		if match.Cached {
+			items = append(items, readerItem{mailbox: match.Mailbox, rowID: match.RowID, uid: match.UID})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return openViewCmd(newReader(m.log, m.r, m.s, m.opts, m, items, 0))
+}
+
 func (m *mailboxListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.windowSize = msg
+	case rowsChangedMsg:
+		if msg.changed(mail.MailboxesTableName, m.acc.UUID) {
+			return m, m.reload()
+		} else if msg.changed(mail.SavedSearchesTableName, m.acc.UUID) || m.messagesChanged(msg) {
+			return m, m.reloadSearches()
+		}
+	case searchesEvaluatedMsg:
+		return m, m.searchesEvaluated(msg)
+	case closeDialogMsg:
+		m.editor = nil
+	case savedSearchEditedMsg:
+		m.editor = nil
+		return m, m.saveSearch(msg.search, msg.isNew)
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "esc":
-			return m, tea.Quit
+		if m.editor != nil {
+			return m, m.editor.Update(msg)
+		}
+		return m, m.handleKey(msg)
+	default:
+		if m.editor != nil {
+			return m, m.editor.Update(msg)
 		}
 	}
 	return m, nil
 }
 
+func (m *mailboxListModel) messagesChanged(msg rowsChangedMsg) bool {
+	for _, mb := range m.mailboxes {
+		if msg.changed(mail.MessagesTableName, mb.UUID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mailboxListModel) handleKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		return tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(m.mailboxes)+len(m.searches)-1 {
+			m.cursor++
+		}
+	case "enter":
+		if m.cursor < len(m.mailboxes) {
+			return openViewCmd(newMessageList(m.log, m.r, m.acc, m.s, m.opts, m, m.mailboxes[m.cursor]))
+		}
+		return m.readSearch()
+	case "w":
+		return openViewCmd(newCompose(m.log, m.acc, m.s, m.compose, m))
+	case "T", "A":
+		if m.cursor < len(m.mailboxes) && m.r.AccountRepo != nil {
+			m.setSpecialMailbox(msg.String() == "T", m.mailboxes[m.cursor].Name)
+		}
+	case "n":
+		if m.r.SavedSearchRepo != nil {
+			m.editor = newSavedSearchEditor(mail.SavedSearch{UUID: uuid.New()}, true)
+			return textinput.Blink
+		}
+	case "e":
+		if s, ok := m.selectedSearch(); ok {
+			m.editor = newSavedSearchEditor(s, false)
+			return textinput.Blink
+		}
+	case "d":
+		if s, ok := m.selectedSearch(); ok {
+			if err := m.r.SavedSearchRepo.Delete(m.acc.UUID, s.UUID); err != nil {
+				m.log.Error().Msgf("unable to delete saved search %s: %v", s.Name, err)
+			}
+			return m.reloadSearches()
+		}
+	}
+	return nil
+}
+
+// setSpecialMailbox makes name the mailbox the account's deleted messages
+// are moved to if trash is set, or its archived messages otherwise.
+func (m *mailboxListModel) setSpecialMailbox(trash bool, name string) {
+	acc := m.acc
+	if trash {
+		acc.TrashMailbox = name
+	} else {
+		acc.ArchiveMailbox = name
+	}
+	if err := m.r.AccountRepo.Update(acc); err != nil {
+		m.log.Error().Msgf("unable to update account %s: %v", acc.Username, err)
+		return
+	}
+	m.acc = acc
+}
+
+func (m *mailboxListModel) saveSearch(s mail.SavedSearch, isNew bool) tea.Cmd {
+	save := m.r.SavedSearchRepo.Update
+	if isNew {
+		save = m.r.SavedSearchRepo.Save
+	}
+	if err := save(m.acc.UUID, s); err != nil {
+		m.log.Error().Msgf("unable to save saved search %s: %v", s.Name, err)
+	}
+	return m.reloadSearches()
+}
+
 func (m *mailboxListModel) View() string {
 	sb := strings.Builder{}
-	for _, mb := range m.list {
-		sb.WriteString(mb)
+	line := func(i int, s string) {
+		if i == m.cursor {
+			sb.WriteString(focusedStyle.Render("> " + s))
+		} else {
+			sb.WriteString("  " + s)
+		}
 		sb.WriteRune('\n')
 	}
 
-	return sb.String()
+	for i, mb := range m.mailboxes {
+		switch mb.Name {
+		case m.acc.Trash():
+			line(i, mb.Name+" (trash)")
+		case m.acc.Archive():
+			line(i, mb.Name+" (archive)")
+		default:
+			line(i, mb.Name)
+		}
+	}
+
+	if len(m.searches) > 0 {
+		sb.WriteString(blurredStyle.Render("Saved searches"))
+		sb.WriteRune('\n')
+	}
+	for i, vm := range m.searches {
+		name := vm.Search.Name
+		if vm.Unread > 0 {
+			name = fmt.Sprintf("%s (%d)", name, vm.Unread)
+		}
+		line(len(m.mailboxes)+i, name)
+	}
+
+	sb.WriteRune('\n')
+	help := "enter open • w write"
+	if m.r.AccountRepo != nil {
+		help += " • T/A set as trash/archive"
+	}
+	if m.r.SavedSearchRepo != nil {
+		help += " • n new search • e edit search • d delete search"
+	}
+	sb.WriteString(blurredStyle.Render(help))
+
+	view := sb.String()
+	if m.editor == nil {
+		return view
+	}
+
+	bg := lipgloss.Place(m.windowSize.Width, m.windowSize.Height, lipgloss.Left, lipgloss.Top, view)
+	fg := m.editor.View()
+	x := (m.windowSize.Width / 2) - (lipgloss.Width(fg) / 2)
+	y := (m.windowSize.Height / 2) - (lipgloss.Height(fg) / 2)
+	m.editor.SetPosition(lipgloss.Position(x), lipgloss.Position(y))
+	return placeOverlay(x, y, fg, bg, false)
 }
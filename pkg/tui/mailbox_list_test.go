@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestMailboxListEvaluatesSavedSearchesInBackground(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username"}
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	mbRepo, msgRepo, idx := mail.NewMailboxRepo(db), mail.NewMessageRepo(db), mail.NewSearchIndex(db)
+	is.NoErr(mbRepo.Save(acc.UUID, inbox))
+	searchRepo := mail.NewSavedSearchRepo(db)
+	is.NoErr(searchRepo.Save(acc.UUID, mail.SavedSearch{UUID: uuid.New(), Name: "CI", Query: "from:ci-bot"}))
+
+	storeMessage := func(subject string) {
+		msg := mail.Message{UUID: uuid.New(), Subject: subject, From: []string{"ci-bot@build.example"}, Date: time.Now()}
+		rowID, err := msgRepo.Save(inbox.UUID, msg)
+		is.NoErr(err)
+		is.NoErr(idx.Add(inbox.UUID, rowID, mail.SearchDocument{Subject: msg.Subject, From: msg.From, Date: msg.Date}))
+	}
+	storeMessage("Build failed")
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := initialMailboxListModel(log, Repositories{
+		MailboxRepo: mbRepo, MessageRepo: msgRepo, SavedSearchRepo: searchRepo, Searcher: mail.NewSearcher(db, nil),
+	}, acc, nil, ReaderOptions{}, ComposeOptions{})
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	messagesChanged := rowsChangedMsg{changes: []kvs.Change{{Table: mail.MessagesTableName, Owner: inbox.UUID.String()}}}
+
+	// nothing is evaluated until the returned command is run
+	evaluate := m.Init()
+	is.True(evaluate != nil)
+	is.Equal(len(m.mailboxes), 1)
+	is.Equal(len(m.searches), 0)
+
+	is.Equal(update(evaluate()), nil)
+	is.Equal(len(m.searches), 1)
+	is.Equal(m.searches[0].Unread, 1)
+
+	// changes made whilst evaluating are left to a single evaluation afterwards
+	storeMessage("Build failed again")
+	evaluate = update(messagesChanged)
+	is.True(evaluate != nil)
+	is.Equal(update(messagesChanged), nil)
+	is.Equal(update(messagesChanged), nil)
+
+	storeMessage("Build failed once more")
+	again := update(evaluate())
+	is.True(again != nil)
+	is.Equal(update(again()), nil)
+	is.Equal(m.searches[0].Unread, 3)
+
+	// results evaluated before the view was opened again are dropped
+	stale := update(messagesChanged)
+	is.True(m.Init() != nil)
+	is.Equal(update(stale()), nil)
+	is.True(m.evaluating)
+}
@@ -0,0 +1,932 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/emersion/go-imap"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+const (
+	messageListDateLayout = "2006-01-02 15:04"
+	// messageListPageSize is how many messages are loaded at a time,
+	// more are loaded as the cursor gets close to the last of them.
+	messageListPageSize = 200
+	// pendingSubject stands in for the subject of a message listed
+	// by the server until its envelope has been fetched.
+	pendingSubject = "loading..."
+)
+
+// messageSort is an indexed message column the list can be ordered by,
+// along with the key the server orders mailboxes which are not cached by.
+type messageSort struct {
+	label, column string
+	remote        mail.SortKey
+}
+
+var messageSorts = []messageSort{
+	{label: "date", column: "date", remote: mail.SortByDate},
+	{label: "sender", column: "sender", remote: mail.SortBySender},
+	{label: "subject", column: "subject", remote: mail.SortBySubject},
+	{label: "size", column: "size", remote: mail.SortBySize},
+}
+
+// messageListModel lists the envelopes of the messages stored for
+// a single mailbox, reading them from the store a page at a time.
+type messageListModel struct {
+	log        logging.I
+	r          Repositories
+	acc        mail.Account
+	s          *session
+	opts       ReaderOptions
+	parent     tea.Model
+	mailbox    mail.Mailbox
+	windowSize tea.WindowSizeMsg
+	table      table.Model
+
+	rows     []kvs.Row[mail.Message]
+	next     kvs.Cursor
+	pageSize int
+	err      error
+
+	sort  int
+	order kvs.Order
+
+	// remote is set while the messages listed are those the server ordered
+	// or threaded, as none are cached, which have no rows until synced
+	remote bool
+	// envelopes holds the index of each message listed by the server whose
+	// envelope has been asked for, set once it has arrived, as they are only
+	// fetched for the messages around the cursor
+	envelopes map[int]bool
+	// fetching is set while waiting on the server, with remoteGen tagging
+	// each fetch so those started before the list was reloaded are dropped
+	fetching  bool
+	remoteGen int
+
+	// threaded lists conversations as trees, which needs the whole mailbox loaded
+	threaded bool
+	threads  []*mail.ThreadNode
+	lines    []threadLine
+	// collapsed threads are keyed by the row ID of their first message
+	collapsed map[uint32]bool
+
+	// selected messages are keyed by row ID, flags are changed on and
+	// messages moved all of them at once instead of the one under the cursor
+	selected map[uint32]bool
+	prompt   textinput.Model
+	// onPrompt is set while the prompt is being written, and is given what was written
+	onPrompt func(value string) tea.Cmd
+	// status is shown in place of the key help until the next key
+	status string
+}
+
+// flagsStoredMsg is sent once the server has been told of changed flags.
+type flagsStoredMsg struct {
+	err error
+}
+
+// messagesRelocatedMsg is sent once messages have been moved, copied
+// or deleted, both on the server and within the store.
+type messagesRelocatedMsg struct {
+	verb   string
+	result mail.RelocateResult
+	err    error
+}
+
+// remoteListedMsg carries the UIDs of the messages of a mailbox which is
+// not cached, as the server ordered them or grouped them into threads.
+type remoteListedMsg struct {
+	gen     int
+	uids    []uint32
+	threads []*mail.ThreadNode
+	err     error
+}
+
+// envelopesFetchedMsg carries the envelopes of the messages listed by the
+// server which are around the cursor, indexes being those they are listed at.
+type envelopesFetchedMsg struct {
+	gen     int
+	indexes []int
+	msgs    []mail.Message
+	err     error
+}
+
+// relocateFunc is what messages are moved, copied or deleted with.
+type relocateFunc func(conn mail.RemoteMover, msgr mail.MessageRepo, from, to mail.Mailbox, rowIDs []uint32) (mail.RelocateResult, error)
+
+// threadLine is a message shown within a threaded list.
+type threadLine struct {
+	node, thread *mail.ThreadNode
+}
+
+func newMessageList(log logging.I, r Repositories, acc mail.Account, s *session, opts ReaderOptions, parent tea.Model, mb mail.Mailbox) *messageListModel {
+	prompt := textinput.New()
+	prompt.CharLimit = 64
+
+	m := &messageListModel{
+		log:       log,
+		r:         r,
+		acc:       acc,
+		s:         s,
+		opts:      opts,
+		parent:    parent,
+		mailbox:   mb,
+		pageSize:  messageListPageSize,
+		collapsed: map[uint32]bool{},
+		selected:  map[uint32]bool{},
+		prompt:    prompt,
+		// newest first is what a mailbox is expected to open on
+		order: kvs.Descending,
+	}
+	// the table is unable to show rows until it has columns
+	m.resize()
+	return m
+}
+
+func (m *messageListModel) Init() tea.Cmd {
+	return m.reload()
+}
+
+// reload reads the list again from the start, loading at least as many
+// messages as were loaded before so the cursor stays where it was. A
+// mailbox with none cached is ordered or threaded by the server instead
+// while online, with the empty list shown until it answers.
+func (m *messageListModel) reload() tea.Cmd {
+	loaded := len(m.rows)
+	if m.remote {
+		loaded = 0
+	}
+	m.rows, m.next, m.err = nil, nil, nil
+	m.remote, m.fetching = false, false
+	m.remoteGen++
+	m.loadPage()
+	for m.next != nil && (m.threaded || len(m.rows) < loaded) {
+		m.loadPage()
+	}
+	if m.threaded {
+		m.thread()
+	}
+	m.setRows()
+
+	if len(m.rows) > 0 || m.err != nil || !m.s.online() {
+		return nil
+	}
+	return m.fetchRemote()
+}
+
+// fetchRemote has the server order or thread the mailbox in the background.
+func (m *messageListModel) fetchRemote() tea.Cmd {
+	m.fetching = true
+	s, name, gen, threaded := m.s, m.mailbox.Name, m.remoteGen, m.threaded
+	key, reverse := messageSorts[m.sort].remote, m.order == kvs.Descending
+	return func() tea.Msg {
+		msg := remoteListedMsg{gen: gen}
+		msg.err = s.sortThread(func(conn mail.RemoteSortThreader) error {
+			var err error
+			if threaded {
+				msg.uids, msg.threads, err = mail.RemoteThreads(conn, name)
+				return err
+			}
+			msg.uids, err = mail.RemoteSort(conn, name, key, reverse)
+			return err
+		})
+		return msg
+	}
+}
+
+// remoteListed shows the messages the server listed, unless the list
+// was reloaded since, then fetches the envelopes of those around the
+// cursor. Should the server fail the cached messages, of which there
+// are none, are left shown.
+func (m *messageListModel) remoteListed(msg remoteListedMsg) tea.Cmd {
+	if msg.gen != m.remoteGen {
+		return nil
+	}
+	m.fetching = false
+	if msg.err != nil {
+		m.log.Error().Msgf("unable to list messages of %s from the server: %v", m.mailbox.Name, msg.err)
+		m.status = fmt.Sprintf("unable to list the messages from the server: %v", msg.err)
+		return nil
+	}
+
+	m.remote = true
+	m.envelopes = map[int]bool{}
+	m.rows = make([]kvs.Row[mail.Message], len(msg.uids))
+	for i, uid := range msg.uids {
+		m.rows[i] = kvs.Row[mail.Message]{Value: mail.Message{RemoteUID: uid}}
+	}
+	if m.threaded {
+		m.threads = msg.threads
+		m.reverseThreads()
+	}
+	m.setRows()
+	return m.fetchEnvelopes()
+}
+
+// fetchEnvelopes fetches the envelopes of the messages listed by the server
+// from a screen before the cursor to two after it, which were not asked for
+// yet, so a mailbox is never downloaded whole just to be listed.
+func (m *messageListModel) fetchEnvelopes() tea.Cmd {
+	if !m.remote {
+		return nil
+	}
+
+	indexes := []int{}
+	want := func(index int) {
+		if _, ok := m.envelopes[index]; !ok {
+			m.envelopes[index] = false
+			indexes = append(indexes, index)
+		}
+	}
+	cursor, height := m.table.Cursor(), max(m.table.Height(), 1)
+	for i := max(cursor-height, 0); i < cursor+2*height; i++ {
+		if !m.threaded && i < len(m.rows) {
+			want(i)
+		}
+		if m.threaded && i < len(m.lines) {
+			// every message of a thread is needed to count those unread
+			m.lines[i].thread.Walk(func(n *mail.ThreadNode, _ int) {
+				if n.Message >= 0 {
+					want(n.Message)
+				}
+			})
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	uids := make([]uint32, len(indexes))
+	for i, index := range indexes {
+		uids[i] = m.rows[index].Value.RemoteUID
+	}
+	s, name, gen := m.s, m.mailbox.Name, m.remoteGen
+	return func() tea.Msg {
+		msg := envelopesFetchedMsg{gen: gen, indexes: indexes}
+		msg.err = s.sortThread(func(conn mail.RemoteSortThreader) error {
+			var err error
+			msg.msgs, err = mail.FetchEnvelopes(conn, name, uids)
+			return err
+		})
+		return msg
+	}
+}
+
+// envelopesFetched shows the envelopes fetched of the messages listed by the
+// server, unless the list was reloaded since. Those the server failed to fetch
+// are asked for again once the cursor next moves.
+func (m *messageListModel) envelopesFetched(msg envelopesFetchedMsg) {
+	if msg.gen != m.remoteGen || !m.remote {
+		return
+	}
+	if msg.err != nil {
+		m.log.Error().Msgf("unable to fetch envelopes of %s from the server: %v", m.mailbox.Name, msg.err)
+		m.status = fmt.Sprintf("unable to fetch the messages from the server: %v", msg.err)
+		for _, index := range msg.indexes {
+			delete(m.envelopes, index)
+		}
+		return
+	}
+
+	byUID := make(map[uint32]mail.Message, len(msg.msgs))
+	for _, env := range msg.msgs {
+		byUID[env.RemoteUID] = env
+	}
+	for _, index := range msg.indexes {
+		// those expunged since they were listed are left blank
+		if env, ok := byUID[m.rows[index].Value.RemoteUID]; ok {
+			m.rows[index].Value = env
+		}
+		m.envelopes[index] = true
+	}
+	m.setRows()
+}
+
+// pending reports whether the envelope of the message listed at
+// index is yet to arrive from the server, being shown as loading.
+func (m *messageListModel) pending(index int) bool {
+	return m.remote && !m.envelopes[index]
+}
+
+// remoteOnly reports whether the messages listed are only on the server,
+// saying so in the status, as they can not be read or changed until synced.
+func (m *messageListModel) remoteOnly() bool {
+	if m.remote {
+		m.status = "the messages are not synced yet, they can be read and changed once they are"
+	}
+	return m.remote
+}
+
+// thread groups every loaded message into conversations,
+// with the most recently active first when newest is first.
+func (m *messageListModel) thread() {
+	msgs := make([]mail.Message, len(m.rows))
+	for i, row := range m.rows {
+		msgs[i] = row.Value
+	}
+	m.threads = mail.ThreadMessages(msgs)
+	m.reverseThreads()
+}
+
+// reverseThreads puts the most recently active thread first when newest is first.
+func (m *messageListModel) reverseThreads() {
+	if m.order == kvs.Descending {
+		for i, j := 0, len(m.threads)-1; i < j; i, j = i+1, j-1 {
+			m.threads[i], m.threads[j] = m.threads[j], m.threads[i]
+		}
+	}
+}
+
+func (m *messageListModel) loadPage() {
+	if m.r.MessageRepo == nil {
+		return
+	}
+
+	rows, next, err := m.r.MessageRepo.FetchRowPageByOwner(m.mailbox.UUID, kvs.Query{
+		OrderBy: messageSorts[m.sort].column,
+		Order:   m.order,
+		Limit:   m.pageSize,
+		After:   m.next,
+	})
+	if err != nil {
+		m.log.Error().Msgf("unable to fetch messages of %s: %v", m.mailbox.Name, err)
+		m.err = err
+		m.next = nil
+		return
+	}
+	m.rows = append(m.rows, rows...)
+	m.next = next
+}
+
+// loadMore loads the next page once the cursor is
+// within a screen of the last message loaded.
+func (m *messageListModel) loadMore() {
+	if m.next == nil || m.table.Cursor() < len(m.rows)-max(m.table.Height(), 1) {
+		return
+	}
+	m.loadPage()
+	m.setRows()
+}
+
+func (m *messageListModel) setRows() {
+	var rows []table.Row
+	if m.threaded {
+		rows = m.threadRows()
+	} else {
+		rows = make([]table.Row, len(m.rows))
+		for i, row := range m.rows {
+			if m.pending(i) {
+				rows[i] = table.Row{"", "", pendingSubject, "", ""}
+				continue
+			}
+			rows[i] = m.tableRow(row, row.Value.Subject)
+		}
+	}
+	m.table.SetRows(rows)
+	switch cursor := m.table.Cursor(); {
+	// the cursor of a table without rows is before its first
+	case cursor < 0:
+		m.table.SetCursor(0)
+	case cursor >= len(rows):
+		m.table.SetCursor(max(len(rows)-1, 0))
+	}
+}
+
+func messageTableRow(msg mail.Message, subject string) table.Row {
+	date := ""
+	if !msg.Date.IsZero() {
+		date = msg.Date.Local().Format(messageListDateLayout)
+	}
+	return table.Row{date, sanitize(strings.Join(msg.From, ", ")), sanitize(subject), formatFlags(msg), formatSize(int64(msg.Size))}
+}
+
+// tableRow is the row of a message, with its flags marked when it is selected.
+func (m *messageListModel) tableRow(row kvs.Row[mail.Message], subject string) table.Row {
+	r := messageTableRow(row.Value, subject)
+	if m.selected[row.ID] {
+		r[3] = "*" + r[3]
+	}
+	return r
+}
+
+// threadKey is what a thread is collapsed by, the row ID of its first
+// message, or its UID while the messages have no rows.
+func (m *messageListModel) threadKey(thread *mail.ThreadNode) uint32 {
+	first := m.rows[thread.FirstMessage()]
+	if m.remote {
+		return first.Value.RemoteUID
+	}
+	return first.ID
+}
+
+// threadRows lays each thread out as a tree, leaving out
+// the replies within those which have been collapsed.
+func (m *messageListModel) threadRows() []table.Row {
+	m.lines = m.lines[:0]
+	rows := []table.Row{}
+	for _, thread := range m.threads {
+		count, unread := thread.Count(), m.unread(thread)
+		collapsed := m.collapsed[m.threadKey(thread)]
+
+		thread.Walk(func(n *mail.ThreadNode, depth int) {
+			if depth > 0 && collapsed {
+				return
+			}
+			m.lines = append(m.lines, threadLine{node: n, thread: thread})
+
+			// a message which was never seen is shown by the subject of its first reply
+			subject := m.rows[n.FirstMessage()].Value.Subject
+			if m.pending(n.FirstMessage()) {
+				subject = pendingSubject
+			}
+
+			switch {
+			case depth > 0:
+				subject = strings.Repeat("  ", depth-1) + "└─ " + subject
+			case count > 1 && collapsed:
+				subject = fmt.Sprintf("▸ %s (%d messages, %d unread)", subject, count, unread)
+			case count > 1:
+				subject = fmt.Sprintf("▾ %s (%d messages, %d unread)", subject, count, unread)
+			}
+			if n.Message < 0 || m.pending(n.Message) {
+				rows = append(rows, table.Row{"", "", subject, "", ""})
+				return
+			}
+			rows = append(rows, m.tableRow(m.rows[n.Message], subject))
+		})
+	}
+	return rows
+}
+
+func (m *messageListModel) unread(thread *mail.ThreadNode) int {
+	unread := 0
+	thread.Walk(func(n *mail.ThreadNode, _ int) {
+		if n.Message >= 0 && !m.pending(n.Message) && !m.rows[n.Message].Value.HasFlag(imap.SeenFlag) {
+			unread++
+		}
+	})
+	return unread
+}
+
+// toggleThread collapses the thread under the cursor, or expands it again.
+func (m *messageListModel) toggleThread() {
+	cursor := m.table.Cursor()
+	if !m.threaded || cursor >= len(m.lines) {
+		return
+	}
+	thread := m.lines[cursor].thread
+	key := m.threadKey(thread)
+	m.collapsed[key] = !m.collapsed[key]
+	m.setRows()
+
+	// keeps the cursor on the thread, which is now on its first line
+	for i, line := range m.lines {
+		if line.thread == thread {
+			m.table.SetCursor(i)
+			break
+		}
+	}
+}
+
+// formatFlags abbreviates the flags of a message to a letter each.
+func formatFlags(msg mail.Message) string {
+	sb := strings.Builder{}
+	if !msg.HasFlag(imap.SeenFlag) {
+		sb.WriteRune('N')
+	}
+	for _, f := range []struct {
+		flag string
+		r    rune
+	}{{imap.AnsweredFlag, 'A'}, {imap.FlaggedFlag, 'F'}, {imap.DraftFlag, 'T'}, {imap.DeletedFlag, 'D'}} {
+		if msg.HasFlag(f.flag) {
+			sb.WriteRune(f.r)
+		}
+	}
+	return sb.String()
+}
+
+func (m *messageListModel) resize() {
+	const dateWidth, senderWidth, flagsWidth, sizeWidth = 16, 24, 6, 8
+	// each column is padded by a space either side
+	subjectWidth := max(m.windowSize.Width-dateWidth-senderWidth-flagsWidth-sizeWidth-10, 10)
+	// columns can only be given to a new table
+	cursor := m.table.Cursor()
+	m.table = table.New(
+		table.WithColumns([]table.Column{
+			{Title: "Date", Width: dateWidth},
+			{Title: "From", Width: senderWidth},
+			{Title: "Subject", Width: subjectWidth},
+			{Title: "Flags", Width: flagsWidth},
+			{Title: "Size", Width: sizeWidth},
+		}),
+		table.WithWidth(m.windowSize.Width),
+		// leaves room for the header row and the status line
+		table.WithHeight(max(m.windowSize.Height-3, 1)),
+		table.WithFocused(true),
+	)
+	m.setRows()
+	m.table.SetCursor(cursor)
+}
+
+func (m *messageListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.windowSize = msg
+		m.resize()
+		return m, m.fetchEnvelopes()
+	case rowsChangedMsg:
+		if msg.changed(mail.MessagesTableName, m.mailbox.UUID) {
+			return m, m.reload()
+		}
+		return m, nil
+	case remoteListedMsg:
+		return m, m.remoteListed(msg)
+	case envelopesFetchedMsg:
+		m.envelopesFetched(msg)
+		return m, nil
+	case flagsStoredMsg:
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to store flags in %s: %v", m.mailbox.Name, msg.err)
+			m.status = fmt.Sprintf("unable to change flags on the server, they are put back on the next sync: %v", msg.err)
+		}
+		return m, nil
+	case messagesRelocatedMsg:
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to have messages %s from %s: %v", msg.verb, m.mailbox.Name, msg.err)
+			m.status = fmt.Sprintf("unable to have the messages %s: %v", msg.verb, msg.err)
+		} else if status := msg.result.Status(); status != "" {
+			m.status = status
+		}
+		m.selected = map[uint32]bool{}
+		return m, m.reload()
+	case tea.KeyMsg:
+		m.status = ""
+		if m.onPrompt != nil {
+			return m, m.updatePrompt(msg)
+		}
+		if cmd, ok := m.handleKey(msg); ok {
+			return m, cmd
+		}
+	}
+
+	var cmd tea.Cmd
+	m.table, cmd = m.table.Update(msg)
+	m.loadMore()
+	return m, tea.Batch(cmd, m.fetchEnvelopes())
+}
+
+// handleKey handles the list's own keys, reporting
+// false for keys which are left to move the cursor.
+func (m *messageListModel) handleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	switch msg.String() {
+	case "ctrl+c":
+		return tea.Quit, true
+	case "esc", "q":
+		return openViewCmd(m.parent), true
+	case "enter":
+		return m.read(), true
+	case "s":
+		m.sort = (m.sort + 1) % len(messageSorts)
+		return m.resort(), true
+	case "t":
+		m.threaded = !m.threaded
+		return m.resort(), true
+	case " ":
+		m.toggleThread()
+		return m.fetchEnvelopes(), true
+	case "x":
+		m.toggleSelected()
+	case "X":
+		m.selected = map[uint32]bool{}
+		m.setRows()
+	case "u":
+		return m.toggleFlag(imap.SeenFlag), true
+	case "f":
+		return m.toggleFlag(imap.FlaggedFlag), true
+	case "a":
+		return m.toggleFlag(imap.AnsweredFlag), true
+	case "d":
+		return m.toggleFlag(imap.DeletedFlag), true
+	case "K":
+		return m.ask("keyword: ", m.tagKeyword), true
+	case "m":
+		return m.ask("move to: ", func(name string) tea.Cmd {
+			return m.relocate(mail.MoveMessages, "moved", name)
+		}), true
+	case "c":
+		return m.ask("copy to: ", func(name string) tea.Cmd {
+			return m.relocate(mail.CopyMessages, "copied", name)
+		}), true
+	case "e":
+		return m.relocate(mail.MoveMessages, "archived", m.acc.Archive()), true
+	case "#":
+		return m.relocate(mail.DeleteMessages, "deleted", m.acc.Trash()), true
+	case "r":
+		if m.order == kvs.Ascending {
+			m.order = kvs.Descending
+		} else {
+			m.order = kvs.Ascending
+		}
+		return m.resort(), true
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// ask shows the prompt in place of the key help, handing
+// what is written to onPrompt once enter is pressed.
+func (m *messageListModel) ask(prompt string, onPrompt func(value string) tea.Cmd) tea.Cmd {
+	m.onPrompt = onPrompt
+	m.prompt.Prompt = prompt
+	m.prompt.SetValue("")
+	return m.prompt.Focus()
+}
+
+func (m *messageListModel) updatePrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.onPrompt = nil
+		m.prompt.Blur()
+		return nil
+	case "enter":
+		onPrompt := m.onPrompt
+		m.onPrompt = nil
+		m.prompt.Blur()
+		value := strings.TrimSpace(m.prompt.Value())
+		if value == "" {
+			return nil
+		}
+		return onPrompt(value)
+	}
+
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return cmd
+}
+
+func (m *messageListModel) tagKeyword(keyword string) tea.Cmd {
+	if !mail.ValidFlag(keyword) {
+		m.status = fmt.Sprintf("%q can not be used as a keyword", keyword)
+		return nil
+	}
+	return m.toggleFlag(imap.CanonicalFlag(keyword))
+}
+
+// cursorRows returns the messages under the cursor, which are every message
+// of a thread which is collapsed, or of one without a message of its own.
+func (m *messageListModel) cursorRows() []kvs.Row[mail.Message] {
+	cursor := m.table.Cursor()
+	if !m.threaded {
+		if cursor >= len(m.rows) {
+			return nil
+		}
+		return m.rows[cursor : cursor+1]
+	}
+
+	if cursor >= len(m.lines) {
+		return nil
+	}
+	node := m.lines[cursor].node
+	collapsed := node == m.lines[cursor].thread && m.collapsed[m.threadKey(node)]
+	if node.Message >= 0 && !collapsed {
+		return m.rows[node.Message : node.Message+1]
+	}
+	rows := []kvs.Row[mail.Message]{}
+	node.Walk(func(n *mail.ThreadNode, _ int) {
+		if n.Message >= 0 {
+			rows = append(rows, m.rows[n.Message])
+		}
+	})
+	return rows
+}
+
+// targets returns the messages flags are changed on or which are moved,
+// which are those selected, or those under the cursor if there are none.
+func (m *messageListModel) targets() []kvs.Row[mail.Message] {
+	if len(m.selected) == 0 {
+		return m.cursorRows()
+	}
+	rows := []kvs.Row[mail.Message]{}
+	for _, row := range m.rows {
+		if m.selected[row.ID] {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// toggleSelected selects the messages under the cursor, or unselects
+// them if they are all selected already, then moves on to the next.
+func (m *messageListModel) toggleSelected() {
+	if m.remoteOnly() {
+		return
+	}
+	rows := m.cursorRows()
+	selected := true
+	for _, row := range rows {
+		selected = selected && m.selected[row.ID]
+	}
+	for _, row := range rows {
+		if selected {
+			delete(m.selected, row.ID)
+		} else {
+			m.selected[row.ID] = true
+		}
+	}
+	m.setRows()
+	m.table.MoveDown(1)
+	m.loadMore()
+}
+
+// toggleFlag sets flag on the targeted messages, or clears
+// it from them if every one of them has it set already.
+func (m *messageListModel) toggleFlag(flag string) tea.Cmd {
+	if m.remoteOnly() {
+		return nil
+	}
+	rows := m.targets()
+	if len(rows) == 0 || m.r.MessageRepo == nil {
+		return nil
+	}
+
+	var op imap.FlagsOp = imap.RemoveFlags
+	rowIDs := make([]uint32, len(rows))
+	for i, row := range rows {
+		rowIDs[i] = row.ID
+		if !row.Value.HasFlag(flag) {
+			op = imap.AddFlags
+		}
+	}
+
+	// the stored messages are changed straight away, with the server told after
+	uids, err := mail.ApplyFlags(m.r.MessageRepo, m.mailbox, rowIDs, op, flag)
+	m.reload()
+	if err != nil {
+		m.log.Error().Msgf("unable to change flags in %s: %v", m.mailbox.Name, err)
+		m.status = fmt.Sprintf("unable to change flags: %v", err)
+		return nil
+	}
+	if !m.s.online() {
+		m.status = "offline, the flags are put back on the next sync"
+		return nil
+	}
+
+	s, name := m.s, m.mailbox.Name
+	return func() tea.Msg {
+		return flagsStoredMsg{err: s.storeFlags(name, uids, op, flag)}
+	}
+}
+
+// relocate moves, copies or deletes the targeted messages with fn, into the
+// mailbox named name. The server is told first, so unlike flags they can
+// not be moved while offline.
+func (m *messageListModel) relocate(fn relocateFunc, verb, name string) tea.Cmd {
+	if m.remoteOnly() {
+		return nil
+	}
+	rows := m.targets()
+	if len(rows) == 0 || m.r.MessageRepo == nil {
+		return nil
+	}
+	if !m.s.online() {
+		m.status = fmt.Sprintf("offline, messages can only be %s while connected", verb)
+		return nil
+	}
+	if verb != "deleted" && name == m.mailbox.Name {
+		m.status = fmt.Sprintf("the messages are within %s already", name)
+		return nil
+	}
+
+	dest, err := m.destination(name)
+	if err != nil {
+		m.log.Error().Msgf("unable to fetch mailboxes: %v", err)
+		m.status = fmt.Sprintf("unable to find %s: %v", name, err)
+		return nil
+	}
+
+	rowIDs := make([]uint32, len(rows))
+	for i, row := range rows {
+		rowIDs[i] = row.ID
+	}
+	s, msgr, from := m.s, m.r.MessageRepo, m.mailbox
+	return func() tea.Msg {
+		msg := messagesRelocatedMsg{verb: verb}
+		msg.err = s.move(func(conn mail.RemoteMover) error {
+			var err error
+			msg.result, err = fn(conn, msgr, from, dest, rowIDs)
+			return err
+		})
+		return msg
+	}
+}
+
+// destination returns the stored mailbox of the account named name, or one
+// which is not stored if there is none, which the server alone is told of.
+func (m *messageListModel) destination(name string) (mail.Mailbox, error) {
+	if m.r.MailboxRepo == nil {
+		return mail.Mailbox{Name: name}, nil
+	}
+	mboxes, err := m.r.MailboxRepo.FetchByOwner(m.acc.UUID)
+	if err != nil {
+		return mail.Mailbox{}, err
+	}
+	for _, mb := range mboxes {
+		if mb.Name == name {
+			return mb, nil
+		}
+	}
+	return mail.Mailbox{Name: name}, nil
+}
+
+func (m *messageListModel) resort() tea.Cmd {
+	m.rows = nil
+	m.table.SetCursor(0)
+	return m.reload()
+}
+
+// read opens the reader on the message under the cursor, which
+// can move through the rest of the messages loaded so far.
+func (m *messageListModel) read() tea.Cmd {
+	if len(m.rows) == 0 || m.remoteOnly() {
+		return nil
+	}
+
+	item := func(i int) readerItem {
+		row := m.rows[i]
+		return readerItem{mailbox: m.mailbox, rowID: row.ID, uid: row.Value.RemoteUID}
+	}
+
+	if !m.threaded {
+		items := make([]readerItem, len(m.rows))
+		for i := range m.rows {
+			items[i] = item(i)
+		}
+		return openViewCmd(newReader(m.log, m.r, m.s, m.opts, m, items, m.table.Cursor()))
+	}
+
+	// every message is read in thread order, even those of collapsed threads
+	cursor := m.table.Cursor()
+	if cursor >= len(m.lines) {
+		return nil
+	}
+	selected := m.lines[cursor].node.FirstMessage()
+	items, index := make([]readerItem, 0, len(m.rows)), 0
+	for _, thread := range m.threads {
+		thread.Walk(func(n *mail.ThreadNode, _ int) {
+			if n.Message < 0 {
+				return
+			}
+			if n.Message == selected {
+				index = len(items)
+			}
+			items = append(items, item(n.Message))
+		})
+	}
+	return openViewCmd(newReader(m.log, m.r, m.s, m.opts, m, items, index))
+}
+
+func (m *messageListModel) View() string {
+	if m.err != nil {
+		return errorStyle.Render(fmt.Sprintf("Unable to list messages: %v", m.err))
+	}
+
+	loaded := fmt.Sprintf("%d messages", len(m.rows))
+	if m.next != nil {
+		loaded = fmt.Sprintf("%d+ messages", len(m.rows))
+	}
+	order := "↑"
+	if m.order == kvs.Descending {
+		order = "↓"
+	}
+	switch {
+	case m.fetching:
+		loaded = "listing messages from the server"
+	case m.remote:
+		loaded = fmt.Sprintf("%s on the server", loaded)
+	}
+	if len(m.selected) > 0 {
+		loaded = fmt.Sprintf("%s, %d selected", loaded, len(m.selected))
+	}
+	status := fmt.Sprintf("%s • %s • by %s %s • enter read • s sort • r reverse • t threads • u/f/a/d read/flag/answer/delete • K keyword • x select • m/c move/copy • e archive • # trash • q back",
+		m.mailbox.Name, loaded, messageSorts[m.sort].label, order)
+	if m.threaded {
+		status = fmt.Sprintf("%s • %s in %d threads • by activity %s • enter read • space fold • r reverse • t flat • u/f/a/d read/flag/answer/delete • K keyword • x select • m/c move/copy • e archive • # trash • q back",
+			m.mailbox.Name, loaded, len(m.threads), order)
+	}
+	switch {
+	case m.onPrompt != nil:
+		return m.table.View() + "\n" + m.prompt.View()
+	case m.status != "":
+		return m.table.View() + "\n" + errorStyle.Render(m.status)
+	}
+
+	return m.table.View() + "\n" + blurredStyle.Render(status)
+}
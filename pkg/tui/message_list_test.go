@@ -0,0 +1,500 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestFormatFlags(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(formatFlags(mail.Message{}), "N")
+	is.Equal(formatFlags(mail.Message{Flags: []string{imap.SeenFlag}}), "")
+	is.Equal(formatFlags(mail.Message{Flags: []string{imap.FlaggedFlag, imap.SeenFlag, imap.AnsweredFlag}}), "AF")
+}
+
+func TestMessageListSortsAndPagesThroughMessages(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	msgRepo := mail.NewMessageRepo(db)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	base := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, from := range []string{"Carol <carol@example.org>", "alice@example.org", "Bob <bob@example.org>", "dave@example.org", "Erin <erin@example.org>"} {
+		_, err := msgRepo.Save(inbox.UUID, mail.Message{
+			UUID: uuid.New(), RemoteUID: uint32(i + 1), From: []string{from},
+			Date: base.Add(time.Duration(i) * time.Hour), Size: uint32(1000 - i),
+		})
+		is.NoErr(err)
+	}
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := newMessageList(log, Repositories{MessageRepo: msgRepo}, mail.Account{}, nil, ReaderOptions{}, nil, inbox)
+	m.pageSize = 2
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	uids := func() []uint32 {
+		uids := []uint32{}
+		for _, row := range m.rows {
+			uids = append(uids, row.Value.RemoteUID)
+		}
+		return uids
+	}
+
+	is.Equal(m.Init(), nil)
+	update(tea.WindowSizeMsg{Width: 100, Height: 4})
+	// newest first, with only the first page loaded
+	is.Equal(uids(), []uint32{5, 4})
+	is.True(m.next != nil)
+
+	// moving close to the last message loaded reads the next page
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	is.Equal(uids(), []uint32{5, 4, 3, 2})
+
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	is.Equal(m.table.Cursor(), 0)
+	is.Equal(uids(), []uint32{5, 4})
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	is.Equal(uids(), []uint32{2, 3})
+
+	// sorting by size, smallest first
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	is.Equal(messageSorts[m.sort].label, "size")
+	is.Equal(uids(), []uint32{5, 4})
+
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	msg := update(tea.KeyMsg{Type: tea.KeyEnter})()
+	reader := msg.(openViewMsg).view.(*readerModel)
+	is.Equal(reader.index, 1)
+	is.Equal(len(reader.items), 4)
+	is.Equal(reader.items[1].uid, uint32(4))
+}
+
+func TestMessageListShowsCollapsibleThreads(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	msgRepo := mail.NewMessageRepo(db)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	base := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, msg := range []mail.Message{
+		{Subject: "Release plan", MessageID: "1@x", Flags: []string{imap.SeenFlag}},
+		{Subject: "Lunch?", MessageID: "lunch@x"},
+		{Subject: "Re: Release plan", MessageID: "2@x", InReplyTo: "1@x"},
+		{Subject: "Re: Release plan", MessageID: "3@x", References: []string{"1@x", "2@x"}, Flags: []string{imap.SeenFlag}},
+	} {
+		msg.UUID, msg.RemoteUID, msg.Date = uuid.New(), uint32(i+1), base.Add(time.Duration(i)*time.Hour)
+		_, err := msgRepo.Save(inbox.UUID, msg)
+		is.NoErr(err)
+	}
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := newMessageList(log, Repositories{MessageRepo: msgRepo}, mail.Account{}, nil, ReaderOptions{}, nil, inbox)
+	m.pageSize = 2
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+
+	m.Init()
+	update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	update(key("t"))
+	// threading needs every message, not just the first page
+	is.Equal(len(m.rows), 4)
+
+	view := stripANSI(m.View())
+	is.True(strings.Contains(view, "▾ Release plan (3 messages, 1 unread)"))
+	is.True(strings.Contains(view, "└─ Re: Release plan"))
+	is.True(strings.Contains(view, "  └─ Re: Release plan"))
+	is.True(strings.Contains(view, "in 2 threads"))
+	// the release plan was replied to most recently
+	is.Equal(m.lines[0].thread.Count(), 3)
+
+	update(key(" "))
+	view = stripANSI(m.View())
+	is.True(strings.Contains(view, "▸ Release plan (3 messages, 1 unread)"))
+	is.True(!strings.Contains(view, "└─"))
+	is.Equal(len(m.lines), 2)
+
+	// the lunch thread comes after every message of the collapsed release plan
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	reader := update(tea.KeyMsg{Type: tea.KeyEnter})().(openViewMsg).view.(*readerModel)
+	is.Equal(len(reader.items), 4)
+	is.Equal(reader.items[reader.index].uid, uint32(2))
+}
+
+func TestMessageListTogglesFlagsOnSelectedMessages(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	msgRepo := mail.NewMessageRepo(db)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	base := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		_, err := msgRepo.Save(inbox.UUID, mail.Message{
+			UUID: uuid.New(), RemoteUID: uint32(i + 1), Subject: "Message", Date: base.Add(time.Duration(i) * time.Hour),
+		})
+		is.NoErr(err)
+	}
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := newMessageList(log, Repositories{MessageRepo: msgRepo}, mail.Account{}, nil, ReaderOptions{}, nil, inbox)
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+	flags := func() []string {
+		flags := []string{}
+		for _, row := range m.rows {
+			flags = append(flags, formatFlags(row.Value))
+		}
+		return flags
+	}
+
+	m.Init()
+	update(tea.WindowSizeMsg{Width: 120, Height: 10})
+
+	// without anything selected only the message under the cursor is changed
+	is.Equal(update(key("u")), nil)
+	is.Equal(flags(), []string{"", "N", "N"})
+	is.True(strings.Contains(stripANSI(m.View()), "offline"))
+	update(key("u"))
+	is.Equal(flags(), []string{"N", "N", "N"})
+
+	update(key("x"))
+	update(key("x"))
+	is.Equal(m.table.Cursor(), 2)
+	is.True(strings.Contains(stripANSI(m.View()), "2 selected"))
+	update(key("f"))
+	is.Equal(flags(), []string{"NF", "NF", "N"})
+
+	// a flag set on only some of those selected is set on every one of them
+	update(key("X"))
+	update(key("d"))
+	update(tea.KeyMsg{Type: tea.KeyUp})
+	update(tea.KeyMsg{Type: tea.KeyUp})
+	update(key("x"))
+	update(key("x"))
+	update(key("x"))
+	update(key("d"))
+	is.Equal(flags(), []string{"NFD", "NFD", "ND"})
+	update(key("d"))
+	is.Equal(flags(), []string{"NF", "NF", "N"})
+
+	update(key("K"))
+	for _, r := range "Work" {
+		update(key(string(r)))
+	}
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	for _, row := range m.rows {
+		is.Equal(row.Value.HasFlag("work"), true)
+	}
+
+	update(key("K"))
+	update(key("a b"))
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	is.True(strings.Contains(stripANSI(m.View()), "can not be used as a keyword"))
+
+	// the changes were stored, not only shown, the oldest message being stored first
+	msgs, err := msgRepo.FetchByOwner(inbox.UUID)
+	is.NoErr(err)
+	is.True(!msgs[0].HasFlag(imap.FlaggedFlag))
+	is.True(msgs[2].HasFlag(imap.FlaggedFlag))
+}
+
+func TestMessageListArchivesAndMovesMessages(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	for _, name := range []string{"INBOX", "ARCHIVE", "TRASH"} {
+		is.NoErr(backend.CreateMailbox("username", name))
+	}
+	backend.StoreMessage("username", "INBOX", "Message-ID: <1@x>\r\nSubject: Release plan\r\n\r\nWhen do we ship?")
+	backend.StoreMessage("username", "INBOX", "Message-ID: <2@x>\r\nSubject: Lunch\r\n\r\nAnyone hungry?")
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username", Password: "password", ArchiveMailbox: "ARCHIVE", TrashMailbox: "TRASH"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), acc)(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	msgRepo, mbRepo := mail.NewMessageRepo(db), mail.NewMailboxRepo(db)
+	mboxes := map[string]mail.Mailbox{}
+	for _, name := range []string{"INBOX", "ARCHIVE", "TRASH"} {
+		mboxes[name] = mail.Mailbox{UUID: uuid.New(), Name: name}
+		is.NoErr(mbRepo.Save(acc.UUID, mboxes[name]))
+	}
+	is.NoErr(mail.SyncMessages(log, cc, msgRepo, nil, mboxes["INBOX"]))
+
+	m := newMessageList(log, Repositories{MessageRepo: msgRepo, MailboxRepo: mbRepo}, acc, newSession(cc), ReaderOptions{}, nil, mboxes["INBOX"])
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+	run := func(cmd tea.Cmd) {
+		is.True(cmd != nil)
+		update(cmd())
+	}
+	stored := func(name string) int {
+		msgs, err := msgRepo.FetchByOwner(mboxes[name].UUID)
+		is.NoErr(err)
+		return len(msgs)
+	}
+
+	m.Init()
+	update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	is.Equal(len(m.rows), 2)
+
+	run(update(key("e")))
+	is.Equal(m.status, "")
+	is.Equal(len(m.rows), 1)
+	is.Equal(stored("ARCHIVE"), 1)
+
+	update(key("m"))
+	for _, r := range "TRASH" {
+		update(key(string(r)))
+	}
+	run(update(tea.KeyMsg{Type: tea.KeyEnter}))
+	is.Equal(m.status, "")
+	is.Equal(len(m.rows), 0)
+	is.Equal(stored("TRASH"), 1)
+
+	// servers unable to expunge the originals alone leave them behind, which is said
+	update(messagesRelocatedMsg{verb: "moved", result: mail.RelocateResult{Unexpunged: 1, Mailbox: "INBOX"}})
+	is.True(strings.Contains(stripANSI(m.View()), "1 of the messages are left within INBOX marked as deleted"))
+
+	// the server agrees with what is stored
+	for name, want := range map[string]int{"INBOX": 0, "ARCHIVE": 1, "TRASH": 1} {
+		is.NoErr(mail.SyncMessages(log, cc, msgRepo, nil, mboxes[name]))
+		is.Equal(stored(name), want)
+	}
+}
+
+func TestMessageListHasTheServerOrderMailboxesWhichAreNotCached(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	store := func(date, subject, id, inReplyTo string) {
+		hdr := "Date: " + date + "\r\nSubject: " + subject + "\r\nMessage-ID: <" + id + ">\r\n"
+		if inReplyTo != "" {
+			hdr += "In-Reply-To: <" + inReplyTo + ">\r\n"
+		}
+		backend.StoreMessage("username", "INBOX", hdr+"\r\nbody")
+	}
+	store("Mon, 01 May 2023 09:00:00 +0000", "Release plan", "1@x", "")
+	store("Mon, 01 May 2023 10:00:00 +0000", "Lunch", "2@x", "")
+	store("Mon, 01 May 2023 11:00:00 +0000", "Re: Release plan", "3@x", "1@x")
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	s.Enable(mock.SortThread())
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username", Password: "password"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), acc)(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	msgRepo := mail.NewMessageRepo(db)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+
+	m := newMessageList(log, Repositories{MessageRepo: msgRepo}, acc, newSession(cc), ReaderOptions{}, nil, inbox)
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+	subjects := func() []string {
+		s := []string{}
+		for _, row := range m.rows {
+			s = append(s, row.Value.Subject)
+		}
+		return s
+	}
+
+	// nothing is cached, so the server is asked once the command is run
+	fetch := m.Init()
+	is.True(fetch != nil)
+	update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	is.Equal(len(m.rows), 0)
+	is.True(m.fetching)
+
+	// the server lists the UIDs, with the envelopes of those shown fetched after
+	envelopes := update(fetch())
+	is.True(envelopes != nil)
+	is.True(m.remote)
+	is.Equal(subjects(), []string{"", "", ""})
+	is.True(strings.Contains(stripANSI(m.View()), pendingSubject))
+	is.Equal(update(envelopes()), nil)
+	is.Equal(subjects(), []string{"Re: Release plan", "Lunch", "Release plan"})
+
+	fetch = update(key("r"))
+	is.True(fetch != nil)
+	update(update(fetch())())
+	is.Equal(subjects(), []string{"Release plan", "Lunch", "Re: Release plan"})
+
+	fetch = update(key("t"))
+	is.True(fetch != nil)
+	update(update(fetch())())
+	view := stripANSI(m.View())
+	is.True(strings.Contains(view, "▾ Release plan (2 messages, 0 unread)"))
+	is.True(strings.Contains(view, "└─ Re: Release plan"))
+	is.True(strings.Contains(view, "3 messages on the server in 2 threads"))
+	// oldest first, the release plan was replied to most recently
+	is.Equal(m.lines[2].thread.Count(), 2)
+
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	update(key(" "))
+	is.Equal(len(m.lines), 2)
+
+	// messages which are only on the server have no rows to change
+	is.Equal(update(key("f")), nil)
+	is.True(m.status != "")
+
+	// a fetch started before the list was reloaded is dropped
+	stale := update(key("r"))
+	is.True(m.reload() != nil)
+	update(stale())
+	is.True(m.fetching)
+	is.Equal(len(m.rows), 0)
+
+	// once synced the cached messages are listed instead
+	is.NoErr(mail.SyncMessages(log, cc, msgRepo, nil, inbox))
+	is.Equal(update(rowsChangedMsg{changes: []kvs.Change{{Table: mail.MessagesTableName, Owner: inbox.UUID.String()}}}), nil)
+	is.True(!m.remote && !m.fetching)
+	is.Equal(len(m.rows), 3)
+}
+
+func TestMessageListOnlyFetchesTheEnvelopesOfMessagesShown(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	base := time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		backend.StoreMessage("username", "INBOX", fmt.Sprintf("Date: %s\r\nSubject: Message %d\r\n\r\nbody",
+			base.Add(time.Duration(i)*time.Hour).Format(time.RFC1123Z), i))
+	}
+	// the server is without SORT, so the list is ordered here
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username", Password: "password"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), acc)(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := newMessageList(log, Repositories{MessageRepo: mail.NewMessageRepo(db)}, acc, newSession(cc), ReaderOptions{}, nil, mail.Mailbox{UUID: uuid.New(), Name: "INBOX"})
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	fetched := func() []int {
+		indexes := []int{}
+		for i, row := range m.rows {
+			if row.Value.Subject != "" {
+				indexes = append(indexes, i)
+			}
+		}
+		return indexes
+	}
+
+	fetch := m.Init()
+	// leaves a table 7 rows high
+	update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	update(update(fetch())())
+	is.Equal(len(m.rows), 50)
+	is.Equal(len(fetched()), 14)
+	is.Equal(m.rows[0].Value.Subject, "Message 49")
+
+	// those from a screen before the cursor to two after it are fetched as it moves
+	for i := 0; i < 20; i++ {
+		cmd := update(tea.KeyMsg{Type: tea.KeyDown})
+		if cmd == nil {
+			continue
+		}
+		// the table's own command is batched along with the fetch
+		batch, ok := cmd().(tea.BatchMsg)
+		is.True(ok)
+		for _, cmd := range batch {
+			update(cmd())
+		}
+	}
+	is.Equal(m.table.Cursor(), 20)
+	is.Equal(len(fetched()), 34)
+	is.Equal(m.rows[33].Value.Subject, "Message 16")
+	is.Equal(m.rows[34].Value.Subject, "")
+}
+
+func TestMessageListFallsBackToTheCacheWhenOffline(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := newMessageList(log, Repositories{MessageRepo: mail.NewMessageRepo(db)}, mail.Account{}, nil, ReaderOptions{}, nil, mail.Mailbox{UUID: uuid.New(), Name: "INBOX"})
+	is.Equal(m.Init(), nil)
+	is.True(!m.remote && !m.fetching)
+	is.Equal(len(m.rows), 0)
+}
@@ -0,0 +1,429 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/wordwrap"
+	"github.com/muesli/reflow/wrap"
+	"github.com/tauraamui/maildew/internal/configdef"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+const readerDateLayout = "Mon, 02 Jan 2006 15:04"
+
+var (
+	ansiEscape       = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	searchMatchStyle = lipgloss.NewStyle().Reverse(true)
+)
+
+// ReaderOptions configures how the reader shows messages.
+type ReaderOptions struct {
+	HTML        HTMLOptions
+	Attachments AttachmentOptions
+}
+
+func ReaderOptionsFromConfig(values configdef.ReaderValues) ReaderOptions {
+	return ReaderOptions{
+		HTML:        HTMLOptionsFromConfig(values),
+		Attachments: AttachmentOptionsFromConfig(values),
+	}
+}
+
+// readerItem is a message within the list the reader was opened from.
+type readerItem struct {
+	mailbox mail.Mailbox
+	rowID   uint32
+	uid     uint32
+}
+
+type readerLoadedMsg struct {
+	index  int
+	raw    []byte
+	parsed *mail.ParsedMessage
+	err    error
+}
+
+type headerMode int
+
+const (
+	headersShort headerMode = iota
+	headersAll
+	headersRaw
+)
+
+// readerModel shows a single message from a list of them, which it
+// can move through without going back to the list.
+type readerModel struct {
+	log        logging.I
+	r          Repositories
+	s          *session
+	opts       ReaderOptions
+	parent     tea.Model
+	items      []readerItem
+	index      int
+	windowSize tea.WindowSizeMsg
+	viewport   viewport.Model
+
+	raw    []byte
+	parsed *mail.ParsedMessage
+	err    error
+	// body is kept rendered at bodyWidth, as rendering HTML is slow
+	body      []string
+	bodyWidth int
+
+	headers headerMode
+	// quotes is set when quoted text is shown in place of being folded
+	quotes bool
+
+	search    textinput.Model
+	searching bool
+	query     string
+	matches   []int
+	match     int
+
+	attachments dialogModel
+}
+
+func newReader(log logging.I, r Repositories, s *session, opts ReaderOptions, parent tea.Model, items []readerItem, index int) *readerModel {
+	search := textinput.New()
+	search.Prompt = "/"
+	search.CharLimit = 128
+
+	return &readerModel{
+		log:      log,
+		r:        r,
+		s:        s,
+		opts:     opts,
+		parent:   parent,
+		items:    items,
+		index:    index,
+		viewport: viewport.New(0, 0),
+		search:   search,
+	}
+}
+
+func (m *readerModel) Init() tea.Cmd {
+	return m.load()
+}
+
+func (m *readerModel) load() tea.Cmd {
+	m.raw, m.parsed, m.err, m.body = nil, nil, nil, nil
+	m.query, m.matches, m.attachments = "", nil, nil
+	m.render()
+	m.viewport.GotoTop()
+
+	if m.r.BodyCache == nil {
+		m.err = mail.ErrBodyNotCached
+		m.render()
+		return nil
+	}
+
+	cache, item, index := m.r.BodyCache, m.items[m.index], m.index
+	return func() tea.Msg {
+		raw, err := cache.Open(item.mailbox, item.rowID)
+		if err != nil {
+			return readerLoadedMsg{index: index, err: err}
+		}
+		parsed, err := mail.ParseMessage(bytes.NewReader(raw))
+		return readerLoadedMsg{index: index, raw: raw, parsed: parsed, err: err}
+	}
+}
+
+func (m *readerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.windowSize = msg
+		m.viewport.Width = msg.Width
+		// leaves room for the status line
+		m.viewport.Height = max(msg.Height-2, 1)
+		m.render()
+		return m, nil
+	case readerLoadedMsg:
+		// a message moved away from before it loaded is of no use
+		if msg.index != m.index {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.log.Error().Msgf("unable to open message %d: %v", m.items[m.index].uid, msg.err)
+		}
+		m.raw, m.parsed, m.err = msg.raw, msg.parsed, msg.err
+		m.render()
+		return m, nil
+	case closeDialogMsg:
+		m.attachments = nil
+		return m, nil
+	case tea.KeyMsg:
+		if m.attachments != nil {
+			return m, m.attachments.Update(msg)
+		}
+		if m.searching {
+			return m, m.updateSearch(msg)
+		}
+		if cmd, ok := m.handleKey(msg); ok {
+			return m, cmd
+		}
+	default:
+		if m.attachments != nil {
+			return m, m.attachments.Update(msg)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// handleKey handles the reader's own keys, reporting
+// false for keys which are left to scroll the viewport.
+func (m *readerModel) handleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	switch msg.String() {
+	case "ctrl+c":
+		return tea.Quit, true
+	case "esc", "q":
+		if m.query != "" {
+			m.query, m.matches = "", nil
+			m.render()
+			return nil, true
+		}
+		return openViewCmd(m.parent), true
+	case "n":
+		if m.index < len(m.items)-1 {
+			m.index++
+			return m.load(), true
+		}
+	case "p":
+		if m.index > 0 {
+			m.index--
+			return m.load(), true
+		}
+	case "h":
+		m.headers = (m.headers + 1) % headersRaw
+		m.render()
+	case "R":
+		if m.headers == headersRaw {
+			m.headers = headersShort
+		} else {
+			m.headers = headersRaw
+		}
+		m.render()
+		m.viewport.GotoTop()
+	case "z":
+		m.quotes = !m.quotes
+		m.render()
+	case "/":
+		m.searching = true
+		m.search.SetValue("")
+		return m.search.Focus(), true
+	case "]":
+		m.jumpToMatch(m.match + 1)
+	case "[":
+		m.jumpToMatch(m.match - 1)
+	case "a":
+		if m.parsed != nil {
+			item := m.items[m.index]
+			m.attachments = newAttachmentList(m.log, m.s, m.opts.Attachments, item.mailbox, item.uid, m.parsed.Attachments())
+		}
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+func (m *readerModel) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.searching = false
+		m.search.Blur()
+		return nil
+	case "enter":
+		m.searching = false
+		m.search.Blur()
+		m.query = strings.TrimSpace(m.search.Value())
+		m.render()
+		m.jumpToMatch(0)
+		return nil
+	}
+
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	return cmd
+}
+
+func (m *readerModel) jumpToMatch(i int) {
+	if len(m.matches) == 0 {
+		return
+	}
+	// wraps around either end
+	m.match = (i + len(m.matches)) % len(m.matches)
+	m.viewport.SetYOffset(m.matches[m.match])
+}
+
+// render lays the message out again and sets it as the viewport's content.
+func (m *readerModel) render() {
+	var lines []string
+	switch {
+	case m.headers == headersRaw && m.raw != nil:
+		lines = strings.Split(sanitize(strings.ReplaceAll(string(m.raw), "\r\n", "\n")), "\n")
+	case m.err != nil:
+		lines = []string{errorStyle.Render(fmt.Sprintf("Unable to open message: %v", m.err))}
+	case m.parsed == nil:
+		lines = []string{blurredStyle.Render("Loading...")}
+	default:
+		lines = append(m.headerLines(), "")
+		lines = append(lines, m.bodyLines()...)
+		if !m.quotes {
+			lines = foldQuotes(lines)
+		}
+	}
+
+	m.matches = nil
+	if m.query != "" {
+		lines, m.matches = highlightMatches(lines, m.query)
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+}
+
+func (m *readerModel) headerLines() []string {
+	// headers are sanitized before being styled, which writes escape sequences of its own
+	label := func(key, value string) string {
+		return blurredStyle.Render(sanitize(key)+":") + " " + value
+	}
+
+	p := m.parsed
+	if m.headers == headersAll {
+		lines := []string{}
+		fields := p.Header.Fields()
+		for fields.Next() {
+			v, err := fields.Text()
+			if err != nil {
+				v = fields.Value()
+			}
+			lines = append(lines, label(fields.Key(), sanitize(v)))
+		}
+		return lines
+	}
+
+	lines := []string{label("From", sanitize(strings.Join(p.From, ", "))), label("To", sanitize(strings.Join(p.To, ", ")))}
+	if len(p.Cc) > 0 {
+		lines = append(lines, label("Cc", sanitize(strings.Join(p.Cc, ", "))))
+	}
+	if !p.Date.IsZero() {
+		lines = append(lines, label("Date", p.Date.Local().Format(readerDateLayout)))
+	}
+	lines = append(lines, label("Subject", focusedStyle.Render(sanitize(p.Subject))))
+	if n := len(p.Attachments()); n > 0 {
+		lines = append(lines, blurredStyle.Render(fmt.Sprintf("%d attachment(s), a to list", n)))
+	}
+	return lines
+}
+
+func (m *readerModel) bodyLines() []string {
+	width := m.viewport.Width
+	if m.body != nil && m.bodyWidth == width {
+		return m.body
+	}
+
+	text := ""
+	part := m.parsed.TextPart()
+	switch {
+	case part == nil:
+		text = blurredStyle.Render("This message has no text to show")
+	case part.ContentType == "text/html":
+		text = renderHTML(m.log, part.Text, width, m.opts.HTML)
+	default:
+		text = sanitize(strings.ReplaceAll(part.Text, "\r\n", "\n"))
+		if width > 0 {
+			text = wrap.String(wordwrap.String(text, width), width)
+		}
+	}
+
+	m.body, m.bodyWidth = strings.Split(strings.TrimRight(text, "\n"), "\n"), width
+	return m.body
+}
+
+func isQuoted(line string) bool {
+	line = strings.TrimLeft(stripANSI(line), " ")
+	return strings.HasPrefix(line, ">") || strings.HasPrefix(line, "│")
+}
+
+// foldQuotes replaces each run of quoted lines with a line
+// saying how many there are, runs of a single line are kept.
+func foldQuotes(lines []string) []string {
+	folded := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); {
+		j := i
+		for j < len(lines) && isQuoted(lines[j]) {
+			j++
+		}
+		if n := j - i; n > 1 {
+			folded = append(folded, blurredStyle.Render(fmt.Sprintf("[%d quoted lines, z to show]", n)))
+			i = j
+			continue
+		}
+		if j == i {
+			j++
+		}
+		folded = append(folded, lines[i:j]...)
+		i = j
+	}
+	return folded
+}
+
+// highlightMatches highlights each case insensitive match of query, the
+// styling of matched lines is dropped. It returns the matched line numbers.
+func highlightMatches(lines []string, query string) ([]string, []int) {
+	pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+	matches := []int{}
+	highlighted := make([]string, len(lines))
+	for i, line := range lines {
+		plain := stripANSI(line)
+		if !pattern.MatchString(plain) {
+			highlighted[i] = line
+			continue
+		}
+		matches = append(matches, i)
+		highlighted[i] = pattern.ReplaceAllStringFunc(plain, func(s string) string {
+			return searchMatchStyle.Render(s)
+		})
+	}
+	return highlighted, matches
+}
+
+func stripANSI(s string) string {
+	return ansiEscape.ReplaceAllString(s, "")
+}
+
+func (m *readerModel) View() string {
+	status := ""
+	switch {
+	case m.searching:
+		status = m.search.View()
+	case m.query != "":
+		status = fmt.Sprintf("%d matches for %q • ] next • [ previous • esc clear", len(m.matches), m.query)
+		if len(m.matches) > 0 {
+			status = fmt.Sprintf("match %d of %d for %q • ] next • [ previous • esc clear", m.match+1, len(m.matches), m.query)
+		}
+	default:
+		status = fmt.Sprintf("%d/%d • n/p message • h headers • R source • z quotes • / search • a attachments • q back", m.index+1, len(m.items))
+	}
+
+	view := m.viewport.View() + "\n" + blurredStyle.Render(status)
+	if m.attachments == nil {
+		return view
+	}
+
+	bg := lipgloss.Place(m.windowSize.Width, m.windowSize.Height, lipgloss.Left, lipgloss.Top, view)
+	fg := m.attachments.View()
+	x := (m.windowSize.Width / 2) - (lipgloss.Width(fg) / 2)
+	y := (m.windowSize.Height / 2) - (lipgloss.Height(fg) / 2)
+	m.attachments.SetPosition(lipgloss.Position(x), lipgloss.Position(y))
+	return placeOverlay(x, y, fg, bg, false)
+}
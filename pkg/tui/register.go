@@ -16,18 +16,22 @@ type registerAccountModel struct {
 	parent     tea.Model
 	imapAddr   string
 	r          Repositories
+	opts       ReaderOptions
+	compose    ComposeOptions
 	inputs     []textinput.Model
 	focusIndex int
 	windowSize tea.WindowSizeMsg
 	errDialog  dialogModel
 }
 
-func initialRegisterAccountModel(log logging.I, parent tea.Model, imapAddr string, r Repositories) registerAccountModel {
+func initialRegisterAccountModel(log logging.I, parent tea.Model, imapAddr string, r Repositories, opts ReaderOptions, compose ComposeOptions) registerAccountModel {
 	m := registerAccountModel{
 		log:      log,
 		parent:   parent,
 		imapAddr: imapAddr,
 		r:        r,
+		opts:     opts,
+		compose:  compose,
 		inputs:   make([]textinput.Model, 2),
 	}
 
@@ -71,10 +75,15 @@ type returnToParentMsg struct {
 func registerAccountCmd(l logging.I, imapAddr string, u, p string, r Repositories) func() tea.Msg {
 	return func() tea.Msg {
 		acc := mail.Account{Username: u, Password: p}
-		cc, err := mail.RegisterAccount(l, imapAddr, r.AccountRepo, r.MailboxRepo, &acc, mail.ResolveClientConnector(imapAddr, acc))
-		if err != nil {
+		if _, err := mail.RegisterAccount(l, imapAddr, r.AccountRepo, r.MailboxRepo, &acc, mail.ResolveClientConnector(imapAddr, acc)); err != nil {
 			return errorMessageMsg{err}
 		}
+
+		// registering logs out once done, so the views get a connection of their own
+		cc, err := mail.Connect(imapAddr, acc)
+		if err != nil {
+			l.Error().Msgf("unable to connect, continuing offline: %v", err)
+		}
 		return returnToParentMsg{cc, acc}
 	}
 }
@@ -83,10 +92,10 @@ type openMailboxListMsg struct {
 	mailboxListModel tea.Model
 }
 
-func openMailboxListCmd(l logging.I, mbRepo mail.MailboxRepo, acc mail.Account) func() tea.Msg {
+func openMailboxListCmd(l logging.I, r Repositories, acc mail.Account, s *session, opts ReaderOptions, compose ComposeOptions) func() tea.Msg {
 	return func() tea.Msg {
 		return openMailboxListMsg{
-			mailboxListModel: initialMailboxListModel(l, mbRepo, acc),
+			mailboxListModel: initialMailboxListModel(l, r, acc, s, opts, compose),
 		}
 	}
 }
@@ -94,7 +103,14 @@ func openMailboxListCmd(l logging.I, mbRepo mail.MailboxRepo, acc mail.Account)
 func (m registerAccountModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case returnToParentMsg:
-		return m.parent, openMailboxListCmd(m.log, m.r.MailboxRepo, msg.acc)
+		s := newSession(msg.cc)
+		if s.online() && m.r.BodyCache != nil {
+			m.r.BodyCache.SetFetcher(s)
+		}
+		if s.online() && m.r.Searcher != nil {
+			m.r.Searcher.SetRemote(s)
+		}
+		return m, openMailboxListCmd(m.log, m.r, msg.acc, s, m.opts, m.compose)
 	case errorMessageMsg:
 		m.errDialog = &errMsgModel{
 			parent: m,
@@ -0,0 +1,60 @@
+package tui
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-imap/server"
+	"github.com/google/uuid"
+	"github.com/matryer/is"
+	"github.com/tauraamui/maildew/internal/kvs"
+	"github.com/tauraamui/maildew/internal/mail/mock"
+	"github.com/tauraamui/maildew/pkg/logging"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+func TestRegisterAccountSearchesTheServerOverTheSession(t *testing.T) {
+	is := is.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	backend := mock.New()
+	backend.RegisterUser("username", "password")
+	is.NoErr(backend.CreateMailbox("username", "INBOX"))
+	backend.StoreMessage("username", "INBOX", "Message-ID: <1@x>\r\nSubject: Release plan\r\n\r\nWhen do we ship?")
+	backend.StoreMessage("username", "INBOX", "Message-ID: <2@x>\r\nSubject: Lunch\r\n\r\nAnyone hungry?")
+	s := server.New(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	defer s.Close()
+
+	acc := mail.Account{UUID: uuid.New(), Username: "username", Password: "password"}
+	cc, err := mail.ResolveClientConnector(l.Addr().String(), acc)(false)
+	is.NoErr(err)
+	defer cc.Close()
+
+	db, err := kvs.NewMemDB()
+	is.NoErr(err)
+	defer db.Close()
+
+	searcher := mail.NewSearcher(db, nil)
+	inbox := mail.Mailbox{UUID: uuid.New(), Name: "INBOX"}
+	q, err := mail.ParseSearchQuery("subject:release")
+	is.NoErr(err)
+
+	// nothing is cached, so nothing is found until the server can be searched
+	matches, err := searcher.Search([]mail.Mailbox{inbox}, q)
+	is.NoErr(err)
+	is.Equal(len(matches), 0)
+
+	log := logging.New(logging.Options{Writer: io.Discard})
+	m := initialRegisterAccountModel(log, nil, l.Addr().String(), Repositories{Searcher: searcher}, ReaderOptions{}, ComposeOptions{})
+	m.Update(returnToParentMsg{cc: cc, acc: acc})
+
+	matches, err = searcher.Search([]mail.Mailbox{inbox}, q)
+	is.NoErr(err)
+	is.Equal(len(matches), 1)
+	is.Equal(matches[0].UID, uint32(1))
+	is.True(!matches[0].Cached)
+}
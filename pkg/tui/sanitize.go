@@ -0,0 +1,53 @@
+package tui
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// controlPictures is where the symbols standing in for the C0 control
+// characters begin, such as ␛ for escape, with ␡ standing in for delete.
+const (
+	controlPictures = '␀'
+	deletePicture   = '␡'
+)
+
+// sanitize makes text written by a message's sender safe to print, as
+// terminals act on the control characters within it, such as escape
+// sequences able to redraw the screen or write to the clipboard. The C0
+// control characters other than newlines and tabs are swapped for the
+// symbols standing in for them, and the C1 control characters, along with
+// bytes which are not UTF-8, are swapped for the replacement character.
+func sanitize(s string) string {
+	clean := true
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf || (c < ' ' && c != '\n' && c != '\t') || c == 0x7f {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r < ' ':
+			b.WriteRune(controlPictures + r)
+		case r == 0x7f:
+			b.WriteRune(deletePicture)
+		case r >= 0x80 && r <= 0x9f:
+			b.WriteRune(utf8.RuneError)
+		default:
+			// bytes which are not UTF-8 decode to the replacement character
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+type savedSearchEditedMsg struct {
+	search mail.SavedSearch
+	// isNew is set when the search is yet to be stored
+	isNew bool
+}
+
+// savedSearchEditorModel is a dialog for naming a saved search and writing
+// its query, the query is checked before the dialog can be submitted.
+type savedSearchEditorModel struct {
+	x, y       lipgloss.Position
+	search     mail.SavedSearch
+	isNew      bool
+	inputs     []textinput.Model
+	focusIndex int
+	err        error
+}
+
+func newSavedSearchEditor(search mail.SavedSearch, isNew bool) *savedSearchEditorModel {
+	m := &savedSearchEditorModel{search: search, isNew: isNew, inputs: make([]textinput.Model, 2)}
+
+	for i := range m.inputs {
+		t := textinput.New()
+		t.CharLimit = 256
+		switch i {
+		case 0:
+			t.Placeholder = "Name"
+			t.SetValue(search.Name)
+			t.PromptStyle = focusedStyle
+			t.TextStyle = focusedStyle
+			t.Focus()
+		case 1:
+			t.Placeholder = "from:ci-bot is:unread newer_than:7d"
+			t.SetValue(search.Query)
+		}
+		m.inputs[i] = t
+	}
+
+	return m
+}
+
+func (m *savedSearchEditorModel) SetPosition(x, y lipgloss.Position) {
+	m.x, m.y = x, y
+}
+
+func (m *savedSearchEditorModel) Update(msg tea.Msg) tea.Cmd {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			return closeDialogCmd()
+		case "enter":
+			if m.focusIndex == len(m.inputs) {
+				return m.submit()
+			}
+			return m.focus(m.focusIndex + 1)
+		case "tab", "down":
+			return m.focus(m.focusIndex + 1)
+		case "shift+tab", "up":
+			return m.focus(m.focusIndex - 1)
+		}
+	}
+
+	cmds := make([]tea.Cmd, len(m.inputs))
+	for i := range m.inputs {
+		m.inputs[i], cmds[i] = m.inputs[i].Update(msg)
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *savedSearchEditorModel) focus(index int) tea.Cmd {
+	if index > len(m.inputs) {
+		index = 0
+	} else if index < 0 {
+		index = len(m.inputs)
+	}
+	m.focusIndex = index
+
+	cmds := make([]tea.Cmd, len(m.inputs))
+	for i := range m.inputs {
+		if i == m.focusIndex {
+			cmds[i] = m.inputs[i].Focus()
+			m.inputs[i].PromptStyle = focusedStyle
+			m.inputs[i].TextStyle = focusedStyle
+			continue
+		}
+		m.inputs[i].Blur()
+		m.inputs[i].PromptStyle = noStyle
+		m.inputs[i].TextStyle = noStyle
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *savedSearchEditorModel) submit() tea.Cmd {
+	name := strings.TrimSpace(m.inputs[0].Value())
+	query := strings.TrimSpace(m.inputs[1].Value())
+	if len(name) == 0 {
+		m.err = errors.New("the search needs a name")
+		return nil
+	}
+
+	q, err := mail.ParseSearchQuery(query)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	if q.IsEmpty() {
+		m.err = errors.New("the query would match every message")
+		return nil
+	}
+
+	search := m.search
+	search.Name, search.Query = name, query
+	isNew := m.isNew
+	return func() tea.Msg { return savedSearchEditedMsg{search: search, isNew: isNew} }
+}
+
+func (m *savedSearchEditorModel) View() string {
+	var b strings.Builder
+
+	title := "Edit saved search"
+	if m.isNew {
+		title = "New saved search"
+	}
+	fmt.Fprintf(&b, "%s\n\n", title)
+
+	for i := range m.inputs {
+		b.WriteString(m.inputs[i].View())
+		b.WriteRune('\n')
+	}
+
+	if m.err != nil {
+		fmt.Fprintf(&b, "\n%s\n", errorStyle.Render(m.err.Error()))
+	}
+
+	button := &blurredSubmitButton
+	if m.focusIndex == len(m.inputs) {
+		button = &focusedSubmitButton
+	}
+	fmt.Fprintf(&b, "\n%s", *button)
+
+	return dialogBoxStyle.Copy().BorderForeground(lipgloss.Color("#874BFD")).Render(b.String())
+}
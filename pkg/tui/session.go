@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/tauraamui/maildew/pkg/mail"
+)
+
+var errOffline = errors.New("not connected to the server")
+
+// session is the connection to the account's server shared by every view,
+// each use of it selects a mailbox of its own so they are made one at a time.
+type session struct {
+	mu   sync.Mutex
+	conn mail.RemoteConnection
+}
+
+func newSession(conn mail.RemoteConnection) *session {
+	return &session{conn: conn}
+}
+
+func (s *session) online() bool {
+	return s != nil && s.conn != nil
+}
+
+func (s *session) do(fn func(conn mail.RemoteConnection) error) error {
+	if !s.online() {
+		return errOffline
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.conn)
+}
+
+// fetcher returns the connection as one able to fetch by UID.
+func fetcher(conn mail.RemoteConnection) (mail.RemoteBodyFetcherConnection, error) {
+	fc, ok := conn.(mail.RemoteBodyFetcherConnection)
+	if !ok {
+		return nil, errors.New("connection is unable to fetch by UID")
+	}
+	return fc, nil
+}
+
+// FetchBody lets the body cache fetch bodies over the session.
+func (s *session) FetchBody(mailboxName string, uid uint32) ([]byte, error) {
+	var data []byte
+	err := s.do(func(conn mail.RemoteConnection) error {
+		fc, err := fetcher(conn)
+		if err != nil {
+			return err
+		}
+		data, err = mail.NewRemoteBodyFetcher(fc).FetchBody(mailboxName, uid)
+		return err
+	})
+	return data, err
+}
+
+func (s *session) saveAttachment(dir string, mb mail.Mailbox, uid uint32, part *mail.Part) (string, error) {
+	var path string
+	err := s.do(func(conn mail.RemoteConnection) error {
+		fc, err := fetcher(conn)
+		if err != nil {
+			return err
+		}
+		path, err = mail.NewAttachmentSaver(fc, dir).Save(mb, uid, part)
+		return err
+	})
+	return path, err
+}
+
+// storeFlags changes the flags of messages on the server, their
+// stored copies are expected to have been changed already.
+func (s *session) storeFlags(mailboxName string, uids []uint32, op imap.FlagsOp, flags ...string) error {
+	return s.do(func(conn mail.RemoteConnection) error {
+		fs, ok := conn.(mail.RemoteFlagStorer)
+		if !ok {
+			return errors.New("connection is unable to store flags")
+		}
+		return mail.StoreRemoteFlags(fs, mailboxName, uids, op, flags...)
+	})
+}
+
+// saveDraft adds the draft to the mailbox named mailboxName on the server.
+func (s *session) saveDraft(mailboxName string, d mail.Draft, date time.Time) error {
+	return s.do(func(conn mail.RemoteConnection) error {
+		ac, ok := conn.(mail.RemoteAppender)
+		if !ok {
+			return errors.New("connection is unable to append messages")
+		}
+		return mail.SaveDraft(ac, mailboxName, d, date)
+	})
+}
+
+// move runs fn with the connection as one able to move messages.
+func (s *session) move(fn func(conn mail.RemoteMover) error) error {
+	return s.do(func(conn mail.RemoteConnection) error {
+		mc, ok := conn.(mail.RemoteMover)
+		if !ok {
+			return errors.New("connection is unable to move messages")
+		}
+		return fn(mc)
+	})
+}
+
+// sortThread runs fn with the connection as one able to have the server
+// sort and thread the messages of a mailbox.
+func (s *session) sortThread(fn func(conn mail.RemoteSortThreader) error) error {
+	return s.do(func(conn mail.RemoteConnection) error {
+		st, ok := conn.(mail.RemoteSortThreader)
+		if !ok {
+			return errors.New("connection is unable to sort or thread messages")
+		}
+		return fn(st)
+	})
+}
+
+// SearchMailbox lets the searcher search the server over the session.
+func (s *session) SearchMailbox(mailboxName string, criteria *imap.SearchCriteria) ([]uint32, error) {
+	var uids []uint32
+	err := s.do(func(conn mail.RemoteConnection) error {
+		sc, ok := conn.(mail.RemoteSearcherConnection)
+		if !ok {
+			return errors.New("connection is unable to search by UID")
+		}
+		var err error
+		uids, err = mail.NewRemoteSearcher(sc).SearchMailbox(mailboxName, criteria)
+		return err
+	})
+	return uids, err
+}